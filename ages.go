@@ -0,0 +1,142 @@
+package cmap
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/decillion/go-cmap/hmap"
+)
+
+// WithAccessAges makes the map sample the ages of the values it loads, that is
+// how long before a call to Load found a key its value was stored, which
+// AgeHistogram reports so that the times to live and the limits of a cache can
+// be chosen from how old the values it serves are. One in every given number
+// of keys is sampled, chosen by its hash so that all the operations on a
+// sampled key are, at the cost of a lock taken by each of them, and the times
+// up to the given number of sampled keys were stored are kept. A sampled key
+// stored while as many are kept replaces one of them chosen at random, so the
+// ages of the keys stored long ago are the ones underestimated. It panics if
+// tracked or every is not positive.
+func WithAccessAges(tracked, every int) Option {
+	if tracked <= 0 || every <= 0 {
+		panic(fmt.Sprintf("cmap: WithAccessAges(%v, %v) out of range", tracked, every))
+	}
+	return func(o *options) { o.ageKeys, o.ageEvery = tracked, every }
+}
+
+// AgeBucket is a bucket of the histogram of AgeHistogram: the estimated number
+// of loads that found values stored at most UpTo before, and longer before than
+// the UpTo of the previous bucket.
+type AgeBucket struct {
+	UpTo  time.Duration
+	Count int64
+}
+
+// ageBuckets is the number of buckets of the histogram of ages, whose bounds
+// are doubled from a millisecond to about 12 days, the last one being
+// unbounded.
+const ageBuckets = 32
+
+// accessAges samples the ages of the values loaded from a map.
+type accessAges struct {
+	every   uint64
+	tracked int
+	mu      sync.Mutex
+	written map[hmap.Digest]int64 // the times the sampled keys were stored, in Unix nanoseconds
+	counts  [ageBuckets]int64     // the sampled loads by the bucket of their ages
+}
+
+// newAccessAges returns the sampler of the ages of the values of a map
+// according to the options o, or nil unless the map is created with
+// WithAccessAges.
+func newAccessAges(o *options) *accessAges {
+	if o.ageKeys == 0 {
+		return nil
+	}
+	return &accessAges{every: uint64(o.ageEvery), tracked: o.ageKeys, written: make(map[hmap.Digest]int64, o.ageKeys)}
+}
+
+// sampled reports whether the key of the digest d is sampled. The digest is
+// multiplied by an odd constant so that the keys sampled are not those of a
+// few buckets, which are chosen by the lower bits of digests.
+func (a *accessAges) sampled(d hmap.Digest) bool {
+	return a.every == 1 || (d.Uint64()*0x9e3779b97f4a7c15>>32)%a.every == 0
+}
+
+// store records that the key of the digest d is stored if it is sampled.
+func (a *accessAges) store(d hmap.Digest) {
+	if !a.sampled(d) {
+		return
+	}
+	now := time.Now().UnixNano()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.written[d]; !ok && len(a.written) >= a.tracked {
+		for victim := range a.written {
+			delete(a.written, victim)
+			break
+		}
+	}
+	a.written[d] = now
+}
+
+// load records the age of the value of the key of the digest d found by Load if
+// the key is sampled and the time it is stored is known.
+func (a *accessAges) load(d hmap.Digest) {
+	if !a.sampled(d) {
+		return
+	}
+	now := time.Now().UnixNano()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if at, ok := a.written[d]; ok {
+		a.counts[ageBucket(time.Duration(now-at))]++
+	}
+}
+
+// ageBucket returns the index of the bucket of the histogram of the given age.
+func ageBucket(age time.Duration) int {
+	i := 0
+	for i < ageBuckets-1 && age > time.Millisecond<<i {
+		i++
+	}
+	return i
+}
+
+// stored counts a value stored in the shard s for the key of the digest d.
+func (m *MapOf[K, V]) stored(s *shard[K, V], d hmap.Digest) {
+	m.count(&s.counters.stores)
+	if m.ages != nil {
+		m.ages.store(d)
+	}
+}
+
+// AgeHistogram returns the histogram of the ages of the values found by Load,
+// in the ascending order of the bounds of its buckets, which are doubled from
+// a millisecond, the UpTo of the last one being the largest duration. The
+// counts are those of the sampled loads multiplied by the rate of sampling,
+// and the buckets after the last one counting any load are omitted. It returns
+// nil unless the map is created with WithAccessAges.
+func (m *MapOf[K, V]) AgeHistogram() (histogram []AgeBucket) {
+	a := m.ages
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	counts := a.counts
+	a.mu.Unlock()
+	n := len(counts)
+	for n > 0 && counts[n-1] == 0 {
+		n--
+	}
+	for i, c := range counts[:n] {
+		upTo := time.Duration(math.MaxInt64)
+		if i < ageBuckets-1 {
+			upTo = time.Millisecond << i
+		}
+		histogram = append(histogram, AgeBucket{upTo, c * int64(a.every)})
+	}
+	return
+}
@@ -0,0 +1,148 @@
+package cmap
+
+import (
+	"container/list"
+	"sync"
+)
+
+// arc is the state of the EvictARC policy, which follows CAR, the variant of
+// the Adaptive Replacement Cache built on clocks. The keys in the map are kept
+// in two clocks: t1 holds the keys used once since they were inserted, and t2
+// the keys used more than once. The keys evicted from them are remembered in
+// the histories b1 and b2. A new key found in b1 means that t1 should have been
+// larger, and one found in b2 that t2 should have been, so the target size p
+// of t1 adapts to the workload.
+//
+// The clocks and histories are updated only when keys are inserted, deleted,
+// or evicted. A use of a key only marks it as referenced in its table, so
+// Load stays lock-free.
+type arc[K comparable] struct {
+	mu             sync.Mutex
+	size           int // the limit of the number of keys
+	p              int // the target size of t1
+	t1, t2, b1, b2 list.List
+	nodes          map[K]arcNode // the element and the list of each key
+}
+
+type arcNode struct {
+	elem *list.Element
+	in   *list.List
+}
+
+// newARC returns the state of a map according to the options o, or nil unless
+// the policy of the map is EvictARC.
+func newARC[K comparable](o *options) *arc[K] {
+	if o.maxKeys == 0 || o.policy != EvictARC {
+		return nil
+	}
+	return &arc[K]{size: int(o.maxKeys), nodes: make(map[K]arcNode)}
+}
+
+// reset forgets all the keys.
+func (a *arc[K]) reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.p = 0
+	a.t1.Init()
+	a.t2.Init()
+	a.b1.Init()
+	a.b2.Init()
+	clear(a.nodes)
+}
+
+// move moves the key of the node n to the back of the list l.
+func (a *arc[K]) move(key K, n arcNode, l *list.List) {
+	n.in.Remove(n.elem)
+	a.nodes[key] = arcNode{l.PushBack(key), l}
+}
+
+// drop forgets the key at the front of the history l.
+func (a *arc[K]) drop(l *list.List) {
+	if e := l.Front(); e != nil {
+		delete(a.nodes, l.Remove(e).(K))
+	}
+}
+
+// admit puts the given key just inserted into the map into a clock.
+func (a *arc[K]) admit(key K) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	n, ok := a.nodes[key]
+	switch {
+	case !ok:
+		if a.t1.Len()+a.b1.Len() >= a.size {
+			a.drop(&a.b1)
+		} else if a.t1.Len()+a.t2.Len()+a.b1.Len()+a.b2.Len() >= 2*a.size {
+			a.drop(&a.b2)
+		}
+		a.nodes[key] = arcNode{a.t1.PushBack(key), &a.t1}
+	case n.in == &a.b1:
+		a.p += max(1, a.b2.Len()/a.b1.Len())
+		if a.p > a.size {
+			a.p = a.size
+		}
+		a.move(key, n, &a.t2)
+	case n.in == &a.b2:
+		if a.p -= max(1, a.b1.Len()/a.b2.Len()); a.p < 0 {
+			a.p = 0
+		}
+		a.move(key, n, &a.t2)
+	}
+}
+
+// forget removes the given key deleted from the map from its clock. The keys
+// in the histories are kept, since they are evicted and not deleted.
+func (a *arc[K]) forget(key K) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if n, ok := a.nodes[key]; ok && (n.in == &a.t1 || n.in == &a.t2) {
+		n.in.Remove(n.elem)
+		delete(a.nodes, key)
+	}
+}
+
+// victim chooses the key to be evicted from the map and moves it to a
+// history. A key is referenced if referenced(key) reports so, which clears
+// the mark of the key, and is gone if ok is false. It returns false if there
+// is no key to be evicted.
+func (a *arc[K]) victim(referenced func(key K) (referenced, ok bool)) (key K, found bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for a.t1.Len()+a.t2.Len() > 0 {
+		from, to := &a.t2, &a.b2
+		if a.t1.Len() >= max(1, a.p) || a.t2.Len() == 0 {
+			from, to = &a.t1, &a.b1
+		}
+		k := from.Front().Value.(K)
+		switch ref, ok := referenced(k); {
+		case !ok:
+			delete(a.nodes, k)
+			from.Remove(from.Front())
+		case ref:
+			a.move(k, a.nodes[k], &a.t2)
+		default:
+			a.move(k, a.nodes[k], to)
+			return k, true
+		}
+	}
+	return
+}
+
+// evictARC removes the key chosen by the EvictARC policy from the full map and
+// reports whether a key is removed.
+func (m *MapOf[K, V]) evictARC() (evicted bool) {
+	for !evicted {
+		victim, ok := m.arc.victim(func(k K) (referenced, ok bool) {
+			s, d := m.locate(k)
+			return s.table().TakeReference(k, d)
+		})
+		if !ok {
+			return false
+		}
+		evicted = m.evictKey(victim)
+	}
+	return
+}
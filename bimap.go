@@ -0,0 +1,136 @@
+package cmap
+
+import "sync"
+
+// BiMapOf is a concurrent bidirectional map between keys of type K and values
+// of type V. Each key is associated with at most one value and vice versa, so
+// keys can be looked up by values as well.
+//
+// Both directions are updated together inside a single critical section. Load
+// and LoadByValue never block unless they happen to observe an update of the
+// pair they look up in progress.
+type BiMapOf[K, V comparable] struct {
+	mu       sync.Mutex
+	forward  *MapOf[K, V]
+	backward *MapOf[V, K]
+}
+
+// BiMap is a concurrent bidirectional map whose keys and values are of
+// arbitrary types.
+type BiMap = BiMapOf[interface{}, interface{}]
+
+// NewBiMap returns an empty bidirectional map whose keys and values are hashed
+// by the given function.
+func NewBiMap[H Hash](hasher func(key interface{}) H) (m *BiMap) {
+	return NewBiMapOf[interface{}, interface{}](hasher, hasher)
+}
+
+// NewBiMapOf returns an empty bidirectional map between keys of type K and
+// values of type V which are hashed by the given functions.
+func NewBiMapOf[K, V comparable, HK, HV Hash](keyHasher func(key K) HK, valueHasher func(value V) HV) (m *BiMapOf[K, V]) {
+	return &BiMapOf[K, V]{
+		forward:  NewMapOf[K, V](keyHasher),
+		backward: NewMapOf[V, K](valueHasher),
+	}
+}
+
+// Load returns the value associated with the given key and true if the key
+// exists. Otherwise, it returns the zero value and false.
+func (m *BiMapOf[K, V]) Load(key K) (value V, ok bool) {
+	if value, ok = m.forward.Load(key); !ok {
+		return
+	}
+	if k, ok := m.backward.Load(value); ok && k == key {
+		return value, true
+	}
+
+	// The pair is being updated.
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.forward.Load(key)
+}
+
+// LoadByValue returns the key associated with the given value and true if the
+// value exists. Otherwise, it returns the zero value and false.
+func (m *BiMapOf[K, V]) LoadByValue(value V) (key K, ok bool) {
+	if key, ok = m.backward.Load(value); !ok {
+		return
+	}
+	if v, ok := m.forward.Load(key); ok && v == value {
+		return key, true
+	}
+
+	// The pair is being updated.
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.backward.Load(value)
+}
+
+// Store associates the given key and value with each other. The previous value
+// of the key and the previous key of the value are removed.
+func (m *BiMapOf[K, V]) Store(key K, value V) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if v, ok := m.forward.Load(key); ok {
+		m.backward.Delete(v)
+	}
+	if k, ok := m.backward.Load(value); ok {
+		m.forward.Delete(k)
+	}
+	m.forward.Store(key, value)
+	m.backward.Store(value, key)
+}
+
+// LoadOrStore returns the existing value of the given key and true if the key
+// exists. Otherwise, it associates the key and the given value with each other
+// as Store does, and returns the value and false.
+func (m *BiMapOf[K, V]) LoadOrStore(key K, value V) (actual V, loaded bool) {
+	if actual, loaded = m.Load(key); loaded {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if actual, loaded = m.forward.Load(key); loaded {
+		return
+	}
+	if k, ok := m.backward.Load(value); ok {
+		m.forward.Delete(k)
+	}
+	m.forward.Store(key, value)
+	m.backward.Store(value, key)
+	return value, false
+}
+
+// Delete removes the given key and its associated value.
+func (m *BiMapOf[K, V]) Delete(key K) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if v, ok := m.forward.LoadAndDelete(key); ok {
+		m.backward.Delete(v)
+	}
+}
+
+// DeleteByValue removes the given value and its associated key.
+func (m *BiMapOf[K, V]) DeleteByValue(value V) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if k, ok := m.backward.LoadAndDelete(value); ok {
+		m.forward.Delete(k)
+	}
+}
+
+// Len returns the number of pairs in the map.
+func (m *BiMapOf[K, V]) Len() int {
+	return m.forward.Len()
+}
+
+// Range iteratively applies the given function to each key-value pair until
+// the function returns false. It gives the same guarantees as MapOf.Range.
+func (m *BiMapOf[K, V]) Range(f func(key K, value V) bool) {
+	m.forward.Range(f)
+}
@@ -0,0 +1,86 @@
+package cmap_test
+
+import (
+	"sync"
+	"testing"
+	"testing/quick"
+
+	"github.com/decillion/go-cmap"
+)
+
+type biMapCall struct {
+	Delete, ByValue bool
+	Key, Value      uint8
+}
+
+func TestBiMapMatchesBuiltInMaps(t *testing.T) {
+	f := func(calls []biMapCall) bool {
+		m := cmap.NewBiMapOf[uint64, uint64](cmap.Uint64Hasher, cmap.Uint64Hasher)
+		forward, backward := make(map[uint64]uint64), make(map[uint64]uint64)
+		for _, c := range calls {
+			k, v := uint64(c.Key%16), uint64(c.Value%16)
+			switch {
+			case !c.Delete:
+				if w, ok := forward[k]; ok {
+					delete(backward, w)
+				}
+				if l, ok := backward[v]; ok {
+					delete(forward, l)
+				}
+				forward[k], backward[v] = v, k
+				m.Store(k, v)
+			case c.ByValue:
+				if k, ok := backward[v]; ok {
+					delete(forward, k)
+					delete(backward, v)
+				}
+				m.DeleteByValue(v)
+			default:
+				if v, ok := forward[k]; ok {
+					delete(forward, k)
+					delete(backward, v)
+				}
+				m.Delete(k)
+			}
+		}
+		for i := uint64(0); i < 16; i++ {
+			v, ok := m.Load(i)
+			if w, found := forward[i]; ok != found || v != w {
+				return false
+			}
+			k, ok := m.LoadByValue(i)
+			if l, found := backward[i]; ok != found || k != l {
+				return false
+			}
+		}
+		return m.Len() == len(forward)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBiMapStaysConsistent(t *testing.T) {
+	const goroutines, stores = 8, 1 << 10
+	m := cmap.NewBiMap(cmap.DefaultHasher)
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < stores; i++ {
+				m.Store((g+i)%16, uint64(i%8))
+			}
+		}(g)
+	}
+	wg.Wait()
+	m.Range(func(k, v interface{}) bool {
+		if got, ok := m.LoadByValue(v); !ok || got != k {
+			t.Errorf("LoadByValue(%v) = %v, %v; want %v, true", v, got, ok, k)
+		}
+		return true
+	})
+	if m.Len() > 8 {
+		t.Errorf("Len() = %v; want at most 8", m.Len())
+	}
+}
@@ -2,114 +2,1403 @@
 package cmap
 
 import (
+	"context"
+	"fmt"
+	"iter"
+	"log/slog"
+	"math/bits"
+	"runtime"
 	"sync"
 	"sync/atomic"
+	"time"
+	"unsafe"
 
-	"github.com/OneOfOne/cmap/hashers"
+	"github.com/decillion/go-cmap/hashers"
 	"github.com/decillion/go-cmap/hmap"
 )
 
 const (
-	iniCapacity   = 1 << 4
-	minLoadFactor = 2
-	midLoadFactor = 4
-	maxLoadFactor = 6
-	maxBucketSize = 18
-	minMapSize    = iniCapacity * midLoadFactor
+	iniCapacity       = 1 << 4
+	minLoadFactor     = 2
+	midLoadFactor     = 4
+	maxLoadFactor     = 6
+	maxBucketSize     = 18
+	minMapSize        = iniCapacity * midLoadFactor
+	migrationStep     = 8       // the number of buckets migrated by an update
+	backgroundStep    = 64      // the number of buckets migrated by the migrator at once
+	parallelMigration = 1 << 16 // the size of a table migrated by GOMAXPROCS goroutines
 )
 
-// Map is a concurrent map.
-type Map struct {
-	mu       sync.Mutex
-	hm       atomic.Value // *hmap.Map
-	inResize int32
-	hasher   func(key interface{}) uint32
+// MapOf is a concurrent map from keys of type K to values of type V.
+//
+// Keys are distributed over shards by their hashes. Each shard has a table of
+// its own, which is resized independently of the others, and every bucket of
+// the table has a lock, so updates of keys in different buckets do not
+// contend.
+type MapOf[K comparable, V any] struct {
+	shards    []shard[K, V]
+	shift     uint // a shard is selected by the upper bits of a digest
+	hasher    func(key K) uint64
+	hasher128 func(key K) (hi, lo uint64)
+	equal     func(a, b K) bool
+	seed      uint64
+	opts      options
+	limit     *limiter             // nil unless the number of keys is limited
+	filter    *tinyLFU[K]          // nil unless the policy is EvictTinyLFU
+	arc       *arc[K]              // nil unless the policy is EvictARC
+	cost      func(value V) int64  // nil unless the limit is on the cost of values
+	onEvict   func(key K, value V) // nil unless WithOnEvict is given
+	hot       *hotKeys             // nil unless WithHotKeys is given
+	ages      *accessAges          // nil unless WithAccessAges is given
 }
 
+// shard is a part of a map. Shards are updated by different cores, so each of
+// them is padded to a multiple of the cache line size to avoid false sharing.
+// The size of shardState does not depend on K and V.
+type shard[K comparable, V any] struct {
+	shardState[K, V]
+	_ [cacheLineSize - unsafe.Sizeof(shardState[int, int]{})%cacheLineSize]byte
+}
+
+// shardState is the state of a shard. Its fields are of the atomic types,
+// which are aligned properly even in a slice on 32-bit platforms.
+//
+// Updates of the table lock only the buckets they touch, holding mu for
+// reading just to keep the table from being replaced by Clear or from being
+// resized after Pin, which hold mu for writing.
+type shardState[K comparable, V any] struct {
+	size      atomic.Int64 // the number of keys
+	mu        sync.RWMutex
+	hm        atomic.Value // *hmap.MapOf[K, V]
+	inResize  atomic.Int32 // the number of pins, which keep the table from being resized
+	migrating sync.Mutex   // held while the table is being migrated
+	migrator  bool         // whether a goroutine is migrating the table; guarded by migrating
+	started   time.Time    // when the migration of the table started; guarded by migrating
+
+	resizes    atomic.Int64 // the number of times the table has been replaced
+	lastResize atomic.Int64 // the duration of the last resize in nanoseconds
+	resizedAt  atomic.Int64 // the time of the last resize in Unix nanoseconds
+
+	hand atomic.Uint64 // the bucket at which EvictLRU looks for a key to evict
+
+	counters counters
+}
+
+// cacheLineSize is the size of a cache line on the common platforms.
+const cacheLineSize = 64
+
+// Map is a concurrent map whose keys and values are of arbitrary types.
+type Map = MapOf[interface{}, interface{}]
+
+// Hash is a constraint that permits the types of hashes, namely uint32 and
+// uint64. All the bits of a hash are used to select a bucket, so a 64-bit hash
+// function should be preferred for very large maps.
+type Hash = hmap.Hash
+
 // DefaultHasher is a hash function for a value of an arbitrary type. It is not
 // encouraged to use this function to values of composit types, because it is
-// slow on such values.
+// slow on such values. Use DeriveHasher for such types instead.
 func DefaultHasher(key interface{}) uint32 {
 	return hashers.TypeHasher32(key)
 }
 
+// DeriveHasher returns a hash function for keys of the same type as the given
+// example, which must be of a comparable type. A struct key is hashed field by
+// field according to a plan computed once, so the function is much faster than
+// DefaultHasher on composite keys. Keys of other types are hashed as by
+// DefaultHasher.
+func DeriveHasher(example interface{}) func(key interface{}) uint32 {
+	return hashers.DeriveHasher(example)
+}
+
 // NewMap returns an empty hash map whose keys are hashed by the given function.
-func NewMap(hasher func(key interface{}) uint32) (m *Map) {
-	m = &Map{hasher: hasher}
-	m.hm.Store(hmap.NewMap(iniCapacity, hasher))
+func NewMap[H Hash](hasher func(key interface{}) H, opts ...Option) (m *Map) {
+	return NewMapOf[interface{}, interface{}](hasher, opts...)
+}
+
+// NewMapFunc is like NewMap but keys are compared by the function equal instead
+// of the == operator, unless equal is nil. Keys equal to each other must have
+// the same hash. Since equal is used in place of ==, keys do not need to be
+// comparable as long as equal can compare them.
+func NewMapFunc[H Hash](hasher func(key interface{}) H, equal func(a, b interface{}) bool, opts ...Option) (m *Map) {
+	return NewMapOfFunc[interface{}, interface{}](hasher, equal, opts...)
+}
+
+// NewMapSharded is like NewMapFunc but the map has the given number of
+// shards. See NewMapOfSharded for the details.
+func NewMapSharded[H Hash](hasher func(key interface{}) H, equal func(a, b interface{}) bool, shards int, opts ...Option) (m *Map) {
+	return NewMapOfSharded[interface{}, interface{}](hasher, equal, shards, opts...)
+}
+
+// NewMapOf returns an empty hash map from keys of type K to values of type V
+// whose keys are hashed by the given function. The function must not retain
+// the keys given to it. Load then does not allocate even on a Map, where a key
+// is boxed into an interface at every call.
+func NewMapOf[K comparable, V any, H Hash](hasher func(key K) H, opts ...Option) (m *MapOf[K, V]) {
+	return NewMapOfFunc[K, V](hasher, nil, opts...)
+}
+
+// NewMapOfFunc is like NewMapOf but keys are compared by the function equal
+// instead of the == operator, unless equal is nil. Keys equal to each other
+// must have the same hash. Neither must equal retain the keys given to it.
+//
+// Every map mixes a random seed of its own into hashes, so an attacker who
+// knows the hash function still cannot tell which keys fall into the same
+// bucket. A hash function with a random seed such as SeededHasher should be
+// used as well if keys with the same hash can be crafted.
+func NewMapOfFunc[K comparable, V any, H Hash](hasher func(key K) H, equal func(a, b K) bool, opts ...Option) (m *MapOf[K, V]) {
+	return NewMapOfSharded[K, V](hasher, equal, 0, opts...)
+}
+
+// NewMapOfSharded is like NewMapOfFunc but the map has the given number of
+// shards, which is rounded up to a power of two. If shards is not positive,
+// the number is chosen from GOMAXPROCS. More shards let more updates proceed
+// in parallel at the cost of memory for their tables.
+func NewMapOfSharded[K comparable, V any, H Hash](hasher func(key K) H, equal func(a, b K) bool, shards int, opts ...Option) (m *MapOf[K, V]) {
+	m = &MapOf[K, V]{hasher: hmap.Widen(hasher), equal: equal, seed: newSeed(), opts: newOptions(opts)}
+	if f := m.opts.stringHasher; f != nil {
+		keys := m.hasher
+		m.hasher = func(key K) uint64 {
+			if s, ok := any(key).(string); ok {
+				return f(s)
+			}
+			return keys(key)
+		}
+	}
+	m.initLimit()
+	m.initShards(shards)
+	return
+}
+
+// NewMapWithCapacity is like NewMap but the map is made large enough to hold
+// the given number of keys without being resized. See Reserve for the details.
+func NewMapWithCapacity[H Hash](hasher func(key interface{}) H, keys int, opts ...Option) (m *Map) {
+	return NewMapOfWithCapacity[interface{}, interface{}](hasher, keys, opts...)
+}
+
+// NewMapOfWithCapacity is like NewMapOf but the map is made large enough to
+// hold the given number of keys without being resized. See Reserve for the
+// details.
+func NewMapOfWithCapacity[K comparable, V any, H Hash](hasher func(key K) H, keys int, opts ...Option) (m *MapOf[K, V]) {
+	m = NewMapOf[K, V](hasher, opts...)
+	m.Reserve(keys)
+	return
+}
+
+// NewMap128 returns an empty hash map whose keys are hashed to 128 bits by the
+// given function. See NewMapOf128 for the details.
+func NewMap128(hasher func(key interface{}) (hi, lo uint64), opts ...Option) (m *Map) {
+	return NewMapOf128[interface{}, interface{}](hasher, opts...)
+}
+
+// NewMapOf128 is like NewMapOf but keys are hashed to 128 bits. The upper 64
+// bits select a bucket and the lower 64 bits serve as a fingerprint that is
+// compared before keys. The mode costs 8 more bytes per key and pays off only
+// in maps of hundreds of millions of keys, where 64-bit hashes collide often
+// enough to make keys compared in vain.
+func NewMapOf128[K comparable, V any](hasher func(key K) (hi, lo uint64), opts ...Option) (m *MapOf[K, V]) {
+	m = &MapOf[K, V]{hasher128: hasher, seed: newSeed(), opts: newOptions(opts)}
+	m.initLimit()
+	m.initShards(0)
+	return
+}
+
+// defaultShards returns the number of shards of a map whose number is not
+// specified.
+func defaultShards() int {
+	return runtime.GOMAXPROCS(0)
+}
+
+// initShards makes the given number of shards rounded up to a power of two,
+// each of which has an empty table of the initial capacity.
+func (m *MapOf[K, V]) initShards(n int) {
+	if n <= 0 {
+		n = defaultShards()
+	}
+	logN := bits.Len(uint(n - 1))
+	m.shards = make([]shard[K, V], 1<<logN)
+	m.shift = uint(64 - logN)
+	for i := range m.shards {
+		m.shards[i].hm.Store(m.newTable(m.opts.iniCapacity))
+	}
+}
+
+// newTable returns an empty hmap.MapOf with the given number of buckets that
+// hashes and compares keys as the map does.
+func (m *MapOf[K, V]) newTable(capacity uint) (h *hmap.MapOf[K, V]) {
+	if m.hasher128 != nil {
+		h = hmap.NewMapOf128[K, V](capacity, m.hasher128, m.equal, m.seed)
+	} else {
+		h = hmap.NewMapOfSeed[K, V](capacity, m.hasher, m.equal, m.seed)
+	}
+	if m.opts.maxKeys != 0 && m.opts.policy >= EvictLRU { // the policies built on marks
+		h.MarkReferences()
+	}
 	return
 }
 
+// table returns the current table of the shard.
+func (s *shard[K, V]) table() *hmap.MapOf[K, V] {
+	return s.hm.Load().(*hmap.MapOf[K, V])
+}
+
+// locate returns the shard of the given key and its digest. Since all the
+// tables of the map hash keys in the same way, the key is hashed only once.
+// Every operation on a key goes through locate, so it samples the key for
+// TopKeys as well.
+func (m *MapOf[K, V]) locate(key K) (s *shard[K, V], d hmap.Digest) {
+	d = m.shards[0].table().Digest(key)
+	if m.hot != nil {
+		m.hot.use(d)
+	}
+	return &m.shards[d.Uint64()>>m.shift], d
+}
+
 // Load returns the value associated with the given key and true if the key
-// exists. Otherwise, it returns nil and false.
-func (m *Map) Load(key interface{}) (value interface{}, ok bool) {
-	hm := m.hm.Load().(*hmap.Map)
-	value, ok = hm.Load(key)
+// exists. Otherwise, it returns the zero value and false.
+func (m *MapOf[K, V]) Load(key K) (value V, ok bool) {
+	h := m.opts.hook
+	if h == nil {
+		return m.load(key)
+	}
+	h.Before(OpLoad)
+	start := time.Now()
+	value, ok = m.load(key)
+	h.After(OpLoad, ok, time.Since(start))
+	return
+}
+
+// load is Load without the hook of the map.
+func (m *MapOf[K, V]) load(key K) (value V, ok bool) {
+	s, d := m.locate(key)
+	if m.filter != nil {
+		m.filter.sketch.increment(d)
+	}
+	value, ok = s.table().LoadHashed(key, d)
+	if ok && m.ages != nil {
+		m.ages.load(d)
+	}
+	if m.opts.counters {
+		if ok {
+			s.counters.hits.Add(1)
+		} else {
+			s.counters.misses.Add(1)
+		}
+	}
+	return
+}
+
+// LoadMany looks up the given keys at once, and sets the value of each key and
+// whether it exists to values and found at the index of the key in keys, as if
+// Load were called on the keys one by one. The keys are looked up in a single
+// loop, which is cheaper than calling Load on each of them, and the results
+// are written to the slices given, so that LoadMany does not allocate; it
+// panics if values or found is shorter than keys. Its lookups are counted for
+// Stats and the like, but are not observed by the hook of the map.
+func (m *MapOf[K, V]) LoadMany(keys []K, values []V, found []bool) {
+	values, found = values[:len(keys)], found[:len(keys)]
+	if m.filter != nil || m.hot != nil || m.ages != nil || m.opts.counters {
+		for i, k := range keys {
+			values[i], found[i] = m.load(k)
+		}
+		return
+	}
+	h := m.shards[0].table() // whose digests are those of every table
+	for i, k := range keys {
+		d := h.Digest(k)
+		values[i], found[i] = m.shards[d.Uint64()>>m.shift].table().LoadHashed(k, d)
+	}
+}
+
+// Store sets the given value to the given key. In a full map with a limit, a
+// new key is not stored unless another key is evicted. See WithLimit and
+// WithCostLimit.
+func (m *MapOf[K, V]) Store(key K, value V) {
+	h := m.opts.hook
+	if h == nil {
+		m.store(key, value)
+		return
+	}
+	h.Before(OpStore)
+	start := time.Now()
+	m.store(key, value)
+	h.After(OpStore, true, time.Since(start))
+}
+
+// store is Store without the hook of the map.
+func (m *MapOf[K, V]) store(key K, value V) {
+	s, d := m.locate(key)
+	if m.limit != nil {
+		if _, stored, _ := m.computeHashed(s, key, d, func(V, bool) (V, bool) {
+			return value, true
+		}); stored {
+			m.stored(s, d)
+		}
+		return
+	}
+	s.mu.RLock()
+	if _, loaded := s.table().SwapHashed(key, d, value); !loaded {
+		s.size.Add(1)
+	}
+	m.resizeIfNeeded(s)
+	s.mu.RUnlock()
+	m.stored(s, d)
+}
+
+// LoadOrStore returns the existing value for the given key and true if the key
+// exists. Otherwise, it stores the given value and returns it and false.
+func (m *MapOf[K, V]) LoadOrStore(key K, value V) (actual V, loaded bool) {
+	s, d := m.locate(key)
+	if actual, loaded = s.table().LoadHashed(key, d); loaded {
+		return
+	}
+	if m.limit != nil {
+		var stored bool
+		actual, stored, loaded = m.computeHashed(s, key, d, func(old V, loaded bool) (V, bool) {
+			if loaded {
+				return old, true
+			}
+			return value, true
+		})
+		if !stored {
+			actual = *new(V)
+		} else if !loaded {
+			m.stored(s, d)
+		}
+		return
+	}
+
+	s.mu.RLock()
+	actual, loaded = s.table().LoadOrStoreHashed(key, d, value)
+	if !loaded {
+		s.size.Add(1)
+		m.resizeIfNeeded(s)
+	}
+	s.mu.RUnlock()
+	if !loaded {
+		m.stored(s, d)
+	}
 	return
 }
 
-// Store sets the given value to the given key.
-func (m *Map) Store(key, value interface{}) {
-	m.mu.Lock()
-	hm := m.hm.Load().(*hmap.Map)
-	hm.Store(key, value)
-	m.resizeIfNeeded()
-	m.mu.Unlock()
+// Swap sets the given value to the given key and returns the previous value
+// and true if the key exists. Otherwise, it returns the zero value and false.
+func (m *MapOf[K, V]) Swap(key K, value V) (previous V, loaded bool) {
+	s, d := m.locate(key)
+	if m.limit != nil {
+		var stored bool
+		if _, stored, loaded = m.computeHashed(s, key, d, func(old V, _ bool) (V, bool) {
+			previous = old
+			return value, true
+		}); stored {
+			m.stored(s, d)
+		}
+		return
+	}
+	s.mu.RLock()
+	previous, loaded = s.table().SwapHashed(key, d, value)
+	if !loaded {
+		s.size.Add(1)
+		m.resizeIfNeeded(s)
+	}
+	s.mu.RUnlock()
+	m.stored(s, d)
+	return
+}
+
+// CompareAndSwap sets the given new value to the given key if the key exists
+// and its value is equal to old. It reports whether the value was replaced.
+// The old value must be of a comparable type.
+func (m *MapOf[K, V]) CompareAndSwap(key K, old, new V) (swapped bool) {
+	s, d := m.locate(key)
+	if v, ok := s.table().LoadHashed(key, d); !ok || any(v) != any(old) {
+		return false
+	}
+	if m.cost != nil {
+		// The cost of the new value may not fit in the budget.
+		_, keep, _ := m.computeHashed(s, key, d, func(v V, ok bool) (V, bool) {
+			if swapped = ok && any(v) == any(old); swapped {
+				return new, true
+			}
+			return v, ok
+		})
+		if swapped = swapped && keep; swapped {
+			m.stored(s, d)
+		}
+		return
+	}
+
+	s.mu.RLock()
+	swapped = s.table().CompareAndSwapHashed(key, d, old, new)
+	s.mu.RUnlock()
+	if swapped {
+		m.stored(s, d)
+	}
+	return
 }
 
 // Delete logically removes the given key and its associated value.
-func (m *Map) Delete(key interface{}) {
-	m.mu.Lock()
-	hm := m.hm.Load().(*hmap.Map)
-	hm.Delete(key)
-	m.resizeIfNeeded()
-	m.mu.Unlock()
+func (m *MapOf[K, V]) Delete(key K) {
+	h := m.opts.hook
+	if h == nil {
+		m.delete(key)
+		return
+	}
+	h.Before(OpDelete)
+	start := time.Now()
+	loaded := m.delete(key)
+	h.After(OpDelete, loaded, time.Since(start))
+}
+
+// delete is Delete without the hook of the map, which reports whether the key
+// existed.
+func (m *MapOf[K, V]) delete(key K) (loaded bool) {
+	s, d := m.locate(key)
+	s.mu.RLock()
+	var old V
+	if old, loaded = s.table().LoadAndDeleteHashed(key, d); loaded {
+		m.removed(s, key, old)
+	}
+	m.resizeIfNeeded(s)
+	s.mu.RUnlock()
+	return
+}
+
+// LoadAndDelete logically removes the given key and returns its associated
+// value and true if the key exists. Otherwise, it returns the zero value and false.
+func (m *MapOf[K, V]) LoadAndDelete(key K) (value V, loaded bool) {
+	s, d := m.locate(key)
+	if value, loaded = s.table().LoadHashed(key, d); !loaded {
+		return
+	}
+
+	s.mu.RLock()
+	value, loaded = s.table().LoadAndDeleteHashed(key, d)
+	if loaded {
+		m.removed(s, key, value)
+		m.resizeIfNeeded(s)
+	}
+	s.mu.RUnlock()
+	return
+}
+
+// CompareAndDelete logically removes the given key if the key exists and its
+// value is equal to old. It reports whether the key was removed. The old value
+// must be of a comparable type.
+func (m *MapOf[K, V]) CompareAndDelete(key K, old V) (removed bool) {
+	s, d := m.locate(key)
+	if v, ok := s.table().LoadHashed(key, d); !ok || any(v) != any(old) {
+		return false
+	}
+
+	s.mu.RLock()
+	if removed = s.table().CompareAndDeleteHashed(key, d, old); removed {
+		m.removed(s, key, old)
+		m.resizeIfNeeded(s)
+	}
+	s.mu.RUnlock()
+	return
+}
+
+// TryStore is like Store but gives up instead of waiting if the key cannot be
+// updated immediately, namely if its bucket is being migrated by a resize or
+// updated by another operation, or if the map is locked by Clear, Clone, or
+// Pin, or if the map with a limit is full. It reports whether the value was
+// stored.
+func (m *MapOf[K, V]) TryStore(key K, value V) (stored bool) {
+	s, d := m.locate(key)
+	if !s.mu.TryRLock() {
+		return false
+	}
+	defer s.mu.RUnlock()
+	if m.limit == nil {
+		loaded, stored := s.table().TryStoreHashed(key, d, value)
+		if stored && !loaded {
+			s.size.Add(1)
+			m.resizeIfNeeded(s)
+		}
+		if stored {
+			m.stored(s, d)
+		}
+		return stored
+	}
+
+	// The room for the whole cost of value is reserved, since the cost of the
+	// previous value is known only once it is replaced.
+	cost := m.costOf(value)
+	if !m.limit.reserve(cost) {
+		return false
+	}
+	previous, loaded, stored := s.table().TrySwapHashed(key, d, value)
+	switch {
+	case !stored:
+		m.limit.release(cost)
+		return
+	case loaded:
+		m.limit.release(m.costOf(previous))
+	default:
+		m.added(s, key)
+		m.resizeIfNeeded(s)
+	}
+	m.stored(s, d)
+	return
+}
+
+// TryDelete is like Delete but gives up instead of waiting in the same cases
+// as TryStore. It reports whether the key is removed, which is true also if
+// the key did not exist.
+func (m *MapOf[K, V]) TryDelete(key K) (removed bool) {
+	s, d := m.locate(key)
+	if !s.mu.TryRLock() {
+		return false
+	}
+	defer s.mu.RUnlock()
+
+	old, loaded, removed := s.table().TryLoadAndDeleteHashed(key, d)
+	if loaded {
+		m.removed(s, key, old)
+		m.resizeIfNeeded(s)
+	}
+	return
+}
+
+// Compute atomically replaces the value of the given key with the result of f
+// applied to its current value, which is the zero value if loaded is false,
+// or removes the key if f returns true for delete. It returns the value of the
+// key afterwards and whether the key exists. No other update of the key can
+// happen between the call to f and the update by its result, so Compute is a
+// read-modify-write of the key without a lock of its own. Since f is called
+// while the bucket of the key is locked, it must be quick and must not use the
+// map. In a full map with a limit, a new key is not stored unless another key
+// is evicted, in which case f may be called again.
+func (m *MapOf[K, V]) Compute(key K, f func(old V, loaded bool) (new V, delete bool)) (value V, ok bool) {
+	s, d := m.locate(key)
+	value, ok, _ = m.computeHashed(s, key, d, func(old V, loaded bool) (V, bool) {
+		new, delete := f(old, loaded)
+		return new, !delete
+	})
+	if ok {
+		m.stored(s, d)
+	}
+	return
+}
+
+// ComputeIfAbsent returns the existing value for the given key and true if
+// the key exists. Otherwise, it stores the value returned by newValue and
+// returns it and false. Unlike LoadOrStore, the value is constructed only if
+// the key is missing, and newValue is called at most once for a key even if
+// the key is stored by many goroutines at once, the others waiting for it and
+// loading its value. Since newValue is called while the bucket of the key is
+// locked, it must not use the map, and it delays the updates of the other keys
+// of the bucket; a value that takes long to construct, such as one read from
+// a database, is better loaded by a LoadingMapOf. In a full map with a limit,
+// the value is not stored unless another key is evicted, in which case the
+// zero value and false are returned.
+func (m *MapOf[K, V]) ComputeIfAbsent(key K, newValue func() V) (actual V, loaded bool) {
+	s, d := m.locate(key)
+	if actual, loaded = s.table().LoadHashed(key, d); loaded {
+		return
+	}
+	// The value is kept for f to be applied again after an eviction.
+	var value V
+	constructed := false
+	actual, stored, loaded := m.computeHashed(s, key, d, func(old V, loaded bool) (V, bool) {
+		if loaded {
+			return old, true
+		}
+		if !constructed {
+			value, constructed = newValue(), true
+		}
+		return value, true
+	})
+	if !stored {
+		actual = *new(V)
+	} else if !loaded {
+		m.stored(s, d)
+	}
+	return
+}
+
+// ComputeIfPresent atomically replaces the value of the given key with the
+// result of f applied to it if the key exists, or removes the key if f returns
+// false for keep. It returns the value of the key afterwards and whether the
+// key exists, which is false without calling f if the key is missing. No other
+// update of the key can happen between the call to f and the update by its
+// result, so an entry is refreshed or dropped only if it exists, while a key
+// deleted meanwhile is not stored again. Since f is called while the bucket of
+// the key is locked, it must be quick and must not use the map. In a map with
+// a cost limit, a new value that does not fit in the budget leaves the old one
+// as it is, and the zero value and false are returned, unless another key is
+// evicted to make room, in which case f may be called again.
+func (m *MapOf[K, V]) ComputeIfPresent(key K, f func(old V) (new V, keep bool)) (value V, ok bool) {
+	s, d := m.locate(key)
+	if _, ok = s.table().LoadHashed(key, d); !ok {
+		return
+	}
+	value, ok, _ = m.computeHashed(s, key, d, func(old V, loaded bool) (V, bool) {
+		if !loaded {
+			return old, false
+		}
+		return f(old)
+	})
+	if ok {
+		m.stored(s, d)
+	}
+	return
+}
+
+// Merge stores the given value to the given key if the key is missing, or
+// otherwise atomically replaces the existing value with the result of f
+// applied to it and the given value, as in merging a partial aggregate into
+// the running one. It returns the value of the key afterwards and true. No
+// update of the key can happen between the call to f and the update by its
+// result, so no merge is lost among concurrent writers. Since f is called
+// while the bucket of the key is locked, it must be quick and must not use the
+// map. In a full map with a limit, a new key is not stored unless another key
+// is evicted, and the zero value and false are returned.
+func (m *MapOf[K, V]) Merge(key K, value V, f func(existing, incoming V) V) (merged V, ok bool) {
+	s, d := m.locate(key)
+	merged, ok, _ = m.computeHashed(s, key, d, func(old V, loaded bool) (V, bool) {
+		if !loaded {
+			return value, true
+		}
+		return f(old, value), true
+	})
+	if ok {
+		m.stored(s, d)
+	}
+	return
+}
+
+// Update replaces the value of the given key with the result of f applied to
+// it if the key exists, by calling CompareAndSwap until the value is not
+// updated by anyone else between the call to f and the swap. It returns the
+// new value and true, or the zero value and false if the key is missing or is
+// removed in the meantime. Unlike ComputeIfPresent, f is called without
+// locking the map, so it may take long and may use the map, but it may be
+// called many times on the values of a key updated often, and it must not
+// change the value passed to it. The values must be of a comparable type. In
+// a map with a cost limit, a new value that does not fit in the budget leaves
+// the old one as it is, and false is returned. False is also returned if the
+// swap fails while the value is not changed, as it does forever for a value
+// that is not equal to itself, such as a NaN. The lookups of Update are not
+// counted as loads by the hooks, the counters or the access ages.
+func (m *MapOf[K, V]) Update(key K, f func(old V) (new V)) (new V, ok bool) {
+	s, d := m.locate(key)
+	old, ok := s.table().LoadHashed(key, d)
+	for ok {
+		new = f(old)
+		if m.CompareAndSwap(key, old, new) {
+			return new, true
+		}
+		var current V
+		if current, ok = s.table().LoadHashed(key, d); ok && (any(current) == any(old) || any(current) != any(current)) {
+			break // the new value does not fit, or the value never compares equal
+		}
+		old = current
+	}
+	var zero V
+	return zero, false
+}
+
+// AddInt64 atomically adds delta to the value of the given key, which is an
+// int64, and returns the new value and true. A missing key is stored with the
+// value delta. It returns zero and false, leaving the map as it is, if the
+// value of the key is not an int64, or if the values of the map cannot be,
+// which they can only if their type is int64 or an interface as in a Map, as
+// well as in a full map with a limit that does not store a new key. The
+// bucket of the key is locked for the addition, as it is for the counters of a
+// CounterMapOf.
+func (m *MapOf[K, V]) AddInt64(key K, delta int64) (new int64, ok bool) {
+	return add(m, key, delta)
+}
+
+// AddFloat64 is like AddInt64 but adds to a float64, as in summing the samples
+// of a metric by key.
+func (m *MapOf[K, V]) AddFloat64(key K, delta float64) (new float64, ok bool) {
+	return add(m, key, delta)
+}
+
+// add is AddInt64 and AddFloat64 for values of type N.
+func add[K comparable, V any, N int64 | float64](m *MapOf[K, V], key K, delta N) (new N, ok bool) {
+	s, d := m.locate(key)
+	var isNumber bool
+	_, ok, _ = m.computeHashed(s, key, d, func(old V, loaded bool) (V, bool) {
+		var n N
+		if n, isNumber = any(old).(N); loaded && !isNumber {
+			return old, true
+		}
+		v, fits := any(n + delta).(V)
+		if isNumber = fits; !fits {
+			return old, loaded
+		}
+		new = n + delta
+		return v, true
+	})
+	if ok = ok && isNumber; !ok {
+		return 0, false
+	}
+	m.stored(s, d)
+	return
+}
+
+// compute atomically replaces the value of the given key with the result of f
+// applied to the current value, which is the zero value if loaded is false. If
+// f returns false for keep, the key is removed instead. It returns the result
+// of f. Since f is called while the bucket of the key is locked, it must not
+// update the map.
+func (m *MapOf[K, V]) compute(key K, f func(old V, loaded bool) (new V, keep bool)) (new V, keep bool) {
+	s, d := m.locate(key)
+	new, keep, _ = m.computeHashed(s, key, d, f)
+	return
+}
+
+// computeHashed is compute on the key of the given shard and digest, which
+// also reports whether the key existed. In a map with a limit, room for a new
+// key, or for the change of the cost of a value, is reserved while its bucket
+// is locked. If there is no room, the map is left as it is and keep is false,
+// unless a key is evicted to make room, in which case f is applied again.
+//
+// The table applies f again if the value it gives loses a race with a
+// lock-free update such as TryStore, so the room reserved by the previous
+// application is given back first, and the room freed by the replacement is
+// given back only once it takes effect.
+func (m *MapOf[K, V]) computeHashed(s *shard[K, V], key K, d hmap.Digest, f func(old V, loaded bool) (new V, keep bool)) (new V, keep, loaded bool) {
+	if m.filter != nil {
+		m.filter.sketch.increment(d)
+	}
+	for {
+		full := false
+		var previous V
+		var reserved, freed int64
+		s.mu.RLock()
+		new, keep = s.table().ComputeHashed(key, d, func(old V, ok bool) (V, bool) {
+			if reserved > 0 {
+				m.limit.release(reserved)
+			}
+			previous, loaded, full = old, ok, false
+			new, keep := f(old, ok)
+			if m.limit != nil {
+				var room bool
+				if reserved, freed, room = m.charge(old, ok, new, keep); !room {
+					full = true
+					return old, ok
+				}
+			}
+			return new, keep
+		})
+		if freed > 0 {
+			m.limit.release(freed)
+		}
+		switch {
+		case keep && !loaded:
+			m.added(s, key)
+		case !keep && loaded:
+			m.removed(s, key, previous)
+		}
+		m.resizeIfNeeded(s)
+		s.mu.RUnlock()
+
+		if !full {
+			return
+		}
+		var zero V
+		if new, keep = zero, false; !m.evict(key, d) {
+			return
+		}
+	}
+}
+
+// lockAll locks all the shards in order.
+func (m *MapOf[K, V]) lockAll() {
+	for i := range m.shards {
+		m.shards[i].mu.Lock()
+	}
+}
+
+// unlockAll unlocks all the shards.
+func (m *MapOf[K, V]) unlockAll() {
+	for i := range m.shards {
+		m.shards[i].mu.Unlock()
+	}
+}
+
+// Clear removes all keys from the map. It replaces the underlying tables with
+// empty ones of the initial capacity instead of deleting keys one by one, so
+// no logically removed keys are left behind.
+func (m *MapOf[K, V]) Clear() {
+	m.lockAll()
+	for i := range m.shards {
+		s := &m.shards[i]
+		if m.opts.hook != nil && s.table().Next() != nil {
+			m.opts.hook.After(OpResize, false, time.Since(s.started))
+		}
+		s.hm.Store(m.newTable(m.opts.iniCapacity))
+		s.size.Store(0)
+	}
+	if m.limit != nil {
+		m.limit.count.Store(0)
+	}
+	if m.arc != nil {
+		m.arc.reset()
+	}
+	m.unlockAll()
+}
+
+// Clone returns a new map that contains the same key-value pairs as the map.
+// The pairs are copied while all the shards are locked, so the clone reflects
+// a consistent state of the map.
+func (m *MapOf[K, V]) Clone() *MapOf[K, V] {
+	return m.CloneFunc(nil)
+}
+
+// CloneFunc is like Clone but sets copyValue(v) instead of v to each key of
+// the clone, so that values can be copied deeply. If copyValue is nil, values
+// are shared between the map and the clone. Since copyValue is called inside
+// the critical section, it must not update the map.
+func (m *MapOf[K, V]) CloneFunc(copyValue func(value V) V) (clone *MapOf[K, V]) {
+	m.lockAll()
+	defer m.unlockAll()
+
+	clone = &MapOf[K, V]{shift: m.shift, hasher: m.hasher, hasher128: m.hasher128, equal: m.equal, seed: m.seed, opts: m.opts}
+	clone.shards = make([]shard[K, V], len(m.shards))
+	for i := range m.shards {
+		oldMap := m.shards[i].table()
+		capacity, _ := oldMap.StatBuckets()
+		clone.shards[i].hm.Store(oldMap.CopyFunc(capacity, copyValue))
+		clone.shards[i].size.Store(m.shards[i].size.Load())
+	}
+	clone.initLimit()
+	if clone.limit == nil {
+		return
+	}
+	var count int64
+	for i := range clone.shards {
+		clone.shards[i].table().Range(func(k K, v V) bool {
+			count += clone.costOf(v)
+			if clone.arc != nil {
+				clone.arc.admit(k)
+			}
+			return true
+		})
+	}
+	clone.limit.count.Store(count)
+	return
+}
+
+// Compact rebuilds the table of every shard with just enough buckets for the
+// keys it holds, dropping logically removed keys at once instead of waiting
+// for a later update to find too many of them. It is meant to be called after
+// a mass deletion to give the memory back. Like Clone, it locks all the shards
+// and copies every key.
+func (m *MapOf[K, V]) Compact() {
+	m.lockAll()
+	defer m.unlockAll()
+
+	for i := range m.shards {
+		s := &m.shards[i]
+		start, h := time.Now(), s.table()
+		m.beforeResize(h)
+		m.replaceTable(s, h, h.Copy(m.opts.fitCapacity(uint(s.size.Load()))), start)
+	}
+}
+
+// StoreAll sets the values of the given map to their keys, as if Store were
+// called on them one by one, but grows the tables of the map once for all the
+// new keys and stores the keys while all the shards are locked, instead of
+// locking a shard for every key. Concurrent updates of the map wait for
+// StoreAll, while loads see the keys stored so far. In a map with a limit, the
+// keys are stored one by one, since some of them may not fit. Like LoadMany,
+// StoreAll is not observed by the hook of the map.
+func (m *MapOf[K, V]) StoreAll(src map[K]V) {
+	if len(src) == 0 {
+		return
+	}
+	if m.limit != nil {
+		for k, v := range src {
+			m.store(k, v)
+		}
+		return
+	}
+	m.lockAll()
+	m.reserve(m.Len() + len(src))
+	for k, v := range src {
+		s, d := m.locate(k)
+		if _, loaded := s.table().SwapHashed(k, d, v); !loaded {
+			s.size.Add(1)
+		}
+		m.stored(s, d)
+	}
+	m.unlockAll()
+	// The keys may be spread so unevenly that some shards still have to grow.
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mu.RLock()
+		m.resizeIfNeeded(s)
+		s.mu.RUnlock()
+	}
+}
+
+// Reserve grows the tables of the map so that the map can hold the given
+// number of keys in total without being resized, so that inserting a large
+// number of keys does not cascade through intermediate resizes. The tables are
+// never shrunk by Reserve, but may be shrunk later if many keys are deleted.
+// Like Clone, it locks all the shards and copies every key, so it is best
+// called while the map is still empty.
+func (m *MapOf[K, V]) Reserve(keys int) {
+	if keys <= 0 {
+		return
+	}
+	m.lockAll()
+	defer m.unlockAll()
+	m.reserve(keys)
+}
+
+// reserve is Reserve while all the shards are locked.
+func (m *MapOf[K, V]) reserve(keys int) {
+	perShard := (uint(keys) + uint(len(m.shards)) - 1) / uint(len(m.shards))
+	capacity := m.opts.reserveCapacity(perShard)
+	for i := range m.shards {
+		s := &m.shards[i]
+		start, h := time.Now(), s.table()
+		if buckets, _ := h.StatBuckets(); buckets < capacity {
+			m.beforeResize(h)
+			m.replaceTable(s, h, h.Copy(capacity), start)
+		}
+	}
+}
+
+// Len returns the number of keys in the map. It does not count logically
+// removed keys and runs in time proportional to the number of shards.
+func (m *MapOf[K, V]) Len() (n int) {
+	for i := range m.shards {
+		n += int(m.shards[i].size.Load())
+	}
+	return
+}
+
+// SizeBytes returns an estimate of the number of bytes the map occupies on the
+// heap, computed from the numbers of buckets and keys and the sizes of K and V.
+// The memory referenced by keys and values, such as the contents of strings,
+// is not counted. Use SizeBytesFunc to count it.
+func (m *MapOf[K, V]) SizeBytes() (size uint64) {
+	return m.SizeBytesFunc(nil)
+}
+
+// SizeBytesFunc is like SizeBytes but adds cost(value) for every value in the
+// map unless cost is nil, so that the memory referenced by values can be
+// counted. Since the values are visited by Range, it takes time proportional
+// to the number of keys.
+func (m *MapOf[K, V]) SizeBytesFunc(cost func(value V) uint64) (size uint64) {
+	size = uint64(unsafe.Sizeof(*m)) + uint64(len(m.shards))*uint64(unsafe.Sizeof(m.shards[0]))
+	for i := range m.shards {
+		size += m.shards[i].table().SizeBytes()
+	}
+	if cost != nil {
+		m.Range(func(_ K, v V) bool {
+			size += cost(v)
+			return true
+		})
+	}
+	return
+}
+
+// Pin keeps the tables of the map from being resized until Unpin is called,
+// so that a protocol of several reads can run against stable tables. Pins
+// nest: the tables are resized again once every Pin is matched by
+// Unpin. A resize in progress is suspended rather than completed, which does
+// not affect the results of operations. The map can still be updated while
+// pinned, but may get slower as its tables fill up. Clear, Compact, and
+// Reserve replace the tables regardless of pins.
+func (m *MapOf[K, V]) Pin() {
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mu.Lock() // To ensure that no other process concurrently resizes the shard.
+		s.inResize.Add(1)
+		s.mu.Unlock()
+	}
+}
+
+// settle returns the table of the shard to be visited by Range. A table being
+// resized can be visited correctly only if the resize does not proceed during
+// the visit, so a resize in progress is completed first unless the shard is
+// pinned.
+func (m *MapOf[K, V]) settle(s *shard[K, V]) *hmap.MapOf[K, V] {
+	if h := s.table(); h.Next() == nil || s.inResize.Load() != 0 {
+		return h
+	}
+	s.mu.RLock()
+	s.migrating.Lock()
+	if h := s.table(); h.Next() != nil && s.inResize.Load() == 0 {
+		buckets, _ := h.StatBuckets()
+		h.Migrate(buckets)
+		m.replaceTable(s, h, h.Next(), s.started)
+	}
+	s.migrating.Unlock()
+	s.mu.RUnlock()
+	return s.table()
+}
+
+// Unpin releases a pin made by Pin.
+func (m *MapOf[K, V]) Unpin() {
+	for i := range m.shards {
+		m.shards[i].inResize.Add(-1)
+	}
 }
 
 // Range iteratively applies the given function to each key-value pair until
 // the function returns false.
-func (m *Map) Range(f func(key, value interface{}) bool) {
-	m.mu.Lock() // To ensure that no other process concurrently resizes the map.
-	atomic.AddInt32(&m.inResize, 1)
-	m.mu.Unlock()
+//
+// Range visits each key at most once and visits exactly once every key that
+// exists during the whole call. The function may call any method of the map,
+// including Store and Delete, without deadlock; such updates are subject to
+// the same guarantee. Range does not keep the map from being resized: it
+// visits the table each shard has when Range reaches the shard, following the
+// keys migrated to a new table by a resize. A resize in progress at that time
+// is completed first unless the map is pinned. If the table is replaced by
+// Clear, Compact, or Reserve during Range, the keys are visited as they were
+// at the replacement.
+func (m *MapOf[K, V]) Range(f func(key K, value V) bool) {
+	if m.opts.randomOrder {
+		m.rangeRandomly(f)
+		return
+	}
+	for i := range m.shards {
+		stopped := false
+		m.settle(&m.shards[i]).Range(func(k K, v V) bool {
+			stopped = !f(k, v)
+			return !stopped
+		})
+		if stopped {
+			return
+		}
+	}
+}
+
+// rangeRandomly is like Range but starts at a random shard and at a random
+// bucket of each table, wrapping around to visit the rest.
+func (m *MapOf[K, V]) rangeRandomly(f func(key K, value V) bool) {
+	stopped := false
+	g := func(k K, v V) bool {
+		stopped = !f(k, v)
+		return !stopped
+	}
+	first := randomOffset(len(m.shards))
+	for i := range m.shards {
+		h := m.settle(&m.shards[(first+i)%len(m.shards)])
+		buckets, _ := h.StatBuckets()
+		start := randomOffset(int(buckets))
+		for b := 0; b < int(buckets); b++ {
+			if h.RangePart((start+b)%int(buckets), int(buckets), g); stopped {
+				return
+			}
+		}
+	}
+}
+
+// RangeContext is like Range but stops early if the given context is done,
+// which is checked before every bucket, and returns the error of the context
+// in that case. Otherwise, it returns nil.
+func (m *MapOf[K, V]) RangeContext(ctx context.Context, f func(key K, value V) bool) error {
+	stopped := false
+	g := func(k K, v V) bool {
+		stopped = !f(k, v)
+		return !stopped
+	}
+	for i := range m.shards {
+		h := m.settle(&m.shards[i])
+		buckets, _ := h.StatBuckets()
+		for b := 0; b < int(buckets); b++ {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+			if h.RangePart(b, int(buckets), g); stopped {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// RangeBatch is like Range but applies f to slices of at most n key-value
+// pairs at a time, which saves the cost of a call of f for every pair. Every
+// slice but the last one holds n pairs. The slice is reused by the next call
+// of f, so f must not retain it. It panics if n is not positive.
+func (m *MapOf[K, V]) RangeBatch(n int, f func(entries []Entry[K, V]) bool) {
+	if n <= 0 {
+		panic(fmt.Sprintf("cmap: RangeBatch(%v) out of range", n))
+	}
+	batch := make([]Entry[K, V], 0, n)
+	for i := range m.shards {
+		var stopped bool
+		if batch, stopped = m.settle(&m.shards[i]).RangeBatch(batch, f); stopped {
+			return
+		}
+	}
+	if len(batch) > 0 {
+		f(batch)
+	}
+}
+
+// RangeParallel is like Range but applies f to the key-value pairs from the
+// given number of goroutines at a time, each of which visits a part of the
+// buckets of every shard in turn. If workers is not positive, GOMAXPROCS
+// goroutines are used. Since f is called concurrently, it must be safe for
+// concurrent use. Once f returns false, the goroutines stop after the calls
+// of f in progress, and RangeParallel returns when all of them have stopped.
+func (m *MapOf[K, V]) RangeParallel(workers int, f func(key K, value V) bool) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	tables := make([]*hmap.MapOf[K, V], len(m.shards))
+	for i := range m.shards {
+		tables[i] = m.settle(&m.shards[i])
+	}
+
+	// Each shard is split into as many parts as there are workers, which take
+	// the parts one by one so that a large shard does not hold up the others.
+	var next atomic.Int64
+	var stopped atomic.Bool
+	parts := len(m.shards) * workers
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !stopped.Load() {
+				i := int(next.Add(1) - 1)
+				if i >= parts {
+					return
+				}
+				tables[i/workers].RangePart(i%workers, workers, func(k K, v V) bool {
+					if stopped.Load() || !f(k, v) {
+						stopped.Store(true)
+						return false
+					}
+					return true
+				})
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// RangeSnapshot is like Range but applies f to the key-value pairs the map
+// contains at a single point in time, so that f sees a consistent state of
+// the map regardless of concurrent updates. The pairs are copied while all the
+// shards are locked, as Clone does, and f is applied after they are unlocked,
+// so f may update the map without affecting the pairs it is given.
+func (m *MapOf[K, V]) RangeSnapshot(f func(key K, value V) bool) {
+	type pair struct {
+		k K
+		v V
+	}
+	m.lockAll()
+	pairs := make([]pair, 0, m.Len())
+	for i := range m.shards {
+		m.shards[i].table().Range(func(k K, v V) bool {
+			pairs = append(pairs, pair{k, v})
+			return true
+		})
+	}
+	m.unlockAll()
+
+	for _, p := range pairs {
+		if !f(p.k, p.v) {
+			return
+		}
+	}
+}
+
+// All returns an iterator over the key-value pairs in the map, which gives
+// the same guarantees as Range.
+func (m *MapOf[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(key K, value V) bool) {
+		m.Range(yield)
+	}
+}
+
+// Keys returns a slice of the keys in the map. The slice reflects the keys
+// visited by a single call of Range.
+func (m *MapOf[K, V]) Keys() (keys []K) {
+	keys = make([]K, 0, m.Len())
+	m.Range(func(k K, _ V) bool {
+		keys = append(keys, k)
+		return true
+	})
+	return
+}
 
-	hm := m.hm.Load().(*hmap.Map)
-	hm.Range(f)
+// Values returns a slice of the values in the map. The slice reflects the
+// values visited by a single call of Range.
+func (m *MapOf[K, V]) Values() (values []V) {
+	values = make([]V, 0, m.Len())
+	m.Range(func(_ K, v V) bool {
+		values = append(values, v)
+		return true
+	})
+	return
+}
 
-	atomic.AddInt32(&m.inResize, -1)
+// AllKeys returns an iterator over the keys in the map, which gives the same
+// guarantees as Range.
+func (m *MapOf[K, V]) AllKeys() iter.Seq[K] {
+	return func(yield func(key K) bool) {
+		m.Range(func(k K, _ V) bool {
+			return yield(k)
+		})
+	}
 }
 
-// This method can only be issued inside the critical section.
-func (m *Map) resizeIfNeeded() {
-	inResize := atomic.LoadInt32(&m.inResize)
-	if inResize != 0 {
+// AllValues returns an iterator over the values in the map, which gives the
+// same guarantees as Range.
+func (m *MapOf[K, V]) AllValues() iter.Seq[V] {
+	return func(yield func(value V) bool) {
+		m.Range(func(_ K, v V) bool {
+			return yield(v)
+		})
+	}
+}
+
+// resizeIfNeeded starts resizing the table of the shard if it is too full or
+// too sparse, or advances the resize in progress. A resize migrates the keys
+// to a new table incrementally, so no update pays for copying the whole table.
+// Operations on keys of migrated buckets are forwarded to the new table, which
+// replaces the old one once all the buckets are migrated. The buckets are
+// migrated mostly by a background goroutine, while every update also migrates
+// migrationStep buckets so that the resize completes even if the goroutine is
+// not scheduled. An update does not wait for another one resizing the table.
+//
+// This method can only be issued while the shard is locked for reading.
+func (m *MapOf[K, V]) resizeIfNeeded(s *shard[K, V]) {
+	if s.inResize.Load() != 0 {
+		return
+	}
+	if h := s.table(); h.Next() == nil && newCapacity(h, &m.opts) == 0 {
+		return
+	}
+	if !s.migrating.TryLock() {
 		return
 	}
+	defer s.migrating.Unlock()
 
-	h := m.hm.Load().(*hmap.Map)
+	h := s.table()
+	if h.Next() == nil {
+		c := newCapacity(h, &m.opts)
+		if c == 0 {
+			return
+		}
+		m.beforeResize(h)
+		h.StartMigration(c)
+		s.started = time.Now()
+	}
+	m.migrate(s, h)
+}
+
+// newCapacity returns the number of buckets the table h should be resized to,
+// or zero if h need not be resized, according to the options o.
+func newCapacity[K comparable, V any](h *hmap.MapOf[K, V], o *options) (capacity uint) {
 	entries, deleted := h.StatEntries()
 	buckets, largest := h.StatBuckets()
-	if entries < minMapSize {
-		return
+	if entries < o.minMapSize {
+		// A small table is not worth resizing, unless it is left with more
+		// buckets than an empty table has and keys are being deleted from
+		// it, as in a map that once grew large and has been emptied.
+		if buckets > o.iniCapacity && deleted > 0 {
+			return o.iniCapacity
+		}
+		return 0
 	}
-	LoadFactor := float32(entries) / float32(buckets)
-	tooSmallBuckets := LoadFactor > maxLoadFactor
+	LoadFactor := float64(entries) / float64(buckets)
+	tooSmallBuckets := LoadFactor > o.maxLoadFactor
 	tooManyDeleted := entries < 5*deleted
-	bucketOverflow := largest > maxBucketSize
+	bucketOverflow := largest > o.maxBucketSize
 
-	var newCapacity uint
 	if tooSmallBuckets || bucketOverflow {
-		newCapacity = 2*buckets - 1
+		capacity = 2*buckets - 1
 	} else if tooManyDeleted {
-		newCapacity = (entries - deleted) / minLoadFactor
+		capacity = o.fitCapacity(entries - deleted)
 	} else {
+		return 0
+	}
+	return
+}
+
+// migrate advances the migration of the table h of the shard and replaces the
+// table with the new one if the migration is done. It starts the background
+// migrator if it is not running. The caller must hold s.migrating.
+func (m *MapOf[K, V]) migrate(s *shard[K, V], h *hmap.MapOf[K, V]) {
+	if h.Migrate(migrationStep) {
+		m.replaceTable(s, h, h.Next(), s.started)
 		return
 	}
-	newMap := hmap.NewMap(newCapacity, m.hasher)
-	oldMap := m.hm.Load().(*hmap.Map)
-	oldMap.Range(func(k, v interface{}) bool {
-		newMap.Store(k, v)
-		return true
-	})
-	m.hm.Store(newMap)
+	if backgroundMigration && !s.migrator {
+		s.migrator = true
+		go m.migrateInBackground(s, h)
+	}
+}
+
+// beforeResize reports to the hook of the map that the table h is about to be
+// resized, unless h is being resized already, in which case the resize
+// reported is ended by the one about to start.
+func (m *MapOf[K, V]) beforeResize(h *hmap.MapOf[K, V]) {
+	if m.opts.hook != nil && h.Next() == nil {
+		m.opts.hook.Before(OpResize)
+	}
+}
+
+// replaceTable replaces the table h of the shard with next, which has been
+// filled with the keys of h since the given time, and records the resize in
+// the statistics of the shard and reports it to the hook of the map.
+func (m *MapOf[K, V]) replaceTable(s *shard[K, V], h, next *hmap.MapOf[K, V], start time.Time) {
+	s.hm.Store(next)
+	now := time.Now()
+	elapsed := now.Sub(start)
+	s.resizes.Add(1)
+	s.lastResize.Store(int64(elapsed))
+	s.resizedAt.Store(now.UnixNano())
+	if m.opts.resizeHook != nil {
+		oldCapacity, _ := h.StatBuckets()
+		newCapacity, _ := next.StatBuckets()
+		m.opts.resizeHook(oldCapacity, newCapacity, elapsed)
+	}
+	if m.opts.hook != nil {
+		m.opts.hook.After(OpResize, true, elapsed)
+	}
+	if m.opts.logEnabled(slog.LevelDebug) {
+		oldCapacity, _ := h.StatBuckets()
+		newCapacity, _ := next.StatBuckets()
+		m.opts.log(slog.LevelDebug, "cmap: resized a table", slog.Uint64("old_buckets", uint64(oldCapacity)),
+			slog.Uint64("new_buckets", uint64(newCapacity)), slog.Duration("elapsed", elapsed))
+	}
+}
+
+// migrateInBackground migrates the table h of the shard backgroundStep buckets
+// at a time, releasing the locks in between so that Pin and Clear are not
+// blocked for long. It gives up if the map is pinned, in which case a later
+// update restarts it, or if the table is replaced by Clear. The buckets of a
+// large table are copied by several goroutines at a time.
+func (m *MapOf[K, V]) migrateInBackground(s *shard[K, V], h *hmap.MapOf[K, V]) {
+	workers := 1
+	if buckets, _ := h.StatBuckets(); buckets >= parallelMigration {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	for done := false; !done; {
+		s.mu.RLock()
+		s.migrating.Lock()
+		switch {
+		case s.table() != h || s.inResize.Load() != 0:
+			done = true
+		case h.MigrateParallel(backgroundStep*uint(workers), workers):
+			m.replaceTable(s, h, h.Next(), s.started)
+			done = true
+		}
+		if done {
+			s.migrator = false
+		}
+		s.migrating.Unlock()
+		s.mu.RUnlock()
+	}
 }
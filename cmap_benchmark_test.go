@@ -2,11 +2,13 @@ package cmap_test
 
 import (
 	"fmt"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"testing"
 
 	"github.com/decillion/go-cmap"
+	"github.com/decillion/go-cmap/hashers"
 )
 
 const (
@@ -90,3 +92,121 @@ func Benchmark_ReadMostly_UnstableKey(b *testing.B) {
 		},
 	})
 }
+
+func BenchmarkLoad(b *testing.B) {
+	keys := make([]interface{}, entries)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	for _, c := range []struct {
+		name string
+		load func(i int)
+	}{
+		{"Map/int", func() func(int) {
+			m := cmap.NewMap(cmap.DefaultHasher)
+			for i := 0; i < entries; i++ {
+				m.Store(i, i)
+			}
+			return func(i int) { m.Load(i % entries) }
+		}()},
+		{"Map/string", func() func(int) {
+			m := cmap.NewMap(cmap.DefaultHasher)
+			for _, k := range keys {
+				m.Store(k, k)
+			}
+			return func(i int) { m.Load(keys[i%entries]) }
+		}()},
+		{"MapOf/int", func() func(int) {
+			m := cmap.NewMapOf[int, int](hashers.Int)
+			for i := 0; i < entries; i++ {
+				m.Store(i, i)
+			}
+			return func(i int) { m.Load(i % entries) }
+		}()},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				c.load(i)
+			}
+		})
+	}
+}
+
+func BenchmarkLoadMany(b *testing.B) {
+	const n = 200
+	m := cmap.NewMapOf[int, int](hashers.Int)
+	keys := make([]int, n)
+	for i := range keys {
+		keys[i] = i * 7
+		m.Store(keys[i], i)
+	}
+	b.Run("Load", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, k := range keys {
+				m.Load(k)
+			}
+		}
+	})
+	b.Run("LoadMany", func(b *testing.B) {
+		values, found := make([]int, n), make([]bool, n)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			m.LoadMany(keys, values, found)
+		}
+	})
+}
+
+func BenchmarkBulkLoad(b *testing.B) {
+	const n = 1 << 16
+	for _, reserve := range []bool{false, true} {
+		b.Run(fmt.Sprintf("Reserve=%v", reserve), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				m := cmap.NewMapOf[int, int](hashers.Int)
+				if reserve {
+					m.Reserve(n)
+				}
+				for k := 0; k < n; k++ {
+					m.Store(k, k)
+				}
+			}
+		})
+	}
+	src := make(map[int]int, n)
+	for k := 0; k < n; k++ {
+		src[k] = k
+	}
+	b.Run("StoreAll", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			cmap.NewMapOf[int, int](hashers.Int).StoreAll(src)
+		}
+	})
+}
+
+func BenchmarkRange(b *testing.B) {
+	const n = 1 << 16
+	m := cmap.NewMapOfWithCapacity[int, int](hashers.Int, n)
+	for k := 0; k < n; k++ {
+		m.Store(k, k)
+	}
+	b.Run("Range", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			sum := 0
+			m.Range(func(_, v int) bool {
+				sum += v
+				return true
+			})
+		}
+	})
+	b.Run("RangeBatch", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			sum := 0
+			m.RangeBatch(256, func(entries []cmap.Entry[int, int]) bool {
+				for _, e := range entries {
+					sum += e.Value
+				}
+				return true
+			})
+		}
+	})
+}
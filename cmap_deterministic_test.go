@@ -0,0 +1,29 @@
+//go:build cmap_deterministic
+
+package cmap_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/decillion/go-cmap"
+)
+
+func TestDeterministicRange(t *testing.T) {
+	keys := func(opts ...cmap.Option) (keys []interface{}) {
+		m := cmap.NewMap(cmap.SeededHasher, opts...)
+		for i := 0; i < capacity; i++ {
+			m.Store(i, i)
+			if i%3 == 0 {
+				m.Delete(i / 2)
+			}
+		}
+		return m.Keys()
+	}
+	if k0, k1 := keys(), keys(); !reflect.DeepEqual(k0, k1) {
+		t.Errorf("Range visits keys in different orders: %v and %v", k0, k1)
+	}
+	if k0, k1 := keys(cmap.WithRandomOrder()), keys(cmap.WithRandomOrder()); !reflect.DeepEqual(k0, k1) {
+		t.Errorf("Range in the random order visits keys in different orders: %v and %v", k0, k1)
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !cmap_deterministic
+
+package cmap_test
+
+import (
+	"testing"
+
+	"github.com/decillion/go-cmap"
+)
+
+func TestRandomOrderStartsAnywhere(t *testing.T) {
+	m := cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) }, cmap.WithRandomOrder())
+	for i := 0; i < capacity; i++ {
+		m.Store(i, i)
+	}
+	first := make(map[int]bool)
+	for i := 0; i < 100; i++ {
+		m.Range(func(k, _ int) bool {
+			first[k] = true
+			return false
+		})
+	}
+	if len(first) < 2 {
+		t.Errorf("Range started at %v in 100 calls; want different keys", first)
+	}
+}
@@ -1,12 +1,25 @@
 package cmap_test
 
 import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"maps"
+	"math"
 	"math/rand"
 	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"testing/quick"
+	"time"
 
 	"github.com/decillion/go-cmap"
+	"github.com/decillion/go-cmap/hashers"
 )
 
 const (
@@ -16,23 +29,37 @@ const (
 type mapIface interface {
 	Load(key interface{}) (value interface{}, ok bool)
 	Store(key, value interface{})
+	LoadOrStore(key, value interface{}) (actual interface{}, loaded bool)
+	Swap(key, value interface{}) (previous interface{}, loaded bool)
+	CompareAndSwap(key, old, new interface{}) (swapped bool)
 	Delete(key interface{})
+	LoadAndDelete(key interface{}) (value interface{}, loaded bool)
+	CompareAndDelete(key, old interface{}) (removed bool)
 	Range(func(key, value interface{}) bool)
 }
 
 type mapOp string
 
 const (
-	Load   = mapOp("Load")
-	Store  = mapOp("Store")
-	Delete = mapOp("Delete")
+	Load             = mapOp("Load")
+	Store            = mapOp("Store")
+	LoadOrStore      = mapOp("LoadOrStore")
+	Swap             = mapOp("Swap")
+	CompareAndSwap   = mapOp("CompareAndSwap")
+	Delete           = mapOp("Delete")
+	LoadAndDelete    = mapOp("LoadAndDelete")
+	CompareAndDelete = mapOp("CompareAndDelete")
 )
 
-var mapOps = [...]mapOp{Load, Store, Delete}
+var mapOps = [...]mapOp{
+	Load, Store, LoadOrStore, Swap, CompareAndSwap,
+	Delete, LoadAndDelete, CompareAndDelete,
+}
 
 type mapCall struct {
 	op   mapOp
 	k, v interface{}
+	old  interface{}
 }
 
 func (c mapCall) apply(m mapIface) (interface{}, bool) {
@@ -42,9 +69,19 @@ func (c mapCall) apply(m mapIface) (interface{}, bool) {
 	case Store:
 		m.Store(c.k, c.v)
 		return nil, false
+	case LoadOrStore:
+		return m.LoadOrStore(c.k, c.v)
+	case Swap:
+		return m.Swap(c.k, c.v)
+	case CompareAndSwap:
+		return nil, m.CompareAndSwap(c.k, c.old, c.v)
 	case Delete:
 		m.Delete(c.k)
 		return nil, false
+	case LoadAndDelete:
+		return m.LoadAndDelete(c.k)
+	case CompareAndDelete:
+		return nil, m.CompareAndDelete(c.k, c.old)
 	default:
 		panic("invalid mapOp")
 	}
@@ -66,8 +103,12 @@ func randValue(r *rand.Rand) interface{} {
 func (mapCall) Generate(r *rand.Rand, size int) reflect.Value {
 	c := mapCall{op: mapOps[rand.Intn(len(mapOps))], k: randValue(r)}
 	switch c.op {
-	case Store:
+	case Store, LoadOrStore, Swap:
 		c.v = randValue(r)
+	case CompareAndSwap:
+		c.v, c.old = randValue(r), randValue(r)
+	case CompareAndDelete:
+		c.old = randValue(r)
 	}
 	return reflect.ValueOf(c)
 }
@@ -89,6 +130,48 @@ func applyHashMap(calls []mapCall) ([]mapResult, map[interface{}]interface{}) {
 	return applyCalls(cmap.NewMap(cmap.DefaultHasher), calls)
 }
 
+func applyHashMap64(calls []mapCall) ([]mapResult, map[interface{}]interface{}) {
+	hasher := func(key interface{}) uint64 {
+		return uint64(cmap.DefaultHasher(key)) << 32
+	}
+	return applyCalls(cmap.NewMap(hasher), calls)
+}
+
+func applyHashMap128(calls []mapCall) ([]mapResult, map[interface{}]interface{}) {
+	hasher := func(key interface{}) (hi, lo uint64) {
+		h := uint64(cmap.DefaultHasher(key))
+		return h & 7, h // few distinct upper halves make buckets long
+	}
+	return applyCalls(cmap.NewMap128(hasher), calls)
+}
+
+func applyShardedMap(calls []mapCall) ([]mapResult, map[interface{}]interface{}) {
+	return applyCalls(cmap.NewMapSharded(cmap.DefaultHasher, nil, 5), calls)
+}
+
+func applyTunedMap(calls []mapCall) ([]mapResult, map[interface{}]interface{}) {
+	m := cmap.NewMapSharded(cmap.DefaultHasher, nil, 1,
+		cmap.WithInitialCapacity(2), cmap.WithLoadFactors(0.5, 1),
+		cmap.WithMaxBucketSize(2), cmap.WithMinMapSize(0))
+	return applyCalls(m, calls)
+}
+
+func applyLimitedMap(calls []mapCall) ([]mapResult, map[interface{}]interface{}) {
+	return applyCalls(cmap.NewMap(cmap.DefaultHasher, cmap.WithLimit(capacity, cmap.Evict)), calls)
+}
+
+func applySeededHashMap(calls []mapCall) ([]mapResult, map[interface{}]interface{}) {
+	return applyCalls(cmap.NewMap(cmap.SeededHasher), calls)
+}
+
+func applyMapOf(calls []mapCall) ([]mapResult, map[interface{}]interface{}) {
+	return applyCalls(stringMap{cmap.NewMapOf[string, string](cmap.StringHasher)}, calls)
+}
+
+func applyBytesMap(calls []mapCall) ([]mapResult, map[interface{}]interface{}) {
+	return applyCalls(bytesMap{cmap.NewBytesMap()}, calls)
+}
+
 func applyBuiltIn(calls []mapCall) ([]mapResult, map[interface{}]interface{}) {
 	return applyCalls(NewBuiltIn(), calls)
 }
@@ -99,6 +182,634 @@ func TestMachesBuiltInMap(t *testing.T) {
 	}
 }
 
+func TestHashMap64MachesBuiltInMap(t *testing.T) {
+	if err := quick.CheckEqual(applyHashMap64, applyBuiltIn, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHashMap128MachesBuiltInMap(t *testing.T) {
+	if err := quick.CheckEqual(applyHashMap128, applyBuiltIn, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestShardedMapMachesBuiltInMap(t *testing.T) {
+	if err := quick.CheckEqual(applyShardedMap, applyBuiltIn, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTunedMapMachesBuiltInMap(t *testing.T) {
+	if err := quick.CheckEqual(applyTunedMap, applyBuiltIn, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLimitedMapMachesBuiltInMap(t *testing.T) {
+	if err := quick.CheckEqual(applyLimitedMap, applyBuiltIn, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOptionsRejectInvalidValues(t *testing.T) {
+	for name, f := range map[string]func(){
+		"WithInitialCapacity(1)": func() { cmap.WithInitialCapacity(1) },
+		"WithLoadFactors(2, 2)":  func() { cmap.WithLoadFactors(2, 2) },
+		"WithLoadFactors(0, 1)":  func() { cmap.WithLoadFactors(0, 1) },
+		"WithMaxBucketSize(0)":   func() { cmap.WithMaxBucketSize(0) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v does not panic", name)
+				}
+			}()
+			f()
+		}()
+	}
+}
+
+func TestSeededHashMapMachesBuiltInMap(t *testing.T) {
+	if err := quick.CheckEqual(applySeededHashMap, applyBuiltIn, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSeededHasher(t *testing.T) {
+	type point struct{ x, y int }
+	for _, key := range []interface{}{"key", 1, point{1, 2}} {
+		if cmap.SeededHasher(key) != cmap.SeededHasher(key) {
+			t.Errorf("SeededHasher(%v) is not deterministic", key)
+		}
+	}
+	if cmap.SeededHasher("key") != cmap.SeededHasherOf("key") {
+		t.Error("SeededHasher and SeededHasherOf disagree on a string")
+	}
+	if cmap.SeededHasher(point{1, 2}) == cmap.SeededHasher(point{2, 1}) {
+		t.Error("SeededHasher does not distinguish struct fields")
+	}
+}
+
+func TestMapOfMachesBuiltInMap(t *testing.T) {
+	if err := quick.CheckEqual(applyMapOf, applyBuiltIn, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStringMap(t *testing.T) {
+	m := cmap.NewStringMap()
+	for i := 0; i < capacity; i++ {
+		m.Store(strconv.Itoa(i), i)
+	}
+	for i := 0; i < capacity; i++ {
+		if v, ok := m.Load(strconv.Itoa(i)); !ok || v != i {
+			t.Fatalf("Load(%q) = %v, %v; want %v, true", strconv.Itoa(i), v, ok, i)
+		}
+	}
+	if m.Len() != capacity {
+		t.Errorf("Len() = %v; want %v", m.Len(), capacity)
+	}
+}
+
+func TestUint64Map(t *testing.T) {
+	m := cmap.NewUint64Map()
+	for i := uint64(0); i < capacity; i++ {
+		m.Store(i<<22, i) // keys sharing low bits like snowflake IDs
+	}
+	for i := uint64(0); i < capacity; i++ {
+		if v, ok := m.Load(i << 22); !ok || v != i {
+			t.Fatalf("Load(%v) = %v, %v; want %v, true", i<<22, v, ok, i)
+		}
+	}
+	if m.Len() != capacity {
+		t.Errorf("Len() = %v; want %v", m.Len(), capacity)
+	}
+}
+
+func TestBytesMapMachesBuiltInMap(t *testing.T) {
+	if err := quick.CheckEqual(applyBytesMap, applyBuiltIn, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBytesMapCopiesKeys(t *testing.T) {
+	m := cmap.NewBytesMap()
+	key := []byte("key")
+	m.Store(key, 1)
+	key[0] = 'K'
+	if v, ok := m.Load([]byte("key")); !ok || v != 1 {
+		t.Errorf("Load(%q) = %v, %v; want 1, true", "key", v, ok)
+	}
+	if v, ok := m.Load(key); ok {
+		t.Errorf("Load(%q) = %v, %v; want nil, false", key, v, ok)
+	}
+}
+
+func TestNewMapFunc(t *testing.T) {
+	type sliceKey struct{ s []int }
+	hasher := func(key interface{}) uint32 {
+		return uint32(len(key.(sliceKey).s))
+	}
+	equal := func(a, b interface{}) bool {
+		return reflect.DeepEqual(a, b)
+	}
+	m := cmap.NewMapFunc(hasher, equal)
+	for i := 0; i < capacity; i++ {
+		m.Store(sliceKey{make([]int, i%16)}, i)
+	}
+	for i := capacity - 16; i < capacity; i++ {
+		if v, ok := m.Load(sliceKey{make([]int, i%16)}); !ok || v != i {
+			t.Errorf("Load(%v) = %v, %v; want %v, true", i%16, v, ok, i)
+		}
+	}
+	if m.Len() != 16 {
+		t.Errorf("Len() = %v; want 16", m.Len())
+	}
+}
+
+func TestNewMapOfFunc(t *testing.T) {
+	hasher := func(key string) uint32 {
+		return cmap.StringHasher(strings.ToLower(key))
+	}
+	m := cmap.NewMapOfFunc[string, int](hasher, strings.EqualFold)
+	m.Store("Key", 1)
+	if v, ok := m.Load("kEY"); !ok || v != 1 {
+		t.Errorf("Load(%q) = %v, %v; want 1, true", "kEY", v, ok)
+	}
+	if _, loaded := m.LoadOrStore("KEY", 2); !loaded {
+		t.Errorf("LoadOrStore(%q) did not load the existing key", "KEY")
+	}
+}
+
+func TestClear(t *testing.T) {
+	f := func(calls []mapCall) bool {
+		m := cmap.NewMap(cmap.DefaultHasher)
+		applyCalls(m, calls)
+		m.Clear()
+		_, final := applyCalls(m, nil)
+		return m.Len() == 0 && len(final) == 0
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestKeysAndValues(t *testing.T) {
+	f := func(calls []mapCall) bool {
+		m := cmap.NewMap(cmap.DefaultHasher)
+		_, final := applyCalls(m, calls)
+		keys, values := m.Keys(), m.Values()
+		if len(keys) != len(final) || len(values) != len(final) {
+			return false
+		}
+		counts := make(map[interface{}]int)
+		for _, v := range final {
+			counts[v]++
+		}
+		for i, k := range keys {
+			if _, ok := final[k]; !ok {
+				return false
+			}
+			counts[values[i]]--
+		}
+		for _, n := range counts {
+			if n != 0 {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAll(t *testing.T) {
+	f := func(calls []mapCall) bool {
+		m := cmap.NewMap(cmap.DefaultHasher)
+		_, final := applyCalls(m, calls)
+		counts := make(map[interface{}]int)
+		for _, v := range final {
+			counts[v]++
+		}
+		n := 0
+		for k := range m.AllKeys() {
+			if _, ok := final[k]; !ok {
+				return false
+			}
+			n++
+		}
+		for v := range m.AllValues() {
+			counts[v]--
+		}
+		for _, c := range counts {
+			if c != 0 {
+				return false
+			}
+		}
+		return n == len(final) && reflect.DeepEqual(maps.Collect(m.All()), final)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+
+	m := cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) })
+	for i := 0; i < capacity; i++ {
+		m.Store(i, i)
+	}
+	n := 0
+	for range m.All() {
+		if n++; n == 10 {
+			break
+		}
+	}
+	if n != 10 {
+		t.Errorf("the loop over All iterates %v times; want 10", n)
+	}
+}
+
+func TestClone(t *testing.T) {
+	f := func(calls, more []mapCall) bool {
+		m := cmap.NewMap(cmap.DefaultHasher)
+		_, want := applyCalls(m, calls)
+		clone := m.Clone()
+		applyCalls(m, more)
+		_, got := applyCalls(clone, nil)
+		return clone.Len() == len(want) && reflect.DeepEqual(got, want)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCloneFunc(t *testing.T) {
+	m := cmap.NewMap(cmap.DefaultHasher)
+	for i := 0; i < capacity; i++ {
+		m.Store(i, []int{i})
+	}
+	clone := m.CloneFunc(func(v interface{}) interface{} {
+		return append([]int(nil), v.([]int)...)
+	})
+	m.Range(func(_, v interface{}) bool {
+		v.([]int)[0] = -1
+		return true
+	})
+	for i := 0; i < capacity; i++ {
+		if v, ok := clone.Load(i); !ok || v.([]int)[0] != i {
+			t.Fatalf("Load(%v) = %v, %v; want [%v], true", i, v, ok, i)
+		}
+	}
+}
+
+func TestRangeStops(t *testing.T) {
+	m := cmap.NewMap(cmap.DefaultHasher)
+	for i := 0; i < capacity; i++ {
+		m.Store(i, i)
+	}
+	n := 0
+	m.Range(func(_, _ interface{}) bool {
+		n++
+		return n < capacity/2
+	})
+	if n != capacity/2 {
+		t.Errorf("Range visited %v keys; want %v", n, capacity/2)
+	}
+}
+
+func TestRangeWithUpdates(t *testing.T) {
+	m := cmap.NewMap(cmap.DefaultHasher)
+	for i := 0; i < capacity; i++ {
+		m.Store(i, i)
+	}
+	visited := make(map[interface{}]int)
+	m.Range(func(k, v interface{}) bool {
+		visited[k]++
+		i := k.(int)
+		if i < capacity {
+			m.Delete(i)
+			m.Store(i+capacity, i) // may or may not be visited
+			if i%2 == 0 {
+				m.Store(i, i) // must not be visited again
+			}
+		}
+		return true
+	})
+	for i := 0; i < capacity; i++ {
+		if visited[i] != 1 {
+			t.Errorf("Range visited %v %v times; want once", i, visited[i])
+		}
+	}
+	for k, n := range visited {
+		if n != 1 {
+			t.Errorf("Range visited %v %v times; want once", k, n)
+		}
+	}
+	if want := 3 * capacity / 2; m.Len() != want {
+		t.Errorf("Len() = %v; want %v", m.Len(), want)
+	}
+}
+
+func TestIter(t *testing.T) {
+	f := func(calls []mapCall) bool {
+		m := cmap.NewMap(cmap.DefaultHasher)
+		_, final := applyCalls(m, calls)
+		visited := make(map[interface{}]interface{})
+		for it := m.Iter(); it.Next(); {
+			visited[it.Key()] = it.Value()
+		}
+		return reflect.DeepEqual(visited, final)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+
+	m := cmap.NewMapOfSharded[int, int](func(key int) uint64 { return uint64(key) }, nil, 1)
+	for i := 0; i < capacity; i++ {
+		m.Store(i, i)
+	}
+	it := m.Iter()
+	if !it.Next() {
+		t.Fatal("Next returns false on a nonempty map")
+	}
+	it.Stop()
+	if it.Next() {
+		t.Error("Next returns true after Stop")
+	}
+	for i := 0; i < 1<<12; i++ {
+		m.Store(i, i)
+	}
+	if stats := m.Stats(); stats.Resizes == 0 && stats.Resizing == 0 {
+		t.Error("the map is not resized after the iterator is stopped")
+	}
+}
+
+func TestRangeContext(t *testing.T) {
+	m := cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) })
+	for i := 0; i < capacity; i++ {
+		m.Store(i, i)
+	}
+	count := 0
+	if err := m.RangeContext(context.Background(), func(_, _ int) bool {
+		count++
+		return true
+	}); err != nil || count != capacity {
+		t.Errorf("RangeContext visited %v keys and returned %v; want %v and nil", count, err, capacity)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	count = 0
+	err := m.RangeContext(ctx, func(_, _ int) bool {
+		if count++; count == 10 {
+			cancel()
+		}
+		return true
+	})
+	if err != context.Canceled || count >= capacity {
+		t.Errorf("RangeContext visited %v keys and returned %v after cancellation", count, err)
+	}
+	for i := 0; i < 1<<12; i++ {
+		m.Store(i, i)
+	}
+	if stats := m.Stats(); stats.Resizes == 0 && stats.Resizing == 0 {
+		t.Error("the map is not resized after RangeContext is canceled")
+	}
+}
+
+func TestRangeParallel(t *testing.T) {
+	const n = 1 << 12
+	m := cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) })
+	for i := 0; i < n; i++ {
+		m.Store(i, i)
+	}
+	var mu sync.Mutex
+	visited := make(map[int]int)
+	m.RangeParallel(4, func(k, v int) bool {
+		mu.Lock()
+		visited[k]++
+		mu.Unlock()
+		return k == v
+	})
+	if len(visited) != n {
+		t.Errorf("RangeParallel visited %v keys; want %v", len(visited), n)
+	}
+	for k, c := range visited {
+		if c != 1 {
+			t.Errorf("RangeParallel visited %v %v times", k, c)
+		}
+	}
+
+	var calls atomic.Int64
+	m.RangeParallel(4, func(_, _ int) bool {
+		calls.Add(1)
+		return false
+	})
+	if c := calls.Load(); c < 1 || c > 4 {
+		t.Errorf("RangeParallel called the function %v times after it returned false", c)
+	}
+}
+
+func TestRangeSnapshot(t *testing.T) {
+	m := cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) })
+	for i := 0; i < capacity; i++ {
+		m.Store(i, i)
+	}
+	visited := make(map[int]int)
+	m.RangeSnapshot(func(k, v int) bool {
+		visited[k] = v
+		m.Delete(capacity - 1 - k)
+		m.Store(capacity+k, k)
+		m.Store(k, -v)
+		return true
+	})
+	if len(visited) != capacity {
+		t.Fatalf("RangeSnapshot visited %v keys; want %v", len(visited), capacity)
+	}
+	for k, v := range visited {
+		if k != v {
+			t.Errorf("RangeSnapshot visited %v: %v; want %v: %v", k, v, k, k)
+		}
+	}
+}
+
+func TestRandomOrder(t *testing.T) {
+	m := cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) }, cmap.WithRandomOrder())
+	for i := 0; i < capacity; i++ {
+		m.Store(i, i)
+		if i%3 == 0 {
+			m.Delete(i / 2)
+		}
+	}
+	want := make(map[int]int)
+	m.RangeSnapshot(func(k, v int) bool {
+		want[k] = v
+		return true
+	})
+	for i := 0; i < 10; i++ {
+		if got := maps.Collect(m.All()); !reflect.DeepEqual(got, want) {
+			t.Fatalf("Range visited %v; want %v", got, want)
+		}
+	}
+}
+
+func TestStringHasher(t *testing.T) {
+	var others atomic.Int64
+	m := cmap.NewMap(func(key interface{}) uint64 {
+		if _, ok := key.(string); ok {
+			t.Errorf("the hasher of the map hashes the string %q", key)
+		}
+		others.Add(1)
+		return hashers.TypeHasher64(key)
+	}, cmap.WithStringHasher(hashers.WyHash))
+	for i := 0; i < capacity; i++ {
+		m.Store(strconv.Itoa(i), i)
+		m.Store(i, i)
+	}
+	for i := 0; i < capacity; i++ {
+		if v, ok := m.Load(strconv.Itoa(i)); !ok || v != i {
+			t.Fatalf("Load(%q) = %v, %v; want %v, true", strconv.Itoa(i), v, ok, i)
+		}
+	}
+	if n := others.Load(); m.Len() != 2*capacity || n == 0 {
+		t.Errorf("Len() = %v and the hasher of the map hashes %v keys; want %v and some keys", m.Len(), n, 2*capacity)
+	}
+}
+
+func TestRangeBatch(t *testing.T) {
+	const n = 10
+	m := cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) })
+	for i := 0; i < capacity; i++ {
+		m.Store(i, i)
+	}
+	visited := make(map[int]int)
+	batches := 0
+	m.RangeBatch(n, func(entries []cmap.Entry[int, int]) bool {
+		if batches++; len(entries) != n && len(visited)+len(entries) != capacity {
+			t.Errorf("RangeBatch passed %v pairs; want %v", len(entries), n)
+		}
+		for _, e := range entries {
+			visited[e.Key] = e.Value
+		}
+		return true
+	})
+	if want := (capacity + n - 1) / n; batches != want {
+		t.Errorf("RangeBatch called the function %v times; want %v", batches, want)
+	}
+	if len(visited) != capacity {
+		t.Fatalf("RangeBatch visited %v keys; want %v", len(visited), capacity)
+	}
+	for k, v := range visited {
+		if k != v {
+			t.Errorf("RangeBatch visited %v: %v; want %v: %v", k, v, k, k)
+		}
+	}
+
+	batches = 0
+	m.RangeBatch(n, func([]cmap.Entry[int, int]) bool {
+		batches++
+		return false
+	})
+	if batches != 1 {
+		t.Errorf("RangeBatch called the function %v times after false; want 1", batches)
+	}
+}
+
+func TestRangePage(t *testing.T) {
+	const n = 1 << 12
+	// The hashes of every four keys collide.
+	m := cmap.NewMapOfSharded[int, int](func(key int) uint64 { return uint64(key / 4) }, nil, 4)
+	for i := 0; i < n; i++ {
+		m.Store(i, i)
+	}
+	visited := make(map[int]int)
+	var token cmap.Cursor
+	for pages := 0; !token.Done(); pages++ {
+		if pages > n {
+			t.Fatalf("RangePage did not reach the end")
+		}
+		var entries []cmap.Entry[int, int]
+		entries, token = m.RangePage(token, 3)
+		if len(entries) < 3 && !token.Done() {
+			t.Fatalf("RangePage returned %v pairs; want at least 3", len(entries))
+		}
+		for _, e := range entries {
+			visited[e.Key]++
+			if e.Key < n && e.Key != e.Value {
+				t.Errorf("RangePage returned %v: %v; want %v: %v", e.Key, e.Value, e.Key, e.Key)
+			}
+			m.Store(n+e.Key, 0) // may or may not be visited
+		}
+		text, err := token.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		token = cmap.Cursor{}
+		if err := token.UnmarshalText(text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < n; i++ {
+		if visited[i] != 1 {
+			t.Errorf("RangePage visited %v %v times; want once", i, visited[i])
+		}
+	}
+	for k, c := range visited {
+		if c != 1 {
+			t.Errorf("RangePage visited %v %v times; want once", k, c)
+		}
+	}
+	if entries, token := m.RangePage(token, 3); entries != nil || !token.Done() {
+		t.Errorf("RangePage at the end = %v, %v; want nil, done", entries, token)
+	}
+	if err := token.UnmarshalText([]byte("page 2")); err == nil {
+		t.Errorf("UnmarshalText accepted an invalid cursor")
+	}
+}
+
+func TestRangeDoesNotBlockResizes(t *testing.T) {
+	const n = 1 << 12
+	m := cmap.NewMapOfSharded[int, int](func(key int) uint64 { return uint64(key) }, nil, 1)
+	for i := 0; i < capacity; i++ {
+		m.Store(i, i)
+	}
+	visited := make(map[int]int)
+	m.Range(func(k, v int) bool {
+		visited[k]++
+		if k == v && k < capacity {
+			for i := 0; i < n/capacity; i++ {
+				m.Store(n*(k+1)+i, -1) // may or may not be visited
+			}
+			m.Delete(k)
+			m.Store(k, -k) // must not be visited again
+		}
+		return true
+	})
+	if stats := m.Stats(); stats.Resizes == 0 {
+		t.Errorf("Stats() = %+v; want resizes during Range", stats)
+	}
+	for i := 0; i < capacity; i++ {
+		if visited[i] != 1 {
+			t.Errorf("Range visited %v %v times; want once", i, visited[i])
+		}
+	}
+	for k, c := range visited {
+		if c != 1 {
+			t.Errorf("Range visited %v %v times; want once", k, c)
+		}
+	}
+}
+
+func TestLenMatchesRange(t *testing.T) {
+	f := func(calls []mapCall) bool {
+		m := cmap.NewMap(cmap.DefaultHasher)
+		_, final := applyCalls(m, calls)
+		return m.Len() == len(final)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
 type BuiltIn struct {
 	b map[interface{}]interface{}
 }
@@ -118,14 +829,1332 @@ func (m *BuiltIn) Store(key, value interface{}) {
 	m.b[key] = value
 }
 
-func (m *BuiltIn) Delete(key interface{}) {
-	delete(m.b, key)
+func (m *BuiltIn) LoadOrStore(key, value interface{}) (actual interface{}, loaded bool) {
+	if actual, loaded = m.b[key]; loaded {
+		return
+	}
+	m.b[key] = value
+	return value, false
 }
 
-func (m *BuiltIn) Range(f func(key, value interface{}) bool) {
+func (m *BuiltIn) Swap(key, value interface{}) (previous interface{}, loaded bool) {
+	previous, loaded = m.b[key]
+	m.b[key] = value
+	return
+}
+
+func (m *BuiltIn) CompareAndSwap(key, old, new interface{}) (swapped bool) {
+	if v, ok := m.b[key]; ok && v == old {
+		m.b[key] = new
+		return true
+	}
+	return false
+}
+
+func (m *BuiltIn) Delete(key interface{}) {
+	delete(m.b, key)
+}
+
+func (m *BuiltIn) LoadAndDelete(key interface{}) (value interface{}, loaded bool) {
+	if value, loaded = m.b[key]; loaded {
+		delete(m.b, key)
+	}
+	return
+}
+
+func (m *BuiltIn) CompareAndDelete(key, old interface{}) (removed bool) {
+	if v, ok := m.b[key]; ok && v == old {
+		delete(m.b, key)
+		return true
+	}
+	return false
+}
+
+func (m *BuiltIn) Range(f func(key, value interface{}) bool) {
 	for k, v := range m.b {
 		if !f(k, v) {
 			return
 		}
 	}
 }
+
+// stringMap adapts *cmap.MapOf[string, string] to mapIface.
+type stringMap struct {
+	m *cmap.MapOf[string, string]
+}
+
+func (m stringMap) result(v string, ok bool) (interface{}, bool) {
+	if !ok {
+		return nil, false
+	}
+	return v, true
+}
+
+func (m stringMap) Load(key interface{}) (value interface{}, ok bool) {
+	return m.result(m.m.Load(key.(string)))
+}
+
+func (m stringMap) Store(key, value interface{}) {
+	m.m.Store(key.(string), value.(string))
+}
+
+func (m stringMap) LoadOrStore(key, value interface{}) (actual interface{}, loaded bool) {
+	return m.m.LoadOrStore(key.(string), value.(string))
+}
+
+func (m stringMap) Swap(key, value interface{}) (previous interface{}, loaded bool) {
+	return m.result(m.m.Swap(key.(string), value.(string)))
+}
+
+func (m stringMap) CompareAndSwap(key, old, new interface{}) (swapped bool) {
+	return m.m.CompareAndSwap(key.(string), old.(string), new.(string))
+}
+
+func (m stringMap) Delete(key interface{}) {
+	m.m.Delete(key.(string))
+}
+
+func (m stringMap) LoadAndDelete(key interface{}) (value interface{}, loaded bool) {
+	return m.result(m.m.LoadAndDelete(key.(string)))
+}
+
+func (m stringMap) CompareAndDelete(key, old interface{}) (removed bool) {
+	return m.m.CompareAndDelete(key.(string), old.(string))
+}
+
+func (m stringMap) Range(f func(key, value interface{}) bool) {
+	m.m.Range(func(k, v string) bool {
+		return f(k, v)
+	})
+}
+
+// bytesMap adapts *cmap.BytesMap to mapIface.
+type bytesMap struct {
+	m *cmap.BytesMap
+}
+
+func (m bytesMap) Load(key interface{}) (value interface{}, ok bool) {
+	return m.m.Load([]byte(key.(string)))
+}
+
+func (m bytesMap) Store(key, value interface{}) {
+	m.m.Store([]byte(key.(string)), value)
+}
+
+func (m bytesMap) LoadOrStore(key, value interface{}) (actual interface{}, loaded bool) {
+	return m.m.LoadOrStore([]byte(key.(string)), value)
+}
+
+func (m bytesMap) Swap(key, value interface{}) (previous interface{}, loaded bool) {
+	return m.m.Swap([]byte(key.(string)), value)
+}
+
+func (m bytesMap) CompareAndSwap(key, old, new interface{}) (swapped bool) {
+	return m.m.CompareAndSwap([]byte(key.(string)), old, new)
+}
+
+func (m bytesMap) Delete(key interface{}) {
+	m.m.Delete([]byte(key.(string)))
+}
+
+func (m bytesMap) LoadAndDelete(key interface{}) (value interface{}, loaded bool) {
+	return m.m.LoadAndDelete([]byte(key.(string)))
+}
+
+func (m bytesMap) CompareAndDelete(key, old interface{}) (removed bool) {
+	return m.m.CompareAndDelete([]byte(key.(string)), old)
+}
+
+func (m bytesMap) Range(f func(key, value interface{}) bool) {
+	m.m.Range(func(k []byte, v interface{}) bool {
+		return f(string(k), v)
+	})
+}
+
+func TestResize(t *testing.T) {
+	const n = 1 << 14
+	m := cmap.NewMapOfSharded[int, int](func(key int) uint64 { return uint64(key) }, nil, 1)
+	for i := 0; i < n; i++ {
+		m.Store(i, i)
+		if v, ok := m.Load(i / 2); !ok || v != i/2 {
+			t.Fatalf("Load(%v) = %v, %v during growth; want %v, true", i/2, v, ok, i/2)
+		}
+	}
+	for i := 0; i < n; i += 2 {
+		m.Delete(i)
+	}
+	for i := 0; i < n; i++ {
+		if v, ok := m.Load(i); ok != (i%2 == 1) || ok && v != i {
+			t.Fatalf("Load(%v) = %v, %v after deletion", i, v, ok)
+		}
+	}
+	count := 0
+	m.Range(func(_, _ int) bool {
+		count++
+		return true
+	})
+	if count != n/2 || m.Len() != n/2 {
+		t.Errorf("Range visited %v keys and Len is %v; want %v", count, m.Len(), n/2)
+	}
+}
+
+func TestShrinkEmptiedMap(t *testing.T) {
+	const n = 1 << 14
+	m := cmap.NewMapOfSharded[int, int](func(key int) uint64 { return uint64(key) }, nil, 1)
+	for i := 0; i < n; i++ {
+		m.Store(i, i)
+	}
+	for i := 0; i < n-10; i++ {
+		m.Delete(i)
+	}
+	// The remaining deletions happen below the minimum map size.
+	for i := n - 10; i < n-5; i++ {
+		m.Delete(i)
+		if v, ok := m.Load(n - 1); !ok || v != n-1 {
+			t.Fatalf("Load(%v) = %v, %v during shrinking", n-1, v, ok)
+		}
+	}
+	m.Store(n, n)
+	count := 0
+	m.Range(func(k, v int) bool {
+		if k < n-5 || k != v {
+			t.Errorf("Range visited %v: %v", k, v)
+		}
+		count++
+		return true
+	})
+	if count != 6 || m.Len() != 6 {
+		t.Errorf("Range visited %v keys and Len is %v; want 6", count, m.Len())
+	}
+}
+
+func TestCompact(t *testing.T) {
+	const n = 1 << 12
+	m := cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) })
+	for i := 0; i < n; i++ {
+		m.Store(i, i)
+	}
+	for i := 0; i < n; i++ {
+		if i%100 != 0 {
+			m.Delete(i)
+		}
+	}
+	m.Compact()
+	for i := 0; i < n; i++ {
+		if v, ok := m.Load(i); ok != (i%100 == 0) || ok && v != i {
+			t.Fatalf("Load(%v) = %v, %v after Compact", i, v, ok)
+		}
+	}
+	m.Store(n, n)
+	if v, ok := m.Load(n); !ok || v != n || m.Len() != (n+99)/100+1 {
+		t.Errorf("Load(%v) = %v, %v and Len() = %v after Compact", n, v, ok, m.Len())
+	}
+}
+
+func TestReserve(t *testing.T) {
+	const n = 1 << 12
+	m := cmap.NewMapOfWithCapacity[int, int](func(key int) uint64 { return uint64(key) }, n/2)
+	for i := 0; i < n/2; i++ {
+		m.Store(i, i)
+	}
+	m.Reserve(n)
+	for i := n / 2; i < n; i++ {
+		m.Store(i, i)
+	}
+	for i := 0; i < n; i++ {
+		if v, ok := m.Load(i); !ok || v != i {
+			t.Fatalf("Load(%v) = %v, %v after Reserve", i, v, ok)
+		}
+	}
+	if m.Len() != n {
+		t.Errorf("Len() = %v, want %v", m.Len(), n)
+	}
+}
+
+func TestLimit(t *testing.T) {
+	const limit = 10
+	hasher := func(key int) uint64 { return uint64(key) }
+	m := cmap.NewMapOf[int, int](hasher, cmap.WithLimit(limit, cmap.Reject))
+	for i := 0; i < 2*limit; i++ {
+		m.Store(i, i)
+	}
+	if m.Len() != limit || m.StoreIfRoom(2*limit, 0) {
+		t.Fatalf("Len() = %v after storing %v keys; want %v", m.Len(), 2*limit, limit)
+	}
+	for i := 0; i < 2*limit; i++ {
+		if v, ok := m.Load(i); ok && !m.StoreIfRoom(i, v+1) {
+			t.Errorf("StoreIfRoom(%v) fails on an existing key", i)
+		}
+	}
+	m.Delete(0)
+	if _, loaded := m.LoadOrStore(2*limit, 0); loaded || m.Len() != limit {
+		t.Errorf("LoadOrStore after Delete = _, %v and Len() = %v", loaded, m.Len())
+	}
+
+	m = cmap.NewMapOf[int, int](hasher, cmap.WithLimit(limit, cmap.Evict))
+	for i := 0; i < 2*limit; i++ {
+		m.Store(i, i)
+		if v, ok := m.Load(i); !ok || v != i || m.Len() > limit {
+			t.Fatalf("Load(%v) = %v, %v and Len() = %v under Evict", i, v, ok, m.Len())
+		}
+	}
+}
+
+func TestEvictLRU(t *testing.T) {
+	const limit, hot = 1 << 8, 1 << 5
+	m := cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) }, cmap.WithLimit(limit, cmap.EvictLRU))
+	for i := 0; i < limit; i++ {
+		m.Store(i, i)
+	}
+	for i := limit; i < 4*limit; i++ {
+		for k := 0; k < hot; k++ {
+			m.Load(k)
+		}
+		m.Store(i, i)
+		if m.Len() != limit {
+			t.Fatalf("Len() = %v after storing %v keys; want %v", m.Len(), i+1, limit)
+		}
+	}
+	for k := 0; k < hot; k++ {
+		if v, ok := m.Load(k); !ok || v != k {
+			t.Errorf("Load(%v) = %v, %v for a key used recently; want %v, true", k, v, ok, k)
+		}
+	}
+}
+
+func TestEvictTinyLFU(t *testing.T) {
+	const limit, hot = 1 << 8, 1 << 5
+	survivors := func(policy cmap.Policy) (n int) {
+		m := cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) }, cmap.WithLimit(limit, policy))
+		for k := 0; k < hot; k++ {
+			m.Store(k, k)
+		}
+		for i := hot; i < 2*limit; i++ {
+			for k := 0; k < hot; k++ {
+				m.Load(k)
+			}
+			m.Store(i, i)
+		}
+		for i := 2 * limit; i < 6*limit; i++ {
+			m.Store(i, i) // a scan of keys used only once
+		}
+		for k := 0; k < hot; k++ {
+			if _, ok := m.Load(k); ok {
+				n++
+			}
+		}
+		return
+	}
+	// EvictLRU keeps only some of them, because they are not used during
+	// the scan.
+	if n := survivors(cmap.EvictTinyLFU); n < hot*3/4 {
+		t.Errorf("%v of %v keys used often survived a scan under EvictTinyLFU", n, hot)
+	}
+}
+
+func TestEvictARC(t *testing.T) {
+	const limit, hot = 1 << 8, 1 << 5
+	m := cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) }, cmap.WithLimit(limit, cmap.EvictARC))
+	for k := 0; k < hot; k++ {
+		m.Store(k, k)
+	}
+	for i := hot; i < 2*limit; i++ {
+		for k := 0; k < hot; k++ {
+			m.Load(k)
+		}
+		m.Store(i, i)
+	}
+	for i := 2 * limit; i < 6*limit; i++ {
+		m.Store(i, i) // a scan of keys used only once
+		if m.Len() != limit {
+			t.Fatalf("Len() = %v after storing %v keys; want %v", m.Len(), i+1, limit)
+		}
+	}
+	for k := 0; k < hot; k++ {
+		if v, ok := m.Load(k); !ok || v != k {
+			t.Errorf("Load(%v) = %v, %v for a key used often; want %v, true", k, v, ok, k)
+		}
+	}
+
+	// Keys deleted from the map and evicted keys coming back are tracked.
+	for i := 0; i < 6*limit; i++ {
+		m.Delete(i - limit)
+		m.Store(i%(2*limit), i)
+		if m.Len() > limit {
+			t.Fatalf("Len() = %v; want at most %v", m.Len(), limit)
+		}
+	}
+	m.Clear()
+	for i := 0; i < 2*limit; i++ {
+		m.Store(i, i)
+	}
+	if c := m.Clone(); c.Len() != limit {
+		t.Errorf("Len() = %v for a clone of a full map; want %v", c.Len(), limit)
+	} else if c.Store(-1, -1); c.Len() != limit {
+		t.Errorf("Len() = %v after storing a new key into a full clone; want %v", c.Len(), limit)
+	}
+}
+
+func TestEvictingPoliciesUnderContention(t *testing.T) {
+	const limit, workers, keys = 1 << 6, 8, 1 << 12
+	for _, policy := range []cmap.Policy{cmap.Evict, cmap.EvictLRU, cmap.EvictTinyLFU, cmap.EvictARC} {
+		m := cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) }, cmap.WithLimit(limit, policy))
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				r := rand.New(rand.NewSource(int64(w)))
+				for i := 0; i < keys; i++ {
+					k := r.Intn(4 * limit)
+					switch i % 4 {
+					case 0:
+						m.Delete(k)
+					case 1:
+						m.Load(k)
+					default:
+						m.Store(k, k)
+					}
+				}
+			}(w)
+		}
+		wg.Wait()
+		if n := m.Len(); n > limit {
+			t.Errorf("Len() = %v under policy %v; want at most %v", n, policy, limit)
+		}
+		m.Range(func(k, v int) bool {
+			if k != v {
+				t.Errorf("Range visited %v: %v under policy %v", k, v, policy)
+			}
+			return true
+		})
+	}
+}
+
+func TestCostLimit(t *testing.T) {
+	const budget = 100
+	cost := func(value string) int64 { return int64(len(value)) }
+	m := cmap.NewMapOf[int, string](func(key int) uint64 { return uint64(key) }, cmap.WithCostLimit(budget, cost, cmap.Evict))
+	total := func() (n int64) {
+		m.Range(func(_ int, v string) bool {
+			n += cost(v)
+			return true
+		})
+		return
+	}
+	for i := 0; i < 10*budget; i++ {
+		m.Store(i%50, strings.Repeat("x", i%30))
+		if n := total(); n > budget {
+			t.Fatalf("the total cost is %v after storing %v values; want at most %v", n, i+1, budget)
+		}
+	}
+	if m.StoreIfRoom(0, strings.Repeat("x", budget+1)) {
+		t.Error("StoreIfRoom stored a value costing more than the budget")
+	}
+	if m.CompareAndSwap(0, "", strings.Repeat("x", budget+1)) {
+		t.Error("CompareAndSwap stored a value costing more than the budget")
+	}
+	m.Clear()
+	m.Store(0, strings.Repeat("x", budget))
+	if v, ok := m.Load(0); !ok || len(v) != budget {
+		t.Errorf("Load(0) = %v, %v for a value costing the whole budget", len(v), ok)
+	}
+	if c := m.Clone(); !c.StoreIfRoom(1, "x") || c.Len() != 1 {
+		t.Errorf("Len() = %v after storing a value into a clone of a full map; want 1", c.Len())
+	}
+
+	r := cmap.NewMapOf[int, string](func(key int) uint64 { return uint64(key) }, cmap.WithCostLimit(budget, cost, cmap.Reject))
+	r.Store(0, strings.Repeat("x", budget/2))
+	r.Store(1, strings.Repeat("x", budget/2))
+	if r.TryStore(2, "x") || r.StoreIfRoom(0, strings.Repeat("x", budget/2+1)) {
+		t.Error("a value is stored beyond the budget")
+	}
+	if r.Delete(1); !r.TryStore(2, "x") || !r.StoreIfRoom(0, strings.Repeat("x", budget/2+1)) {
+		t.Error("a value is not stored within the budget")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("NewMapOf does not panic with a cost function of another type")
+		}
+	}()
+	cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) }, cmap.WithCostLimit(budget, cost, cmap.Reject))
+}
+
+func TestCostLimitConcurrentUpdates(t *testing.T) {
+	const budget, workers, n = 1 << 20, 8, 1 << 16
+	cost := func(value string) int64 { return int64(len(value)) }
+	m := cmap.NewMapOf[int, string](func(key int) uint64 { return uint64(key) }, cmap.WithCostLimit(budget, cost, cmap.Reject))
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				if w%2 == 0 {
+					m.TryStore(i%2, strings.Repeat("x", (i+w)%20))
+					continue
+				}
+				m.Compute(i%2, func(old string, loaded bool) (string, bool) {
+					return strings.Repeat("x", (len(old)+w)%20), false
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The room reserved is the cost of the values if the map holds exactly as
+	// much more as its budget leaves.
+	var total int64
+	m.Range(func(_ int, v string) bool {
+		total += cost(v)
+		return true
+	})
+	if !m.StoreIfRoom(4, strings.Repeat("x", int(budget-total))) {
+		t.Errorf("a value costing the %v left of the budget is not stored", budget-total)
+	}
+	if m.TryStore(5, "x") {
+		t.Error("a value is stored beyond the budget")
+	}
+}
+
+func TestOnEvict(t *testing.T) {
+	const limit = 10
+	for _, policy := range []cmap.Policy{cmap.Evict, cmap.EvictLRU, cmap.EvictTinyLFU, cmap.EvictARC} {
+		var m *cmap.MapOf[int, int]
+		evicted := make(map[int]int)
+		onEvict := func(k, v int) {
+			if _, ok := m.Load(k); ok {
+				t.Errorf("policy %v: the evicted key %v is in the map", policy, k)
+			}
+			evicted[k] = v
+		}
+		m = cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) }, cmap.WithLimit(limit, policy), cmap.WithOnEvict(onEvict))
+		for i := 0; i < 10*limit; i++ {
+			m.Store(i, -i)
+		}
+		for k := 0; k < 10*limit; k++ {
+			v, ok := m.Load(k)
+			if w, found := evicted[k]; ok == found || ok && v != -k || found && w != -k {
+				t.Errorf("policy %v: Load(%v) = %v, %v and the key is evicted with %v, %v", policy, k, v, ok, w, found)
+			}
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("NewMapOf does not panic with a function of WithOnEvict of another type")
+		}
+	}()
+	cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) }, cmap.WithLimit(limit, cmap.Evict), cmap.WithOnEvict(func(string, int) {}))
+}
+
+func TestLimitIsNeverExceeded(t *testing.T) {
+	const limit, workers = 100, 8
+	m := cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) }, cmap.WithLimit(limit, cmap.Reject))
+	var stored atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < limit; i++ {
+				if m.StoreIfRoom(w*limit+i, i) {
+					stored.Add(1)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	if stored.Load() != limit || m.Len() != limit {
+		t.Errorf("%v keys are stored and Len() = %v; want %v", stored.Load(), m.Len(), limit)
+	}
+}
+
+func TestSizeBytes(t *testing.T) {
+	m := cmap.NewMapOf[string, string](cmap.StringHasher)
+	empty := m.SizeBytes()
+	for i := 0; i < capacity; i++ {
+		m.Store(strconv.Itoa(i), strings.Repeat("x", i))
+	}
+	size := m.SizeBytes()
+	if size <= empty {
+		t.Errorf("SizeBytes() = %v with %v keys and %v without keys", size, capacity, empty)
+	}
+	total := m.SizeBytesFunc(func(v string) uint64 { return uint64(len(v)) })
+	if want := size + capacity*(capacity-1)/2; total != want {
+		t.Errorf("SizeBytesFunc() = %v, want %v", total, want)
+	}
+}
+
+func TestResizeHook(t *testing.T) {
+	const n = 1 << 12
+	var mu sync.Mutex
+	var resizes [][2]uint
+	hook := func(oldCapacity, newCapacity uint, elapsed time.Duration) {
+		mu.Lock()
+		resizes = append(resizes, [2]uint{oldCapacity, newCapacity})
+		mu.Unlock()
+		if elapsed < 0 {
+			t.Errorf("the resize from %v to %v buckets took %v", oldCapacity, newCapacity, elapsed)
+		}
+	}
+	m := cmap.NewMapOfSharded[int, int](func(key int) uint64 { return uint64(key) }, nil, 1, cmap.WithResizeHook(hook))
+	for i := 0; i < n; i++ {
+		m.Store(i, i)
+	}
+	m.Compact()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(resizes) < 2 {
+		t.Fatalf("the hook is called %v times; want at least 2", len(resizes))
+	}
+	for _, r := range resizes[:len(resizes)-1] {
+		if r[1] <= r[0] {
+			t.Errorf("the table is resized from %v to %v buckets while keys are added", r[0], r[1])
+		}
+	}
+	if r := resizes[len(resizes)-1]; r[1] != n/2 {
+		t.Errorf("Compact resizes the table from %v to %v buckets; want %v", r[0], r[1], n/2)
+	}
+}
+
+func TestStats(t *testing.T) {
+	const n = 1 << 12
+	m := cmap.NewMapOfSharded[int, int](func(key int) uint64 { return uint64(key) }, nil, 2)
+	if stats := m.Stats(); stats.Shards != 2 || stats.Buckets == 0 || stats.Resizes != 0 {
+		t.Errorf("Stats() = %+v on an empty map", stats)
+	}
+	for i := 0; i < n; i++ {
+		m.Store(i, i)
+	}
+	m.Delete(0)
+	m.Compact()
+	stats := m.Stats()
+	if stats.Keys != n-1 || stats.Entries != n-1 || stats.Tombstones != 0 || stats.Resizing != 0 {
+		t.Errorf("Stats() = %+v after Compact; want %v keys without tombstones", stats, n-1)
+	}
+	if stats.Resizes < 2 || stats.LargestBucket == 0 || stats.LargestBucket > stats.Entries {
+		t.Errorf("Stats() = %+v after resizes", stats)
+	}
+	m.Delete(1)
+	if stats := m.Stats(); stats.Keys != n-2 || stats.Tombstones != 1 {
+		t.Errorf("Stats() = %+v after Delete; want %v keys and a tombstone", stats, n-2)
+	}
+}
+
+func TestStatsCounters(t *testing.T) {
+	const limit = 10
+	hasher := func(key int) uint64 { return uint64(key) }
+	m := cmap.NewMapOf[int, int](hasher, cmap.WithCounters(), cmap.WithLimit(limit, cmap.Evict))
+	for i := 0; i < 2*limit; i++ {
+		m.Store(i, i)
+	}
+	m.LoadOrStore(2*limit, 0)
+	m.CompareAndSwap(2*limit, 0, 1)
+	m.CompareAndSwap(2*limit, 0, 1) // fails
+	for i := 0; i < 3*limit; i++ {
+		m.Load(i)
+	}
+	m.Delete(2 * limit)
+	m.Delete(2 * limit) // removes nothing
+	want := cmap.Stats{Hits: limit, Misses: 2 * limit, Stores: 2*limit + 2, Deletes: limit + 2, Evictions: limit + 1}
+	if got := m.Stats(); got.Hits != want.Hits || got.Misses != want.Misses || got.Stores != want.Stores ||
+		got.Deletes != want.Deletes || got.Evictions != want.Evictions {
+		t.Errorf("Stats() = %+v; want the counters of %+v", got, want)
+	}
+
+	u := cmap.NewMapOf[int, int](hasher)
+	u.Store(0, 0)
+	u.Load(0)
+	if stats := u.Stats(); stats.Hits != 0 || stats.Stores != 0 {
+		t.Errorf("Stats() = %+v for a map without WithCounters", stats)
+	}
+}
+
+func TestBucketHistogram(t *testing.T) {
+	m := cmap.NewMapOf[int, int](func(key int) uint64 { return 0 }) // every key collides
+	for i := 0; i < 16; i++ {
+		m.Store(i, i)
+	}
+	h := m.BucketHistogram()
+	stats := m.Stats()
+	var buckets uint
+	for _, n := range h {
+		buckets += n
+	}
+	if buckets != stats.Buckets || uint(len(h)-1) != stats.LargestBucket || h[len(h)-1] == 0 {
+		t.Errorf("BucketHistogram() = %v; want %v buckets, the largest of which has %v entries", h, stats.Buckets, stats.LargestBucket)
+	}
+}
+
+func TestAgeHistogram(t *testing.T) {
+	hasher := func(key int) uint64 { return uint64(key) }
+	if h := cmap.NewMapOf[int, int](hasher).AgeHistogram(); h != nil {
+		t.Errorf("AgeHistogram() = %v without WithAccessAges; want nil", h)
+	}
+	const age = 20 * time.Millisecond
+	m := cmap.NewMapOf[int, int](hasher, cmap.WithAccessAges(capacity, 1))
+	for i := 0; i < 8; i++ {
+		m.Store(i, i)
+	}
+	time.Sleep(age)
+	for i := 0; i < 16; i++ {
+		m.Load(i) // of which the last 8 are not found
+	}
+	m.Store(0, 0)
+	m.Load(0)
+	h := m.AgeHistogram()
+	var loads, old int64
+	for i, b := range h {
+		if i > 0 && b.UpTo <= h[i-1].UpTo {
+			t.Errorf("AgeHistogram() = %v; want ascending bounds", h)
+		}
+		loads += b.Count
+		if b.UpTo >= age {
+			old += b.Count
+		}
+	}
+	if loads != 9 || old != 8 {
+		t.Errorf("AgeHistogram() = %v; want a load of a new value and 8 loads of values at least %v old", h, age)
+	}
+
+	m = cmap.NewMapOf[int, int](hasher, cmap.WithAccessAges(capacity, 4))
+	for i := 0; i < capacity; i++ {
+		m.Store(i, i)
+	}
+	for i := 0; i < capacity; i++ {
+		m.Load(i)
+	}
+	loads = 0
+	for _, b := range m.AgeHistogram() {
+		if b.Count%4 != 0 {
+			t.Errorf("AgeHistogram() = %v sampling one in 4 keys; want multiples of 4", m.AgeHistogram())
+		}
+		loads += b.Count
+	}
+	if loads < capacity/4 || loads > 4*capacity {
+		t.Errorf("AgeHistogram() estimates %v loads; want about %v", loads, capacity)
+	}
+}
+
+func TestSample(t *testing.T) {
+	const n, draws = 1 << 10, 1 << 15
+	m := cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) })
+	if entries := m.Sample(1); entries != nil {
+		t.Errorf("Sample(1) = %v on an empty map; want nil", entries)
+	}
+	for i := 0; i < n; i++ {
+		m.Store(i, -i)
+	}
+	if entries := m.Sample(0); entries != nil {
+		t.Errorf("Sample(0) = %v; want nil", entries)
+	}
+	entries := m.Sample(draws)
+	if len(entries) != draws {
+		t.Fatalf("Sample(%v) returns %v entries", draws, len(entries))
+	}
+	counts := make(map[int]int)
+	for _, e := range entries {
+		if e.Key < 0 || e.Key >= n || e.Value != -e.Key {
+			t.Fatalf("Sample draws %+v, which is not in the map", e)
+		}
+		counts[e.Key]++
+	}
+	// Each key is drawn 32 times on average.
+	if len(counts) < n*9/10 {
+		t.Errorf("Sample(%v) draws %v of %v keys", draws, len(counts), n)
+	}
+	for k, c := range counts {
+		if c > 4*draws/n {
+			t.Errorf("the key %v is drawn %v times; want about %v", k, c, draws/n)
+		}
+	}
+}
+
+func TestLongestChains(t *testing.T) {
+	hasher := func(key int) uint64 {
+		if key < 32 {
+			return 0 // the first keys collide
+		}
+		return uint64(key)
+	}
+	m := cmap.NewMapOf[int, int](hasher)
+	for i := 0; i < capacity; i++ {
+		m.Store(i, i)
+	}
+	m.Range(func(int, int) bool { return true }) // completes the resizes in progress
+	m.Delete(0)
+	chains := m.LongestChains(3)
+	if len(chains) != 3 || len(chains[1]) > len(chains[0]) || len(chains[2]) > len(chains[1]) {
+		t.Fatalf("LongestChains(3) = %v; want three chains, longest first", chains)
+	}
+	var colliding, live []int
+	for _, l := range chains[0] {
+		if l.Key < 32 {
+			colliding = append(colliding, l.Key)
+			if l.Live {
+				live = append(live, l.Key)
+			}
+		}
+	}
+	slices.Sort(colliding)
+	slices.Sort(live)
+	if len(colliding) != 32 || colliding[0] != 0 || colliding[31] != 31 || !slices.Equal(live, colliding[1:]) {
+		t.Errorf("the longest chain has the colliding keys %v, of which %v are live; want 0 to 31 and 1 to 31", colliding, live)
+	}
+	if chains := m.LongestChains(0); chains != nil {
+		t.Errorf("LongestChains(0) = %v; want nil", chains)
+	}
+}
+
+func TestTopKeys(t *testing.T) {
+	hasher := func(key int) uint64 { return uint64(key) }
+	for _, every := range []int{1, 4} {
+		m := cmap.NewMapOf[int, int](hasher, cmap.WithHotKeys(8, every))
+		for i := 0; i < 1002; i++ {
+			m.Store(i, i)
+		}
+		for i := 0; i < 1000; i++ {
+			m.Load(0)
+			if i%2 == 0 {
+				m.Store(1, i)
+			}
+			m.Load(i + 2) // each of the other keys is used once
+		}
+		top := m.TopKeys(2)
+		if len(top) != 2 || top[0].Key != 0 || top[1].Key != 1 || top[0].Count < top[1].Count {
+			t.Errorf("TopKeys(2) = %v sampling one in %v uses; want keys 0 and 1", top, every)
+		}
+		if every == 1 && (top[0].Count < 1000 || top[1].Count < 500) {
+			t.Errorf("TopKeys(2) = %v; want counts of at least 1000 and 500", top)
+		}
+		if n := len(m.TopKeys(100)); n != 8 {
+			t.Errorf("TopKeys(100) reports %v keys; want the 8 tracked", n)
+		}
+		m.Delete(0)
+		if top := m.TopKeys(1); len(top) != 1 || top[0].Key != 1 {
+			t.Errorf("TopKeys(1) = %v after the hottest key is deleted; want key 1", top)
+		}
+	}
+	if top := cmap.NewMapOf[int, int](hasher).TopKeys(1); top != nil {
+		t.Errorf("TopKeys(1) = %v for a map without WithHotKeys", top)
+	}
+}
+
+// recordingHook counts the operations reported to it.
+type recordingHook struct {
+	mu     sync.Mutex
+	before map[cmap.Op]int
+	after  map[string]int // by the operation and whether it found its key
+}
+
+func (h *recordingHook) Before(op cmap.Op) {
+	h.mu.Lock()
+	h.before[op]++
+	h.mu.Unlock()
+}
+
+func (h *recordingHook) After(op cmap.Op, found bool, elapsed time.Duration) {
+	h.mu.Lock()
+	h.after[fmt.Sprint(op, found)]++
+	h.mu.Unlock()
+}
+
+func TestHook(t *testing.T) {
+	h := &recordingHook{before: make(map[cmap.Op]int), after: make(map[string]int)}
+	m := cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) }, cmap.WithHook(h))
+	for i := 0; i < capacity; i++ {
+		m.Store(i, i)
+	}
+	m.Load(0)
+	m.Load(-1)
+	m.Delete(0)
+	m.Delete(0)
+	m.Swap(1, 1) // not observed
+	m.Compact()
+	m.Range(func(int, int) bool { return true }) // completes the resizes in progress
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.before[cmap.OpStore] != capacity || h.after["store true"] != capacity {
+		t.Errorf("the hook observes %v and %v stores; want %v", h.before[cmap.OpStore], h.after["store true"], capacity)
+	}
+	if h.before[cmap.OpLoad] != 2 || h.after["load true"] != 1 || h.after["load false"] != 1 {
+		t.Errorf("the hook observes the loads as %v", h.after)
+	}
+	if h.before[cmap.OpDelete] != 2 || h.after["delete true"] != 1 || h.after["delete false"] != 1 {
+		t.Errorf("the hook observes the deletes as %v", h.after)
+	}
+	if resizes := h.before[cmap.OpResize]; resizes < m.Stats().Shards || resizes != h.after["resize true"] {
+		t.Errorf("the hook observes %v resizes starting and %v ending", resizes, h.after["resize true"])
+	}
+}
+
+func TestPublish(t *testing.T) {
+	m := cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) }, cmap.WithCounters())
+	m.Publish("cmap_test_stats")
+	m.Store(0, 0)
+	m.Load(0)
+	var stats cmap.Stats
+	if err := json.Unmarshal([]byte(expvar.Get("cmap_test_stats").String()), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Keys != 1 || stats.Hits != 1 || stats.Shards != m.Stats().Shards {
+		t.Errorf("the published variable is %+v; want the statistics of the map", stats)
+	}
+}
+
+func TestStatsWindow(t *testing.T) {
+	m := cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) }, cmap.WithCounters())
+	m.Store(0, 0)
+	m.Load(0)
+	w := m.StatsWindow()
+	m.Load(0)
+	m.Load(1)
+	if stats, elapsed := w.Snapshot(); stats.Hits != 1 || stats.Misses != 1 || stats.Stores != 0 || stats.Keys != 1 || elapsed <= 0 {
+		t.Errorf("Snapshot() = %+v, %v; want a hit and a miss", stats, elapsed)
+	}
+	if stats, _ := w.Reset(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("Reset() = %+v; want a hit and a miss", stats)
+	}
+	m.Store(1, 1)
+	if stats, _ := w.Reset(); stats.Hits != 0 || stats.Misses != 0 || stats.Stores != 1 || stats.Keys != 2 {
+		t.Errorf("Reset() = %+v after another Reset; want a store", stats)
+	}
+	if stats := m.Stats(); stats.Hits != 2 || stats.Misses != 1 || stats.Stores != 2 {
+		t.Errorf("Stats() = %+v after Reset; want the counts since the map was created", stats)
+	}
+}
+
+func TestPin(t *testing.T) {
+	const n = 1 << 12
+	m := cmap.NewMapOfSharded[int, int](func(key int) uint64 { return uint64(key) }, nil, 1)
+	m.Pin()
+	m.Pin()
+	for i := 0; i < n; i++ {
+		m.Store(i, i)
+	}
+	m.Unpin()
+	if stats := m.Stats(); stats.Resizes != 0 || stats.Resizing != 0 {
+		t.Errorf("Stats() = %+v while the map is pinned", stats)
+	}
+	m.Unpin()
+	for i := 0; i < n; i++ {
+		m.Store(i, -i)
+	}
+	if stats := m.Stats(); stats.Resizes == 0 && stats.Resizing == 0 {
+		t.Errorf("Stats() = %+v after Unpin", stats)
+	}
+	for i := 0; i < n; i++ {
+		if v, ok := m.Load(i); !ok || v != -i {
+			t.Fatalf("Load(%v) = %v, %v; want %v, true", i, v, ok, -i)
+		}
+	}
+}
+
+func TestTryStoreAndTryDelete(t *testing.T) {
+	m := cmap.NewMapOf[string, int](cmap.StringHasher)
+	if !m.TryStore("a", 1) || !m.TryStore("a", 2) || !m.TryStore("b", 3) {
+		t.Fatal("TryStore failed on an idle map")
+	}
+	if v, ok := m.Load("a"); !ok || v != 2 || m.Len() != 2 {
+		t.Errorf("Load(a) = %v, %v and Len() = %v; want 2, true and 2", v, ok, m.Len())
+	}
+	if !m.TryDelete("a") || !m.TryDelete("c") {
+		t.Fatal("TryDelete failed on an idle map")
+	}
+	if _, ok := m.Load("a"); ok || m.Len() != 1 {
+		t.Errorf("a remains after TryDelete or Len() = %v; want 1", m.Len())
+	}
+}
+
+func TestLoadMany(t *testing.T) {
+	f := func(stored, keys []uint8) bool {
+		m := cmap.NewMapOf[uint8, int](func(key uint8) uint64 { return uint64(key % 16) })
+		builtIn := make(map[uint8]int)
+		for i, k := range stored {
+			m.Store(k, i)
+			builtIn[k] = i
+		}
+		values, found := make([]int, len(keys)), make([]bool, len(keys))
+		m.LoadMany(keys, values, found)
+		for i, k := range keys {
+			if v, ok := builtIn[k]; values[i] != v || found[i] != ok {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+
+	m := cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) }, cmap.WithCounters())
+	m.Store(0, 1)
+	values, found := make([]int, 2), make([]bool, 2)
+	m.LoadMany([]int{0, 1}, values, found)
+	if values[0] != 1 || !found[0] || found[1] {
+		t.Errorf("LoadMany([0 1]) = %v, %v; want [1 0], [true false]", values, found)
+	}
+	if stats := m.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("Stats() = %+v after LoadMany; want a hit and a miss", stats)
+	}
+}
+
+func TestStoreAll(t *testing.T) {
+	f := func(stored, src map[uint8]int) bool {
+		m := cmap.NewMapOf[uint8, int](func(key uint8) uint64 { return uint64(key % 16) })
+		for k, v := range stored {
+			m.Store(k, v)
+		}
+		m.StoreAll(src)
+		want := maps.Clone(stored)
+		maps.Copy(want, src)
+		return m.Len() == len(want) && maps.Equal(maps.Collect(m.All()), want)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+
+	const n = 1 << 12
+	src := make(map[int]int, n)
+	for i := 0; i < n; i++ {
+		src[i] = -i
+	}
+	m := cmap.NewMapOfSharded[int, int](func(key int) uint64 { return uint64(key) }, nil, 1)
+	m.StoreAll(src)
+	if stats := m.Stats(); stats.Keys != n || stats.Resizes > 1 || stats.Resizing != 0 {
+		t.Errorf("Stats() = %+v after StoreAll; want %v keys stored by at most one resize", stats, n)
+	}
+	limited := cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) }, cmap.WithLimit(capacity, cmap.Reject))
+	limited.StoreAll(src)
+	if limited.Len() != capacity {
+		t.Errorf("Len() = %v after StoreAll to a map limited to %v keys", limited.Len(), capacity)
+	}
+}
+
+func TestCompute(t *testing.T) {
+	// Each key is counted up to 3 and removed at the next call.
+	count := func(old int, loaded bool) (int, bool) {
+		return old + 1, loaded && old == 3
+	}
+	f := func(keys []uint8) bool {
+		m := cmap.NewMapOf[uint8, int](func(key uint8) uint64 { return uint64(key % 16) })
+		builtIn := make(map[uint8]int)
+		for _, k := range keys {
+			k %= 32 // so that keys recur
+			old, loaded := builtIn[k]
+			want, del := count(old, loaded)
+			if del {
+				delete(builtIn, k)
+			} else {
+				builtIn[k] = want
+			}
+			if v, ok := m.Compute(k, count); ok == del || ok && v != want {
+				return false
+			}
+		}
+		return maps.Equal(maps.Collect(m.All()), builtIn)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+
+	const workers, n = 8, 1024
+	m := cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) })
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				m.Compute(i%16, func(old int, _ bool) (int, bool) { return old + 1, false })
+			}
+		}()
+	}
+	wg.Wait()
+	for k, v := range m.All() {
+		if v != workers*n/16 {
+			t.Errorf("the key %v is counted %v times; want %v", k, v, workers*n/16)
+		}
+	}
+
+	limited := cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) }, cmap.WithLimit(1, cmap.Reject))
+	limited.Store(0, 0)
+	if v, ok := limited.Compute(1, count); ok || v != 0 || limited.Len() != 1 {
+		t.Errorf("Compute(1) = %v, %v in a full map; want 0, false", v, ok)
+	}
+}
+
+func TestComputeIfAbsent(t *testing.T) {
+	m := cmap.NewMapOf[int, *int](func(key int) uint64 { return uint64(key) })
+	var calls atomic.Int32
+	newValue := func() *int {
+		calls.Add(1)
+		time.Sleep(time.Millisecond) // lets the other goroutines find the key missing
+		return new(int)
+	}
+	const workers = 8
+	values := make([]*int, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			values[w], _ = m.ComputeIfAbsent(0, newValue)
+		}()
+	}
+	wg.Wait()
+	if n := calls.Load(); n != 1 {
+		t.Errorf("newValue is called %v times for a key; want once", n)
+	}
+	for _, v := range values {
+		if v != values[0] || v == nil {
+			t.Fatalf("ComputeIfAbsent returns %v; want the same value to every goroutine", values)
+		}
+	}
+	if v, loaded := m.ComputeIfAbsent(0, newValue); !loaded || v != values[0] || calls.Load() != 1 {
+		t.Errorf("ComputeIfAbsent(0) = %v, %v on an existing key; want %v, true", v, loaded, values[0])
+	}
+
+	limited := cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) }, cmap.WithLimit(1, cmap.Reject))
+	limited.Store(0, 0)
+	if v, loaded := limited.ComputeIfAbsent(1, func() int { return 1 }); loaded || v != 0 || limited.Len() != 1 {
+		t.Errorf("ComputeIfAbsent(1) = %v, %v in a full map; want 0, false", v, loaded)
+	}
+	evicting := cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) }, cmap.WithLimit(1, cmap.Evict))
+	evicting.Store(0, 0)
+	calls.Store(0)
+	if v, loaded := evicting.ComputeIfAbsent(1, func() int { calls.Add(1); return 1 }); loaded || v != 1 || calls.Load() != 1 {
+		t.Errorf("ComputeIfAbsent(1) = %v, %v calling newValue %v times in a full map evicting keys; want 1, false, and once", v, loaded, calls.Load())
+	}
+}
+
+func TestComputeIfPresent(t *testing.T) {
+	// Each key is counted down and removed at zero.
+	countDown := func(old int) (int, bool) {
+		return old - 1, old > 1
+	}
+	f := func(keys []uint8) bool {
+		m := cmap.NewMapOf[uint8, int](func(key uint8) uint64 { return uint64(key % 16) })
+		builtIn := make(map[uint8]int)
+		for i, k := range keys {
+			k %= 32 // so that keys recur
+			if i%3 == 0 {
+				m.Store(k, 3)
+				builtIn[k] = 3
+				continue
+			}
+			old, loaded := builtIn[k]
+			want, keep := countDown(old)
+			if !loaded {
+				keep = false
+			} else if keep {
+				builtIn[k] = want
+			} else {
+				delete(builtIn, k)
+			}
+			if v, ok := m.ComputeIfPresent(k, countDown); ok != keep || ok && v != want {
+				return false
+			}
+		}
+		return maps.Equal(maps.Collect(m.All()), builtIn)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+
+	m := cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) })
+	if v, ok := m.ComputeIfPresent(0, func(int) (int, bool) {
+		t.Error("f is called on a missing key")
+		return 1, true
+	}); ok || v != 0 || m.Len() != 0 {
+		t.Errorf("ComputeIfPresent(0) = %v, %v on a missing key; want 0, false", v, ok)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	sum := func(existing, incoming int) int { return existing + incoming }
+	f := func(pairs []uint8) bool {
+		m := cmap.NewMapOf[uint8, int](func(key uint8) uint64 { return uint64(key % 16) })
+		builtIn := make(map[uint8]int)
+		for _, p := range pairs {
+			k, v := p%32, int(p/32) // so that keys recur
+			builtIn[k] += v
+			if merged, ok := m.Merge(k, v, sum); !ok || merged != builtIn[k] {
+				return false
+			}
+		}
+		return maps.Equal(maps.Collect(m.All()), builtIn)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+
+	const workers, n = 8, 1024
+	m := cmap.NewMapOf[int, []int](func(key int) uint64 { return uint64(key) })
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				m.Merge(i%16, []int{w}, func(existing, incoming []int) []int {
+					return append(slices.Clip(existing), incoming...)
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	for k, v := range m.All() {
+		if len(v) != workers*n/16 {
+			t.Errorf("the key %v has %v values merged; want %v", k, len(v), workers*n/16)
+		}
+	}
+}
+
+func TestAddInt64(t *testing.T) {
+	const workers, n = 8, 1024
+	m := cmap.NewMap(cmap.DefaultHasher)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				if _, ok := m.AddInt64(i%16, 2); !ok {
+					t.Errorf("AddInt64(%v, 2) fails", i%16)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for k, v := range m.All() {
+		if v != int64(2*workers*n/16) {
+			t.Errorf("the key %v is added up to %v; want %v", k, v, 2*workers*n/16)
+		}
+	}
+	if v, ok := m.AddInt64(0, -1); !ok || v != 2*workers*n/16-1 {
+		t.Errorf("AddInt64(0, -1) = %v, %v; want %v, true", v, ok, 2*workers*n/16-1)
+	}
+	m.Store("a", "b")
+	if v, ok := m.AddInt64("a", 1); ok || v != 0 {
+		t.Errorf(`AddInt64("a", 1) = %v, %v on a string; want 0, false`, v, ok)
+	}
+	if v, _ := m.Load("a"); v != "b" {
+		t.Errorf(`Load("a") = %v after a failed AddInt64; want b`, v)
+	}
+
+	ints := cmap.NewMapOf[string, int64](cmap.StringHasher)
+	if v, ok := ints.AddInt64("a", 3); !ok || v != 3 {
+		t.Errorf(`AddInt64("a", 3) = %v, %v on a missing key; want 3, true`, v, ok)
+	}
+	strs := cmap.NewMapOf[string, string](cmap.StringHasher)
+	if v, ok := strs.AddInt64("a", 3); ok || v != 0 || strs.Len() != 0 {
+		t.Errorf(`AddInt64("a", 3) = %v, %v on a map of strings; want 0, false`, v, ok)
+	}
+	limited := cmap.NewMapOf[int, int64](func(key int) uint64 { return uint64(key) }, cmap.WithLimit(1, cmap.Reject))
+	limited.Store(0, 0)
+	if v, ok := limited.AddInt64(1, 1); ok || v != 0 || limited.Len() != 1 {
+		t.Errorf("AddInt64(1, 1) = %v, %v in a full map; want 0, false", v, ok)
+	}
+}
+
+func TestAddFloat64(t *testing.T) {
+	const workers, n = 8, 1024
+	m := cmap.NewMapOf[int, float64](func(key int) uint64 { return uint64(key) })
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				m.AddFloat64(i%16, 0.5)
+			}
+		}()
+	}
+	wg.Wait()
+	for k, v := range m.All() {
+		if v != workers*n/16/2 {
+			t.Errorf("the key %v is added up to %v; want %v", k, v, workers*n/16/2)
+		}
+	}
+
+	boxed := cmap.NewMap(cmap.DefaultHasher)
+	if v, ok := boxed.AddFloat64("a", 1.5); !ok || v != 1.5 {
+		t.Errorf(`AddFloat64("a", 1.5) = %v, %v on a missing key; want 1.5, true`, v, ok)
+	}
+	boxed.Store("b", int64(1))
+	if v, ok := boxed.AddFloat64("b", 1.5); ok || v != 0 {
+		t.Errorf(`AddFloat64("b", 1.5) = %v, %v on an int64; want 0, false`, v, ok)
+	}
+	if v, ok := cmap.NewMapOf[int, int64](func(key int) uint64 { return uint64(key) }).AddFloat64(0, 1); ok || v != 0 {
+		t.Errorf("AddFloat64(0, 1) = %v, %v on a map of int64; want 0, false", v, ok)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	const workers, n = 8, 1024
+	m := cmap.NewMapOf[int, string](func(key int) uint64 { return uint64(key) })
+	for i := 0; i < 16; i++ {
+		m.Store(i, "")
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				m.Update(i%16, func(old string) string {
+					return old + "x"
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	for k, v := range m.All() {
+		if len(v) != workers*n/16 {
+			t.Errorf("the key %v is updated %v times; want %v", k, len(v), workers*n/16)
+		}
+	}
+	if v, ok := m.Update(16, func(string) string {
+		t.Error("f is called on a missing key")
+		return ""
+	}); ok || v != "" || m.Len() != 16 {
+		t.Errorf("Update(16) = %q, %v on a missing key; want an empty string and false", v, ok)
+	}
+
+	limited := cmap.NewMapOf[int, string](func(key int) uint64 { return uint64(key) },
+		cmap.WithCostLimit(4, func(v string) int64 { return int64(len(v)) }, cmap.Reject))
+	limited.Store(0, "ab")
+	if v, ok := limited.Update(0, func(old string) string { return old + "cde" }); ok || v != "" {
+		t.Errorf("Update(0) = %q, %v beyond the cost limit; want an empty string and false", v, ok)
+	}
+	if v, _ := limited.Load(0); v != "ab" {
+		t.Errorf("Load(0) = %q after Update beyond the cost limit; want ab", v)
+	}
+
+	floats := cmap.NewMapOf[int, float64](func(key int) uint64 { return uint64(key) })
+	floats.Store(0, math.NaN())
+	if v, ok := floats.Update(0, func(old float64) float64 { return 1 }); ok || v != 0 {
+		t.Errorf("Update(0) = %v, %v on a NaN; want 0 and false", v, ok)
+	}
+}
+
+func TestLoadDoesNotAllocate(t *testing.T) {
+	m := cmap.NewMap(cmap.DefaultHasher)
+	for i := 0; i < 1000; i++ {
+		m.Store(i, i)
+		m.Store(strconv.Itoa(i), i)
+	}
+	key, s := 1000, "1000"
+	if n := testing.AllocsPerRun(100, func() {
+		key--
+		m.Load(key)
+		m.Load(s)
+	}); n != 0 {
+		t.Errorf("Load allocates %v times", n)
+	}
+}
@@ -0,0 +1,115 @@
+// Package cmapotel instruments the maps of package cmap with OpenTelemetry.
+// It is a module of its own so that the module of package cmap does not
+// depend on the OpenTelemetry API.
+//
+// The metrics of a map are recorded by a hook given to the map:
+//
+//	hook, err := cmapotel.NewHook(otel.Meter("example.com/sessions"), "sessions")
+//	if err != nil {
+//		...
+//	}
+//	sessions := cmap.NewMapOf[string, *Session](cmap.StringHasher, cmap.WithHook(hook))
+//
+// Since the methods of a map take no context, the spans of the operations on
+// the critical path of a request are recorded by calling them through Load,
+// Store, and Delete of this package, which start the spans from the context of
+// the request.
+package cmapotel
+
+import (
+	"context"
+	"time"
+
+	"github.com/decillion/go-cmap"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Hook is a cmap.Hook that records the durations of the operations of a map
+// in the histogram cmap.operation.duration and the number of resizes in
+// progress in the counter cmap.resizes.active, with the name of the map as
+// the attribute cmap.map and the operation as cmap.operation.
+type Hook struct {
+	duration metric.Float64Histogram
+	resizes  metric.Int64UpDownCounter
+	// The options of the measurements of each operation, and of whether it
+	// found its key, which are made once since they are the same every time.
+	records [len(ops)][2]metric.RecordOption
+	adds    metric.AddOption
+}
+
+// ops are the operations reported by a map.
+var ops = [...]cmap.Op{cmap.OpLoad, cmap.OpStore, cmap.OpDelete, cmap.OpResize}
+
+// NewHook returns a hook that records the metrics of the map of the given name
+// by the given meter.
+func NewHook(meter metric.Meter, name string) (*Hook, error) {
+	duration, err := meter.Float64Histogram("cmap.operation.duration",
+		metric.WithDescription("The duration of the operations of the map."), metric.WithUnit("s"))
+	if err != nil {
+		return nil, err
+	}
+	resizes, err := meter.Int64UpDownCounter("cmap.resizes.active",
+		metric.WithDescription("The number of resizes of the shards of the map in progress."), metric.WithUnit("{resize}"))
+	if err != nil {
+		return nil, err
+	}
+	h := &Hook{duration: duration, resizes: resizes}
+	for _, op := range ops {
+		for i, found := range []bool{false, true} {
+			h.records[op][i] = metric.WithAttributeSet(attribute.NewSet(
+				attribute.String("cmap.map", name), attribute.String("cmap.operation", op.String()), attribute.Bool("cmap.found", found)))
+		}
+	}
+	h.adds = metric.WithAttributeSet(attribute.NewSet(attribute.String("cmap.map", name)))
+	return h, nil
+}
+
+// Before records the start of a resize.
+func (h *Hook) Before(op cmap.Op) {
+	if op == cmap.OpResize {
+		h.resizes.Add(context.Background(), 1, h.adds)
+	}
+}
+
+// After records the duration of an operation, and the end of a resize.
+func (h *Hook) After(op cmap.Op, found bool, elapsed time.Duration) {
+	if int(op) >= len(ops) {
+		return
+	}
+	i := 0
+	if found {
+		i = 1
+	}
+	h.duration.Record(context.Background(), elapsed.Seconds(), h.records[op][i])
+	if op == cmap.OpResize {
+		h.resizes.Add(context.Background(), -1, h.adds)
+	}
+}
+
+// Load calls m.Load(key) in a span named cmap.Load started by the given tracer
+// from the given context, which records whether the key is found.
+func Load[K comparable, V any](ctx context.Context, tracer trace.Tracer, m *cmap.MapOf[K, V], key K) (value V, ok bool) {
+	_, span := tracer.Start(ctx, "cmap.Load")
+	defer span.End()
+	value, ok = m.Load(key)
+	span.SetAttributes(attribute.Bool("cmap.found", ok))
+	return
+}
+
+// Store calls m.Store(key, value) in a span named cmap.Store started by the
+// given tracer from the given context.
+func Store[K comparable, V any](ctx context.Context, tracer trace.Tracer, m *cmap.MapOf[K, V], key K, value V) {
+	_, span := tracer.Start(ctx, "cmap.Store")
+	defer span.End()
+	m.Store(key, value)
+}
+
+// Delete calls m.Delete(key) in a span named cmap.Delete started by the given
+// tracer from the given context.
+func Delete[K comparable, V any](ctx context.Context, tracer trace.Tracer, m *cmap.MapOf[K, V], key K) {
+	_, span := tracer.Start(ctx, "cmap.Delete")
+	defer span.End()
+	m.Delete(key)
+}
@@ -0,0 +1,68 @@
+package cmapotel_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/decillion/go-cmap"
+	"github.com/decillion/go-cmap/cmapotel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestHook(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	meter := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test")
+	hook, err := cmapotel.NewHook(meter, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := cmap.NewMapOf[string, int](cmap.StringHasher, cmap.WithHook(hook))
+	m.Store("a", 1)
+	m.Load("a")
+	m.Load("b")
+	m.Load("c")
+
+	var data metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &data); err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]uint64)
+	for _, scope := range data.ScopeMetrics {
+		for _, metric := range scope.Metrics {
+			if metric.Name != "cmap.operation.duration" {
+				continue
+			}
+			for _, p := range metric.Data.(metricdata.Histogram[float64]).DataPoints {
+				op, _ := p.Attributes.Value("cmap.operation")
+				found, _ := p.Attributes.Value("cmap.found")
+				counts[op.AsString()+" "+found.Emit()] += p.Count
+			}
+		}
+	}
+	if counts["store true"] != 1 || counts["load true"] != 1 || counts["load false"] != 2 {
+		t.Errorf("the recorded operations are %v", counts)
+	}
+}
+
+func TestSpans(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)).Tracer("test")
+	m := cmap.NewMapOf[string, int](cmap.StringHasher)
+	ctx := context.Background()
+	cmapotel.Store(ctx, tracer, m, "a", 1)
+	if v, ok := cmapotel.Load(ctx, tracer, m, "a"); v != 1 || !ok {
+		t.Errorf(`Load("a") = %v, %v; want 1, true`, v, ok)
+	}
+	cmapotel.Delete(ctx, tracer, m, "a")
+
+	var names []string
+	for _, s := range spans.Ended() {
+		names = append(names, s.Name())
+	}
+	if len(names) != 3 || names[0] != "cmap.Store" || names[1] != "cmap.Load" || names[2] != "cmap.Delete" {
+		t.Errorf("the spans recorded are %v", names)
+	}
+}
@@ -0,0 +1,97 @@
+// Package cmapprom exposes the statistics of the maps of package cmap to
+// Prometheus. It is a module of its own so that the module of package cmap
+// does not depend on the Prometheus client.
+//
+// A map is graphed by registering a collector for it:
+//
+//	prometheus.MustRegister(cmapprom.NewCollector("sessions", sessions))
+//
+// The hit rate of a map created with cmap.WithCounters is computed from the
+// counters of hits and misses, for example by
+//
+//	rate(cmap_hits_total[5m]) / (rate(cmap_hits_total[5m]) + rate(cmap_misses_total[5m]))
+package cmapprom
+
+import (
+	"github.com/decillion/go-cmap"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Source is a map whose statistics are collected, such as a cmap.MapOf.
+type Source interface {
+	Stats() cmap.Stats
+}
+
+// Collector is a prometheus.Collector of the statistics of a map. The
+// statistics are read from the map every time they are collected.
+type Collector struct {
+	source Source
+	descs  []*prometheus.Desc
+}
+
+// metric is a metric of the statistics of a map.
+type metric struct {
+	name, help string
+	kind       prometheus.ValueType
+	value      func(stats *cmap.Stats) float64
+}
+
+// metrics are the metrics collected by a Collector, in the order of its descs.
+var metrics = []metric{
+	{"keys", "The number of keys in the map.", prometheus.GaugeValue,
+		func(s *cmap.Stats) float64 { return float64(s.Keys) }},
+	{"entries", "The number of entries in the tables of the map, including tombstones.", prometheus.GaugeValue,
+		func(s *cmap.Stats) float64 { return float64(s.Entries) }},
+	{"tombstones", "The number of logically deleted entries in the tables of the map.", prometheus.GaugeValue,
+		func(s *cmap.Stats) float64 { return float64(s.Tombstones) }},
+	{"buckets", "The total number of buckets of the tables of the map.", prometheus.GaugeValue,
+		func(s *cmap.Stats) float64 { return float64(s.Buckets) }},
+	{"largest_bucket", "The number of entries in the largest bucket of the map.", prometheus.GaugeValue,
+		func(s *cmap.Stats) float64 { return float64(s.LargestBucket) }},
+	{"shards", "The number of shards of the map.", prometheus.GaugeValue,
+		func(s *cmap.Stats) float64 { return float64(s.Shards) }},
+	{"resizing_shards", "The number of shards of the map being resized.", prometheus.GaugeValue,
+		func(s *cmap.Stats) float64 { return float64(s.Resizing) }},
+	{"resizes_total", "The number of completed resizes of the shards of the map.", prometheus.CounterValue,
+		func(s *cmap.Stats) float64 { return float64(s.Resizes) }},
+	{"last_resize_seconds", "The duration of the latest resize of the map.", prometheus.GaugeValue,
+		func(s *cmap.Stats) float64 { return s.LastResize.Seconds() }},
+	{"hits_total", "The number of loads that found the key.", prometheus.CounterValue,
+		func(s *cmap.Stats) float64 { return float64(s.Hits) }},
+	{"misses_total", "The number of loads that did not find the key.", prometheus.CounterValue,
+		func(s *cmap.Stats) float64 { return float64(s.Misses) }},
+	{"stores_total", "The number of values stored.", prometheus.CounterValue,
+		func(s *cmap.Stats) float64 { return float64(s.Stores) }},
+	{"deletes_total", "The number of keys removed, including evicted ones.", prometheus.CounterValue,
+		func(s *cmap.Stats) float64 { return float64(s.Deletes) }},
+	{"evictions_total", "The number of keys evicted to make room for others.", prometheus.CounterValue,
+		func(s *cmap.Stats) float64 { return float64(s.Evictions) }},
+}
+
+// NewCollector returns a collector of the statistics of the given map, whose
+// metrics are named cmap_keys, cmap_hits_total, and so on, with the given
+// name as the value of their label "map", so that the collectors of several
+// maps can be registered together. The counts of operations are zero unless
+// the map is created with cmap.WithCounters.
+func NewCollector(name string, source Source) *Collector {
+	c := &Collector{source: source, descs: make([]*prometheus.Desc, len(metrics))}
+	for i, m := range metrics {
+		c.descs[i] = prometheus.NewDesc(prometheus.BuildFQName("cmap", "", m.name), m.help, nil, prometheus.Labels{"map": name})
+	}
+	return c
+}
+
+// Describe sends the descriptors of the metrics of the collector to ch.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range c.descs {
+		ch <- d
+	}
+}
+
+// Collect reads the statistics of the map and sends them to ch.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.source.Stats()
+	for i, m := range metrics {
+		ch <- prometheus.MustNewConstMetric(c.descs[i], m.kind, m.value(&stats))
+	}
+}
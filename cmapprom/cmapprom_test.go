@@ -0,0 +1,43 @@
+package cmapprom_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/decillion/go-cmap"
+	"github.com/decillion/go-cmap/cmapprom"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector(t *testing.T) {
+	m := cmap.NewMapOf[string, int](cmap.StringHasher, cmap.WithCounters())
+	m.Store("a", 1)
+	m.Store("b", 2)
+	m.Load("a")
+	m.Load("c")
+
+	r := prometheus.NewPedanticRegistry()
+	r.MustRegister(cmapprom.NewCollector("test", m))
+	r.MustRegister(cmapprom.NewCollector("other", cmap.NewMapOf[string, int](cmap.StringHasher)))
+	const want = `
+# HELP cmap_keys The number of keys in the map.
+# TYPE cmap_keys gauge
+cmap_keys{map="other"} 0
+cmap_keys{map="test"} 2
+# HELP cmap_hits_total The number of loads that found the key.
+# TYPE cmap_hits_total counter
+cmap_hits_total{map="other"} 0
+cmap_hits_total{map="test"} 1
+# HELP cmap_misses_total The number of loads that did not find the key.
+# TYPE cmap_misses_total counter
+cmap_misses_total{map="other"} 0
+cmap_misses_total{map="test"} 1
+`
+	if err := testutil.GatherAndCompare(r, strings.NewReader(want), "cmap_keys", "cmap_hits_total", "cmap_misses_total"); err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(cmapprom.NewCollector("test", m)); n != 14 {
+		t.Errorf("the collector collects %v metrics; want 14", n)
+	}
+}
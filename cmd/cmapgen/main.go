@@ -0,0 +1,112 @@
+// Command cmapgen generates a concurrent map specialized for a key type and a
+// value type. The generated map has the same design and the same methods as
+// cmap.MapOf, but it does not depend on generics or on this module.
+//
+// Usage:
+//
+//	cmapgen -name UserMap -key string -value '*User' [flags]
+//
+// It is intended to be used with go generate:
+//
+//	//go:generate cmapgen -name UserMap -key string -value *User
+//
+// Flags:
+//
+//	-name      name of the generated map type (required)
+//	-key       key type (required)
+//	-value     value type (required)
+//	-hasher    name of a func(key) uint32 in the package; it can be omitted
+//	           for string and integer key types
+//	-cas       generate CompareAndSwap and CompareAndDelete, which require a
+//	           comparable value type (default true)
+//	-package   package name of the generated file (default $GOPACKAGE)
+//	-o         output file (default <name>_cmap.go in lower case)
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// config holds the parameters of the generated code.
+type config struct {
+	Package string
+	Name    string
+	Key     string
+	Value   string
+	Hasher  string
+	CAS     bool
+	Args    string
+}
+
+var integerTypes = map[string]bool{
+	"int": true, "int8": true, "int16": true, "int32": true, "int64": true,
+	"uint": true, "uint8": true, "uint16": true, "uint32": true, "uint64": true,
+	"uintptr": true, "byte": true, "rune": true,
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("cmapgen: ")
+
+	var c config
+	var output string
+	flag.StringVar(&c.Name, "name", "", "name of the generated map type")
+	flag.StringVar(&c.Key, "key", "", "key type")
+	flag.StringVar(&c.Value, "value", "", "value type")
+	flag.StringVar(&c.Hasher, "hasher", "", "name of a func(key) uint32 hashing keys")
+	flag.BoolVar(&c.CAS, "cas", true, "generate CompareAndSwap and CompareAndDelete")
+	flag.StringVar(&c.Package, "package", os.Getenv("GOPACKAGE"), "package name")
+	flag.StringVar(&output, "o", "", "output file")
+	flag.Parse()
+	c.Args = strings.Join(os.Args[1:], " ")
+
+	if output == "" {
+		output = strings.ToLower(c.Name) + "_cmap.go"
+	}
+	src, err := generate(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(output, src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// generate returns the formatted source code of the map described by c.
+func generate(c config) ([]byte, error) {
+	switch {
+	case c.Name == "" || c.Key == "" || c.Value == "":
+		return nil, fmt.Errorf("-name, -key, and -value are required")
+	case c.Package == "":
+		return nil, fmt.Errorf("-package is required outside go generate")
+	case c.Hasher == "" && c.Key != "string" && !integerTypes[c.Key]:
+		return nil, fmt.Errorf("-hasher is required for key type %s", c.Key)
+	}
+
+	var buf bytes.Buffer
+	if err := mapTemplate.Execute(&buf, c); err != nil {
+		return nil, err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("generated invalid code: %v", err)
+	}
+	return src, nil
+}
+
+var mapTemplate = template.Must(template.New("map").Funcs(template.FuncMap{
+	"unexport": func(s string) string {
+		r := []rune(s)
+		r[0] = unicode.ToLower(r[0])
+		return string(r)
+	},
+	"integer": func(s string) bool { return integerTypes[s] },
+}).Parse(mapSource))
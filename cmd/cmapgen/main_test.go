@@ -0,0 +1,52 @@
+package main
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"testing"
+)
+
+func typeCheck(t *testing.T, src []byte, extra string) {
+	t.Helper()
+	fset := token.NewFileSet()
+	files := []*ast.File{}
+	for name, s := range map[string]string{"gen.go": string(src), "extra.go": extra} {
+		f, err := parser.ParseFile(fset, name, s, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	if _, err := conf.Check("p", fset, files, nil); err != nil {
+		t.Fatalf("generated code does not type-check: %v\n%s", err, src)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	for _, c := range []struct {
+		config
+		extra string
+	}{
+		{config{Package: "p", Name: "StringIntMap", Key: "string", Value: "int", CAS: true}, "package p\n"},
+		{config{Package: "p", Name: "IDMap", Key: "uint64", Value: "*T", CAS: true}, "package p\ntype T struct{}\n"},
+		{config{Package: "p", Name: "PointMap", Key: "Point", Value: "[]byte", Hasher: "hashPoint"},
+			"package p\ntype Point struct{ X, Y int }\nfunc hashPoint(p Point) uint32 { return uint32(p.X*31 + p.Y) }\n"},
+	} {
+		src, err := generate(c.config)
+		if err != nil {
+			t.Fatalf("generate(%+v): %v", c.config, err)
+		}
+		typeCheck(t, src, c.extra)
+	}
+}
+
+func TestGenerateRequiresHasher(t *testing.T) {
+	c := config{Package: "p", Name: "PointMap", Key: "Point", Value: "int"}
+	if _, err := generate(c); err == nil {
+		t.Error("generate succeeded without a hasher for a struct key")
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+// mapSource is the template of the generated code. It is a monomorphized copy
+// of hmap.MapOf and cmap.MapOf.
+const mapSource = `// Code generated by "cmapgen {{.Args}}"; DO NOT EDIT.
+
+{{$t := unexport .Name -}}
+package {{.Package}}
+
+import (
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+const (
+	{{$t}}IniCapacity   = 1 << 4
+	{{$t}}MinLoadFactor = 2
+	{{$t}}MidLoadFactor = 4
+	{{$t}}MaxLoadFactor = 6
+	{{$t}}MaxBucketSize = 18
+	{{$t}}MinMapSize    = {{$t}}IniCapacity * {{$t}}MidLoadFactor
+)
+
+// {{.Name}} is a concurrent map from {{.Key}} to {{.Value}}.
+type {{.Name}} struct {
+	size     int64 // the number of keys; kept first for 64-bit alignment
+	mu       sync.Mutex
+	hm       unsafe.Pointer // *{{$t}}Table
+	inResize int32
+}
+
+// {{$t}}Table is a non-resizable hash map. Only update operations need an
+// external synchronization.
+type {{$t}}Table struct {
+	buckets       []{{$t}}Bucket
+	numOfEntries  uint
+	numOfDeleted  uint
+	largestBucket uint
+}
+
+type {{$t}}Bucket struct {
+	first        unsafe.Pointer // *{{$t}}Entry
+	numOfEntries uint
+}
+
+type {{$t}}Entry struct {
+	key   {{.Key}}
+	value unsafe.Pointer // *{{.Value}}
+	next  unsafe.Pointer // *{{$t}}Entry
+}
+
+// {{$t}}Deleted is the value of a logically deleted entry.
+var {{$t}}Deleted = unsafe.Pointer(new(byte))
+
+func {{$t}}Hash(key {{.Key}}) uint32 {
+{{- if .Hasher}}
+	return {{.Hasher}}(key)
+{{- else if eq .Key "string"}}
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+	return hash
+{{- else}}
+	h := uint64(key)
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return uint32(h)
+{{- end}}
+}
+
+func new{{.Name}}Table(capacity uint) *{{$t}}Table {
+	return &{{$t}}Table{buckets: make([]{{$t}}Bucket, capacity)}
+}
+
+func (e *{{$t}}Entry) loadValue() (value {{.Value}}, ok bool) {
+	p := atomic.LoadPointer(&e.value)
+	if p == {{$t}}Deleted {
+		return value, false
+	}
+	return *(*{{.Value}})(p), true
+}
+
+func (e *{{$t}}Entry) storeValue(value {{.Value}}) {
+	atomic.StorePointer(&e.value, unsafe.Pointer(&value))
+}
+
+func (e *{{$t}}Entry) loadNext() *{{$t}}Entry {
+	return (*{{$t}}Entry)(atomic.LoadPointer(&e.next))
+}
+
+func (t *{{$t}}Table) findEntry(key {{.Key}}) (b *{{$t}}Bucket, e *{{$t}}Entry) {
+	b = &t.buckets[{{$t}}Hash(key)%uint32(len(t.buckets))]
+	e = (*{{$t}}Entry)(atomic.LoadPointer(&b.first))
+	for e != nil && e.key != key {
+		e = e.loadNext()
+	}
+	return b, e
+}
+
+func (t *{{$t}}Table) insert(b *{{$t}}Bucket, key {{.Key}}, value {{.Value}}) {
+	t.numOfEntries++
+	b.numOfEntries++
+	if b.numOfEntries > t.largestBucket {
+		t.largestBucket++
+	}
+	e := &{{$t}}Entry{key: key, next: atomic.LoadPointer(&b.first)}
+	e.storeValue(value)
+	atomic.StorePointer(&b.first, unsafe.Pointer(e))
+}
+
+// New{{.Name}} returns an empty {{.Name}}.
+func New{{.Name}}() *{{.Name}} {
+	return &{{.Name}}{hm: unsafe.Pointer(new{{.Name}}Table({{$t}}IniCapacity))}
+}
+
+func (m *{{.Name}}) table() *{{$t}}Table {
+	return (*{{$t}}Table)(atomic.LoadPointer(&m.hm))
+}
+
+// Load returns the value associated with the given key and true if the key
+// exists. Otherwise, it returns the zero value and false.
+func (m *{{.Name}}) Load(key {{.Key}}) (value {{.Value}}, ok bool) {
+	if _, e := m.table().findEntry(key); e != nil {
+		return e.loadValue()
+	}
+	return value, false
+}
+
+// Store sets the given value to the given key.
+func (m *{{.Name}}) Store(key {{.Key}}, value {{.Value}}) {
+	m.Swap(key, value)
+}
+
+// LoadOrStore returns the existing value for the given key and true if the key
+// exists. Otherwise, it stores the given value and returns it and false.
+func (m *{{.Name}}) LoadOrStore(key {{.Key}}, value {{.Value}}) (actual {{.Value}}, loaded bool) {
+	if actual, loaded = m.Load(key); loaded {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.table()
+	b, e := t.findEntry(key)
+	if e == nil {
+		t.insert(b, key, value)
+	} else if actual, loaded = e.loadValue(); loaded {
+		return
+	} else {
+		t.numOfDeleted--
+		e.storeValue(value)
+	}
+	atomic.AddInt64(&m.size, 1)
+	m.resizeIfNeeded()
+	return value, false
+}
+
+// Swap sets the given value to the given key and returns the previous value
+// and true if the key exists. Otherwise, it returns the zero value and false.
+func (m *{{.Name}}) Swap(key {{.Key}}, value {{.Value}}) (previous {{.Value}}, loaded bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.table()
+	b, e := t.findEntry(key)
+	if e != nil {
+		p := atomic.SwapPointer(&e.value, unsafe.Pointer(&value))
+		if p != {{$t}}Deleted {
+			return *(*{{.Value}})(p), true
+		}
+		t.numOfDeleted--
+	} else {
+		t.insert(b, key, value)
+	}
+	atomic.AddInt64(&m.size, 1)
+	m.resizeIfNeeded()
+	return
+}
+{{if .CAS}}
+// CompareAndSwap sets the given new value to the given key if the key exists
+// and its value is equal to old. It reports whether the value was replaced.
+func (m *{{.Name}}) CompareAndSwap(key {{.Key}}, old, new {{.Value}}) (swapped bool) {
+	if v, ok := m.Load(key); !ok || v != old {
+		return false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, e := m.table().findEntry(key); e != nil {
+		if v, ok := e.loadValue(); ok && v == old {
+			e.storeValue(new)
+			return true
+		}
+	}
+	return false
+}
+{{end}}
+// Delete logically removes the given key and its associated value.
+func (m *{{.Name}}) Delete(key {{.Key}}) {
+	m.LoadAndDelete(key)
+}
+
+// LoadAndDelete logically removes the given key and returns its associated
+// value and true if the key exists. Otherwise, it returns the zero value and
+// false.
+func (m *{{.Name}}) LoadAndDelete(key {{.Key}}) (value {{.Value}}, loaded bool) {
+	if value, loaded = m.Load(key); !loaded {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.table()
+	if _, e := t.findEntry(key); e != nil {
+		if value, loaded = e.loadValue(); loaded {
+			atomic.StorePointer(&e.value, {{$t}}Deleted)
+			t.numOfDeleted++
+			atomic.AddInt64(&m.size, -1)
+			m.resizeIfNeeded()
+		}
+	}
+	return
+}
+{{if .CAS}}
+// CompareAndDelete logically removes the given key if the key exists and its
+// value is equal to old. It reports whether the key was removed.
+func (m *{{.Name}}) CompareAndDelete(key {{.Key}}, old {{.Value}}) (removed bool) {
+	if v, ok := m.Load(key); !ok || v != old {
+		return false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.table()
+	if _, e := t.findEntry(key); e != nil {
+		if v, ok := e.loadValue(); ok && v == old {
+			atomic.StorePointer(&e.value, {{$t}}Deleted)
+			t.numOfDeleted++
+			atomic.AddInt64(&m.size, -1)
+			m.resizeIfNeeded()
+			return true
+		}
+	}
+	return false
+}
+{{end}}
+// Clear removes all keys from the map.
+func (m *{{.Name}}) Clear() {
+	m.mu.Lock()
+	atomic.StorePointer(&m.hm, unsafe.Pointer(new{{.Name}}Table({{$t}}IniCapacity)))
+	atomic.StoreInt64(&m.size, 0)
+	m.mu.Unlock()
+}
+
+// Len returns the number of keys in the map.
+func (m *{{.Name}}) Len() int {
+	return int(atomic.LoadInt64(&m.size))
+}
+
+// Range iteratively applies the given function to each key-value pair until
+// the function returns false. The map is not resized while Range is running.
+func (m *{{.Name}}) Range(f func(key {{.Key}}, value {{.Value}}) bool) {
+	m.mu.Lock() // To ensure that no other process concurrently resizes the map.
+	atomic.AddInt32(&m.inResize, 1)
+	m.mu.Unlock()
+	defer atomic.AddInt32(&m.inResize, -1)
+
+	m.table().rangeEntries(f)
+}
+
+func (t *{{$t}}Table) rangeEntries(f func(key {{.Key}}, value {{.Value}}) bool) {
+	for i := range t.buckets {
+		e := (*{{$t}}Entry)(atomic.LoadPointer(&t.buckets[i].first))
+		for ; e != nil; e = e.loadNext() {
+			if v, ok := e.loadValue(); ok && !f(e.key, v) {
+				return
+			}
+		}
+	}
+}
+
+// This method can only be issued inside the critical section.
+func (m *{{.Name}}) resizeIfNeeded() {
+	if atomic.LoadInt32(&m.inResize) != 0 {
+		return
+	}
+
+	t := m.table()
+	entries, deleted := t.numOfEntries, t.numOfDeleted
+	buckets, largest := uint(len(t.buckets)), t.largestBucket
+	if entries < {{$t}}MinMapSize {
+		return
+	}
+	tooSmallBuckets := float32(entries)/float32(buckets) > {{$t}}MaxLoadFactor
+	tooManyDeleted := entries < 5*deleted
+	bucketOverflow := largest > {{$t}}MaxBucketSize
+
+	var newCapacity uint
+	if tooSmallBuckets || bucketOverflow {
+		newCapacity = 2*buckets - 1
+	} else if tooManyDeleted {
+		newCapacity = (entries - deleted) / {{$t}}MinLoadFactor
+	} else {
+		return
+	}
+	newTable := new{{.Name}}Table(newCapacity)
+	t.rangeEntries(func(k {{.Key}}, v {{.Value}}) bool {
+		b, _ := newTable.findEntry(k)
+		newTable.insert(b, k, v)
+		return true
+	})
+	atomic.StorePointer(&m.hm, unsafe.Pointer(newTable))
+}
+`
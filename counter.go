@@ -0,0 +1,76 @@
+package cmap
+
+import "sync/atomic"
+
+// CounterMapOf is a concurrent map from keys of type K to int64 counters.
+//
+// Each counter is allocated once when its key is first added, and after that
+// it is incremented by an atomic instruction while only the bucket of its key
+// is locked, so that an addition is never applied to a counter that Delete
+// has removed.
+type CounterMapOf[K comparable] struct {
+	m *MapOf[K, *int64]
+}
+
+// CounterMap is a concurrent counter map whose keys are of arbitrary types.
+type CounterMap = CounterMapOf[interface{}]
+
+// NewCounterMap returns an empty counter map whose keys are hashed by the
+// given function.
+func NewCounterMap[H Hash](hasher func(key interface{}) H) (m *CounterMap) {
+	return NewCounterMapOf[interface{}](hasher)
+}
+
+// NewCounterMapOf returns an empty counter map from keys of type K which are
+// hashed by the given function.
+func NewCounterMapOf[K comparable, H Hash](hasher func(key K) H) (m *CounterMapOf[K]) {
+	return &CounterMapOf[K]{m: NewMapOf[K, *int64](hasher)}
+}
+
+// Add atomically adds delta to the counter of the given key and returns the
+// new count. A missing key is added with a count of zero before the addition.
+func (m *CounterMapOf[K]) Add(key K, delta int64) (count int64) {
+	m.m.compute(key, func(c *int64, loaded bool) (*int64, bool) {
+		if !loaded {
+			c = new(int64)
+		}
+		count = atomic.AddInt64(c, delta)
+		return c, true
+	})
+	return
+}
+
+// Inc atomically increments the counter of the given key and returns the new
+// count.
+func (m *CounterMapOf[K]) Inc(key K) (count int64) {
+	return m.Add(key, 1)
+}
+
+// Load returns the count of the given key and true if the key exists.
+// Otherwise, it returns zero and false.
+func (m *CounterMapOf[K]) Load(key K) (count int64, ok bool) {
+	if c, ok := m.m.Load(key); ok {
+		return atomic.LoadInt64(c), true
+	}
+	return 0, false
+}
+
+// Delete removes the given key and its counter. An addition concurrent with
+// Delete is applied either before the counter is removed or to a new counter.
+func (m *CounterMapOf[K]) Delete(key K) {
+	m.m.Delete(key)
+}
+
+// Len returns the number of counters in the map.
+func (m *CounterMapOf[K]) Len() int {
+	return m.m.Len()
+}
+
+// Range iteratively applies the given function to each key and its count
+// until the function returns false. It gives the same guarantees as
+// MapOf.Range.
+func (m *CounterMapOf[K]) Range(f func(key K, count int64) bool) {
+	m.m.Range(func(k K, c *int64) bool {
+		return f(k, atomic.LoadInt64(c))
+	})
+}
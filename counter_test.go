@@ -0,0 +1,47 @@
+package cmap_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/decillion/go-cmap"
+)
+
+func TestCounterMapConcurrentAdd(t *testing.T) {
+	const goroutines, keys, adds = 8, 16, 1 << 10
+	m := cmap.NewCounterMapOf[uint64](cmap.Uint64Hasher)
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < adds; i++ {
+				m.Inc(uint64(i % keys))
+				m.Add(uint64(i%keys), 2)
+			}
+		}()
+	}
+	wg.Wait()
+
+	want := int64(3 * goroutines * adds / keys)
+	for k := uint64(0); k < keys; k++ {
+		if count, ok := m.Load(k); !ok || count != want {
+			t.Errorf("Load(%v) = %v, %v; want %v, true", k, count, ok, want)
+		}
+	}
+	if m.Len() != keys {
+		t.Errorf("Len() = %v; want %v", m.Len(), keys)
+	}
+}
+
+func TestCounterMapDelete(t *testing.T) {
+	m := cmap.NewCounterMap(cmap.DefaultHasher)
+	m.Add("key", 5)
+	m.Delete("key")
+	if count, ok := m.Load("key"); ok {
+		t.Errorf("Load after Delete = %v, %v; want 0, false", count, ok)
+	}
+	if count := m.Inc("key"); count != 1 {
+		t.Errorf("Inc after Delete = %v; want 1", count)
+	}
+}
@@ -0,0 +1,71 @@
+package cmap
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// WriteCSV writes the key-value pairs of a map with string keys to w as
+// records of two fields, the key and the value formatted by format, or by
+// fmt.Sprint if format is nil, and flushes w. A tab-separated dump is written
+// by setting w.Comma to '\t'. The pairs are visited by Range, so the map is
+// not locked and updates made during WriteCSV may or may not be written.
+func WriteCSV[V any](w *csv.Writer, m *MapOf[string, V], format func(value V) string) (err error) {
+	if format == nil {
+		format = func(value V) string { return fmt.Sprint(value) }
+	}
+	record := make([]string, 2)
+	m.Range(func(k string, v V) bool {
+		record[0], record[1] = k, format(v)
+		err = w.Write(record)
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// ReadCSV reads records of two fields, a key and its value, from r until its
+// end, and stores the values parsed by parse into a map with string keys. It is
+// the inverse of WriteCSV given the inverse of its format. A tab-separated
+// dump is read by setting r.Comma to '\t'. It returns the number of records
+// stored, and stops at the first record that is malformed or whose value
+// cannot be parsed, reporting the line of the record. A nil parse stores the
+// fields as they are, which is allowed only if V is string.
+func ReadCSV[V any](r *csv.Reader, m *MapOf[string, V], parse func(field string) (V, error)) (n int, err error) {
+	if parse == nil {
+		if _, ok := any(new(V)).(*string); !ok {
+			return 0, errNoParse
+		}
+		parse = func(field string) (value V, err error) {
+			*any(&value).(*string) = field
+			return
+		}
+	}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		line, _ := r.FieldPos(0)
+		if len(record) != 2 {
+			return n, fmt.Errorf("cmap: line %v: a record of %v fields instead of 2", line, len(record))
+		}
+		v, err := parse(record[1])
+		if err != nil {
+			return n, fmt.Errorf("cmap: line %v: %w", line, err)
+		}
+		m.Store(record[0], v)
+		n++
+	}
+}
+
+// errNoParse is returned by ReadCSV if no parse function is given.
+var errNoParse = errors.New("cmap: ReadCSV without a parse function into values other than strings")
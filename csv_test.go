@@ -0,0 +1,73 @@
+package cmap_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/quick"
+
+	"github.com/decillion/go-cmap"
+)
+
+func TestCSVMatchesBuiltInMap(t *testing.T) {
+	f := func(builtin map[string]int, tabs bool) bool {
+		m := cmap.NewMapOf[string, int](cmap.StringHasher)
+		for k, v := range builtin {
+			m.Store(k, v)
+		}
+		var b bytes.Buffer
+		w := csv.NewWriter(&b)
+		r := csv.NewReader(&b)
+		if tabs {
+			w.Comma, r.Comma = '\t', '\t'
+		}
+		if err := cmap.WriteCSV(w, m, strconv.Itoa); err != nil {
+			return false
+		}
+		read := cmap.NewMapOf[string, int](cmap.StringHasher)
+		// csv turns \r\n in quoted fields into \n, so such keys are not
+		// read back as they are written.
+		n, err := cmap.ReadCSV(r, read, strconv.Atoi)
+		if err != nil || n != len(builtin) {
+			return false
+		}
+		for k, v := range builtin {
+			if w, ok := read.Load(strings.ReplaceAll(k, "\r\n", "\n")); !ok || w != v {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCSVErrors(t *testing.T) {
+	m := cmap.NewMapOf[string, string](cmap.StringHasher)
+	m.Store("a,b", `"quoted"`)
+	var b bytes.Buffer
+	if err := cmap.WriteCSV(csv.NewWriter(&b), m, nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := "\"a,b\",\"\"\"quoted\"\"\"\n"; b.String() != want {
+		t.Errorf("WriteCSV writes %q; want %q", b.String(), want)
+	}
+
+	read := cmap.NewMapOf[string, int](cmap.StringHasher)
+	n, err := cmap.ReadCSV(csv.NewReader(strings.NewReader("a,1\nb,2\nc,x\nd,4\n")), read, strconv.Atoi)
+	if err == nil || !strings.Contains(err.Error(), "line 3") || n != 2 || read.Len() != 2 {
+		t.Errorf("ReadCSV = %v, %v and stores %v keys; want 2 and an error on line 3", n, err, read.Len())
+	}
+	if _, err := cmap.ReadCSV(csv.NewReader(strings.NewReader("a,1,2\n")), read, strconv.Atoi); err == nil {
+		t.Error("ReadCSV accepts a record of three fields")
+	}
+	if _, err := cmap.ReadCSV(csv.NewReader(strings.NewReader("a,1\n")), read, nil); err == nil {
+		t.Error("ReadCSV into int values accepts a nil parse")
+	}
+	if n, err := cmap.ReadCSV(csv.NewReader(&b), m, nil); err != nil || n != 1 {
+		t.Errorf("ReadCSV = %v, %v with a nil parse into string values; want 1, nil", n, err)
+	}
+}
@@ -0,0 +1,110 @@
+package cmap
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+)
+
+// DebugHandler returns an HTTP handler that renders a page of the statistics
+// of the map and the histogram of BucketHistogram, in the manner of the index
+// of net/http/pprof, to be mounted on the mux of a service that embeds the
+// map:
+//
+//	mux.Handle("/debug/cmap/sessions", sessions.DebugHandler(false))
+//
+// If listKeys is true, the page also lists the keys of the map, formatted by
+// fmt.Sprint, a page of RangePage at a time: the query parameter cursor is the
+// text of the cursor of the page, which is the beginning of the map if absent,
+// and limit is the number of keys on a page, 100 by default and at most 1000.
+// The values are never rendered, and the keys are listed only if asked for,
+// since they may be private to the users of the service; like pprof, the
+// handler is meant to be served only to its operators.
+func (m *MapOf[K, V]) DebugHandler(listKeys bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := debugPage{Stats: m.Stats(), Histogram: m.BucketHistogram(), ListKeys: listKeys}
+		if listKeys {
+			var cursor Cursor
+			if text := r.FormValue("cursor"); text != "" {
+				if err := cursor.UnmarshalText([]byte(text)); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			page.Limit = defaultDebugLimit
+			if text := r.FormValue("limit"); text != "" {
+				limit, err := strconv.Atoi(text)
+				if err != nil || limit <= 0 || limit > maxDebugLimit {
+					http.Error(w, fmt.Sprintf("cmap: the limit must be from 1 to %v", maxDebugLimit), http.StatusBadRequest)
+					return
+				}
+				page.Limit = limit
+			}
+			entries, next := m.RangePage(cursor, page.Limit)
+			for _, e := range entries {
+				page.Keys = append(page.Keys, fmt.Sprint(e.Key))
+			}
+			if !next.Done() {
+				text, _ := next.MarshalText()
+				page.Next = string(text)
+			}
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if err := debugTemplate.Execute(w, &page); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// The number of keys listed on a page of DebugHandler, by default and at most.
+const (
+	defaultDebugLimit = 100
+	maxDebugLimit     = 1000
+)
+
+// debugPage is the data of the page rendered by DebugHandler.
+type debugPage struct {
+	Stats     Stats
+	Histogram []uint
+	ListKeys  bool
+	Keys      []string
+	Limit     int
+	Next      string // the text of the cursor of the next page, or empty at the end of the map
+}
+
+var debugTemplate = template.Must(template.New("cmap").Parse(`<!DOCTYPE html>
+<html>
+<head><title>cmap</title></head>
+<body>
+<h1>Statistics</h1>
+<table>
+{{with .Stats}}<tr><td>Keys</td><td>{{.Keys}}</td></tr>
+<tr><td>Entries</td><td>{{.Entries}}</td></tr>
+<tr><td>Tombstones</td><td>{{.Tombstones}}</td></tr>
+<tr><td>Buckets</td><td>{{.Buckets}}</td></tr>
+<tr><td>Largest bucket</td><td>{{.LargestBucket}}</td></tr>
+<tr><td>Shards</td><td>{{.Shards}}</td></tr>
+<tr><td>Shards being resized</td><td>{{.Resizing}}</td></tr>
+<tr><td>Resizes</td><td>{{.Resizes}}</td></tr>
+<tr><td>Last resize</td><td>{{.LastResize}}</td></tr>
+<tr><td>Hits</td><td>{{.Hits}}</td></tr>
+<tr><td>Misses</td><td>{{.Misses}}</td></tr>
+<tr><td>Stores</td><td>{{.Stores}}</td></tr>
+<tr><td>Deletes</td><td>{{.Deletes}}</td></tr>
+<tr><td>Evictions</td><td>{{.Evictions}}</td></tr>{{end}}
+</table>
+<h1>Buckets by the length of their chains</h1>
+<table>
+<tr><th>Length</th><th>Buckets</th></tr>
+{{range $n, $buckets := .Histogram}}<tr><td>{{$n}}</td><td>{{$buckets}}</td></tr>
+{{end}}</table>
+{{if .ListKeys}}<h1>Keys</h1>
+<ul>
+{{range .Keys}}<li>{{.}}</li>
+{{end}}</ul>
+{{with .Next}}<p><a href="?cursor={{.}}&amp;limit={{$.Limit}}">Next page</a></p>
+{{end}}{{end}}</body>
+</html>
+`))
@@ -0,0 +1,68 @@
+package cmap_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/decillion/go-cmap"
+)
+
+func TestDebugHandler(t *testing.T) {
+	m := cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) })
+	for i := 0; i < capacity; i++ {
+		m.Store(i, -i)
+	}
+	get := func(h http.Handler, query string) (int, string) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/debug/cmap?"+query, nil))
+		body, _ := io.ReadAll(w.Result().Body)
+		return w.Code, string(body)
+	}
+
+	_, body := get(m.DebugHandler(false), "")
+	if !strings.Contains(body, "<td>Keys</td><td>"+strconv.Itoa(capacity)+"</td>") {
+		t.Errorf("the page lacks the number of keys:\n%s", body)
+	}
+	if strings.Contains(body, "<li>") {
+		t.Errorf("the page lists the keys without listKeys:\n%s", body)
+	}
+
+	item := regexp.MustCompile(`<li>(-?\d+)</li>`)
+	next := regexp.MustCompile(`href="\?cursor=([^&]+)&amp;limit=10"`)
+	seen := make(map[int]bool)
+	query := "limit=10"
+	for pages := 0; query != ""; pages++ {
+		if pages > capacity {
+			t.Fatal("the pages of the keys do not end")
+		}
+		code, body := get(m.DebugHandler(true), query)
+		if code != http.StatusOK {
+			t.Fatalf("GET ?%v responds %v: %s", query, code, body)
+		}
+		for _, match := range item.FindAllStringSubmatch(body, -1) {
+			key, _ := strconv.Atoi(match[1])
+			if seen[key] {
+				t.Errorf("the key %v is listed twice", key)
+			}
+			seen[key] = true
+		}
+		query = ""
+		if match := next.FindStringSubmatch(body); match != nil {
+			query = "cursor=" + match[1] + "&limit=10"
+		}
+	}
+	if len(seen) != capacity {
+		t.Errorf("%v keys are listed; want %v", len(seen), capacity)
+	}
+
+	for _, query := range []string{"cursor=nonsense", "limit=0", "limit=1001", "limit=x"} {
+		if code, _ := get(m.DebugHandler(true), query); code != http.StatusBadRequest {
+			t.Errorf("GET ?%v responds %v; want %v", query, code, http.StatusBadRequest)
+		}
+	}
+}
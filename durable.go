@@ -0,0 +1,581 @@
+package cmap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DurableMapOf is a concurrent map with keys of type K and values of type V
+// whose updates survive restarts. Every Store and Delete is appended to a
+// write-ahead log in a directory before it takes effect, and a checkpoint of
+// the whole map written by Checkpoint replaces the log, so that the map is
+// reconstructed by Recover from the latest checkpoint and the log after it.
+//
+// The records of the log are written to the file at once, so they survive a
+// crash of the process, but they survive a crash of the machine only once
+// Sync is called. The keys and values are encoded by the codecs given by
+// WithCodecs, as in WriteTo.
+type DurableMapOf[K comparable, V any] struct {
+	m      *MapOf[K, V]
+	dir    string
+	keys   Codec[K]
+	values Codec[V]
+
+	mu   sync.Mutex // guards log and size; taken after the locks of the map
+	log  *os.File   // the write-ahead log, or nil once the map is closed
+	size int64      // the size of the log
+
+	janitor sync.Mutex    // guards stop and done
+	stop    chan struct{} // closed to stop the checkpointer, or nil if none runs
+	done    chan struct{} // closed when the checkpointer has stopped
+}
+
+// DurableMap is a durable map whose keys and values are of arbitrary types,
+// which are encoded by the codecs given by WithCodecs.
+type DurableMap = DurableMapOf[interface{}, interface{}]
+
+// The files of a durable map in its directory.
+const (
+	checkpointFile = "checkpoint"
+	logFile        = "wal"
+)
+
+// The operations of the records of the log.
+const (
+	opStore byte = iota + 1
+	opDelete
+)
+
+// ErrClosed is returned by the methods of DurableMapOf and MmapMap updating a
+// map after Close.
+var ErrClosed = errors.New("cmap: the map is closed")
+
+// Recover returns the durable map stored in the given directory, whose keys
+// are hashed by the given function. See RecoverOf.
+func Recover[H Hash](dir string, hasher func(key interface{}) H, opts ...Option) (m *DurableMap, err error) {
+	return RecoverOf[interface{}, interface{}](dir, hasher, opts...)
+}
+
+// RecoverOf returns the durable map with keys of type K and values of type V
+// stored in the given directory, where keys are hashed by the given function.
+// The map is reconstructed from the latest checkpoint and the log written
+// after it, and it is empty if the directory is empty or does not exist, in
+// which case the directory is created. A record torn off at the end of the log
+// by a crash is discarded. The map must be closed by Close. The options must
+// not set a limit, since evictions are not logged.
+func RecoverOf[K comparable, V any, H Hash](dir string, hasher func(key K) H, opts ...Option) (m *DurableMapOf[K, V], err error) {
+	m = &DurableMapOf[K, V]{m: NewMapOf[K, V](hasher, opts...), dir: dir}
+	if m.m.limit != nil {
+		return nil, errors.New("cmap: a durable map with a limit")
+	}
+	if m.keys, m.values, err = m.m.codecs(); err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(dir, 0o777); err != nil {
+		return nil, err
+	}
+	if err = m.readCheckpoint(); err != nil {
+		return nil, err
+	}
+	if m.log, err = os.OpenFile(filepath.Join(dir, logFile), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o666); err != nil {
+		return nil, err
+	}
+	if err = m.replay(); err != nil {
+		m.log.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// readCheckpoint stores the pairs of the checkpoint into the map, if there is
+// a checkpoint.
+func (m *DurableMapOf[K, V]) readCheckpoint() error {
+	f, err := os.Open(filepath.Join(m.dir, checkpointFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = m.m.ReadFrom(f)
+	return err
+}
+
+// replay applies the records of the log to the map and truncates the log
+// after the last record that is read whole.
+func (m *DurableMapOf[K, V]) replay() error {
+	r := bufio.NewReader(m.log)
+	var good int64 // the end of the last record read whole
+	var payload []byte
+	for {
+		size, n, err := readUvarint(r)
+		if err == io.EOF {
+			return nil // the log ends after a record
+		} else if err != nil {
+			break
+		}
+		if size > 1<<31 {
+			break
+		}
+		if uint64(cap(payload)) < size+4 {
+			payload = make([]byte, size+4)
+		}
+		payload = payload[:size+4]
+		if _, err := io.ReadFull(r, payload); err != nil {
+			break
+		}
+		sum := binary.LittleEndian.Uint32(payload[size:])
+		if payload = payload[:size]; crc32.ChecksumIEEE(payload) != sum {
+			break
+		}
+		if err := m.apply(payload); err != nil {
+			return err
+		}
+		good += int64(n) + int64(size) + 4
+		m.size = good
+	}
+	return m.log.Truncate(good)
+}
+
+// readUvarint reads an unsigned varint from r and also returns the number of
+// bytes read.
+func readUvarint(r io.ByteReader) (x uint64, n int, err error) {
+	counted := byteCounter{r: r}
+	x, err = binary.ReadUvarint(&counted)
+	return x, counted.n, err
+}
+
+type byteCounter struct {
+	r io.ByteReader
+	n int
+}
+
+func (c *byteCounter) ReadByte() (b byte, err error) {
+	if b, err = c.r.ReadByte(); err == nil {
+		c.n++
+	}
+	return
+}
+
+var errBadRecord = errors.New("cmap: a record of the log is malformed")
+
+// apply applies the record of the given payload to the map.
+func (m *DurableMapOf[K, V]) apply(payload []byte) error {
+	if len(payload) == 0 {
+		return errBadRecord
+	}
+	op, fields := payload[0], payload[1:]
+	field, fields, ok := cutField(fields)
+	if !ok {
+		return errBadRecord
+	}
+	key, err := m.keys.Decode(field)
+	if err != nil {
+		return err
+	}
+	switch op {
+	case opStore:
+		if field, _, ok = cutField(fields); !ok {
+			return errBadRecord
+		}
+		value, err := m.values.Decode(field)
+		if err != nil {
+			return err
+		}
+		m.m.Store(key, value)
+	case opDelete:
+		m.m.Delete(key)
+	default:
+		return errBadRecord
+	}
+	return nil
+}
+
+// cutField cuts a field prefixed with its length from the front of data.
+func cutField(data []byte) (field, rest []byte, ok bool) {
+	size, n := binary.Uvarint(data)
+	if n <= 0 || size > uint64(len(data)-n) {
+		return nil, nil, false
+	}
+	return data[n : n+int(size)], data[n+int(size):], true
+}
+
+// record returns the record of the log of the given operation.
+func (m *DurableMapOf[K, V]) record(op byte, key K, value V) (record []byte, err error) {
+	payload := []byte{op}
+	var scratch []byte
+	if scratch, err = m.keys.Encode(nil, key); err != nil {
+		return nil, err
+	}
+	payload = append(binary.AppendUvarint(payload, uint64(len(scratch))), scratch...)
+	if op == opStore {
+		if scratch, err = m.values.Encode(scratch[:0], value); err != nil {
+			return nil, err
+		}
+		payload = append(binary.AppendUvarint(payload, uint64(len(scratch))), scratch...)
+	}
+	record = binary.AppendUvarint(nil, uint64(len(payload)))
+	record = append(record, payload...)
+	return binary.LittleEndian.AppendUint32(record, crc32.ChecksumIEEE(payload)), nil
+}
+
+// append appends the given record to the log.
+func (m *DurableMapOf[K, V]) append(record []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.log == nil {
+		return ErrClosed
+	}
+	if n, err := m.log.Write(record); err != nil {
+		if n > 0 {
+			m.log.Truncate(m.size) // so that no torn record precedes the next ones
+		}
+		return err
+	}
+	m.size += int64(len(record))
+	return nil
+}
+
+// Load returns the value associated with the given key and true if the key
+// exists. Otherwise, it returns the zero value and false.
+func (m *DurableMapOf[K, V]) Load(key K) (value V, ok bool) {
+	return m.m.Load(key)
+}
+
+// Store sets the given value to the given key once the update is appended to
+// the log. If the update cannot be encoded or appended, the map is left as it
+// is and the error is returned.
+func (m *DurableMapOf[K, V]) Store(key K, value V) (err error) {
+	record, err := m.record(opStore, key, value)
+	if err != nil {
+		return err
+	}
+	// The record is appended while the key is locked, so that the updates of
+	// the key are logged in the order in which they take effect.
+	m.m.compute(key, func(old V, loaded bool) (V, bool) {
+		if err = m.append(record); err != nil {
+			return old, loaded
+		}
+		return value, true
+	})
+	return
+}
+
+// Delete removes the given key and its associated value once the deletion is
+// appended to the log. If the deletion cannot be appended, the map is left as
+// it is and the error is returned.
+func (m *DurableMapOf[K, V]) Delete(key K) (err error) {
+	var zero V
+	record, err := m.record(opDelete, key, zero)
+	if err != nil {
+		return err
+	}
+	m.m.compute(key, func(old V, loaded bool) (V, bool) {
+		if !loaded {
+			return old, false
+		}
+		if err = m.append(record); err != nil {
+			return old, true
+		}
+		return old, false
+	})
+	return
+}
+
+// Len returns the number of keys in the map.
+func (m *DurableMapOf[K, V]) Len() int {
+	return m.m.Len()
+}
+
+// Range iteratively applies the given function to each key-value pair until
+// the function returns false. It gives the same guarantees as MapOf.Range.
+func (m *DurableMapOf[K, V]) Range(f func(key K, value V) bool) {
+	m.m.Range(f)
+}
+
+// Sync commits the log to stable storage, so that the updates made so far
+// survive a crash of the machine.
+func (m *DurableMapOf[K, V]) Sync() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.log == nil {
+		return ErrClosed
+	}
+	return m.log.Sync()
+}
+
+// Checkpoint writes a checkpoint of the map to stable storage and empties the
+// log, so that the log does not grow without bounds and Recover has fewer
+// records to replay. The map is locked while the checkpoint is written, as by
+// WriteTo. A crash during Checkpoint loses nothing: the previous checkpoint is
+// replaced only once the new one is complete, and the log written since the
+// previous one can be replayed on the new one.
+func (m *DurableMapOf[K, V]) Checkpoint() (err error) {
+	m.m.lockAll()
+	defer m.m.unlockAll()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.log == nil {
+		return ErrClosed
+	}
+
+	tmp := filepath.Join(m.dir, checkpointFile+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err = m.m.writeSnapshot(f); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(m.dir, checkpointFile))
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err = syncDir(m.dir); err != nil {
+		return err
+	}
+	if err = m.log.Truncate(0); err != nil {
+		return err
+	}
+	m.size = 0
+	return m.log.Sync()
+}
+
+// syncDir commits the entries of the given directory to stable storage.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// StartCheckpoints starts a goroutine that calls Checkpoint every time the
+// given interval passes. A checkpointer already running is stopped first. The
+// checkpointer runs until Close is called. It panics if interval is not
+// positive.
+func (m *DurableMapOf[K, V]) StartCheckpoints(interval time.Duration) {
+	m.startCheckpoints(interval, m.Checkpoint)
+}
+
+// startCheckpoints is StartCheckpoints with the function that writes a
+// checkpoint.
+func (m *DurableMapOf[K, V]) startCheckpoints(interval time.Duration, checkpoint func() error) {
+	ticker := time.NewTicker(interval)
+	m.janitor.Lock()
+	defer m.janitor.Unlock()
+
+	m.stopCheckpoints()
+	stop, done := make(chan struct{}), make(chan struct{})
+	m.stop, m.done = stop, done
+	go func() {
+		defer close(done)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				checkpoint()
+			}
+		}
+	}()
+}
+
+// stopCheckpoints stops the checkpointer if it is running and waits until it
+// has stopped. The caller must hold m.janitor.
+func (m *DurableMapOf[K, V]) stopCheckpoints() {
+	if m.stop == nil {
+		return
+	}
+	close(m.stop)
+	<-m.done
+	m.stop, m.done = nil, nil
+}
+
+// Close stops the checkpointer started by StartCheckpoints, commits the log to
+// stable storage, and closes it. The map can still be read after Close, but
+// Store and Delete return ErrClosed. Close does nothing if the map is already
+// closed.
+func (m *DurableMapOf[K, V]) Close() error {
+	m.janitor.Lock()
+	m.stopCheckpoints()
+	m.janitor.Unlock()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.log == nil {
+		return nil
+	}
+	err := m.log.Sync()
+	if cerr := m.log.Close(); err == nil {
+		err = cerr
+	}
+	m.log = nil
+	return err
+}
+
+// DurableTTLMapOf is a durable map with keys of type K and values of type V in
+// which a key may be stored with a time to live, as in a TTLMapOf. The
+// records of the log and the checkpoints carry the wall-clock time at which
+// each value expires, so that a key recovered by RecoverTTL expires when it
+// would have, instead of being kept for good. An expired key is treated as
+// absent, and it is deleted from memory by the first method that finds it
+// expired without the deletion being logged, since its record is found
+// expired again by Recover.
+type DurableTTLMapOf[K comparable, V any] struct {
+	m *DurableMapOf[K, ttlValue[V]]
+}
+
+// DurableTTLMap is a durable map with times to live whose keys and values are
+// of arbitrary types, which are encoded by the codecs given by WithCodecs.
+type DurableTTLMap = DurableTTLMapOf[interface{}, interface{}]
+
+// RecoverTTL returns the durable map with times to live stored in the given
+// directory, whose keys are hashed by the given function. See RecoverTTLOf.
+func RecoverTTL[H Hash](dir string, hasher func(key interface{}) H, opts ...Option) (m *DurableTTLMap, err error) {
+	return RecoverTTLOf[interface{}, interface{}](dir, hasher, opts...)
+}
+
+// RecoverTTLOf returns the durable map with times to live with keys of type K
+// and values of type V stored in the given directory, as RecoverOf does. The
+// codecs given by WithCodecs take the values stored, without their times to
+// live. The keys that have expired since they were logged are not recovered.
+func RecoverTTLOf[K comparable, V any, H Hash](dir string, hasher func(key K) H, opts ...Option) (m *DurableTTLMapOf[K, V], err error) {
+	values, verr := codecOf[V](newOptions(opts).valueCodec, "values")
+	opts = append(opts[:len(opts):len(opts)], func(o *options) { o.valueCodec = ttlCodec[V]{values, verr} })
+	d, err := RecoverOf[K, ttlValue[V]](dir, hasher, opts...)
+	if err != nil {
+		return nil, err
+	}
+	m = &DurableTTLMapOf[K, V]{m: d}
+	m.DeleteExpired()
+	return m, nil
+}
+
+// Load returns the value associated with the given key and true if the key
+// exists and is not expired. Otherwise, it returns the zero value and false.
+func (m *DurableTTLMapOf[K, V]) Load(key K) (value V, ok bool) {
+	v, ok := m.load(key)
+	return v.value, ok
+}
+
+// LoadWithExpiration is like Load but also returns the time at which the key
+// expires, which is the zero time if the key never expires.
+func (m *DurableTTLMapOf[K, V]) LoadWithExpiration(key K) (value V, expiresAt time.Time, ok bool) {
+	v, ok := m.load(key)
+	if ok && v.deadline != 0 {
+		expiresAt = time.Unix(0, v.deadline)
+	}
+	return v.value, expiresAt, ok
+}
+
+// load returns the value of the given key with its deadline and true if the
+// key exists and is not expired, deleting the key if it is expired.
+func (m *DurableTTLMapOf[K, V]) load(key K) (v ttlValue[V], ok bool) {
+	if v, ok = m.m.Load(key); ok && v.expired(time.Now().UnixNano()) {
+		m.deleteExpired(key)
+		return ttlValue[V]{}, false
+	}
+	return
+}
+
+// Store sets the given value to the given key, which never expires, once the
+// update is appended to the log, as DurableMapOf.Store does.
+func (m *DurableTTLMapOf[K, V]) Store(key K, value V) error {
+	return m.m.Store(key, ttlValue[V]{value: value})
+}
+
+// StoreWithTTL sets the given value to the given key, which expires once the
+// given duration has passed, once the update is appended to the log with the
+// time at which the key expires. A key stored with a non-positive duration is
+// expired at once.
+func (m *DurableTTLMapOf[K, V]) StoreWithTTL(key K, value V, ttl time.Duration) error {
+	return m.m.Store(key, ttlValue[V]{value: value, deadline: deadline(ttl)})
+}
+
+// Delete removes the given key and its associated value once the deletion is
+// appended to the log, as DurableMapOf.Delete does.
+func (m *DurableTTLMapOf[K, V]) Delete(key K) error {
+	return m.m.Delete(key)
+}
+
+// deleteExpired removes the given key from memory if it is expired, without
+// logging the deletion, and reports whether it is removed.
+func (m *DurableTTLMapOf[K, V]) deleteExpired(key K) (found bool) {
+	now := time.Now().UnixNano()
+	m.m.m.compute(key, func(old ttlValue[V], ok bool) (ttlValue[V], bool) {
+		found = ok && old.expired(now)
+		return old, ok && !found
+	})
+	return
+}
+
+// DeleteExpired deletes the keys that are expired from memory.
+func (m *DurableTTLMapOf[K, V]) DeleteExpired() {
+	now := time.Now().UnixNano()
+	m.m.m.Range(func(k K, v ttlValue[V]) bool {
+		if v.expired(now) {
+			m.deleteExpired(k)
+		}
+		return true
+	})
+}
+
+// Len returns the number of keys in the map, which includes the expired keys
+// that have not been deleted yet.
+func (m *DurableTTLMapOf[K, V]) Len() int {
+	return m.m.Len()
+}
+
+// Range iteratively applies the given function to each key-value pair that is
+// not expired until the function returns false, deleting the expired keys it
+// finds. It gives the same guarantees as MapOf.Range.
+func (m *DurableTTLMapOf[K, V]) Range(f func(key K, value V) bool) {
+	now := time.Now().UnixNano()
+	m.m.Range(func(k K, v ttlValue[V]) bool {
+		if v.expired(now) {
+			m.deleteExpired(k)
+			return true
+		}
+		return f(k, v.value)
+	})
+}
+
+// Sync commits the log to stable storage, as DurableMapOf.Sync does.
+func (m *DurableTTLMapOf[K, V]) Sync() error {
+	return m.m.Sync()
+}
+
+// Checkpoint deletes the expired keys from memory and writes a checkpoint of
+// the map, as DurableMapOf.Checkpoint does.
+func (m *DurableTTLMapOf[K, V]) Checkpoint() error {
+	m.DeleteExpired()
+	return m.m.Checkpoint()
+}
+
+// StartCheckpoints starts a goroutine that calls Checkpoint every time the
+// given interval passes, as DurableMapOf.StartCheckpoints does, which also
+// deletes the expired keys from memory.
+func (m *DurableTTLMapOf[K, V]) StartCheckpoints(interval time.Duration) {
+	m.m.startCheckpoints(interval, m.Checkpoint)
+}
+
+// Close stops the checkpointer and closes the log, as DurableMapOf.Close does.
+func (m *DurableTTLMapOf[K, V]) Close() error {
+	return m.m.Close()
+}
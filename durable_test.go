@@ -0,0 +1,185 @@
+package cmap_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/decillion/go-cmap"
+)
+
+type durableMapCall struct {
+	Op         uint8
+	Key, Value uint8
+}
+
+func TestDurableMapMatchesBuiltInMap(t *testing.T) {
+	f := func(calls []durableMapCall) bool {
+		dir := t.TempDir()
+		m, err := cmap.RecoverOf[uint64, uint64](dir, cmap.Uint64Hasher)
+		if err != nil {
+			t.Fatal(err)
+		}
+		builtin := make(map[uint64]uint64)
+		for _, c := range calls {
+			k, v := uint64(c.Key%16), uint64(c.Value)
+			switch c.Op % 8 {
+			case 0:
+				err = m.Delete(k)
+				delete(builtin, k)
+			case 1:
+				err = m.Checkpoint()
+			case 2:
+				m.Close()
+				if m, err = cmap.RecoverOf[uint64, uint64](dir, cmap.Uint64Hasher); err != nil {
+					t.Fatal(err)
+				}
+			default:
+				err = m.Store(k, v)
+				builtin[k] = v
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := m.Close(); err != nil {
+			t.Fatal(err)
+		}
+		m, err = cmap.RecoverOf[uint64, uint64](dir, cmap.Uint64Hasher)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer m.Close()
+		for i := uint64(0); i < 16; i++ {
+			v, ok := m.Load(i)
+			if w, found := builtin[i]; ok != found || v != w {
+				return false
+			}
+		}
+		return m.Len() == len(builtin)
+	}
+	if err := quick.Check(f, &quick.Config{MaxCount: 20}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDurableMapTornLog(t *testing.T) {
+	dir := t.TempDir()
+	m, err := cmap.RecoverOf[string, string](dir, cmap.StringHasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Store("a", "1")
+	m.Checkpoint()
+	m.Store("b", "2")
+	m.Delete("a")
+	m.Close()
+	if err := m.Store("c", "3"); err != cmap.ErrClosed {
+		t.Errorf("Store after Close = %v; want ErrClosed", err)
+	}
+
+	log := filepath.Join(dir, "wal")
+	f, err := os.OpenFile(log, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{20, 1, 1}) // a record torn by a crash
+	f.Close()
+	intact, _ := os.Stat(log)
+
+	m, err = cmap.RecoverOf[string, string](dir, cmap.StringHasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.Load("a"); ok || m.Len() != 1 {
+		t.Errorf("Len() = %v after Recover; want 1 without the deleted key", m.Len())
+	}
+	if v, ok := m.Load("b"); !ok || v != "2" {
+		t.Errorf(`Load("b") = %v, %v after Recover; want 2, true`, v, ok)
+	}
+	if info, _ := os.Stat(log); info.Size() != intact.Size()-3 {
+		t.Errorf("the log is of %v bytes after Recover; want %v", info.Size(), intact.Size()-3)
+	}
+	if err := m.Store("c", "3"); err != nil {
+		t.Fatal(err)
+	}
+	m.Close()
+	if m, err = cmap.RecoverOf[string, string](dir, cmap.StringHasher); err != nil || m.Len() != 2 {
+		t.Fatalf("RecoverOf = %v with %v keys; want nil with 2 keys", err, m.Len())
+	}
+	m.Close()
+
+	if _, err := cmap.RecoverOf[string, string](t.TempDir(), cmap.StringHasher, cmap.WithLimit(1, cmap.Evict)); err == nil {
+		t.Error("RecoverOf accepts a limit")
+	}
+	if _, err := cmap.Recover(t.TempDir(), cmap.SeededHasher); err == nil {
+		t.Error("Recover accepts a map without codecs")
+	}
+}
+
+func TestDurableMapCheckpoints(t *testing.T) {
+	dir := t.TempDir()
+	m, err := cmap.RecoverOf[int, int](dir, func(key int) uint64 { return uint64(key) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.StartCheckpoints(time.Millisecond)
+	for i := 0; i < capacity; i++ {
+		if err := m.Store(i, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if info, err := os.Stat(filepath.Join(dir, "wal")); err == nil && info.Size() == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the log is not emptied by a checkpoint")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if m, err = cmap.RecoverOf[int, int](dir, func(key int) uint64 { return uint64(key) }); err != nil || m.Len() != capacity {
+		t.Fatalf("RecoverOf = %v with %v keys; want nil with %v keys", err, m.Len(), capacity)
+	}
+	m.Close()
+}
+
+func TestDurableTTLMapKeepsDeadlines(t *testing.T) {
+	dir := t.TempDir()
+	m, err := cmap.RecoverTTLOf[string, int](dir, cmap.StringHasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Store("forever", 1)
+	m.StoreWithTTL("short", 2, 20*time.Millisecond)
+	m.StoreWithTTL("long", 3, time.Hour)
+	_, expiresAt, _ := m.LoadWithExpiration("long")
+	for i, checkpoint := range []bool{false, true} {
+		if checkpoint {
+			if err := m.Checkpoint(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		m.Close()
+		time.Sleep(30 * time.Millisecond) // lets the short key expire
+		if m, err = cmap.RecoverTTLOf[string, int](dir, cmap.StringHasher); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := m.Load("short"); ok || m.Len() != 2 {
+			t.Errorf("recovery %v: the expired key is recovered or Len() = %v; want 2", i, m.Len())
+		}
+		if v, at, ok := m.LoadWithExpiration("long"); !ok || v != 3 || !at.Equal(expiresAt) {
+			t.Errorf(`recovery %v: LoadWithExpiration("long") = %v, %v, %v; want 3, %v, true`, i, v, at, ok, expiresAt)
+		}
+		if v, at, ok := m.LoadWithExpiration("forever"); !ok || v != 1 || !at.IsZero() {
+			t.Errorf(`recovery %v: LoadWithExpiration("forever") = %v, %v, %v; want 1, the zero time, true`, i, v, at, ok)
+		}
+	}
+	m.Close()
+}
@@ -0,0 +1,173 @@
+package cmap
+
+import (
+	"bufio"
+	"cmp"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/decillion/go-cmap/hmap"
+)
+
+// Export writes the map to w as a sequence of chunks, each of which is a
+// snapshot in the format of WriteTo of about chunkSize pairs, and returns the
+// number of bytes written. It is ExportFrom from the beginning of
+// the map, for maps too large to be written by WriteTo at once.
+func (m *MapOf[K, V]) Export(w io.Writer, chunkSize int) (n int64, err error) {
+	_, n, err = m.ExportFrom(w, Cursor{}, chunkSize)
+	return
+}
+
+// ExportFrom writes the key-value pairs of the map from the given cursor to w
+// as a sequence of chunks, each of which is a snapshot in the format of
+// WriteTo, so that Import reads them back. The keys are written in the order
+// of their hashes, as RangePage visits them, and a chunk holds at most
+// chunkSize pairs unless the hashes of several keys collide at its end.
+//
+// The map is never locked, nor copied as a whole. Each chunk is collected by
+// visiting the shard of its cursor as Range does, keeping only the chunkSize
+// pairs of the smallest hashes from the cursor on, and it is written before
+// the next one is collected, so the memory used is proportional to chunkSize
+// and updates proceed during the export. Each chunk thus gives the guarantees
+// of Range for its range of hashes, and the keys stored into a range already
+// written are not exported. Collecting a chunk takes time proportional to the
+// number of keys in its shard, so more shards given to NewMapOfSharded, or
+// larger chunks, make the export faster.
+//
+// It returns the cursor of the first chunk not written, which is done once
+// the whole map is, and the number of bytes of the chunks written completely.
+// If writing a chunk fails, the export can be resumed at the returned cursor
+// after discarding the bytes of w past the returned number. It panics if
+// chunkSize is not positive.
+func (m *MapOf[K, V]) ExportFrom(w io.Writer, from Cursor, chunkSize int) (next Cursor, n int64, err error) {
+	if chunkSize <= 0 {
+		panic(fmt.Sprintf("cmap: ExportFrom chunk size %v out of range", chunkSize))
+	}
+	keys, values, err := m.codecs()
+	if err != nil {
+		return from, 0, err
+	}
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	var chunk, dropped chunkHeap[K, V]
+	var buf, scratch []byte
+	for next = from; !next.done; {
+		i := int(next.hash >> m.shift)
+		// The chunk holds the pairs of the hashes in [next.hash, upTo), where
+		// upTo is lowered as pairs of smaller hashes are found.
+		var upTo uint64
+		bounded := false
+		chunk = chunk[:0]
+		m.settle(&m.shards[i]).RangeHashed(func(k K, d hmap.Digest, v V) bool {
+			hash := d.Uint64()
+			if hash < next.hash || bounded && hash >= upTo {
+				return true
+			}
+			heap.Push(&chunk, hashedEntry[K, V]{hash, Entry[K, V]{Key: k, Value: v}})
+			// The pairs of the largest hash are dropped only if chunkSize
+			// pairs are left, so that keys of the same hash are kept in
+			// the same chunk, since the cursor could not tell them apart.
+			for len(chunk) > chunkSize {
+				top := chunk[0].hash
+				dropped = dropped[:0]
+				for len(chunk) > 0 && chunk[0].hash == top {
+					dropped = append(dropped, heap.Pop(&chunk).(hashedEntry[K, V]))
+				}
+				if len(chunk) < chunkSize {
+					for _, e := range dropped {
+						heap.Push(&chunk, e)
+					}
+					break
+				}
+				upTo, bounded = top, true
+			}
+			return true
+		})
+		clear(dropped[:cap(dropped)])
+		slices.SortFunc(chunk, func(a, b hashedEntry[K, V]) int {
+			return cmp.Compare(a.hash, b.hash)
+		})
+
+		if len(chunk) > 0 {
+			buf = binary.AppendUvarint(append(buf[:0], snapshotMagic...), uint64(len(chunk)))
+			for _, e := range chunk {
+				if buf, scratch, err = appendPair(buf, scratch, keys, values, e.Key, e.Value); err != nil {
+					return next, n, err
+				}
+				if len(buf) >= bw.Size() {
+					if _, err = bw.Write(buf); err != nil {
+						return next, n, err
+					}
+					buf = buf[:0]
+				}
+			}
+			if _, err = bw.Write(buf); err == nil {
+				err = bw.Flush()
+			}
+			if err != nil {
+				return next, n, err
+			}
+			n = cw.n
+		}
+		switch {
+		case bounded:
+			next = Cursor{hash: upTo}
+		case i == len(m.shards)-1:
+			next = Cursor{done: true}
+		default:
+			next = Cursor{hash: uint64(i+1) << m.shift}
+		}
+	}
+	return next, n, nil
+}
+
+// hashedEntry is a key-value pair with the hash by which ExportFrom orders the
+// pairs.
+type hashedEntry[K comparable, V any] struct {
+	hash uint64
+	Entry[K, V]
+}
+
+// chunkHeap is a heap of the pairs of a chunk of ExportFrom, whose top is the
+// pair of the largest hash.
+type chunkHeap[K comparable, V any] []hashedEntry[K, V]
+
+func (h chunkHeap[K, V]) Len() int           { return len(h) }
+func (h chunkHeap[K, V]) Less(i, j int) bool { return h[i].hash > h[j].hash }
+func (h chunkHeap[K, V]) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *chunkHeap[K, V]) Push(x any)        { *h = append(*h, x.(hashedEntry[K, V])) }
+
+func (h *chunkHeap[K, V]) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = hashedEntry[K, V]{}
+	*h = old[:len(old)-1]
+	return e
+}
+
+// Import reads the chunks written by Export or ExportFrom, or snapshots
+// written by WriteTo one after another, from r until its end, and stores
+// their key-value pairs into the map as ReadFrom does. It returns the number
+// of bytes read.
+func (m *MapOf[K, V]) Import(r io.Reader) (n int64, err error) {
+	keys, values, err := m.codecs()
+	if err != nil {
+		return 0, err
+	}
+	br, ok := r.(snapshotReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	cr := &countingReader{r: br}
+	for {
+		read := cr.n
+		if err = m.readSnapshot(cr, keys, values); err == io.EOF && cr.n == read {
+			return cr.n, nil
+		} else if err != nil {
+			return cr.n, err
+		}
+	}
+}
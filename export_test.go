@@ -0,0 +1,160 @@
+package cmap_test
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"testing/quick"
+
+	"github.com/decillion/go-cmap"
+)
+
+func TestExportMatchesBuiltInMap(t *testing.T) {
+	f := func(builtin map[string]uint16, chunkSize uint8) bool {
+		m := cmap.NewMapOfSharded[string, uint16](cmap.StringHasher, func(a, b string) bool { return a == b }, 4)
+		for k, v := range builtin {
+			m.Store(k, v)
+		}
+		var b bytes.Buffer
+		n, err := m.Export(&b, int(chunkSize%8)+1)
+		if err != nil || n != int64(b.Len()) {
+			return false
+		}
+		imported := cmap.NewMapOf[string, uint16](cmap.StringHasher)
+		if read, err := imported.Import(&b); err != nil || read != n || imported.Len() != len(builtin) {
+			return false
+		}
+		for k, v := range builtin {
+			if w, ok := imported.Load(k); !ok || w != v {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+
+	// Keys of the same hash are written in the same chunk, which holds at
+	// least chunkSize pairs unless it is the last one of its shard.
+	m := cmap.NewMapOfSharded[int, int](func(key int) uint64 { return uint64(key / 3) }, nil, 1)
+	for i := 0; i < 3*capacity; i++ {
+		m.Store(i, i)
+	}
+	var b bytes.Buffer
+	if _, err := m.Export(&b, 4); err != nil {
+		t.Fatal(err)
+	}
+	for r, total := bytes.NewReader(b.Bytes()), 0; r.Len() > 0; {
+		chunk := cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key / 3) })
+		if _, err := chunk.ReadFrom(r); err != nil {
+			t.Fatal(err)
+		}
+		if total += chunk.Len(); chunk.Len() != 6 && r.Len() > 0 || chunk.Len()%3 != 0 {
+			t.Fatalf("a chunk holds %v keys; want 6", chunk.Len())
+		}
+		chunk.Range(func(k, _ int) bool {
+			for i := k / 3 * 3; i < k/3*3+3; i++ {
+				if _, ok := chunk.Load(i); !ok {
+					t.Fatalf("the chunk of the key %v misses the key %v of the same hash", k, i)
+				}
+			}
+			return true
+		})
+		if r.Len() == 0 && total != 3*capacity {
+			t.Errorf("the chunks hold %v keys; want %v", total, 3*capacity)
+		}
+	}
+}
+
+// failingWriter fails once the given number of bytes have been written.
+type failingWriter struct {
+	bytes.Buffer
+	limit int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.Len()+len(p) > w.limit {
+		n, _ := w.Buffer.Write(p[:w.limit-w.Len()])
+		return n, errors.New("the disk is full")
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestExportResumes(t *testing.T) {
+	m := cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) * 0x9e3779b97f4a7c15 })
+	for i := 0; i < capacity; i++ {
+		m.Store(i, i)
+	}
+	var exported bytes.Buffer
+	var cursor cmap.Cursor
+	for attempts := 0; !cursor.Done(); attempts++ {
+		if attempts > capacity {
+			t.Fatal("ExportFrom makes no progress")
+		}
+		w := &failingWriter{limit: 1 << 12}
+		next, n, err := m.ExportFrom(w, cursor, 16)
+		if err == nil && !next.Done() {
+			t.Fatal("ExportFrom returns nil before the end of the map")
+		}
+		exported.Write(w.Bytes()[:n])
+		cursor = next
+	}
+	imported := cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) })
+	if _, err := imported.Import(&exported); err != nil || imported.Len() != capacity {
+		t.Fatalf("Import = %v with %v keys; want nil with %v keys", err, imported.Len(), capacity)
+	}
+
+	// Import reads snapshots written by WriteTo as well.
+	var snapshots bytes.Buffer
+	a := cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) })
+	a.Store(1, 1)
+	a.WriteTo(&snapshots)
+	a.Store(2, 2)
+	a.WriteTo(&snapshots)
+	snapshots.WriteString("cm")
+	b := cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) })
+	if _, err := b.Import(&snapshots); err == nil || b.Len() != 2 {
+		t.Errorf("Import = %v with %v keys; want an error for the torn snapshot with 2 keys", err, b.Len())
+	}
+}
+
+func TestExportWithUpdates(t *testing.T) {
+	m := cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) * 0x9e3779b97f4a7c15 })
+	for i := 0; i < capacity; i++ {
+		m.Store(i, i)
+	}
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := capacity; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			m.Store(i, i)
+			m.Delete(i - 8)
+		}
+	}()
+	var b bytes.Buffer
+	_, err := m.Export(&b, 64)
+	close(done)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported := cmap.NewMapOf[int, int](func(key int) uint64 { return uint64(key) })
+	if _, err := imported.Import(&b); err != nil {
+		t.Fatal(err)
+	}
+	// The keys not updated during the export are all exported.
+	for i := 0; i < capacity-8; i++ {
+		if v, ok := imported.Load(i); !ok || v != i {
+			t.Fatalf("Load(%v) = %v, %v after Import; want %v, true", i, v, ok, i)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package cmap
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+)
+
+// gobEntry is a key-value pair encoded by GobEncode. The pairs are encoded as
+// structs, so that keys and values of interface types are encoded with their
+// dynamic types, which must be registered with gob.Register unless they are
+// of the basic types.
+type gobEntry[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+// GobEncode encodes the key-value pairs the map contains at a single point in
+// time, which are encoded one by one straight from the tables while all the
+// shards are locked, so that no copy of the map is made. Updates of the map
+// thus wait until the encoding finishes.
+func (m *MapOf[K, V]) GobEncode() ([]byte, error) {
+	m.lockAll()
+	defer m.unlockAll()
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(m.Len()); err != nil {
+		return nil, err
+	}
+	var err error
+	for i := range m.shards {
+		m.shards[i].table().Range(func(k K, v V) bool {
+			err = enc.Encode(gobEntry[K, V]{k, v})
+			return err == nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// GobDecode stores the key-value pairs encoded by GobEncode into the map,
+// keeping the keys already in the map. The tables are grown for the pairs
+// before they are stored. The map must have been created by one of the
+// constructors, which give the hash function of the keys, so a map to be
+// decoded by gob must be created before it is given to the decoder.
+func (m *MapOf[K, V]) GobDecode(data []byte) error {
+	if m.shards == nil {
+		return errors.New("cmap: GobDecode into a map not created by a constructor")
+	}
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	var n int
+	if err := dec.Decode(&n); err != nil {
+		return err
+	}
+	// Every pair takes at least a byte, so a count beyond the length of the
+	// data is corrupt, and the tables are not grown for it.
+	if n < 0 || n > len(data) {
+		return errors.New("cmap: invalid number of pairs in the gob encoding")
+	}
+	m.Reserve(m.Len() + n)
+	for i := 0; i < n; i++ {
+		var e gobEntry[K, V]
+		if err := dec.Decode(&e); err != nil {
+			return err
+		}
+		m.Store(e.Key, e.Value)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package cmap_test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+	"testing/quick"
+
+	"github.com/decillion/go-cmap"
+)
+
+func TestGobMatchesBuiltInMap(t *testing.T) {
+	f := func(builtin map[uint64]string) bool {
+		m := cmap.NewMapOf[uint64, string](cmap.Uint64Hasher)
+		for k, v := range builtin {
+			m.Store(k, v)
+		}
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(m); err != nil {
+			return false
+		}
+		decoded := cmap.NewMapOf[uint64, string](cmap.Uint64Hasher)
+		if err := gob.NewDecoder(&buf).Decode(decoded); err != nil || decoded.Len() != len(builtin) {
+			return false
+		}
+		for k, v := range builtin {
+			if w, ok := decoded.Load(k); !ok || w != v {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+type gobPoint struct{ X, Y int }
+
+func TestGobInterfaces(t *testing.T) {
+	gob.Register(gobPoint{})
+	type checkpoint struct {
+		Name string
+		Map  *cmap.Map
+	}
+	m := cmap.NewMap(cmap.SeededHasher)
+	m.Store("a", 1)
+	m.Store(gobPoint{1, 2}, []string{"b"})
+	m.Store(3, gobPoint{3, 4})
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(checkpoint{"c", m}); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := checkpoint{Map: cmap.NewMap(cmap.SeededHasher)}
+	decoded.Map.Store("d", 5) // kept
+	if err := gob.NewDecoder(&buf).Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Name != "c" || decoded.Map.Len() != 4 {
+		t.Errorf("decoded %q with %v keys; want %q with 4 keys", decoded.Name, decoded.Map.Len(), "c")
+	}
+	if v, ok := decoded.Map.Load(gobPoint{1, 2}); !ok || v.([]string)[0] != "b" {
+		t.Errorf("Load(gobPoint{1, 2}) = %v, %v after decoding", v, ok)
+	}
+	if v, ok := decoded.Map.Load(3); !ok || v != (gobPoint{3, 4}) {
+		t.Errorf("Load(3) = %v, %v after decoding", v, ok)
+	}
+
+	var zero cmap.MapOf[string, int]
+	if err := zero.GobDecode(nil); err == nil {
+		t.Error("GobDecode into the zero map does not fail")
+	}
+	for _, n := range []int{-1, 1 << 40} {
+		var buf bytes.Buffer
+		gob.NewEncoder(&buf).Encode(n)
+		if err := decoded.Map.GobDecode(buf.Bytes()); err == nil {
+			t.Errorf("GobDecode succeeds on an encoding claiming %v pairs", n)
+		}
+	}
+}
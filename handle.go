@@ -0,0 +1,123 @@
+package cmap
+
+import "sync"
+
+// HandleMapOf is a concurrent map from keys of type K to values of type V that
+// are updated in place through handles. Each key has a handle with a lock of
+// its own, allocated once when the key is first added, so a large value is
+// changed by locking its handle alone, neither copying the value nor entering
+// the critical section of the map:
+//
+//	h, _ := m.LoadOrCreate(key)
+//	h.Lock()
+//	h.Value().Append(item)
+//	h.Unlock()
+type HandleMapOf[K comparable, V any] struct {
+	m *MapOf[K, *Handle[V]]
+}
+
+// HandleMap is a concurrent handle map whose keys and values are of arbitrary
+// types.
+type HandleMap = HandleMapOf[interface{}, interface{}]
+
+// Handle is the handle of the value of a key of a HandleMapOf. Its value is
+// read and written only while it is locked.
+type Handle[V any] struct {
+	mu      sync.Mutex
+	value   V
+	removed bool
+}
+
+// Lock locks the handle.
+func (h *Handle[V]) Lock() {
+	h.mu.Lock()
+}
+
+// Unlock unlocks the handle.
+func (h *Handle[V]) Unlock() {
+	h.mu.Unlock()
+}
+
+// Value returns a pointer to the value of the handle, through which the value
+// is changed in place. It must be called, and the pointer used, only while the
+// handle is locked.
+func (h *Handle[V]) Value() *V {
+	return &h.value
+}
+
+// SetValue replaces the value of the handle. It must be called only while the
+// handle is locked.
+func (h *Handle[V]) SetValue(value V) {
+	h.value = value
+}
+
+// Removed reports whether the key of the handle has been removed from the map,
+// after which the changes of its value are no longer seen through the map. It
+// must be called only while the handle is locked.
+func (h *Handle[V]) Removed() bool {
+	return h.removed
+}
+
+// NewHandleMap returns an empty handle map whose keys are hashed by the given
+// function.
+func NewHandleMap[H Hash](hasher func(key interface{}) H) (m *HandleMap) {
+	return NewHandleMapOf[interface{}, interface{}](hasher)
+}
+
+// NewHandleMapOf returns an empty handle map from keys of type K to values of
+// type V whose keys are hashed by the given function.
+func NewHandleMapOf[K comparable, V any, H Hash](hasher func(key K) H) (m *HandleMapOf[K, V]) {
+	return &HandleMapOf[K, V]{m: NewMapOf[K, *Handle[V]](hasher)}
+}
+
+// Load returns the handle of the given key and true if the key exists.
+// Otherwise, it returns nil and false.
+func (m *HandleMapOf[K, V]) Load(key K) (h *Handle[V], ok bool) {
+	return m.m.Load(key)
+}
+
+// LoadOrCreate returns the handle of the given key and true if the key exists.
+// Otherwise, it adds the key with a handle of the zero value and returns the
+// handle and false.
+func (m *HandleMapOf[K, V]) LoadOrCreate(key K) (h *Handle[V], loaded bool) {
+	if h, ok := m.m.Load(key); ok {
+		return h, true
+	}
+	return m.m.LoadOrStore(key, new(Handle[V]))
+}
+
+// Store sets the given value to the handle of the given key, which is added
+// if it is missing.
+func (m *HandleMapOf[K, V]) Store(key K, value V) {
+	for {
+		h, _ := m.LoadOrCreate(key)
+		h.Lock()
+		if !h.removed {
+			h.value = value
+			h.Unlock()
+			return
+		}
+		h.Unlock() // the key was removed meanwhile, and may be added again
+	}
+}
+
+// Delete removes the given key, marking its handle as removed.
+func (m *HandleMapOf[K, V]) Delete(key K) {
+	if h, ok := m.m.LoadAndDelete(key); ok {
+		h.Lock()
+		h.removed = true
+		h.Unlock()
+	}
+}
+
+// Len returns the number of keys in the map.
+func (m *HandleMapOf[K, V]) Len() int {
+	return m.m.Len()
+}
+
+// Range iteratively applies the given function to each key and its handle
+// until the function returns false. It gives the same guarantees as
+// MapOf.Range.
+func (m *HandleMapOf[K, V]) Range(f func(key K, h *Handle[V]) bool) {
+	m.m.Range(f)
+}
@@ -0,0 +1,75 @@
+package cmap_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/decillion/go-cmap"
+)
+
+func TestHandleMapConcurrentUpdates(t *testing.T) {
+	const goroutines, keys, updates = 8, 16, 1 << 10
+	m := cmap.NewHandleMapOf[uint64, []int](cmap.Uint64Hasher)
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < updates; i++ {
+				h, _ := m.LoadOrCreate(uint64(i % keys))
+				h.Lock()
+				*h.Value() = append(*h.Value(), g)
+				h.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for k := uint64(0); k < keys; k++ {
+		h, ok := m.Load(k)
+		if !ok {
+			t.Fatalf("Load(%v) finds no handle", k)
+		}
+		h.Lock()
+		if n := len(*h.Value()); n != goroutines*updates/keys {
+			t.Errorf("the value of %v has %v elements; want %v", k, n, goroutines*updates/keys)
+		}
+		h.Unlock()
+	}
+	if m.Len() != keys {
+		t.Errorf("Len() = %v; want %v", m.Len(), keys)
+	}
+}
+
+func TestHandleMapDelete(t *testing.T) {
+	m := cmap.NewHandleMap(cmap.DefaultHasher)
+	m.Store("key", 1)
+	h, loaded := m.LoadOrCreate("key")
+	if !loaded {
+		t.Fatal(`LoadOrCreate("key") creates a handle of a stored key`)
+	}
+	m.Delete("key")
+	h.Lock()
+	if !h.Removed() || *h.Value() != 1 {
+		t.Errorf("the handle of a deleted key has %v and Removed() = %v; want 1 and true", *h.Value(), h.Removed())
+	}
+	h.SetValue(2)
+	h.Unlock()
+	if _, ok := m.Load("key"); ok || m.Len() != 0 {
+		t.Errorf("the deleted key is loaded or Len() = %v", m.Len())
+	}
+
+	m.Store("key", 3)
+	n := 0
+	m.Range(func(key interface{}, h *cmap.Handle[interface{}]) bool {
+		h.Lock()
+		defer h.Unlock()
+		if n++; key != "key" || *h.Value() != 3 || h.Removed() {
+			t.Errorf("Range visits %v with %v", key, *h.Value())
+		}
+		return true
+	})
+	if n != 1 {
+		t.Errorf("Range visits %v keys; want 1", n)
+	}
+}
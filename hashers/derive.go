@@ -0,0 +1,91 @@
+package hashers
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// plan hashes a value of a certain type by reflection.
+type plan func(v reflect.Value) uint64
+
+// plans caches a plan for each type.
+var plans sync.Map // map[reflect.Type]plan
+
+// DeriveHasher returns a hash function for values of the same type as the
+// given example, which must be of a comparable type. The function hashes a
+// struct field by field according to a plan computed once per type, which is
+// much faster than hashing its string representation. A value of another type
+// is hashed by TypeHasher32.
+func DeriveHasher(example interface{}) func(key interface{}) uint32 {
+	t := reflect.TypeOf(example)
+	if t == nil || !t.Comparable() {
+		panic(fmt.Sprintf("hashers: DeriveHasher of non-comparable type %v", t))
+	}
+	p := planOf(t)
+	return func(key interface{}) uint32 {
+		if v := reflect.ValueOf(key); v.IsValid() && v.Type() == t {
+			return Fold(p(v))
+		}
+		return TypeHasher32(key)
+	}
+}
+
+// planOf returns the plan for the given type, computing it if necessary.
+func planOf(t reflect.Type) plan {
+	if p, ok := plans.Load(t); ok {
+		return p.(plan)
+	}
+	p, _ := plans.LoadOrStore(t, newPlan(t))
+	return p.(plan)
+}
+
+func newPlan(t reflect.Type) plan {
+	switch t.Kind() {
+	case reflect.String:
+		return func(v reflect.Value) uint64 { return String(v.String()) }
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(v reflect.Value) uint64 { return Int64(v.Int()) }
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return func(v reflect.Value) uint64 { return Uint64(v.Uint()) }
+	case reflect.Float32, reflect.Float64:
+		return func(v reflect.Value) uint64 { return Float64(v.Float()) }
+	case reflect.Complex64, reflect.Complex128:
+		return func(v reflect.Value) uint64 { return Complex128(v.Complex()) }
+	case reflect.Bool:
+		return func(v reflect.Value) uint64 { return Bool(v.Bool()) }
+	case reflect.Pointer, reflect.UnsafePointer, reflect.Chan:
+		return func(v reflect.Value) uint64 { return UnsafePointer(v.UnsafePointer()) }
+	case reflect.Interface:
+		return func(v reflect.Value) uint64 {
+			if v.IsNil() {
+				return 0
+			}
+			return planOf(v.Elem().Type())(v.Elem())
+		}
+	case reflect.Array:
+		elem := planOf(t.Elem())
+		return func(v reflect.Value) uint64 {
+			h := uint64(offset64)
+			for i := 0; i < v.Len(); i++ {
+				h = mix(h + elem(v.Index(i)))
+			}
+			return h
+		}
+	case reflect.Struct:
+		fields := make([]plan, t.NumField())
+		for i := range fields {
+			fields[i] = planOf(t.Field(i).Type)
+		}
+		return func(v reflect.Value) uint64 {
+			h := uint64(offset64)
+			for i, field := range fields {
+				h = mix(h + field(v.Field(i)))
+			}
+			return h
+		}
+	}
+	// Values of the other kinds are not comparable and cannot be keys of maps
+	// using the == operator.
+	return func(v reflect.Value) uint64 { return String(fmt.Sprintf("%#v", v)) }
+}
@@ -0,0 +1,170 @@
+package hashers
+
+import (
+	"math/bits"
+	"unsafe"
+)
+
+// Hash functions in this file process eight bytes at a time, so they are much
+// faster than String on long strings such as URLs. They are not cryptographic
+// hash functions.
+
+const (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+// read64 returns the little-endian 64-bit integer at the head of s.
+func read64(s string) uint64 {
+	_ = s[7]
+	return uint64(s[0]) | uint64(s[1])<<8 | uint64(s[2])<<16 | uint64(s[3])<<24 |
+		uint64(s[4])<<32 | uint64(s[5])<<40 | uint64(s[6])<<48 | uint64(s[7])<<56
+}
+
+// read32 returns the little-endian 32-bit integer at the head of s.
+func read32(s string) uint64 {
+	_ = s[3]
+	return uint64(s[0]) | uint64(s[1])<<8 | uint64(s[2])<<16 | uint64(s[3])<<24
+}
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxPrime1
+}
+
+func xxMergeRound(acc, val uint64) uint64 {
+	acc ^= xxRound(0, val)
+	return acc*xxPrime1 + xxPrime4
+}
+
+// XXHash64 returns the 64-bit xxHash of the given string with the seed zero.
+func XXHash64(s string) uint64 {
+	n := uint64(len(s))
+	var seed, h uint64
+	if len(s) >= 32 {
+		v1 := seed + xxPrime1 + xxPrime2
+		v2 := seed + xxPrime2
+		v3 := seed
+		v4 := seed - xxPrime1
+		for ; len(s) >= 32; s = s[32:] {
+			v1 = xxRound(v1, read64(s[0:8]))
+			v2 = xxRound(v2, read64(s[8:16]))
+			v3 = xxRound(v3, read64(s[16:24]))
+			v4 = xxRound(v4, read64(s[24:32]))
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) +
+			bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxMergeRound(h, v1)
+		h = xxMergeRound(h, v2)
+		h = xxMergeRound(h, v3)
+		h = xxMergeRound(h, v4)
+	} else {
+		h = seed + xxPrime5
+	}
+	h += n
+
+	for ; len(s) >= 8; s = s[8:] {
+		h ^= xxRound(0, read64(s))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+	if len(s) >= 4 {
+		h ^= read32(s) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		s = s[4:]
+	}
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i]) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+	return h
+}
+
+// XXHash64Bytes returns the same hash as XXHash64 for the string with the same
+// contents. It does not copy the given slice.
+func XXHash64Bytes(b []byte) uint64 {
+	return XXHash64(unsafe.String(unsafe.SliceData(b), len(b)))
+}
+
+// wySecret is the default secret of wyhash.
+var wySecret = [4]uint64{0x2d358dccaa6c78a5, 0x8bb84b93962eacc9, 0x4b33a62ed433d4a3, 0x4d5a2da51de1aa47}
+
+func wyMix(a, b uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	return hi ^ lo
+}
+
+// WyHash returns the wyhash (final version 4) of the given string with the
+// seed zero and the default secret.
+func WyHash(s string) uint64 {
+	return WyHashSeed(s, 0)
+}
+
+// WyHashSeed is like WyHash but uses the given seed.
+func WyHashSeed(s string, seed uint64) uint64 {
+	n := uint64(len(s))
+	seed ^= wyMix(seed^wySecret[0], wySecret[1])
+	var a, b uint64
+	switch {
+	case len(s) >= 4 && len(s) <= 16:
+		d := (len(s) >> 3) << 2
+		a = read32(s)<<32 | read32(s[d:])
+		b = read32(s[len(s)-4:])<<32 | read32(s[len(s)-4-d:])
+	case len(s) > 0 && len(s) < 4:
+		a = uint64(s[0])<<16 | uint64(s[len(s)>>1])<<8 | uint64(s[len(s)-1])
+	case len(s) > 16:
+		p := s
+		if len(p) >= 48 {
+			see1, see2 := seed, seed
+			for ; len(p) >= 48; p = p[48:] {
+				seed = wyMix(read64(p[0:8])^wySecret[1], read64(p[8:16])^seed)
+				see1 = wyMix(read64(p[16:24])^wySecret[2], read64(p[24:32])^see1)
+				see2 = wyMix(read64(p[32:40])^wySecret[3], read64(p[40:48])^see2)
+			}
+			seed ^= see1 ^ see2
+		}
+		for ; len(p) > 16; p = p[16:] {
+			seed = wyMix(read64(p[0:8])^wySecret[1], read64(p[8:16])^seed)
+		}
+		// The last 16 bytes are read from s, since p may be shorter than that.
+		a = read64(s[len(s)-16:])
+		b = read64(s[len(s)-8:])
+	}
+	a ^= wySecret[1]
+	b ^= seed
+	hi, lo := bits.Mul64(a, b)
+	return wyMix(lo^wySecret[0]^n, hi^wySecret[1])
+}
+
+// WyHashBytes returns the same hash as WyHash for the string with the same
+// contents. It does not copy the given slice.
+func WyHashBytes(b []byte) uint64 {
+	return WyHash(unsafe.String(unsafe.SliceData(b), len(b)))
+}
+
+// TypeHasherFunc returns a hash function like TypeHasher64 except that strings
+// are hashed by the given function, such as XXHash64 or WyHash. Keys of the
+// other types are hashed as by TypeHasher64.
+func TypeHasherFunc(stringHasher func(s string) uint64) func(key interface{}) uint64 {
+	return func(key interface{}) uint64 {
+		if s, ok := key.(string); ok {
+			return stringHasher(s)
+		}
+		return TypeHasher64(key)
+	}
+}
+
+// String128 returns a 128-bit hash of the given string, which is made of two
+// independent 64-bit hashes, namely XXHash64 and WyHash.
+func String128(s string) (hi, lo uint64) {
+	return XXHash64(s), WyHash(s)
+}
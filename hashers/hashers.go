@@ -0,0 +1,213 @@
+// Package hashers implements hash functions for values of basic types and
+// helpers to compose them into hash functions for values of composite types.
+//
+// A hash function for a struct can be written by hashing each field and
+// combining the results:
+//
+//	func hashPoint(p Point) uint64 {
+//		return hashers.CombineHashes(hashers.Int(p.X), hashers.Int(p.Y))
+//	}
+//
+// Values equal to each other under the == operator always have the same hash.
+package hashers
+
+import (
+	"math"
+	"reflect"
+	"time"
+	"unsafe"
+)
+
+const (
+	offset64 = 14695981039346656037
+	prime64  = 1099511628211
+)
+
+// mix is the finalizer of MurmurHash3, which makes every bit of the hash
+// depend on every bit of h.
+func mix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// Fold returns a 32-bit hash made of all the bits of the given 64-bit hash.
+func Fold(h uint64) uint32 {
+	return uint32(h ^ h>>32)
+}
+
+// CombineHashes returns a hash of the sequence of the given hashes. The result
+// depends on the order of the hashes.
+func CombineHashes(hashes ...uint64) uint64 {
+	h := uint64(offset64)
+	for _, x := range hashes {
+		h = mix(h + x)
+	}
+	return h
+}
+
+// String returns the 64-bit FNV-1a hash of the given string.
+func String(s string) uint64 {
+	h := uint64(offset64)
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= prime64
+	}
+	return h
+}
+
+// Bytes returns the same hash as String for the string with the same contents.
+func Bytes(b []byte) uint64 {
+	h := uint64(offset64)
+	for _, c := range b {
+		h ^= uint64(c)
+		h *= prime64
+	}
+	return h
+}
+
+// Uint64 returns a hash of the given value.
+func Uint64(v uint64) uint64 { return mix(v) }
+
+// Uint32 returns a hash of the given value.
+func Uint32(v uint32) uint64 { return mix(uint64(v)) }
+
+// Uint16 returns a hash of the given value.
+func Uint16(v uint16) uint64 { return mix(uint64(v)) }
+
+// Uint8 returns a hash of the given value.
+func Uint8(v uint8) uint64 { return mix(uint64(v)) }
+
+// Uint returns a hash of the given value.
+func Uint(v uint) uint64 { return mix(uint64(v)) }
+
+// Uintptr returns a hash of the given value.
+func Uintptr(v uintptr) uint64 { return mix(uint64(v)) }
+
+// Int64 returns a hash of the given value.
+func Int64(v int64) uint64 { return mix(uint64(v)) }
+
+// Int32 returns a hash of the given value.
+func Int32(v int32) uint64 { return mix(uint64(v)) }
+
+// Int16 returns a hash of the given value.
+func Int16(v int16) uint64 { return mix(uint64(v)) }
+
+// Int8 returns a hash of the given value.
+func Int8(v int8) uint64 { return mix(uint64(v)) }
+
+// Int returns a hash of the given value.
+func Int(v int) uint64 { return mix(uint64(v)) }
+
+// Bool returns a hash of the given value.
+func Bool(v bool) uint64 {
+	if v {
+		return mix(1)
+	}
+	return mix(0)
+}
+
+// Float64 returns a hash of the given value. Positive and negative zeros have
+// the same hash since they are equal.
+func Float64(v float64) uint64 {
+	if v == 0 {
+		v = 0 // normalize -0
+	}
+	return mix(math.Float64bits(v))
+}
+
+// Float32 returns a hash of the given value. Positive and negative zeros have
+// the same hash since they are equal.
+func Float32(v float32) uint64 {
+	if v == 0 {
+		v = 0 // normalize -0
+	}
+	return mix(uint64(math.Float32bits(v)))
+}
+
+// Complex128 returns a hash of the given value.
+func Complex128(v complex128) uint64 {
+	return CombineHashes(Float64(real(v)), Float64(imag(v)))
+}
+
+// Complex64 returns a hash of the given value.
+func Complex64(v complex64) uint64 {
+	return CombineHashes(Float32(real(v)), Float32(imag(v)))
+}
+
+// Pointer returns a hash of the address the given pointer points to.
+func Pointer[T any](p *T) uint64 {
+	return UnsafePointer(unsafe.Pointer(p))
+}
+
+// UnsafePointer returns a hash of the given address.
+func UnsafePointer(p unsafe.Pointer) uint64 {
+	return mix(uint64(uintptr(p)))
+}
+
+// Time returns a hash of the instant of the given time. Times representing the
+// same instant have the same hash even if their locations differ, so the hash
+// is also consistent with Time.Equal.
+func Time(t time.Time) uint64 {
+	return Int64(t.UnixNano())
+}
+
+// TypeHasher64 is a hash function for a value of an arbitrary type. Values of
+// the predeclared types and time.Time are hashed directly. Values of the other
+// types, such as named types, pointers, arrays, and structs, are hashed by
+// reflection, which is considerably slower.
+func TypeHasher64(v interface{}) uint64 {
+	switch v := v.(type) {
+	case string:
+		return String(v)
+	case int:
+		return Int(v)
+	case int8:
+		return Int8(v)
+	case int16:
+		return Int16(v)
+	case int32:
+		return Int32(v)
+	case int64:
+		return Int64(v)
+	case uint:
+		return Uint(v)
+	case uint8:
+		return Uint8(v)
+	case uint16:
+		return Uint16(v)
+	case uint32:
+		return Uint32(v)
+	case uint64:
+		return Uint64(v)
+	case uintptr:
+		return Uintptr(v)
+	case float32:
+		return Float32(v)
+	case float64:
+		return Float64(v)
+	case complex64:
+		return Complex64(v)
+	case complex128:
+		return Complex128(v)
+	case bool:
+		return Bool(v)
+	case time.Time:
+		return Time(v)
+	case nil:
+		return 0
+	}
+	return reflectHasher(reflect.ValueOf(v))
+}
+
+// TypeHasher32 is like TypeHasher64 but returns a 32-bit hash.
+func TypeHasher32(v interface{}) uint32 {
+	return Fold(TypeHasher64(v))
+}
+
+func reflectHasher(v reflect.Value) uint64 {
+	return planOf(v.Type())(v)
+}
@@ -0,0 +1,165 @@
+package hashers_test
+
+import (
+	"math"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/decillion/go-cmap/hashers"
+)
+
+type named int
+
+type point struct {
+	X, Y float64
+	p    *int
+}
+
+func TestEqualValuesHaveEqualHashes(t *testing.T) {
+	x := new(int)
+	loc := time.FixedZone("UTC+9", 9*60*60)
+	now := time.Now()
+	for _, c := range [][2]interface{}{
+		{0.0, math.Copysign(0, -1)},
+		{float32(0), float32(math.Copysign(0, -1))},
+		{named(1), named(1)},
+		{point{0, 1, x}, point{math.Copysign(0, -1), 1, x}},
+		{[2]interface{}{"a", 1}, [2]interface{}{"a", 1}},
+		{x, x},
+	} {
+		if c[0] != c[1] {
+			t.Fatalf("%#v != %#v", c[0], c[1])
+		}
+		if h0, h1 := hashers.TypeHasher64(c[0]), hashers.TypeHasher64(c[1]); h0 != h1 {
+			t.Errorf("TypeHasher64(%#v) = %x but TypeHasher64(%#v) = %x", c[0], h0, c[1], h1)
+		}
+	}
+	if hashers.Time(now) != hashers.Time(now.In(loc)) {
+		t.Error("Time hashes the same instant differently in different locations")
+	}
+}
+
+func TestTypeHasherMatchesTypedHashers(t *testing.T) {
+	f := func(s string, i int64, u uint16, b bool, f float64) bool {
+		return hashers.TypeHasher64(s) == hashers.String(s) &&
+			hashers.TypeHasher64(i) == hashers.Int64(i) &&
+			hashers.TypeHasher64(u) == hashers.Uint16(u) &&
+			hashers.TypeHasher64(b) == hashers.Bool(b) &&
+			hashers.TypeHasher64(f) == hashers.Float64(f) &&
+			hashers.String(s) == hashers.Bytes([]byte(s))
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCombineHashesDependsOnOrder(t *testing.T) {
+	f := func(x, y uint64) bool {
+		return x == y || hashers.CombineHashes(x, y) != hashers.CombineHashes(y, x)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStructFieldsAreDistinguished(t *testing.T) {
+	if hashers.TypeHasher64(point{X: 1}) == hashers.TypeHasher64(point{Y: 1}) {
+		t.Error("TypeHasher64 does not distinguish struct fields")
+	}
+}
+
+type record struct {
+	Name  string
+	ID    uint32
+	Point point
+	Tag   interface{}
+}
+
+func TestDeriveHasher(t *testing.T) {
+	hasher := hashers.DeriveHasher(record{})
+	f := func(name string, id uint32, x float64, tag int8) bool {
+		r0 := record{name, id, point{X: x}, tag}
+		r1 := record{name, id, point{X: x}, tag}
+		r2 := record{name, id + 1, point{X: x}, tag}
+		return hasher(r0) == hasher(r1) && hasher(r0) != hasher(r2) &&
+			hasher(name) == hashers.TypeHasher32(name)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+	if hasher(nil) != hashers.TypeHasher32(nil) {
+		t.Error("DeriveHasher hashes nil differently from TypeHasher32")
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	var r hashers.Registry
+	hashers.Register(&r, func(key string) uint64 { return uint64(len(key)) })
+	if h := r.Hasher("abc"); h != 3 {
+		t.Errorf("Hasher(%q) = %d, want 3", "abc", h)
+	}
+	if h, want := r.Hasher(42), hashers.TypeHasher64(42); h != want {
+		t.Errorf("Hasher(42) = %x, want %x", h, want)
+	}
+	hashers.Register(&r, func(key int) uint64 { return uint64(key) })
+	if h := r.Hasher(42); h != 42 {
+		t.Errorf("Hasher(42) = %d after registration, want 42", h)
+	}
+	if h, want := r.Hasher32(nil), hashers.TypeHasher32(nil); h != want {
+		t.Errorf("Hasher32(nil) = %x, want %x", h, want)
+	}
+}
+
+func TestXXHash64(t *testing.T) {
+	for _, c := range []struct {
+		s    string
+		hash uint64
+	}{
+		{"", 0xef46db3751d8e999},
+		{"abc", 0x44bc2cf5ad770999},
+		{"Nobody inspects the spammish repetition", 0xfbcea83c8a378bf1},
+	} {
+		if h := hashers.XXHash64(c.s); h != c.hash {
+			t.Errorf("XXHash64(%q) = %x, want %x", c.s, h, c.hash)
+		}
+	}
+}
+
+func TestWyHash(t *testing.T) {
+	// The test vectors of the reference implementation, where the seed of each
+	// message is its index.
+	for i, c := range []struct {
+		s    string
+		hash uint64
+	}{
+		{"", 0x93228a4de0eec5a2},
+		{"a", 0xc5bac3db178713c4},
+		{"abc", 0xa97f2f7b1d9b3314},
+		{"message digest", 0x786d1f1df3801df4},
+		{"abcdefghijklmnopqrstuvwxyz", 0xdca5a8138ad37c87},
+		{"ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789", 0xb9e734f117cfaf70},
+		{"12345678901234567890123456789012345678901234567890123456789012345678901234567890", 0x6cc5eab49a92d617},
+	} {
+		if h := hashers.WyHashSeed(c.s, uint64(i)); h != c.hash {
+			t.Errorf("WyHashSeed(%q, %v) = %x, want %x", c.s, i, h, c.hash)
+		}
+	}
+	if h, want := hashers.WyHash(""), uint64(0x93228a4de0eec5a2); h != want {
+		t.Errorf(`WyHash("") = %x, want %x`, h, want)
+	}
+}
+
+func TestFastHashersMatchBytes(t *testing.T) {
+	f := func(b []byte) bool {
+		return hashers.XXHash64(string(b)) == hashers.XXHash64Bytes(b) &&
+			hashers.WyHash(string(b)) == hashers.WyHashBytes(b)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+	hasher := hashers.TypeHasherFunc(hashers.WyHash)
+	if hasher("abc") != hashers.WyHash("abc") || hasher(1) != hashers.TypeHasher64(1) {
+		t.Error("TypeHasherFunc does not select the hash function by type")
+	}
+}
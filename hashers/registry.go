@@ -0,0 +1,43 @@
+package hashers
+
+import (
+	"reflect"
+	"sync"
+)
+
+// Registry maps types of keys to hash functions, so that a map holding keys of
+// different types can hash each key with the function suited to its type. The
+// zero value is an empty registry ready to use. A registry is safe for
+// concurrent use, and its Hasher method can be passed to cmap.NewMap.
+type Registry struct {
+	hashers sync.Map // map[reflect.Type]func(key interface{}) uint64
+}
+
+// Register makes the registry hash keys of type K with the given function. It
+// replaces the function previously registered for K, if any.
+func Register[K any](r *Registry, hasher func(key K) uint64) {
+	r.hashers.Store(reflect.TypeOf((*K)(nil)).Elem(), func(key interface{}) uint64 {
+		return hasher(key.(K))
+	})
+}
+
+// Hasher hashes the given key by the function registered for its type. A key
+// of an unregistered type is hashed as by TypeHasher64. The decision made for
+// each type is cached, so hashing a key costs a single lookup by its type.
+func (r *Registry) Hasher(key interface{}) uint64 {
+	t := reflect.TypeOf(key)
+	if t == nil {
+		return TypeHasher64(key)
+	}
+	if hasher, ok := r.hashers.Load(t); ok {
+		return hasher.(func(key interface{}) uint64)(key)
+	}
+	var fallback func(key interface{}) uint64 = TypeHasher64
+	hasher, _ := r.hashers.LoadOrStore(t, fallback)
+	return hasher.(func(key interface{}) uint64)(key)
+}
+
+// Hasher32 is like Hasher but folds the hash to 32 bits.
+func (r *Registry) Hasher32(key interface{}) uint32 {
+	return Fold(r.Hasher(key))
+}
@@ -2,161 +2,1039 @@
 package hmap
 
 import (
+	"cmp"
+	"slices"
+	"sync"
 	"sync/atomic"
 	"unsafe"
 )
 
-// Map is a non-resizable hash map. A single update operation and multiple read
-// operations can be executed concurrently on the map, while multiple update
-// operations cannot. In other words, only update operations need an external
-// synchronization.
+// MapOf is a non-resizable hash map from keys of type K to values of type V.
+// Every bucket of the map has a lock of its own, so any number of update
+// operations and read operations can be executed concurrently on the map.
+// Update operations on keys in the same bucket are serialized by its lock,
+// while read operations never wait for locks. Neither do Swap, Store, and
+// CompareAndSwap on an existing key, which replace its value by an atomic
+// operation.
 //
-// Store and Delete are update operations and Load and Range are read
-// operations. StatBuckets and StatEntries are considered to be write
-// operations, while they do not modify the map.
-type Map struct {
-	hasher        func(key interface{}) (hash uint32)
-	buckets       []*bucket
-	numOfEntries  uint
-	numOfDeleted  uint
-	largestBucket uint
-}
-
-type bucket struct {
-	first        unsafe.Pointer // *entry
+// StartMigration, Migrate, and MigrateParallel are the exceptions: they can be
+// executed concurrently with the other operations but not with each other, so
+// they need an external synchronization.
+type MapOf[K comparable, V any] struct {
+	hasher    func(key K) (hash uint64)
+	hasher128 func(key K) (hi, lo uint64)
+	equal     func(a, b K) bool
+	seed      uint64
+	buckets   []*bucket[K, V]
+	next      unsafe.Pointer // *MapOf[K, V] to which keys are migrated
+	migrated  uint           // the number of migrated buckets
+	marking   bool           // whether the keys used are marked as referenced
+
+	// The statistics are updated by every insertion or deletion, so they are
+	// kept apart from the fields above, which every operation reads, to avoid
+	// false sharing.
+	_             [cacheLineSize]byte
+	numOfEntries  atomic.Int64
+	numOfDeleted  atomic.Int64
+	largestBucket atomic.Int64
+	_             [cacheLineSize - 24]byte
+}
+
+// cacheLineSize is the size of a cache line on the common platforms.
+const cacheLineSize = 64
+
+// Map is a non-resizable hash map whose keys and values are of arbitrary
+// types. See MapOf for the details.
+type Map = MapOf[interface{}, interface{}]
+
+// Hash is a constraint that permits the types of hashes. Maps use all the bits
+// of a hash to select a bucket, so 64-bit hashes suffer from fewer collisions
+// than 32-bit ones in very large maps.
+type Hash interface {
+	~uint32 | ~uint64
+}
+
+// Widen converts a hash function returning hashes of type H into the one
+// returning uint64.
+func Widen[K any, H Hash](hasher func(key K) H) func(key K) uint64 {
+	if hasher, ok := any(hasher).(func(key K) uint64); ok {
+		return hasher
+	}
+	return func(key K) uint64 {
+		return uint64(hasher(key))
+	}
+}
+
+type bucket[K comparable, V any] struct {
+	mu           sync.Mutex     // held by update operations on the bucket
+	first        unsafe.Pointer // *entry[K, V]
 	numOfEntries uint
+	migrated     uint32 // nonzero if the keys are migrated to the next map
 }
 
-type entry struct {
-	key   interface{}
-	value unsafe.Pointer // *interface{}
-	next  unsafe.Pointer // *entry
+// Digest is the hash of a key computed by a map. The upper half, which is mixed
+// with the seed of the map, selects a bucket and the lower half is a
+// fingerprint compared before keys. The lower half is zero unless 128-bit
+// hashes are used.
+//
+// A digest computed by a map is valid for every map created with the same
+// hash function, equality, and seed, so the caller of the Hashed variants of
+// the operations can hash a key once and use the digest for several maps.
+type Digest struct {
+	hi, lo uint64
 }
 
-var (
-	deleted  = unsafe.Pointer(new(interface{}))
-	terminal = unsafe.Pointer(new(interface{}))
-)
+// Uint64 returns the upper half of the digest.
+func (d Digest) Uint64() uint64 {
+	return d.hi
+}
+
+type entry[K comparable, V any] struct {
+	digest Digest
+	key    K
+	value  unsafe.Pointer // *V
+	next   unsafe.Pointer // *entry[K, V]
 
-func (b *bucket) loadFirst() (first *entry) {
-	return (*entry)(atomic.LoadPointer(&b.first))
+	// referenced is nonzero if the key has been used since Sweep last
+	// visited it. It is maintained only if the map marks references.
+	referenced uint32
 }
 
-func (b *bucket) storeFirst(first *entry) {
+// mark marks the entry as referenced if the map marks references. The mark is
+// written only if it is not set yet, so that hot keys do not keep writing to
+// the entry.
+func (m *MapOf[K, V]) mark(e *entry[K, V]) {
+	if m.marking && atomic.LoadUint32(&e.referenced) == 0 {
+		atomic.StoreUint32(&e.referenced, 1)
+	}
+}
+
+// deleted is the value of a logically deleted entry.
+var deleted = unsafe.Pointer(new(byte))
+
+// moved is the value of an entry migrated to the next map.
+var moved = unsafe.Pointer(new(byte))
+
+func (b *bucket[K, V]) loadFirst() (first *entry[K, V]) {
+	return (*entry[K, V])(atomic.LoadPointer(&b.first))
+}
+
+func (b *bucket[K, V]) storeFirst(first *entry[K, V]) {
 	atomic.StorePointer(&b.first, unsafe.Pointer(first))
 }
 
-func (e *entry) loadValue() (value interface{}) {
-	return *(*interface{})(atomic.LoadPointer(&e.value))
+func (b *bucket[K, V]) isMigrated() bool {
+	return atomic.LoadUint32(&b.migrated) != 0
 }
 
-func (e *entry) storeValue(value interface{}) {
+// loadValue returns the value of the entry and true if the entry is not
+// deleted. Otherwise, it returns the zero value and false.
+func (e *entry[K, V]) loadValue() (value V, ok bool) {
+	p := atomic.LoadPointer(&e.value)
+	if p == deleted {
+		return value, false
+	}
+	return *(*V)(p), true
+}
+
+func (e *entry[K, V]) storeValue(value V) {
 	atomic.StorePointer(&e.value, unsafe.Pointer(&value))
 }
 
-func (e *entry) loadNext() (next *entry) {
-	return (*entry)(atomic.LoadPointer(&e.next))
+// deleteValue logically removes the entry and returns the previous value and
+// true if the entry was not deleted. Otherwise, it returns the zero value and
+// false.
+func (e *entry[K, V]) deleteValue() (previous V, ok bool) {
+	p := atomic.SwapPointer(&e.value, deleted)
+	if p == deleted {
+		return previous, false
+	}
+	return *(*V)(p), true
 }
 
-func (e *entry) storeNext(next *entry) {
+// swapValue replaces the value of the entry with the given one and returns the
+// previous value and true if the entry was not deleted. Otherwise, it returns
+// the zero value and false.
+func (e *entry[K, V]) swapValue(value V) (previous V, ok bool) {
+	p := atomic.SwapPointer(&e.value, unsafe.Pointer(&value))
+	if p == deleted {
+		return previous, false
+	}
+	return *(*V)(p), true
+}
+
+// compareAndSwapValue replaces the value of the entry with new, which is a
+// pointer to a value or deleted, if the current value is not deleted and is
+// equal to old. It reports whether the value was replaced.
+func (e *entry[K, V]) compareAndSwapValue(old V, new unsafe.Pointer) (swapped bool) {
+	for {
+		p := atomic.LoadPointer(&e.value)
+		if p == deleted || any(*(*V)(p)) != any(old) {
+			return false
+		}
+		if atomic.CompareAndSwapPointer(&e.value, p, new) {
+			return true
+		}
+	}
+}
+
+func (e *entry[K, V]) loadNext() (next *entry[K, V]) {
+	return (*entry[K, V])(atomic.LoadPointer(&e.next))
+}
+
+func (e *entry[K, V]) storeNext(next *entry[K, V]) {
 	atomic.StorePointer(&e.next, unsafe.Pointer(next))
 }
 
 // StatBuckets returns the number of buckets and the number of keys in the
 // largest bucket.
-func (m *Map) StatBuckets() (capacity, largest uint) {
-	return uint(len(m.buckets)), m.largestBucket
+func (m *MapOf[K, V]) StatBuckets() (capacity, largest uint) {
+	return uint(len(m.buckets)), uint(m.largestBucket.Load())
+}
+
+// BucketHistogram returns the distribution of the lengths of the chains of
+// entries in the buckets of the map, in which the i-th element is the number of
+// buckets whose chains have i entries, including logically deleted ones. Its
+// length is one more than the length of the longest chain. The chains are
+// walked without locking the buckets, so the histogram of a map updated
+// concurrently may be off by the entries updated in the meantime.
+func (m *MapOf[K, V]) BucketHistogram() (histogram []uint) {
+	histogram = []uint{0}
+	for _, b := range m.buckets {
+		n := 0
+		for e := b.loadFirst(); e != nil; e = e.loadNext() {
+			n++
+		}
+		for len(histogram) <= n {
+			histogram = append(histogram, 0)
+		}
+		histogram[n]++
+	}
+	return
+}
+
+// Link is an entry of a chain reported by LongestChains.
+type Link[K comparable] struct {
+	Key    K
+	Digest Digest
+	Live   bool // whether the key has a value in the map, rather than being deleted or moved
+}
+
+// LongestChains returns the entries of up to n of the longest chains in the
+// buckets of the map, longest first, each in the order of its chain, so that
+// the keys whose hashes cluster are found. The chains include logically
+// deleted entries, as those of BucketHistogram do, and the chains of the map
+// to which keys are being migrated are not visited. Like BucketHistogram, the
+// chains are walked without locking the buckets.
+func (m *MapOf[K, V]) LongestChains(n int) (chains [][]Link[K]) {
+	if n <= 0 {
+		return nil
+	}
+	lengths := make([]int, len(m.buckets))
+	var longest []int // the indexes of the buckets of the longest chains
+	for i, b := range m.buckets {
+		for e := b.loadFirst(); e != nil; e = e.loadNext() {
+			lengths[i]++
+		}
+		if lengths[i] > 0 {
+			longest = append(longest, i)
+		}
+	}
+	slices.SortStableFunc(longest, func(i, j int) int {
+		return cmp.Compare(lengths[j], lengths[i])
+	})
+	for _, i := range longest[:min(n, len(longest))] {
+		var chain []Link[K]
+		for e := m.buckets[i].loadFirst(); e != nil; e = e.loadNext() {
+			p := atomic.LoadPointer(&e.value)
+			chain = append(chain, Link[K]{e.key, e.digest, p != deleted && p != moved})
+		}
+		if chain != nil {
+			chains = append(chains, chain)
+		}
+	}
+	return
 }
 
 // StatEntries returns the number of keys physically existing in the map and
 // the number of logically deleted keys.
-func (m *Map) StatEntries() (mapSize, deleted uint) {
-	return m.numOfEntries, m.numOfDeleted
+func (m *MapOf[K, V]) StatEntries() (mapSize, deleted uint) {
+	return uint(m.numOfEntries.Load()), uint(m.numOfDeleted.Load())
+}
+
+// SizeBytes returns an estimate of the number of bytes the map occupies on the
+// heap, counting its buckets, its entries, and the values of the keys, as well
+// as the map to which keys are being migrated. The memory referenced by keys
+// and values, such as the contents of strings, is not counted.
+func (m *MapOf[K, V]) SizeBytes() (size uint64) {
+	var b bucket[K, V]
+	var e entry[K, V]
+	var v V
+	entries, deleted := m.StatEntries()
+	size = uint64(unsafe.Sizeof(*m))
+	size += uint64(len(m.buckets)) * uint64(unsafe.Sizeof(&b)+unsafe.Sizeof(b))
+	size += uint64(entries)*uint64(unsafe.Sizeof(e)) + uint64(entries-deleted)*uint64(unsafe.Sizeof(v))
+	if next := m.loadNext(); next != nil {
+		size += next.SizeBytes()
+	}
+	return
 }
 
 // NewMap returns an empty hash map that maintain the given number of buckets.
 // The function hasher is used to hash keys.
-func NewMap(capacity uint, hasher func(key interface{}) uint32) (m *Map) {
-	buckets := make([]*bucket, capacity)
+func NewMap[H Hash](capacity uint, hasher func(key interface{}) H) (m *Map) {
+	return NewMapOf[interface{}, interface{}](capacity, hasher)
+}
+
+// NewMapOf returns an empty hash map that maintain the given number of buckets.
+// The function hasher is used to hash keys. It must not retain the keys given
+// to it, so that keys of read operations need not escape to the heap.
+func NewMapOf[K comparable, V any, H Hash](capacity uint, hasher func(key K) H) (m *MapOf[K, V]) {
+	return NewMapOfFunc[K, V](capacity, hasher, nil)
+}
+
+// NewMapOfFunc is like NewMapOf but keys are compared by the function equal
+// instead of the == operator, unless equal is nil. Keys equal to each other
+// must have the same hash. Neither must equal retain the keys given to it.
+func NewMapOfFunc[K comparable, V any, H Hash](capacity uint, hasher func(key K) H, equal func(a, b K) bool) (m *MapOf[K, V]) {
+	return NewMapOfSeed[K, V](capacity, hasher, equal, 0)
+}
+
+// NewMapOfSeed is like NewMapOfFunc but the given seed is mixed into every hash
+// before a bucket is selected, unless the seed is zero. Keys are thus placed
+// differently in maps with different seeds, which makes it hard to craft keys
+// that fall into the same bucket without knowing the seed.
+func NewMapOfSeed[K comparable, V any, H Hash](capacity uint, hasher func(key K) H, equal func(a, b K) bool, seed uint64) (m *MapOf[K, V]) {
+	buckets := make([]*bucket[K, V], capacity)
 	for i := uint(0); i < capacity; i++ {
-		buckets[i] = &bucket{}
-		sentinel := &entry{key: terminal}
-		buckets[i].storeFirst(sentinel)
+		buckets[i] = &bucket[K, V]{}
+	}
+	return &MapOf[K, V]{hasher: Widen(hasher), equal: equal, seed: seed, buckets: buckets}
+}
+
+// NewMapOf128 is like NewMapOfSeed but keys are hashed to 128 bits. The upper
+// 64 bits select a bucket and the lower 64 bits are compared before keys, so
+// keys are rarely compared in vain even if buckets are long in huge maps.
+func NewMapOf128[K comparable, V any](capacity uint, hasher func(key K) (hi, lo uint64), equal func(a, b K) bool, seed uint64) (m *MapOf[K, V]) {
+	m = NewMapOfSeed[K, V, uint64](capacity, nil, equal, seed)
+	m.hasher128 = hasher
+	return
+}
+
+// mix is the finalizer of MurmurHash3, which makes every bit of the hash
+// depend on every bit of h.
+func mix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// Digest returns the digest of the given key. It can be called concurrently
+// with any operation.
+func (m *MapOf[K, V]) Digest(key K) (d Digest) {
+	if m.hasher128 != nil {
+		d.hi, d.lo = m.hasher128(*noescape(&key))
+	} else {
+		d.hi = m.hasher(*noescape(&key))
+	}
+	if m.seed != 0 {
+		d.hi = mix(d.hi ^ m.seed)
+	}
+	return
+}
+
+// noescape returns p hiding it from escape analysis. The compiler cannot see
+// what the hash function and the equality of a map do with a key, so it would
+// move to the heap every key passed to them, which costs an allocation at
+// every Load for a key boxed into an interface. They must not retain keys, so
+// such keys can stay on the stack of the caller.
+func noescape[T any](p *T) *T {
+	x := uintptr(unsafe.Pointer(p))
+	return *(**T)(unsafe.Pointer(&x))
+}
+
+// bucketOf returns the bucket of the key with the given digest.
+func (m *MapOf[K, V]) bucketOf(d Digest) *bucket[K, V] {
+	return m.buckets[d.hi%uint64(len(m.buckets))]
+}
+
+// loadNext returns the map to which keys are migrated, or nil if the migration
+// has not started.
+func (m *MapOf[K, V]) loadNext() *MapOf[K, V] {
+	return (*MapOf[K, V])(atomic.LoadPointer(&m.next))
+}
+
+// lockBucket locks the bucket of the key with the given digest in the map that
+// holds the key, which is the map itself unless the bucket has been migrated.
+// It returns the map and the bucket. Update operations are applied to them.
+func (m *MapOf[K, V]) lockBucket(d Digest) (owner *MapOf[K, V], b *bucket[K, V]) {
+	for {
+		b = m.bucketOf(d)
+		b.mu.Lock()
+		if !b.isMigrated() {
+			return m, b
+		}
+		b.mu.Unlock()
+		m = m.loadNext()
 	}
-	return &Map{hasher: hasher, buckets: buckets}
 }
 
-// findEntry returns the bucket and the entry with the given key and true if
-// the key exists. Otherwise, it returns the bucket with the given key, the
-// sentinel entry, and false.
-func (m *Map) findEntry(key interface{}) (b *bucket, e *entry, ok bool) {
-	i := m.hasher(key) % uint32(len(m.buckets))
-	b = m.buckets[i]
+// tryLockBucket is like lockBucket but gives up and returns false instead of
+// waiting if the bucket is locked.
+func (m *MapOf[K, V]) tryLockBucket(d Digest) (owner *MapOf[K, V], b *bucket[K, V], ok bool) {
+	for {
+		b = m.bucketOf(d)
+		if !b.mu.TryLock() {
+			return m, b, false
+		}
+		if !b.isMigrated() {
+			return m, b, true
+		}
+		b.mu.Unlock()
+		m = m.loadNext()
+	}
+}
+
+// findEntry returns the entry with the given key in the bucket b and true if
+// the key exists. Otherwise, it returns nil and false. Keys are compared only
+// if their digests are equal.
+func (m *MapOf[K, V]) findEntry(b *bucket[K, V], key K, d Digest) (e *entry[K, V], ok bool) {
 	e = b.loadFirst()
 
-	for e.key != key && e.key != terminal {
-		e = e.loadNext()
+	if m.equal != nil {
+		for e != nil && (e.digest != d || !m.equal(e.key, *noescape(&key))) {
+			e = e.loadNext()
+		}
+		return e, e != nil
 	}
-	if e.key == key {
-		return b, e, true
+	for e != nil && (e.digest != d || e.key != key) {
+		e = e.loadNext()
 	}
-	return b, e, false
+	return e, e != nil
 }
 
 // Load returns the value associated with the given key and true if the key
-// exists. Otherwise, it returns nil and false.
-func (m *Map) Load(key interface{}) (value interface{}, ok bool) {
-	if _, e, ok := m.findEntry(key); ok {
-		if v := e.loadValue(); v != deleted {
-			return v, true
+// exists. Otherwise, it returns the zero value and false.
+func (m *MapOf[K, V]) Load(key K) (value V, ok bool) {
+	return m.LoadHashed(key, m.Digest(key))
+}
+
+// LoadHashed is like Load but takes the digest of the key instead of hashing
+// it. So do the other Hashed variants of the operations.
+func (m *MapOf[K, V]) LoadHashed(key K, d Digest) (value V, ok bool) {
+	e, value, ok := m.loadEntry(key, d)
+	if ok {
+		m.mark(e)
+	}
+	return
+}
+
+// loadEntry is LoadHashed without marking the key as referenced, which also
+// returns the entry of the key.
+func (m *MapOf[K, V]) loadEntry(key K, d Digest) (e *entry[K, V], value V, ok bool) {
+	b := m.bucketOf(d)
+	if b.isMigrated() {
+		return m.loadNext().loadEntry(key, d)
+	}
+	if e, ok := m.findEntry(b, key, d); ok {
+		switch p := atomic.LoadPointer(&e.value); p {
+		case deleted:
+			return nil, value, false
+		case moved:
+			return m.loadNext().loadEntry(key, d)
+		default:
+			return e, *(*V)(p), true
 		}
 	}
-	return nil, false
+	return nil, value, false
 	// The linearization point of Load should be taken as the folowing:
-	// 1. If ok == true, take the point of e.loadValue();
+	// 1. If ok == true, take the point of loading the value of e;
 	// 2. If ok != true, take the point of the invocation of Load.
 }
 
+// insert adds a new entry with the given key, value, and digest to the head of
+// the bucket b, which must be locked. The key must not exist in the bucket.
+func (m *MapOf[K, V]) insert(b *bucket[K, V], key K, value V, d Digest) {
+	m.insertPointer(b, key, unsafe.Pointer(&value), d)
+}
+
+// insertPointer is like insert but takes a pointer to the value. It returns
+// the new entry.
+func (m *MapOf[K, V]) insertPointer(b *bucket[K, V], key K, value unsafe.Pointer, d Digest) (newEntry *entry[K, V]) {
+	m.numOfEntries.Add(1)
+	b.numOfEntries++
+	for n := int64(b.numOfEntries); ; {
+		largest := m.largestBucket.Load()
+		if n <= largest || m.largestBucket.CompareAndSwap(largest, n) {
+			break
+		}
+	}
+	newEntry = &entry[K, V]{digest: d, key: key, value: value}
+	newEntry.storeNext(b.loadFirst())
+	b.storeFirst(newEntry) // linearization point
+	return
+}
+
 // Store sets the given value to the given key.
-func (m *Map) Store(key, value interface{}) {
-	if b, e, ok := m.findEntry(key); ok {
-		if v := e.loadValue(); v == deleted {
-			m.numOfDeleted--
+func (m *MapOf[K, V]) Store(key K, value V) {
+	m.SwapHashed(key, m.Digest(key), value)
+}
+
+// Swap sets the given value to the given key and returns the previous value
+// and true if the key exists. Otherwise, it returns the zero value and false.
+func (m *MapOf[K, V]) Swap(key K, value V) (previous V, loaded bool) {
+	return m.SwapHashed(key, m.Digest(key), value)
+}
+
+// SwapHashed is the Hashed variant of Swap.
+func (m *MapOf[K, V]) SwapHashed(key K, d Digest, value V) (previous V, loaded bool) {
+	if previous, loaded = m.swapExisting(key, d, unsafe.Pointer(&value)); loaded {
+		return
+	}
+
+	m, b := m.lockBucket(d)
+	defer b.mu.Unlock()
+
+	e, ok := m.findEntry(b, key, d)
+	if !ok {
+		m.insert(b, key, value, d)
+		return previous, false
+	}
+	if previous, loaded = e.swapValue(value); !loaded { // linearization point
+		m.numOfDeleted.Add(-1)
+	}
+	return
+}
+
+// swapExisting replaces the value of the given key with the given pointer to a
+// value without locking the bucket, if the key exists and is not deleted. It
+// returns the previous value and true if the value was replaced. Otherwise, it
+// returns the zero value and false, and the caller has to fall back on
+// locking, since only the locking operations can bring a deleted key back or
+// update the statistics of the map.
+func (m *MapOf[K, V]) swapExisting(key K, d Digest, value unsafe.Pointer) (previous V, ok bool) {
+	for {
+		b := m.bucketOf(d)
+		if b.isMigrated() {
+			m = m.loadNext()
+			continue
 		}
-		e.storeValue(value) // linearization point
-	} else {
-		m.numOfEntries++
-		b.numOfEntries++
-		if b.numOfEntries > m.largestBucket {
-			m.largestBucket++
+		e, found := m.findEntry(b, key, d)
+		if !found {
+			return previous, false
+		}
+		p := atomic.LoadPointer(&e.value)
+		switch p {
+		case deleted:
+			return previous, false
+		case moved:
+			m = m.loadNext()
+			continue
+		}
+		if atomic.CompareAndSwapPointer(&e.value, p, value) {
+			m.mark(e)
+			return *(*V)(p), true // linearization point
+		}
+	}
+}
+
+// TryStoreHashed is like SwapHashed but gives up instead of waiting if the
+// bucket of the key is locked, for example by a migration. It reports whether
+// the key existed and whether the value was stored.
+func (m *MapOf[K, V]) TryStoreHashed(key K, d Digest, value V) (loaded, ok bool) {
+	_, loaded, ok = m.TrySwapHashed(key, d, value)
+	return
+}
+
+// TrySwapHashed is like TryStoreHashed but also returns the previous value if
+// the key existed.
+func (m *MapOf[K, V]) TrySwapHashed(key K, d Digest, value V) (previous V, loaded, ok bool) {
+	if previous, loaded = m.swapExisting(key, d, unsafe.Pointer(&value)); loaded {
+		return previous, true, true
+	}
+
+	m, b, ok := m.tryLockBucket(d)
+	if !ok {
+		return previous, false, false
+	}
+	defer b.mu.Unlock()
+
+	e, found := m.findEntry(b, key, d)
+	if !found {
+		m.insert(b, key, value, d)
+		return previous, false, true
+	}
+	if previous, loaded = e.swapValue(value); !loaded { // linearization point
+		m.numOfDeleted.Add(-1)
+	}
+	return previous, loaded, true
+}
+
+// TryDeleteHashed is like LoadAndDeleteHashed but gives up instead of waiting
+// if the bucket of the key is locked. It reports whether the key existed and
+// whether the key is removed.
+func (m *MapOf[K, V]) TryDeleteHashed(key K, d Digest) (loaded, ok bool) {
+	_, loaded, ok = m.TryLoadAndDeleteHashed(key, d)
+	return
+}
+
+// TryLoadAndDeleteHashed is like TryDeleteHashed but also returns the value of
+// the key if the key existed.
+func (m *MapOf[K, V]) TryLoadAndDeleteHashed(key K, d Digest) (value V, loaded, ok bool) {
+	m, b, ok := m.tryLockBucket(d)
+	if !ok {
+		return value, false, false
+	}
+	defer b.mu.Unlock()
+
+	if e, found := m.findEntry(b, key, d); found {
+		if value, loaded = e.deleteValue(); loaded { // linearization point
+			m.numOfDeleted.Add(1)
+		}
+	}
+	return value, loaded, true
+}
+
+// LoadOrStore returns the existing value for the given key and true if the key
+// exists. Otherwise, it stores the given value and returns it and false.
+func (m *MapOf[K, V]) LoadOrStore(key K, value V) (actual V, loaded bool) {
+	return m.LoadOrStoreHashed(key, m.Digest(key), value)
+}
+
+// LoadOrStoreHashed is the Hashed variant of LoadOrStore.
+func (m *MapOf[K, V]) LoadOrStoreHashed(key K, d Digest, value V) (actual V, loaded bool) {
+	m, b := m.lockBucket(d)
+	defer b.mu.Unlock()
+
+	e, ok := m.findEntry(b, key, d)
+	if !ok {
+		m.insert(b, key, value, d)
+		return value, false
+	}
+	if v, ok := e.loadValue(); ok {
+		return v, true // linearization point
+	}
+	m.numOfDeleted.Add(-1)
+	e.storeValue(value) // linearization point
+	return value, false
+}
+
+// CompareAndSwap sets the given new value to the given key if the key exists
+// and its value is equal to old. It reports whether the value was replaced.
+// The old value must be of a comparable type.
+func (m *MapOf[K, V]) CompareAndSwap(key K, old, new V) (swapped bool) {
+	return m.CompareAndSwapHashed(key, m.Digest(key), old, new)
+}
+
+// CompareAndSwapHashed is the Hashed variant of CompareAndSwap. It never locks
+// a bucket, since it does not change the statistics of the map.
+func (m *MapOf[K, V]) CompareAndSwapHashed(key K, d Digest, old, new V) (swapped bool) {
+	for {
+		b := m.bucketOf(d)
+		if b.isMigrated() {
+			m = m.loadNext()
+			continue
+		}
+		e, ok := m.findEntry(b, key, d)
+		if !ok {
+			return false
+		}
+		p := atomic.LoadPointer(&e.value)
+		switch {
+		case p == moved:
+			m = m.loadNext()
+		case p == deleted || any(*(*V)(p)) != any(old):
+			return false
+		case atomic.CompareAndSwapPointer(&e.value, p, unsafe.Pointer(&new)):
+			return true // linearization point
 		}
-		newEntry := &entry{key: key}
-		newEntry.storeValue(value)
-		newEntry.storeNext(b.loadFirst())
-		b.storeFirst(newEntry) // linearization point
 	}
 }
 
 // Delete logically removes the given key and its associated value.
-func (m *Map) Delete(key interface{}) {
-	if _, e, ok := m.findEntry(key); ok {
-		if v := e.loadValue(); v != deleted {
-			m.numOfDeleted++
+func (m *MapOf[K, V]) Delete(key K) {
+	m.LoadAndDeleteHashed(key, m.Digest(key))
+}
+
+// LoadAndDelete logically removes the given key and returns its associated
+// value and true if the key exists. Otherwise, it returns the zero value and
+// false.
+func (m *MapOf[K, V]) LoadAndDelete(key K) (value V, loaded bool) {
+	return m.LoadAndDeleteHashed(key, m.Digest(key))
+}
+
+// LoadAndDeleteHashed is the Hashed variant of LoadAndDelete.
+func (m *MapOf[K, V]) LoadAndDeleteHashed(key K, d Digest) (value V, loaded bool) {
+	m, b := m.lockBucket(d)
+	defer b.mu.Unlock()
+
+	if e, ok := m.findEntry(b, key, d); ok {
+		if value, loaded = e.deleteValue(); loaded { // linearization point
+			m.numOfDeleted.Add(1)
+		}
+	}
+	return
+}
+
+// CompareAndDelete logically removes the given key if the key exists and its
+// value is equal to old. It reports whether the key was removed. The old value
+// must be of a comparable type.
+func (m *MapOf[K, V]) CompareAndDelete(key K, old V) (removed bool) {
+	return m.CompareAndDeleteHashed(key, m.Digest(key), old)
+}
+
+// CompareAndDeleteHashed is the Hashed variant of CompareAndDelete.
+func (m *MapOf[K, V]) CompareAndDeleteHashed(key K, d Digest, old V) (removed bool) {
+	m, b := m.lockBucket(d)
+	defer b.mu.Unlock()
+
+	if e, ok := m.findEntry(b, key, d); ok && e.compareAndSwapValue(old, deleted) {
+		m.numOfDeleted.Add(1) // linearization point is in compareAndSwapValue
+		return true
+	}
+	return false
+}
+
+// Compute atomically replaces the value of the given key with the result of f
+// applied to the current value, which is the zero value if loaded is false. If
+// f returns false for keep, the key is removed instead. It returns the result
+// of f. Since f is called while the bucket of the key is locked, it must not
+// update the map. The function f may be called again if the value is replaced
+// by Swap or CompareAndSwap in the meantime, which do not lock the bucket.
+func (m *MapOf[K, V]) Compute(key K, f func(old V, loaded bool) (new V, keep bool)) (new V, keep bool) {
+	return m.ComputeHashed(key, m.Digest(key), f)
+}
+
+// ComputeHashed is the Hashed variant of Compute.
+func (m *MapOf[K, V]) ComputeHashed(key K, d Digest, f func(old V, loaded bool) (new V, keep bool)) (new V, keep bool) {
+	m, b := m.lockBucket(d)
+	defer b.mu.Unlock()
+
+	e, ok := m.findEntry(b, key, d)
+	if !ok {
+		var zero V
+		if new, keep = f(zero, false); keep {
+			m.insert(b, key, new, d)
+		}
+		return
+	}
+	for {
+		var old V
+		p := atomic.LoadPointer(&e.value)
+		loaded := p != deleted
+		if loaded {
+			old = *(*V)(p)
+		}
+		new, keep = f(old, loaded)
+		q := deleted
+		switch {
+		case keep:
+			q = unsafe.Pointer(&new)
+		case !loaded:
+			return
+		}
+		if atomic.CompareAndSwapPointer(&e.value, p, q) { // linearization point
+			switch {
+			case keep && !loaded:
+				m.numOfDeleted.Add(-1)
+			case !keep:
+				m.numOfDeleted.Add(1)
+			}
+			return
 		}
-		e.storeValue(deleted) // linearization point
 	}
 }
 
 // Range iteratively applies the given function to each key-value pair until
 // the function returns false.
-func (m *Map) Range(f func(key, value interface{}) bool) {
-	for _, b := range m.buckets {
-		for e := b.loadFirst(); e.key != terminal; e = e.loadNext() {
-			v := e.loadValue()
-			if v == deleted {
+//
+// Range visits each key at most once. A key that exists during the whole call
+// is visited exactly once, even if the function itself updates the map. The
+// value passed to the function is the one associated with the key when the key
+// is visited. These guarantees hold even if the map is migrated during Range,
+// in which case the values of migrated keys are looked up in the next map,
+// provided that the migration does not proceed during Range if it has started
+// before Range.
+func (m *MapOf[K, V]) Range(f func(key K, value V) bool) {
+	m.rangeEntries(func(e *entry[K, V], v V) bool {
+		return f(e.key, v)
+	})
+}
+
+// Entry is a key-value pair of a map.
+type Entry[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+// RangeBatch is like Range but appends the key-value pairs to batch and
+// applies f to batch each time it is filled to its capacity, after which the
+// pairs are appended to batch[:0] again. It returns the pairs appended since
+// f was last applied, so that batches can be continued across maps, and
+// whether f returned false. The capacity of batch must be positive.
+func (m *MapOf[K, V]) RangeBatch(batch []Entry[K, V], f func(batch []Entry[K, V]) bool) (rest []Entry[K, V], stopped bool) {
+	stopped = m.rangeEntries(func(e *entry[K, V], v V) bool {
+		if batch = append(batch, Entry[K, V]{e.key, v}); len(batch) == cap(batch) {
+			if !f(batch) {
+				return false
+			}
+			batch = batch[:0]
+		}
+		return true
+	})
+	return batch, stopped
+}
+
+// MarkReferences makes the map mark every existing key that is loaded, stored,
+// or swapped as referenced, which Sweep reports and clears. New keys are not
+// marked until they are used again. It allows clock-like
+// eviction policies to find the keys not used recently at the cost of a write
+// to the entry of a key the first time it is used after a Sweep. It must be
+// called before the map is used. Maps to which the keys are migrated or copied
+// mark references as well.
+func (m *MapOf[K, V]) MarkReferences() {
+	m.marking = true
+}
+
+// Sweep is like RangePart but visits only the keys, passing to the function
+// whether each key has been referenced since it was last visited by Sweep.
+// The reference marks of visited keys are cleared.
+func (m *MapOf[K, V]) Sweep(part, parts int, f func(key K, referenced bool) bool) {
+	m.rangeEntriesPart(part, parts, func(e *entry[K, V], _ V) bool {
+		return f(e.key, atomic.SwapUint32(&m.current(e).referenced, 0) != 0)
+	})
+}
+
+// current returns the entry that holds the key of the entry e of the map,
+// which is the entry in the next map if e has been moved, since the key is
+// marked there once it is moved.
+func (m *MapOf[K, V]) current(e *entry[K, V]) *entry[K, V] {
+	if atomic.LoadPointer(&e.value) != moved {
+		return e
+	}
+	next := m.loadNext()
+	if c, ok := next.findEntry(next.bucketOf(e.digest), e.key, e.digest); ok {
+		return c
+	}
+	return e
+}
+
+// TakeReference reports whether the key of the given digest has been marked
+// as referenced, clearing the mark, and true if the key exists. Otherwise, it
+// returns false and false.
+func (m *MapOf[K, V]) TakeReference(key K, d Digest) (referenced, ok bool) {
+	e, _, ok := m.loadEntry(key, d)
+	if !ok {
+		return false, false
+	}
+	return atomic.SwapUint32(&e.referenced, 0) != 0, true
+}
+
+// RangeHashed is like Range but also passes the digest of each key to the
+// function.
+func (m *MapOf[K, V]) RangeHashed(f func(key K, d Digest, value V) bool) {
+	m.rangeEntries(func(e *entry[K, V], v V) bool {
+		return f(e.key, e.digest, v)
+	})
+}
+
+// RangePart is like Range but visits only the keys in the part-th of the given
+// number of parts into which the buckets of the map are split, where part is
+// in [0, parts). Every key visited by Range falls into exactly one part, so
+// the parts can be visited concurrently.
+func (m *MapOf[K, V]) RangePart(part, parts int, f func(key K, value V) bool) {
+	m.rangeEntriesPart(part, parts, func(e *entry[K, V], v V) bool {
+		return f(e.key, v)
+	})
+}
+
+// rangeEntries applies the given function to each entry with a value in the
+// map and the map to which keys are migrated until the function returns
+// false. It reports whether the function returned false.
+func (m *MapOf[K, V]) rangeEntries(f func(e *entry[K, V], v V) bool) (stopped bool) {
+	return m.rangeEntriesPart(0, 1, f)
+}
+
+// rangeEntriesPart is like rangeEntries but visits only the part-th of the
+// given number of parts of the buckets of each map.
+//
+// Every key in the map is visited through its entry, whose value is looked up
+// in the next map once the entry is moved. If the migration has already
+// started, the keys inserted into the next map after their buckets are
+// migrated are visited in the next map, skipping the keys moved from the map.
+func (m *MapOf[K, V]) rangeEntriesPart(part, parts int, f func(e *entry[K, V], v V) bool) (stopped bool) {
+	next := m.loadNext()
+	if m.rangeBuckets(part, parts, nil, f) {
+		return true
+	}
+	if next != nil {
+		return next.rangeBuckets(part, parts, m, f)
+	}
+	return false
+}
+
+// rangeBuckets applies f to each entry with a value in the part-th of the
+// given number of parts of the buckets of the map, skipping the entries of the
+// keys moved from the previous map prev unless prev is nil.
+func (m *MapOf[K, V]) rangeBuckets(part, parts int, prev *MapOf[K, V], f func(e *entry[K, V], v V) bool) (stopped bool) {
+	from, to := len(m.buckets)*part/parts, len(m.buckets)*(part+1)/parts
+	for _, b := range m.buckets[from:to] {
+		for e := b.loadFirst(); e != nil; e = e.loadNext() {
+			if prev != nil {
+				if old, ok := prev.findEntry(prev.bucketOf(e.digest), e.key, e.digest); ok && atomic.LoadPointer(&old.value) == moved {
+					continue
+				}
+			}
+			var v V
+			switch p := atomic.LoadPointer(&e.value); p {
+			case deleted:
 				continue
+			case moved:
+				var ok bool
+				if _, v, ok = m.loadNext().loadEntry(e.key, e.digest); !ok {
+					continue
+				}
+			default:
+				v = *(*V)(p)
+			}
+			if !f(e, v) {
+				return true
 			}
-			f(e.key, v)
 		}
 	}
+	return false
+}
+
+// Copy returns a new map with the given number of buckets that hashes and
+// compares keys as the map does and contains the same key-value pairs. The
+// digests cached in the entries are reused, so no key is hashed again. Copy is
+// considered to be a read operation of the map.
+func (m *MapOf[K, V]) Copy(capacity uint) *MapOf[K, V] {
+	return m.CopyFunc(capacity, nil)
+}
+
+// CopyFunc is like Copy but sets copyValue(v) instead of v to each key of the
+// copy, unless copyValue is nil.
+func (m *MapOf[K, V]) CopyFunc(capacity uint, copyValue func(value V) V) *MapOf[K, V] {
+	c := m.newMap(capacity)
+	m.rangeEntries(func(e *entry[K, V], v V) bool {
+		if copyValue != nil {
+			v = copyValue(v)
+		}
+		c.insert(c.bucketOf(e.digest), e.key, v, e.digest)
+		return true
+	})
+	return c
+}
+
+// newMap returns an empty map with the given number of buckets that hashes and
+// compares keys as the map does.
+func (m *MapOf[K, V]) newMap(capacity uint) *MapOf[K, V] {
+	c := &MapOf[K, V]{hasher: m.hasher, hasher128: m.hasher128, equal: m.equal, seed: m.seed, marking: m.marking, buckets: make([]*bucket[K, V], capacity)}
+	for i := range c.buckets {
+		c.buckets[i] = &bucket[K, V]{}
+	}
+	return c
+}
+
+// StartMigration starts migrating the keys of the map to a new map with the
+// given number of buckets and returns the new map. The keys are moved bucket by
+// bucket by Migrate, so that no single operation pays for copying the whole
+// map. In the meantime, operations on a key in a migrated bucket are forwarded
+// to the new map, which can thus replace the map once the migration is done.
+// StartMigration must be called only once.
+func (m *MapOf[K, V]) StartMigration(capacity uint) (next *MapOf[K, V]) {
+	next = m.newMap(capacity)
+	atomic.StorePointer(&m.next, unsafe.Pointer(next))
+	return
+}
+
+// Next returns the map returned by StartMigration, or nil if the migration has
+// not started.
+func (m *MapOf[K, V]) Next() *MapOf[K, V] {
+	return m.loadNext()
+}
+
+// Migrate moves the keys in at most the given number of buckets to the map
+// returned by StartMigration. It reports whether all the buckets have been
+// migrated. The digests cached in the entries are reused, so no key is hashed
+// again.
+func (m *MapOf[K, V]) Migrate(buckets uint) (done bool) {
+	next := m.loadNext()
+	for ; buckets > 0 && m.migrated < uint(len(m.buckets)); buckets-- {
+		b := m.buckets[m.migrated]
+		b.mu.Lock()
+		for e := b.loadFirst(); e != nil; e = e.loadNext() {
+			next.migrateEntry(e, next.bucketOf(e.digest))
+		}
+		atomic.StoreUint32(&b.migrated, 1)
+		b.mu.Unlock()
+		m.migrated++
+	}
+	return m.migrated == uint(len(m.buckets))
+}
+
+// migrateEntry moves the entry e of the previous map to the bucket b of the
+// map unless the entry is deleted. The bucket of e must be locked.
+func (m *MapOf[K, V]) migrateEntry(e *entry[K, V], b *bucket[K, V]) {
+	p := atomic.LoadPointer(&e.value)
+	if p == deleted {
+		return
+	}
+	// The key cannot exist in b, because keys in the bucket of e are forwarded
+	// only after the bucket is marked as migrated.
+	b.mu.Lock()
+	newEntry := m.insertPointer(b, e.key, p, e.digest)
+	atomic.StoreUint32(&newEntry.referenced, atomic.LoadUint32(&e.referenced))
+	b.mu.Unlock()
+	// The value may be replaced without the lock until the entry is moved.
+	for !atomic.CompareAndSwapPointer(&e.value, p, moved) {
+		p = atomic.LoadPointer(&e.value)
+		atomic.StorePointer(&newEntry.value, p)
+	}
+}
+
+// MigrateParallel is like Migrate but copies the keys with the given number of
+// goroutines. Each goroutine copies the keys falling into its own share of the
+// buckets of the new map. It is worth the overhead only if many buckets are
+// migrated at once.
+func (m *MapOf[K, V]) MigrateParallel(buckets uint, workers int) (done bool) {
+	if workers <= 1 {
+		return m.Migrate(buckets)
+	}
+	next := m.loadNext()
+	end := m.migrated + buckets
+	if end > uint(len(m.buckets)) {
+		end = uint(len(m.buckets))
+	}
+	src := m.buckets[m.migrated:end]
+	for _, b := range src {
+		b.mu.Lock()
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for _, b := range src {
+				for e := b.loadFirst(); e != nil; e = e.loadNext() {
+					if i := e.digest.hi % uint64(len(next.buckets)); i%uint64(workers) == uint64(w) {
+						next.migrateEntry(e, next.buckets[i])
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for _, b := range src {
+		atomic.StoreUint32(&b.migrated, 1)
+		b.mu.Unlock()
+	}
+	m.migrated = end
+	return m.migrated == uint(len(m.buckets))
 }
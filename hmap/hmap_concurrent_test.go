@@ -0,0 +1,88 @@
+package hmap_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/decillion/go-cmap/hmap"
+)
+
+func TestConcurrentUpdates(t *testing.T) {
+	const workers, keys = 4, 1 << 12
+	m := hmap.NewMapOfSeed[uint64, uint64](capacity, func(key uint64) uint64 { return key }, nil, 0x5eed)
+	next := m.StartMigration(2 * capacity)
+
+	var wg sync.WaitGroup
+	for w := uint64(0); w < workers; w++ {
+		wg.Add(1)
+		go func(w uint64) {
+			defer wg.Done()
+			for k := w; k < keys; k += workers {
+				m.Store(k, k)
+				m.Store(k, k+1) // replaces the value without locking
+				m.CompareAndSwap(k, k+1, k+2)
+				m.Compute(k, func(old uint64, loaded bool) (uint64, bool) {
+					return old + 1, loaded
+				})
+			}
+		}(w)
+	}
+	for !m.Migrate(1) {
+	}
+	wg.Wait()
+
+	for k := uint64(0); k < keys; k++ {
+		if v, ok := next.Load(k); !ok || v != k+3 {
+			t.Fatalf("Load(%v) = %v, %v; want %v, true", k, v, ok, k+3)
+		}
+	}
+	if n, _ := next.StatEntries(); n != keys {
+		t.Errorf("the map has %v keys; want %v", n, keys)
+	}
+}
+
+func TestRangeDuringMigration(t *testing.T) {
+	const stable, churn = 1 << 10, 1 << 12
+	m := hmap.NewMapOfSeed[uint64, uint64](capacity, func(key uint64) uint64 { return key }, nil, 0x5eed)
+	for k := uint64(0); k < stable; k++ {
+		m.Store(k, k)
+	}
+	var wg sync.WaitGroup
+	visited := make(map[uint64]int)
+	m.Range(func(k, v uint64) bool {
+		if len(visited) == 0 { // the migration starts after Range
+			m.StartMigration(4 * capacity)
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				for k := uint64(stable); k < stable+churn; k++ {
+					m.Store(k, k)
+					m.Delete(k - 1)
+					m.Store(k-1, k) // a key deleted and stored again
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				for !m.Migrate(1) {
+				}
+			}()
+		}
+		visited[k]++
+		if k < stable && v != k {
+			t.Errorf("Range visited %v: %v; want %v: %v", k, v, k, k)
+		}
+		return true
+	})
+	wg.Wait()
+
+	for k := uint64(0); k < stable; k++ {
+		if visited[k] != 1 {
+			t.Errorf("Range visited %v %v times; want once", k, visited[k])
+		}
+	}
+	for k, n := range visited {
+		if n != 1 {
+			t.Errorf("Range visited %v %v times; want once", k, n)
+		}
+	}
+}
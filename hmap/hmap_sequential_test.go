@@ -6,7 +6,7 @@ import (
 	"testing"
 	"testing/quick"
 
-	"github.com/OneOfOne/cmap/hashers"
+	"github.com/decillion/go-cmap/hashers"
 	"github.com/decillion/go-cmap/hmap"
 )
 
@@ -17,23 +17,37 @@ const (
 type mapIface interface {
 	Load(key interface{}) (value interface{}, ok bool)
 	Store(key, value interface{})
+	LoadOrStore(key, value interface{}) (actual interface{}, loaded bool)
+	Swap(key, value interface{}) (previous interface{}, loaded bool)
+	CompareAndSwap(key, old, new interface{}) (swapped bool)
 	Delete(key interface{})
+	LoadAndDelete(key interface{}) (value interface{}, loaded bool)
+	CompareAndDelete(key, old interface{}) (removed bool)
 	Range(func(key, value interface{}) bool)
 }
 
 type mapOp string
 
 const (
-	Load   = mapOp("Load")
-	Store  = mapOp("Store")
-	Delete = mapOp("Delete")
+	Load             = mapOp("Load")
+	Store            = mapOp("Store")
+	LoadOrStore      = mapOp("LoadOrStore")
+	Swap             = mapOp("Swap")
+	CompareAndSwap   = mapOp("CompareAndSwap")
+	Delete           = mapOp("Delete")
+	LoadAndDelete    = mapOp("LoadAndDelete")
+	CompareAndDelete = mapOp("CompareAndDelete")
 )
 
-var mapOps = [...]mapOp{Load, Store, Delete}
+var mapOps = [...]mapOp{
+	Load, Store, LoadOrStore, Swap, CompareAndSwap,
+	Delete, LoadAndDelete, CompareAndDelete,
+}
 
 type mapCall struct {
 	op   mapOp
 	k, v interface{}
+	old  interface{}
 }
 
 func (c mapCall) apply(m mapIface) (interface{}, bool) {
@@ -43,9 +57,19 @@ func (c mapCall) apply(m mapIface) (interface{}, bool) {
 	case Store:
 		m.Store(c.k, c.v)
 		return nil, false
+	case LoadOrStore:
+		return m.LoadOrStore(c.k, c.v)
+	case Swap:
+		return m.Swap(c.k, c.v)
+	case CompareAndSwap:
+		return nil, m.CompareAndSwap(c.k, c.old, c.v)
 	case Delete:
 		m.Delete(c.k)
 		return nil, false
+	case LoadAndDelete:
+		return m.LoadAndDelete(c.k)
+	case CompareAndDelete:
+		return nil, m.CompareAndDelete(c.k, c.old)
 	default:
 		panic("invalid mapOp")
 	}
@@ -67,8 +91,12 @@ func randValue(r *rand.Rand) interface{} {
 func (mapCall) Generate(r *rand.Rand, size int) reflect.Value {
 	c := mapCall{op: mapOps[rand.Intn(len(mapOps))], k: randValue(r)}
 	switch c.op {
-	case Store:
+	case Store, LoadOrStore, Swap:
 		c.v = randValue(r)
+	case CompareAndSwap:
+		c.v, c.old = randValue(r), randValue(r)
+	case CompareAndDelete:
+		c.old = randValue(r)
 	}
 	return reflect.ValueOf(c)
 }
@@ -100,6 +128,199 @@ func TestMachesBuiltInMap(t *testing.T) {
 	}
 }
 
+func TestFullWidthHash(t *testing.T) {
+	hasher := func(key uint64) uint64 {
+		return key << 32 // all keys collide in the lower 32 bits
+	}
+	m := hmap.NewMapOf[uint64, uint64](capacity-1, hasher)
+	for i := uint64(0); i < capacity; i++ {
+		m.Store(i, i)
+	}
+	if _, largest := m.StatBuckets(); largest > 2 {
+		t.Errorf("the largest bucket has %v keys; want at most 2", largest)
+	}
+}
+
+func TestBucketHistogram(t *testing.T) {
+	hasher := func(key uint64) uint64 {
+		return key % 4 << 32 // the keys fall into four buckets only
+	}
+	m := hmap.NewMapOf[uint64, uint64](capacity, hasher)
+	for i := uint64(0); i < 4*8; i++ {
+		m.Store(i, i)
+	}
+	m.Delete(0)
+	h := m.BucketHistogram()
+	var buckets, entries uint
+	for i, n := range h {
+		buckets += n
+		entries += uint(i) * n
+	}
+	if total, _ := m.StatBuckets(); buckets != total || h[0] < total-4 {
+		t.Errorf("BucketHistogram() = %v; want %v buckets, all but four of which are empty", h, total)
+	}
+	if entries != 4*8 || h[len(h)-1] == 0 || len(h) < 9 {
+		t.Errorf("BucketHistogram() = %v; want 32 entries, including the deleted one", h)
+	}
+}
+
+func TestLongestChains(t *testing.T) {
+	hasher := func(key uint64) uint64 {
+		return min(key%4, 2) // the keys fall into three buckets of 8, 8, and 16 keys
+	}
+	m := hmap.NewMapOf[uint64, uint64](capacity, hasher)
+	for i := uint64(0); i < 4*8; i++ {
+		m.Store(i, i)
+	}
+	m.Delete(0)
+	chains := m.LongestChains(2)
+	if len(chains) != 2 || len(chains[0]) != 16 || len(chains[1]) != 8 {
+		t.Fatalf("LongestChains(2) = %v; want chains of 16 and 8 keys", chains)
+	}
+	for _, l := range chains[0] {
+		if l.Key%4 < 2 || !l.Live {
+			t.Errorf("the longest chain has %+v", l)
+		}
+	}
+	chains = m.LongestChains(4)
+	if len(chains) != 3 {
+		t.Fatalf("LongestChains(4) = %v; want three chains", chains)
+	}
+	for _, chain := range chains[1:] {
+		for _, l := range chain {
+			if l.Key%4 != chain[0].Key%4 || l.Live != (l.Key != 0) {
+				t.Errorf("a chain of 8 keys has %+v", l)
+			}
+		}
+	}
+}
+
+func TestSeed(t *testing.T) {
+	hasher := func(key uint64) uint64 {
+		return key * capacity // all keys fall into the first bucket
+	}
+	m := hmap.NewMapOfSeed[uint64, uint64](capacity, hasher, nil, 0x5eed)
+	for i := uint64(0); i < capacity; i++ {
+		m.Store(i, i)
+	}
+	if _, largest := m.StatBuckets(); largest > capacity/16 {
+		t.Errorf("the largest bucket has %v keys; want at most %v", largest, capacity/16)
+	}
+}
+
+func TestCopyDoesNotRehash(t *testing.T) {
+	calls := 0
+	hasher := func(key uint64) uint64 {
+		calls++
+		return key
+	}
+	m := hmap.NewMapOfSeed[uint64, uint64](capacity, hasher, nil, 0x5eed)
+	for i := uint64(0); i < capacity; i++ {
+		m.Store(i, i)
+	}
+	m.Delete(0)
+	calls = 0
+	c := m.Copy(2 * capacity)
+	if calls != 0 {
+		t.Errorf("Copy called the hash function %v times", calls)
+	}
+	if n, _ := c.StatEntries(); n != capacity-1 {
+		t.Errorf("the copy has %v keys; want %v", n, capacity-1)
+	}
+	for i := uint64(1); i < capacity; i++ {
+		if v, ok := c.Load(i); !ok || v != i {
+			t.Fatalf("Load(%v) = %v, %v on the copy; want %v, true", i, v, ok, i)
+		}
+	}
+	if _, ok := c.Load(0); ok {
+		t.Error("the copy contains a deleted key")
+	}
+}
+
+func TestSizeBytes(t *testing.T) {
+	m := hmap.NewMapOf[uint64, [4]uint64](capacity, hashers.Uint64)
+	empty := m.SizeBytes()
+	for i := uint64(0); i < capacity; i++ {
+		m.Store(i, [4]uint64{i})
+	}
+	full := m.SizeBytes()
+	if full < empty+capacity*(8+32) {
+		t.Errorf("SizeBytes() = %v with %v keys and %v without keys", full, capacity, empty)
+	}
+	m.Delete(0)
+	if size := m.SizeBytes(); size != full-32 {
+		t.Errorf("SizeBytes() = %v after Delete; want %v", size, full-32)
+	}
+}
+
+func TestRangePart(t *testing.T) {
+	m := hmap.NewMapOf[uint64, uint64](capacity, hashers.Uint64)
+	for i := uint64(0); i < capacity; i++ {
+		m.Store(i, i)
+	}
+	m.StartMigration(3 * capacity)
+	m.Migrate(capacity / 2)
+	const parts = 7
+	visited := make(map[uint64]int)
+	for part := 0; part < parts; part++ {
+		m.RangePart(part, parts, func(k, v uint64) bool {
+			visited[k]++
+			return k == v
+		})
+	}
+	if len(visited) != capacity {
+		t.Errorf("the parts have %v keys; want %v", len(visited), capacity)
+	}
+	for k, c := range visited {
+		if c != 1 {
+			t.Errorf("%v is visited %v times", k, c)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	testMigrate(t, func(m *hmap.MapOf[uint64, uint64]) bool { return m.Migrate(1) })
+}
+
+func TestMigrateParallel(t *testing.T) {
+	testMigrate(t, func(m *hmap.MapOf[uint64, uint64]) bool { return m.MigrateParallel(5, 3) })
+}
+
+func testMigrate(t *testing.T, migrate func(m *hmap.MapOf[uint64, uint64]) (done bool)) {
+	m := hmap.NewMapOfSeed[uint64, uint64](capacity, func(key uint64) uint64 { return key }, nil, 0x5eed)
+	want := make(map[uint64]uint64)
+	for i := uint64(0); i < capacity; i++ {
+		m.Store(i, i)
+		want[i] = i
+	}
+	next := m.StartMigration(2 * capacity)
+	for i := uint64(0); !migrate(m); i++ {
+		// Update keys both in migrated buckets and in the others.
+		m.Store(i, i+1)
+		want[i] = i + 1
+		m.Delete(capacity - 1 - i)
+		delete(want, capacity-1-i)
+		m.Store(capacity+i, i)
+		want[capacity+i] = i
+	}
+	if m.Next() != next {
+		t.Fatal("Next does not return the map StartMigration returned")
+	}
+	got := make(map[uint64]uint64)
+	next.Range(func(k, v uint64) bool {
+		got[k] = v
+		return true
+	})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("the migrated map has %v; want %v", got, want)
+	}
+	for k, v := range want {
+		if w, ok := m.Load(k); !ok || w != v {
+			t.Fatalf("Load(%v) = %v, %v on the old map; want %v, true", k, w, ok, v)
+		}
+	}
+}
+
 type BuiltIn struct {
 	b map[interface{}]interface{}
 }
@@ -119,10 +340,47 @@ func (m *BuiltIn) Store(key, value interface{}) {
 	m.b[key] = value
 }
 
+func (m *BuiltIn) LoadOrStore(key, value interface{}) (actual interface{}, loaded bool) {
+	if actual, loaded = m.b[key]; loaded {
+		return
+	}
+	m.b[key] = value
+	return value, false
+}
+
+func (m *BuiltIn) Swap(key, value interface{}) (previous interface{}, loaded bool) {
+	previous, loaded = m.b[key]
+	m.b[key] = value
+	return
+}
+
+func (m *BuiltIn) CompareAndSwap(key, old, new interface{}) (swapped bool) {
+	if v, ok := m.b[key]; ok && v == old {
+		m.b[key] = new
+		return true
+	}
+	return false
+}
+
 func (m *BuiltIn) Delete(key interface{}) {
 	delete(m.b, key)
 }
 
+func (m *BuiltIn) LoadAndDelete(key interface{}) (value interface{}, loaded bool) {
+	if value, loaded = m.b[key]; loaded {
+		delete(m.b, key)
+	}
+	return
+}
+
+func (m *BuiltIn) CompareAndDelete(key, old interface{}) (removed bool) {
+	if v, ok := m.b[key]; ok && v == old {
+		delete(m.b, key)
+		return true
+	}
+	return false
+}
+
 func (m *BuiltIn) Range(f func(key, value interface{}) bool) {
 	for k, v := range m.b {
 		if !f(k, v) {
@@ -130,3 +388,25 @@ func (m *BuiltIn) Range(f func(key, value interface{}) bool) {
 		}
 	}
 }
+
+func TestSweep(t *testing.T) {
+	m := hmap.NewMapOf[uint64, uint64](capacity, hashers.Uint64)
+	m.MarkReferences()
+	for i := uint64(0); i < capacity; i++ {
+		m.Store(i, i)
+	}
+	m.StartMigration(3 * capacity)
+	m.Migrate(capacity / 2)
+	for i := uint64(0); i < capacity; i += 2 {
+		m.Load(i)
+	}
+	m.Migrate(capacity / 4)
+	for round := 0; round < 2; round++ {
+		m.Sweep(0, 1, func(k uint64, referenced bool) bool {
+			if want := round == 0 && k%2 == 0; referenced != want {
+				t.Errorf("Sweep in round %v passed %v, %v; want %v", round, k, referenced, want)
+			}
+			return true
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package cmap
+
+import "time"
+
+// Op is an operation of a map observed by a Hook.
+type Op uint8
+
+// The operations observed by a Hook.
+const (
+	OpLoad   Op = iota // Load
+	OpStore            // Store
+	OpDelete           // Delete
+	OpResize           // a resize of the table of a shard
+)
+
+var opNames = [...]string{OpLoad: "load", OpStore: "store", OpDelete: "delete", OpResize: "resize"}
+
+// String returns the name of the operation in lower case, such as "load".
+func (op Op) String() string {
+	if int(op) < len(opNames) {
+		return opNames[op]
+	}
+	return "unknown"
+}
+
+// Hook observes the operations of a map for instrumentation, such as the Hook
+// of package cmapotel. Before is called when an operation starts and
+// After when it ends, with whether the operation found its key, which is
+// always true for Store, and how long it took. A resize ends with found true
+// once it completes, or false if it is abandoned by Clear. A resize is
+// reported when it starts and when the new table replaces the old one, which
+// may be done by a later update or in the background, so the calls for
+// resizes may come from other goroutines than the one that started them and
+// may interleave with those of other shards.
+//
+// The methods are called on the paths of the operations, while the shard of
+// a resize is locked, so they must be safe for concurrent use, must not call
+// the methods of the map, and should return quickly.
+type Hook interface {
+	Before(op Op)
+	After(op Op, found bool, elapsed time.Duration)
+}
+
+// WithHook sets the hook that observes Load, Store, Delete, and the resizes of
+// a map. A map without a hook pays only a check of it per operation, while a
+// map with one also reads the clock twice. The other methods, such as Swap
+// and LoadAndDelete, are not observed.
+func WithHook(h Hook) Option {
+	return func(o *options) { o.hook = h }
+}
@@ -0,0 +1,109 @@
+package cmap
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/decillion/go-cmap/hmap"
+)
+
+// WithHotKeys makes the map track how often its keys are used, so that TopKeys
+// reports the handful of keys that take most of the operations. One in every
+// given number of operations on a key is sampled, at the cost of a lock taken
+// by the sampled operation, and the counts of up to the given number of keys
+// are kept by the Space-Saving algorithm: a sampled key not tracked yet
+// replaces the tracked key of the least count, inheriting its count. The
+// counts of the keys used most are thus accurate, while those of the others
+// are overestimated. Operations are sampled randomly, and all of them are in
+// the deterministic mode. Only the hashes of the keys are kept, so that the
+// keys used do not have to be copied to the heap, which means that the keys
+// TopKeys reports are only those in the map; a hot key that is absent, such
+// as one loaded in vain over and over, is tracked but not reported. It panics
+// if tracked or every is not positive.
+func WithHotKeys(tracked, every int) Option {
+	if tracked <= 0 || every <= 0 {
+		panic(fmt.Sprintf("cmap: WithHotKeys(%v, %v) out of range", tracked, every))
+	}
+	return func(o *options) { o.hotKeys, o.hotEvery = tracked, every }
+}
+
+// HotKey is a key reported by TopKeys with the estimated number of times it
+// has been used.
+type HotKey[K comparable] struct {
+	Key   K
+	Count int64
+}
+
+// hotKeys tracks the keys used most by sampling the operations of a map.
+type hotKeys struct {
+	every   int
+	tracked int
+	mu      sync.Mutex
+	counts  map[hmap.Digest]int64 // the counts of the sampled uses of the digests of the tracked keys
+}
+
+// newHotKeys returns the tracker of the hot keys of a map according to the
+// options o, or nil unless the map is created with WithHotKeys.
+func newHotKeys(o *options) *hotKeys {
+	if o.hotKeys == 0 {
+		return nil
+	}
+	return &hotKeys{every: o.hotEvery, tracked: o.hotKeys, counts: make(map[hmap.Digest]int64, o.hotKeys)}
+}
+
+// use records a use of the key of the digest d if it is sampled.
+func (h *hotKeys) use(d hmap.Digest) {
+	if h.every > 1 && randomOffset(h.every) != 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.counts[d]; ok || len(h.counts) < h.tracked {
+		h.counts[d]++
+		return
+	}
+	var least hmap.Digest
+	fewest := int64(-1)
+	for k, n := range h.counts {
+		if fewest < 0 || n < fewest {
+			least, fewest = k, n
+		}
+	}
+	delete(h.counts, least)
+	h.counts[d] = fewest + 1
+}
+
+// TopKeys returns up to n of the keys used most, in the descending order of
+// their estimated counts of uses, which are the counts of sampled uses
+// multiplied by the rate of sampling. The keys are found by visiting the
+// shards that hold them, so TopKeys is meant for occasional inspection rather
+// than for the paths of requests. It returns nil unless the map is created
+// with WithHotKeys, which also bounds the number of keys reported.
+func (m *MapOf[K, V]) TopKeys(n int) (top []HotKey[K]) {
+	h := m.hot
+	if h == nil || n <= 0 {
+		return nil
+	}
+	h.mu.Lock()
+	counts := maps.Clone(h.counts)
+	h.mu.Unlock()
+	shards := make(map[*shard[K, V]]bool)
+	for d := range counts {
+		shards[&m.shards[d.Uint64()>>m.shift]] = true
+	}
+	for s := range shards {
+		s.table().RangeHashed(func(k K, d hmap.Digest, _ V) bool {
+			if c, ok := counts[d]; ok {
+				top = append(top, HotKey[K]{k, c * int64(h.every)})
+			}
+			return true
+		})
+	}
+	slices.SortFunc(top, func(a, b HotKey[K]) int {
+		return cmp.Compare(b.Count, a.Count)
+	})
+	return top[:min(n, len(top))]
+}
@@ -0,0 +1,46 @@
+package cmap
+
+import "iter"
+
+// Iterator is a cursor over the key-value pairs in a map, which gives the same
+// guarantees as Range. The iteration runs on a goroutine of its own until Next
+// returns false or Stop is called, so an iterator abandoned before the end
+// must be stopped.
+type Iterator[K comparable, V any] struct {
+	next  func() (K, V, bool)
+	stop  func()
+	key   K
+	value V
+}
+
+// Iter returns an iterator positioned before the first key-value pair of the
+// map.
+func (m *MapOf[K, V]) Iter() *Iterator[K, V] {
+	next, stop := iter.Pull2(m.All())
+	return &Iterator[K, V]{next: next, stop: stop}
+}
+
+// Next advances the iterator to the next key-value pair and reports whether
+// there is one. Once it returns false, the iterator is stopped.
+func (it *Iterator[K, V]) Next() (ok bool) {
+	if it.key, it.value, ok = it.next(); !ok {
+		it.stop()
+	}
+	return
+}
+
+// Key returns the key of the current pair.
+func (it *Iterator[K, V]) Key() K {
+	return it.key
+}
+
+// Value returns the value of the current pair.
+func (it *Iterator[K, V]) Value() V {
+	return it.value
+}
+
+// Stop ends the iteration, after which Next returns false. It may be called
+// more than once.
+func (it *Iterator[K, V]) Stop() {
+	it.stop()
+}
@@ -0,0 +1,119 @@
+package cmap
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// MarshalJSON encodes the map as a JSON object of the key-value pairs it
+// contains at a single point in time, as RangeSnapshot gives them. The keys are
+// encoded as encoding/json encodes the keys of built-in maps: strings are used
+// directly, and encoding.TextMarshalers and then integers are converted to
+// strings. The dynamic types of the keys of a Map must be one of them, and an
+// error is returned if two keys of a Map are encoded alike, as 1 and "1" are.
+// The object is sorted by key.
+func (m *MapOf[K, V]) MarshalJSON() ([]byte, error) {
+	object := make(map[string]V, m.Len())
+	var err error
+	m.RangeSnapshot(func(k K, v V) bool {
+		var s string
+		if s, err = marshalKey(k); err != nil {
+			return false
+		}
+		if _, ok := object[s]; ok {
+			err = fmt.Errorf("cmap: two keys are marshaled to the same JSON key %q", s)
+			return false
+		}
+		object[s] = v
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(object)
+}
+
+// marshalKey returns the key of a JSON object encoding the given key, trying
+// the kinds of keys in the order in which encoding/json does.
+func marshalKey(key any) (string, error) {
+	v := reflect.ValueOf(key)
+	if v.Kind() == reflect.String {
+		return v.String(), nil
+	}
+	if t, ok := key.(encoding.TextMarshaler); ok {
+		if v.Kind() == reflect.Pointer && v.IsNil() {
+			return "", nil
+		}
+		b, err := t.MarshalText()
+		return string(b), err
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	}
+	return "", fmt.Errorf("cmap: cannot marshal a key of type %T to JSON", key)
+}
+
+// UnmarshalJSON stores the key-value pairs of the given JSON object into the
+// map, keeping the keys already in the map, as encoding/json decodes objects
+// into built-in maps. The keys are decoded as MarshalJSON encodes them, where
+// the keys of a Map are decoded to strings, and nothing is stored unless all
+// of them are decoded. The map must have been created by one of the
+// constructors, which give the hash function of the keys.
+func (m *MapOf[K, V]) UnmarshalJSON(data []byte) error {
+	if m.shards == nil {
+		return errors.New("cmap: UnmarshalJSON into a map not created by a constructor")
+	}
+	var object map[string]V
+	if err := json.Unmarshal(data, &object); err != nil {
+		return err
+	}
+	keys := make([]K, 0, len(object))
+	values := make([]V, 0, len(object))
+	for s, v := range object {
+		k, err := unmarshalKey[K](s)
+		if err != nil {
+			return err
+		}
+		keys, values = append(keys, k), append(values, v)
+	}
+	for i, k := range keys {
+		m.Store(k, values[i])
+	}
+	return nil
+}
+
+// unmarshalKey returns the key encoded as the given key of a JSON object,
+// trying the kinds of keys in the order in which encoding/json does.
+func unmarshalKey[K comparable](s string) (key K, err error) {
+	v := reflect.ValueOf(&key).Elem()
+	if t, ok := any(&key).(encoding.TextUnmarshaler); ok {
+		err = t.UnmarshalText([]byte(s))
+		return
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+		return
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		v.SetInt(n)
+		return key, err
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		v.SetUint(n)
+		return key, err
+	case reflect.Interface:
+		if v.NumMethod() == 0 {
+			v.Set(reflect.ValueOf(s))
+			return
+		}
+	}
+	return key, fmt.Errorf("cmap: cannot unmarshal a JSON key into a key of type %v", v.Type())
+}
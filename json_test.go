@@ -0,0 +1,107 @@
+package cmap_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"testing/quick"
+
+	"github.com/decillion/go-cmap"
+)
+
+func TestJSONMatchesBuiltInMap(t *testing.T) {
+	f := func(builtin map[string]int) bool {
+		m := cmap.NewMapOf[string, int](cmap.StringHasher)
+		for k, v := range builtin {
+			m.Store(k, v)
+		}
+		data, err := json.Marshal(m)
+		if want, _ := json.Marshal(builtin); err != nil || string(data) != string(want) {
+			return false
+		}
+		decoded := cmap.NewMapOf[string, int](cmap.StringHasher)
+		if err := json.Unmarshal(data, decoded); err != nil || decoded.Len() != len(builtin) {
+			return false
+		}
+		for k, v := range builtin {
+			if w, ok := decoded.Load(k); !ok || w != v {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestJSONKeys(t *testing.T) {
+	m := cmap.NewMapOf[int16, string](func(key int16) uint64 { return uint64(key) })
+	m.Store(-1, "a")
+	m.Store(2, "b")
+	data, err := json.Marshal(m)
+	if want := `{"-1":"a","2":"b"}`; err != nil || string(data) != want {
+		t.Errorf("Marshal = %s, %v; want %s, nil", data, err, want)
+	}
+	if err := json.Unmarshal([]byte(`{"3":"c","70000":"d"}`), m); err == nil {
+		t.Error("Unmarshal accepts a key out of the range of int16")
+	}
+	if v, ok := m.Load(3); ok {
+		t.Errorf("Load(3) = %v, %v after Unmarshal fails; want false", v, ok)
+	}
+	if err := json.Unmarshal([]byte(`{"3":"c"}`), m); err != nil || m.Len() != 3 {
+		t.Errorf("Unmarshal = %v and Len() = %v; want nil and 3", err, m.Len())
+	}
+
+	a := cmap.NewMap(cmap.SeededHasher)
+	a.Store("x", 1)
+	a.Store(struct{}{}, 2)
+	if _, err := json.Marshal(a); err == nil {
+		t.Error("Marshal accepts a key of a struct type")
+	}
+	a.Delete(struct{}{})
+	if err := json.Unmarshal([]byte(`{"y":[true]}`), a); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := json.Marshal(a); string(data) != `{"x":1,"y":[true]}` || err != nil {
+		t.Errorf("Marshal = %s, %v for a Map", data, err)
+	}
+
+	a.Store(1, 2)
+	a.Store("1", 3)
+	if _, err := json.Marshal(a); err == nil {
+		t.Error(`Marshal accepts the keys 1 and "1", which are encoded alike`)
+	}
+
+	// An integer that is a TextMarshaler is encoded as such, as in a built-in
+	// map.
+	hex := cmap.NewMapOf[hexKey, int](func(key hexKey) uint64 { return uint64(key) })
+	hex.Store(255, 1)
+	data, err = json.Marshal(hex)
+	if want, _ := json.Marshal(map[hexKey]int{255: 1}); err != nil || string(data) != string(want) {
+		t.Errorf("Marshal = %s, %v; want %s, nil", data, err, want)
+	}
+	if err := json.Unmarshal([]byte(`{"0x10":2}`), hex); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := hex.Load(16); !ok || v != 2 {
+		t.Errorf("Load(16) = %v, %v after Unmarshal; want 2, true", v, ok)
+	}
+
+	var zero cmap.MapOf[string, int]
+	if err := json.Unmarshal([]byte(`{}`), &zero); err == nil {
+		t.Error("Unmarshal into the zero map does not fail")
+	}
+}
+
+// hexKey is an integer key encoded in hexadecimal as a TextMarshaler.
+type hexKey int
+
+func (k hexKey) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%#x", int(k))), nil
+}
+
+func (k *hexKey) UnmarshalText(text []byte) error {
+	_, err := fmt.Sscanf(string(text), "0x%x", (*int)(k))
+	return err
+}
@@ -0,0 +1,305 @@
+package cmap
+
+import (
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sync/atomic"
+
+	"github.com/decillion/go-cmap/hmap"
+)
+
+// Policy decides what happens when a new key is stored in a map that holds as
+// many keys as its limit.
+type Policy int
+
+const (
+	// Reject leaves the map as it is, so the new key is not stored.
+	Reject Policy = iota
+	// Evict removes an arbitrary key from the map to make room for the new one.
+	Evict
+	// EvictLRU removes a key that has not been used recently to make room for
+	// the new one, approximating the least recently used key by the CLOCK
+	// algorithm: an existing key is marked when it is loaded or stored, and
+	// the hands sweeping the buckets of the shards evict the first key that
+	// is not marked, clearing the marks they pass. A new key is not marked, so
+	// keys that are never used again are evicted first. Loads stay lock-free,
+	// and write to a key only the first time it is loaded after a hand has
+	// passed it.
+	EvictLRU
+	// EvictTinyLFU is EvictLRU with the W-TinyLFU admission filter in front,
+	// which keeps keys used only once from evicting keys used often. The
+	// number of times each key is used is estimated by a compact frequency
+	// sketch, and a new key first enters a window of about one percent of the
+	// limit. The key pushed out of the window replaces the key chosen by
+	// EvictLRU only if it has been used more often; otherwise it is evicted
+	// itself. Every Load writes to the sketch, which costs Load a few atomic
+	// instructions.
+	EvictTinyLFU
+	// EvictARC removes keys according to CAR, the variant of the Adaptive
+	// Replacement Cache built on clocks. The keys are split into those used
+	// once and those used more than once since they were inserted, and the
+	// share of each part adapts to the workload by remembering as many keys
+	// evicted recently as the limit, so that the policy keeps up with both
+	// recency-heavy and frequency-heavy phases. Loads only mark keys, as in
+	// EvictLRU, while insertions and deletions take a lock of the map.
+	EvictARC
+)
+
+// WithLimit limits the number of keys in a map to the given number, which is
+// never exceeded even by concurrent updates. A new key stored in a full map is
+// handled according to the policy, while existing keys can always be updated.
+// StoreIfRoom reports whether a key is stored. It panics if keys is not
+// positive.
+func WithLimit(keys int, policy Policy) Option {
+	if keys <= 0 {
+		panic(fmt.Sprintf("cmap: WithLimit(%v) out of range", keys))
+	}
+	return func(o *options) { o.maxKeys, o.policy = int64(keys), policy }
+}
+
+// WithCostLimit limits the total cost of the values in a map to the given
+// budget, where the cost of each value is given by the function cost, such as
+// the size of the value in bytes. A value that does not fit in the budget is
+// handled according to the policy as a new key in a full map is by WithLimit,
+// where as many keys as needed are evicted, and is not stored if it is
+// rejected, even if its key exists. V must be the type of the values of the
+// map, or else the constructor of the map panics. The cost of a value must
+// not change while the value is in the map. It panics if budget is not
+// positive, or if the policy is EvictTinyLFU or EvictARC, whose state is sized
+// by the number of keys.
+func WithCostLimit[V any](budget int64, cost func(value V) int64, policy Policy) Option {
+	if budget <= 0 {
+		panic(fmt.Sprintf("cmap: WithCostLimit(%v) out of range", budget))
+	}
+	if policy == EvictTinyLFU || policy == EvictARC {
+		panic("cmap: WithCostLimit with a policy sized by the number of keys")
+	}
+	return func(o *options) { o.maxKeys, o.policy, o.cost = budget, policy, cost }
+}
+
+// limiter counts the keys of a map with a limit, or their cost if the map has
+// a cost function. The count includes the keys being inserted, for which room
+// is reserved before they are inserted, so it is never less than the number
+// or cost of the keys in the map.
+type limiter struct {
+	max    int64
+	policy Policy
+	count  atomic.Int64
+}
+
+// newLimiter returns a limiter holding the given count according to the
+// options o, or nil if the options set no limit.
+func newLimiter(o *options, count int64) *limiter {
+	if o.maxKeys == 0 {
+		return nil
+	}
+	l := &limiter{max: o.maxKeys, policy: o.policy}
+	l.count.Store(count)
+	return l
+}
+
+// WithOnEvict sets the function called with every key and value evicted from
+// a map with a limit to make room for another one, such as to release the
+// resources held by the value. The function is called once the key is gone,
+// while no lock of the map is held, so it may call the methods of the map,
+// but it delays the store that evicted the key. K and V must be the types of
+// the keys and values of the map, or else the constructor of the map panics.
+func WithOnEvict[K comparable, V any](f func(key K, value V)) Option {
+	return func(o *options) { o.onEvict = f }
+}
+
+// reserve reserves the room of the given count and reports whether there is
+// room.
+func (l *limiter) reserve(n int64) bool {
+	for {
+		c := l.count.Load()
+		if c+n > l.max {
+			return false
+		}
+		if l.count.CompareAndSwap(c, c+n) {
+			return true
+		}
+	}
+}
+
+// release gives back the room of the given count.
+func (l *limiter) release(n int64) {
+	l.count.Add(-n)
+}
+
+// initLimit sets up the limit and the eviction policy of the map according to
+// its options, along with the tracking of its hot keys and the ages of its
+// values.
+func (m *MapOf[K, V]) initLimit() {
+	m.limit, m.filter, m.arc = newLimiter(&m.opts, 0), newTinyLFU[K](&m.opts), newARC[K](&m.opts)
+	m.hot, m.ages = newHotKeys(&m.opts), newAccessAges(&m.opts)
+	if m.opts.cost != nil {
+		cost, ok := m.opts.cost.(func(value V) int64)
+		if !ok {
+			panic(fmt.Sprintf("cmap: the cost function of WithCostLimit is %T, not %v", m.opts.cost, reflect.TypeFor[func(value V) int64]()))
+		}
+		m.cost = cost
+	}
+	if m.opts.onEvict != nil {
+		onEvict, ok := m.opts.onEvict.(func(key K, value V))
+		if !ok {
+			panic(fmt.Sprintf("cmap: the function of WithOnEvict is %T, not %v", m.opts.onEvict, reflect.TypeFor[func(key K, value V)]()))
+		}
+		m.onEvict = onEvict
+	}
+}
+
+// costOf returns the amount a value takes of the limit of the map, which is
+// one unless the map has a cost function.
+func (m *MapOf[K, V]) costOf(value V) int64 {
+	if m.cost == nil {
+		return 1
+	}
+	return m.cost(value)
+}
+
+// charge reserves the room for replacing the old value of a key, which is
+// absent if loaded is false, with the new value, which is removed if keep is
+// false. It returns the room reserved and the room freed by the replacement,
+// which the caller gives back once the replacement takes effect, and reports
+// whether there is room. Nothing needs to be reserved but for a new key unless
+// the map has a cost function.
+func (m *MapOf[K, V]) charge(old V, loaded bool, new V, keep bool) (reserved, freed int64, ok bool) {
+	var n int64
+	switch {
+	case keep && !loaded:
+		n = m.costOf(new)
+	case keep && m.cost != nil:
+		n = m.cost(new) - m.cost(old)
+	}
+	if n > 0 {
+		if !m.limit.reserve(n) {
+			return 0, 0, false
+		}
+		return n, 0, true
+	}
+	return 0, -n, true
+}
+
+// added records that the given key is added to the shard s. The room of the
+// key must have been reserved.
+func (m *MapOf[K, V]) added(s *shard[K, V], key K) {
+	s.size.Add(1)
+	if m.arc != nil {
+		m.arc.admit(key)
+	}
+}
+
+// removed records that the given key and its value are removed from the
+// shard s.
+func (m *MapOf[K, V]) removed(s *shard[K, V], key K, value V) {
+	s.size.Add(-1)
+	m.count(&s.counters.deletes)
+	if m.limit != nil {
+		m.limit.release(m.costOf(value))
+	}
+	if m.arc != nil {
+		m.arc.forget(key)
+	}
+}
+
+// evict removes a key from a full map according to the policy to make room for
+// the new key of the digest d, and reports whether a key is removed. The key is
+// looked for first in the shard of the new key, so that the keys stay evenly
+// spread over the shards.
+func (m *MapOf[K, V]) evict(key K, d hmap.Digest) (evicted bool) {
+	first := int(d.Uint64() >> m.shift)
+	switch m.limit.policy {
+	case Evict:
+		for i := range m.shards {
+			m.shards[(first+i)%len(m.shards)].table().Range(func(k K, _ V) bool {
+				evicted = m.evictKey(k)
+				return !evicted
+			})
+			if evicted {
+				return true
+			}
+		}
+	case EvictLRU:
+		m.sweepVictims(d, func(victim K) bool {
+			evicted = m.evictKey(victim)
+			return evicted
+		})
+	case EvictTinyLFU:
+		evicted = m.evictTinyLFU(key, d)
+	case EvictARC:
+		evicted = m.evictARC()
+	}
+	return
+}
+
+// evictKey removes the given key chosen to be evicted and reports whether the
+// key is removed, which is false if the key is already gone. The function of
+// WithOnEvict is called on the key removed.
+func (m *MapOf[K, V]) evictKey(key K) (evicted bool) {
+	value, evicted := m.LoadAndDelete(key)
+	if !evicted {
+		return false
+	}
+	if m.opts.counters {
+		s, _ := m.locate(key)
+		s.counters.evictions.Add(1)
+	}
+	if m.onEvict != nil {
+		m.onEvict(key, value)
+	}
+	if m.opts.logEnabled(slog.LevelDebug) {
+		m.opts.log(slog.LevelDebug, "cmap: evicted a key", slog.Any("key", key))
+	}
+	return true
+}
+
+// sweepVictims applies f to the keys that are not marked as referenced, which
+// are found by the hands of the shards starting at the shard of the digest d,
+// until f returns true. The hands go round the shards at most twice, since all
+// the marks are cleared in the first round.
+func (m *MapOf[K, V]) sweepVictims(d hmap.Digest, f func(victim K) (done bool)) {
+	first := int(d.Uint64() >> m.shift)
+	for i := 0; i < 2*len(m.shards); i++ {
+		if m.sweepShard(&m.shards[(first+i)%len(m.shards)], f) {
+			return
+		}
+	}
+}
+
+// sweepShard is sweepVictims on the buckets of the shard after its hand, going
+// round the buckets once. It reports whether f returned true.
+func (m *MapOf[K, V]) sweepShard(s *shard[K, V], f func(victim K) (done bool)) (done bool) {
+	h := s.table()
+	buckets, _ := h.StatBuckets()
+	for i := uint(0); i < buckets; i++ {
+		b := int(s.hand.Add(1) % uint64(buckets))
+		h.Sweep(b, int(buckets), func(k K, referenced bool) bool {
+			done = !referenced && f(k)
+			return !done
+		})
+		if done {
+			return true
+		}
+	}
+	return false
+}
+
+// StoreIfRoom is like Store but reports whether the value is stored. The value
+// is not stored only if the key is new and the map is full, or if the value
+// does not fit in the budget of a map with a cost limit, which never happens
+// in a map without a limit.
+func (m *MapOf[K, V]) StoreIfRoom(key K, value V) (stored bool) {
+	if m.limit == nil {
+		m.Store(key, value)
+		return true
+	}
+	s, d := m.locate(key)
+	if _, stored, _ = m.computeHashed(s, key, d, func(V, bool) (V, bool) {
+		return value, true
+	}); stored {
+		m.stored(s, d)
+	}
+	return
+}
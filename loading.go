@@ -0,0 +1,125 @@
+package cmap
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// LoadingMapOf is a concurrent map with keys of type K and values of type V
+// that loads the value of a missing key with a loader function, such as a
+// read-through cache in front of a database. The loader is called once for a
+// missing key even if the key is looked up by many goroutines at once: the
+// first of them calls the loader, and the others wait for its result.
+type LoadingMapOf[K comparable, V any] struct {
+	m      *MapOf[K, V]
+	calls  *MapOf[K, *loadCall[V]] // the loads in progress
+	loader func(key K) (V, error)
+}
+
+// loadCall is a call to the loader of a LoadingMapOf. Its value and error are
+// set before done is closed.
+type loadCall[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+// LoadingMap is a concurrent loading map whose keys and values are of
+// arbitrary types.
+type LoadingMap = LoadingMapOf[interface{}, interface{}]
+
+// NewLoadingMap returns an empty loading map whose keys are hashed by the
+// given function and whose missing values are loaded by the given loader.
+func NewLoadingMap[H Hash](hasher func(key interface{}) H, loader func(key interface{}) (interface{}, error), opts ...Option) (m *LoadingMap) {
+	return NewLoadingMapOf[interface{}, interface{}](hasher, loader, opts...)
+}
+
+// NewLoadingMapOf returns an empty loading map with keys of type K and values
+// of type V, where keys are hashed by the given function and missing values
+// are loaded by the given loader. The options apply to the map of the loaded
+// values, so that a limit bounds the number of values kept.
+func NewLoadingMapOf[K comparable, V any, H Hash](hasher func(key K) H, loader func(key K) (V, error), opts ...Option) (m *LoadingMapOf[K, V]) {
+	return &LoadingMapOf[K, V]{
+		m:      NewMapOf[K, V](hasher, opts...),
+		calls:  NewMapOf[K, *loadCall[V]](hasher),
+		loader: loader,
+	}
+}
+
+// Load returns the value associated with the given key, which is loaded by
+// the loader and stored if the key is missing. If the loader fails, Load
+// returns its error, which is also returned to the goroutines waiting for the
+// same load, and nothing is stored, so the next Load of the key calls the
+// loader again. If the loader panics, the panic is propagated to the goroutine
+// that called it, and the waiting ones get an error.
+func (m *LoadingMapOf[K, V]) Load(key K) (value V, err error) {
+	if value, ok := m.m.Load(key); ok {
+		return value, nil
+	}
+	c := &loadCall[V]{done: make(chan struct{})}
+	if other, loaded := m.calls.LoadOrStore(key, c); loaded {
+		<-other.done
+		return other.value, other.err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			if o := &m.m.opts; o.logEnabled(slog.LevelError) {
+				o.log(slog.LevelError, "cmap: the loader panicked", slog.Any("key", key), slog.Any("panic", r))
+			}
+			c.err = fmt.Errorf("cmap: the loader of %v panicked: %v", key, r)
+			m.finish(key, c)
+			panic(r)
+		}
+		m.finish(key, c)
+	}()
+
+	// The key may have been stored by a load finished since the lookup above.
+	if value, ok := m.m.Load(key); ok {
+		c.value = value
+		return value, nil
+	}
+	if c.value, c.err = m.loader(key); c.err == nil {
+		m.m.Store(key, c.value)
+	}
+	return c.value, c.err
+}
+
+// finish ends the load c of the given key and wakes up the goroutines waiting
+// for it.
+func (m *LoadingMapOf[K, V]) finish(key K, c *loadCall[V]) {
+	m.calls.Delete(key)
+	close(c.done)
+}
+
+// LoadIfPresent returns the value associated with the given key and true if
+// the key exists, without calling the loader. Otherwise, it returns the zero
+// value and false.
+func (m *LoadingMapOf[K, V]) LoadIfPresent(key K) (value V, ok bool) {
+	return m.m.Load(key)
+}
+
+// Store sets the given value to the given key. A load of the key in progress
+// replaces the value once it finishes.
+func (m *LoadingMapOf[K, V]) Store(key K, value V) {
+	m.m.Store(key, value)
+}
+
+// Delete removes the given key and its associated value, so that the next
+// Load of the key calls the loader. A load of the key in progress stores the
+// loaded value once it finishes.
+func (m *LoadingMapOf[K, V]) Delete(key K) {
+	m.m.Delete(key)
+}
+
+// Len returns the number of keys in the map, which excludes the keys being
+// loaded.
+func (m *LoadingMapOf[K, V]) Len() int {
+	return m.m.Len()
+}
+
+// Range iteratively applies the given function to each key-value pair, except
+// for the keys being loaded, until the function returns false. It gives the
+// same guarantees as MapOf.Range.
+func (m *LoadingMapOf[K, V]) Range(f func(key K, value V) bool) {
+	m.m.Range(f)
+}
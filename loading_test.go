@@ -0,0 +1,87 @@
+package cmap_test
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/decillion/go-cmap"
+)
+
+func TestLoadingMapLoadsOnce(t *testing.T) {
+	const keys, workers = 16, 8
+	var calls [keys]atomic.Int32
+	release := make(chan struct{})
+	m := cmap.NewLoadingMapOf[int, int](func(key int) uint64 { return uint64(key) }, func(key int) (int, error) {
+		calls[key].Add(1)
+		<-release
+		return -key, nil
+	})
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		for k := 0; k < keys; k++ {
+			wg.Add(1)
+			go func(k int) {
+				defer wg.Done()
+				if v, err := m.Load(k); v != -k || err != nil {
+					t.Errorf("Load(%v) = %v, %v; want %v, nil", k, v, err, -k)
+				}
+			}(k)
+		}
+	}
+	close(release)
+	wg.Wait()
+	for k := 0; k < keys; k++ {
+		if n := calls[k].Load(); n != 1 {
+			t.Errorf("the loader is called %v times for the key %v; want 1", n, k)
+		}
+		if v, ok := m.LoadIfPresent(k); v != -k || !ok {
+			t.Errorf("LoadIfPresent(%v) = %v, %v; want %v, true", k, v, ok, -k)
+		}
+	}
+	if m.Len() != keys {
+		t.Errorf("Len() = %v; want %v", m.Len(), keys)
+	}
+	m.Delete(0)
+	if v, err := m.Load(0); v != 0 || err != nil || calls[0].Load() != 2 {
+		t.Errorf("Load(0) = %v, %v after Delete with %v calls to the loader", v, err, calls[0].Load())
+	}
+}
+
+func TestLoadingMapErrors(t *testing.T) {
+	errLoad := errors.New("load failed")
+	fail := true
+	m := cmap.NewLoadingMapOf[int, int](func(key int) uint64 { return uint64(key) }, func(key int) (int, error) {
+		if key < 0 {
+			panic("negative key")
+		}
+		if fail {
+			return 0, errLoad
+		}
+		return key, nil
+	})
+	if _, err := m.Load(1); err != errLoad {
+		t.Errorf("Load(1) = _, %v; want %v", err, errLoad)
+	}
+	if _, ok := m.LoadIfPresent(1); ok || m.Len() != 0 {
+		t.Error("a value that failed to load is stored")
+	}
+	fail = false
+	if v, err := m.Load(1); v != 1 || err != nil {
+		t.Errorf("Load(1) = %v, %v after the loader recovers; want 1, nil", v, err)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Load does not propagate the panic of the loader")
+			}
+		}()
+		m.Load(-1)
+	}()
+	if v, err := m.Load(2); v != 2 || err != nil {
+		t.Errorf("Load(2) = %v, %v after the loader panicked; want 2, nil", v, err)
+	}
+}
@@ -0,0 +1,29 @@
+package cmap
+
+import (
+	"context"
+	"log/slog"
+)
+
+// WithLogger sets the logger that receives the events a map handles on its
+// own, which are otherwise silent: the resizes of its tables and the keys it
+// evicts at level Debug, the sweeps of the janitor of a map with times to live
+// at level Debug, and the panics of the loader of a loading map at level
+// Error. The attributes of an event are built only if the logger is enabled for
+// its level, so a logger at level Info costs the map next to nothing. The
+// logger is called while the map is not locked, except for the events of
+// resizes, which are logged while the shard is locked.
+func WithLogger(l *slog.Logger) Option {
+	return func(o *options) { o.logger = l }
+}
+
+// logEnabled reports whether the map has a logger enabled for the given level.
+func (o *options) logEnabled(level slog.Level) bool {
+	return o.logger != nil && o.logger.Enabled(context.Background(), level)
+}
+
+// log logs an event of the map at the given level, which the caller has found
+// enabled by logEnabled.
+func (o *options) log(level slog.Level, msg string, attrs ...slog.Attr) {
+	o.logger.LogAttrs(context.Background(), level, msg, attrs...)
+}
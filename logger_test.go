@@ -0,0 +1,81 @@
+package cmap_test
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/decillion/go-cmap"
+)
+
+// logBuffer collects the lines logged by the goroutines of a map.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestLogger(t *testing.T) {
+	var b logBuffer
+	logger := slog.New(slog.NewTextHandler(&b, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	hasher := func(key int) uint64 { return uint64(key) }
+
+	m := cmap.NewMapOf[int, int](hasher, cmap.WithLogger(logger), cmap.WithLimit(capacity, cmap.Evict))
+	for i := 0; i < 2*capacity; i++ {
+		m.Store(i, i)
+	}
+	l := cmap.NewLoadingMapOf[int, int](hasher, func(int) (int, error) { panic("no value") }, cmap.WithLogger(logger))
+	func() {
+		defer func() { recover() }()
+		l.Load(1)
+	}()
+	const ttl = 10 * time.Millisecond
+	ttlMap := cmap.NewTTLMapOf[int, int](hasher, cmap.WithLogger(logger))
+	defer ttlMap.Close()
+	ttlMap.StoreWithTTL(0, 0, ttl)
+	ttlMap.StartJanitor(ttl)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !strings.Contains(b.String(), "deleted=1") {
+		if time.Now().After(deadline) {
+			t.Fatalf("the janitor logs no sweep deleting the key:\n%s", b.String())
+		}
+		time.Sleep(ttl)
+	}
+	ttlMap.Close()
+	log := b.String()
+	for _, want := range []string{
+		`level=DEBUG msg="cmap: resized a table"`,
+		`level=DEBUG msg="cmap: evicted a key"`,
+		`level=ERROR msg="cmap: the loader panicked" key=1 panic="no value"`,
+		`level=DEBUG msg="cmap: the janitor swept the expired keys" deleted=1 keys=0`,
+	} {
+		if !strings.Contains(log, want) {
+			t.Errorf("the log lacks %q:\n%s", want, log)
+		}
+	}
+
+	b.buf.Reset()
+	quiet := slog.New(slog.NewTextHandler(&b, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	m = cmap.NewMapOf[int, int](hasher, cmap.WithLogger(quiet))
+	for i := 0; i < capacity; i++ {
+		m.Store(i, i)
+	}
+	if log := b.String(); log != "" {
+		t.Errorf("a logger at level Info logs:\n%s", log)
+	}
+}
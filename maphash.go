@@ -0,0 +1,19 @@
+package cmap
+
+// SeededHasher is a hash function for a value of an arbitrary comparable type
+// built on hash/maphash. Unlike DefaultHasher, its seed is randomized per
+// process, so the same keys are placed differently in every process, and it
+// is fast on values of composite types as well.
+//
+// If the package is built with the tag cmap_deterministic, SeededHasher hashes
+// keys as hashers.TypeHasher64 does instead, so that hashes are the same in
+// every process.
+func SeededHasher(key interface{}) uint64 {
+	return seededHasher(key)
+}
+
+// SeededHasherOf is like SeededHasher but hashes a value of type K without
+// boxing it into an interface.
+func SeededHasherOf[K comparable](key K) uint64 {
+	return seededHasherOf(key)
+}
@@ -0,0 +1,449 @@
+package cmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/decillion/go-cmap/hashers"
+)
+
+// MmapMap is a concurrent map from byte slices to byte slices whose entries
+// live in a memory-mapped file instead of the Go heap, so that they survive
+// restarts and are not scanned by the garbage collector. It trades the
+// flexibility of MapOf for that: the keys and values are limited to the sizes
+// given when the file is created, and the file holds a fixed number of keys,
+// since it is a hash table of slots of those sizes which is never resized.
+//
+// The file is divided into shards, each of which is locked separately and
+// probed linearly. Loads of a shard share its lock, and updates hold it
+// exclusively. The updates are written to the mapping, from which the kernel
+// writes them back to the file, so they survive a crash of the process, but
+// they survive a crash of the machine only once Sync is called. A value
+// replaced in place may be torn by a crash of the machine before Sync.
+//
+// A file opened by OpenSharedMmapMap can be opened by several processes at
+// once, each of which maps the same pages, so that a parent process and its
+// workers share one table instead of holding copies of it. The shards are then
+// also locked against the other processes by locks of their ranges of the file.
+type MmapMap struct {
+	file      *os.File
+	data      []byte // the mapping of the file
+	keySize   int
+	valueSize int
+	slotSize  int
+	perShard  int  // the number of slots of a shard
+	shift     uint // the shift of a hash to the index of its shard
+	shards    []mmapShard
+	shared    bool // whether the shards are locked against other processes
+	closed    atomic.Bool
+}
+
+type mmapShard struct {
+	mu      sync.RWMutex
+	slots   []byte     // the slots of the shard in the mapping
+	start   int64      // the offset of the slots in the file
+	count   int        // the number of keys unless the map is shared; guarded by mu
+	flockMu sync.Mutex // guards readers
+	readers int        // the number of goroutines holding mu for reading in a shared map
+}
+
+// The layout of a file of MmapMap. The file starts with a header of
+// mmapHeaderSize bytes, followed by the slots of the shards one after another.
+// Each slot is a header of mmapSlotHeader bytes, holding its state and the
+// lengths of its key and value, followed by the key and the value.
+const (
+	mmapMagic      = "cmapmmap"
+	mmapVersion    = 1
+	mmapHeaderSize = 64
+	mmapSlotHeader = 16
+)
+
+// The states of a slot.
+const (
+	slotEmpty byte = iota
+	slotUsed
+	slotDeleted
+)
+
+// The errors returned by the methods of MmapMap.
+var (
+	ErrTooLarge = errors.New("cmap: the key or value is larger than the slots of the file")
+	ErrFull     = errors.New("cmap: the part of the file for the key is full")
+)
+
+// OpenMmapMap opens the file of the given path as a MmapMap, creating it if it
+// does not exist. A new file holds about the given number of keys, each of
+// which is at most maxKeySize bytes and holds a value of at most maxValueSize
+// bytes. The sizes of an existing file are read from it, so the other
+// arguments are ignored. The map must be closed by Close. MmapMap is available
+// on Linux and macOS only, where the file is mapped by mmap.
+func OpenMmapMap(path string, capacity, maxKeySize, maxValueSize int) (m *MmapMap, err error) {
+	return openMmapMap(path, capacity, maxKeySize, maxValueSize, false)
+}
+
+// OpenSharedMmapMap is like OpenMmapMap but the file may be opened by other
+// processes at the same time. Each shard is locked by fcntl against the other
+// processes as well as by a mutex against the goroutines of the process, which
+// makes every method a system call more expensive. A file on a memory file
+// system such as /dev/shm on Linux is a segment of shared memory that is never
+// written back to a disk. Since the locks of fcntl are released whenever a
+// process closes any descriptor of the file, a process must not open the file
+// more than once at a time.
+func OpenSharedMmapMap(path string, capacity, maxKeySize, maxValueSize int) (m *MmapMap, err error) {
+	return openMmapMap(path, capacity, maxKeySize, maxValueSize, true)
+}
+
+func openMmapMap(path string, capacity, maxKeySize, maxValueSize int, shared bool) (m *MmapMap, err error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o666)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+		}
+	}()
+	if shared {
+		// The header is locked so that only one process creates the
+		// file, and the others read the header it writes.
+		if err = lockFile(f, 0, mmapHeaderSize, true); err != nil {
+			return nil, err
+		}
+		defer unlockFile(f, 0, mmapHeaderSize)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, mmapHeaderSize)
+	if info.Size() == 0 {
+		if capacity <= 0 || maxKeySize <= 0 || maxValueSize < 0 {
+			return nil, fmt.Errorf("cmap: OpenMmapMap(%v, %v, %v) out of range", capacity, maxKeySize, maxValueSize)
+		}
+		shards := min(64, 1<<bits.Len(uint(capacity-1)/8))
+		perShard := (capacity+shards-1)/shards*4/3 + 1
+		copy(header, mmapMagic)
+		binary.LittleEndian.PutUint32(header[8:], mmapVersion)
+		binary.LittleEndian.PutUint32(header[12:], uint32(maxKeySize))
+		binary.LittleEndian.PutUint32(header[16:], uint32(maxValueSize))
+		binary.LittleEndian.PutUint32(header[20:], uint32(shards))
+		binary.LittleEndian.PutUint64(header[24:], uint64(perShard))
+		size := int64(mmapHeaderSize) + int64(shards)*int64(perShard)*int64(slotSize(maxKeySize, maxValueSize))
+		if err = f.Truncate(size); err != nil {
+			return nil, err
+		}
+		if _, err = f.WriteAt(header, 0); err != nil {
+			return nil, err
+		}
+		if info, err = f.Stat(); err != nil {
+			return nil, err
+		}
+	} else if _, err = f.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+
+	if string(header[:8]) != mmapMagic || binary.LittleEndian.Uint32(header[8:]) != mmapVersion {
+		return nil, errors.New("cmap: not a file of MmapMap")
+	}
+	m = &MmapMap{
+		file:      f,
+		shared:    shared,
+		keySize:   int(binary.LittleEndian.Uint32(header[12:])),
+		valueSize: int(binary.LittleEndian.Uint32(header[16:])),
+		perShard:  int(binary.LittleEndian.Uint64(header[24:])),
+	}
+	shards := int(binary.LittleEndian.Uint32(header[20:]))
+	m.slotSize = slotSize(m.keySize, m.valueSize)
+	if shards == 0 || shards&(shards-1) != 0 || m.perShard == 0 ||
+		info.Size() != int64(mmapHeaderSize)+int64(shards)*int64(m.perShard)*int64(m.slotSize) {
+		return nil, errors.New("cmap: the file of MmapMap is corrupted")
+	}
+	if m.data, err = mapFile(f, int(info.Size())); err != nil {
+		return nil, err
+	}
+
+	m.shift = uint(64 - bits.Len(uint(shards-1)))
+	m.shards = make([]mmapShard, shards)
+	shardSize := m.perShard * m.slotSize
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.start = int64(mmapHeaderSize + i*shardSize)
+		s.slots = m.data[s.start : s.start+int64(shardSize)]
+		for j := 0; j < m.perShard; j++ {
+			slot := m.slot(s, j)
+			if slot[0] != slotUsed {
+				continue
+			}
+			if binary.LittleEndian.Uint32(slot[4:]) > uint32(m.keySize) || binary.LittleEndian.Uint32(slot[8:]) > uint32(m.valueSize) {
+				unmapFile(m.data)
+				return nil, errors.New("cmap: the file of MmapMap is corrupted")
+			}
+			s.count++
+		}
+	}
+	return m, nil
+}
+
+// slotSize returns the size of a slot rounded up to a multiple of eight.
+func slotSize(keySize, valueSize int) int {
+	return (mmapSlotHeader + keySize + valueSize + 7) &^ 7
+}
+
+// rlock locks the shard s for reading, or returns ErrClosed after Close.
+func (m *MmapMap) rlock(s *mmapShard) error {
+	s.mu.RLock()
+	if m.closed.Load() {
+		s.mu.RUnlock()
+		return ErrClosed
+	}
+	if !m.shared {
+		return nil
+	}
+	// The lock of the file is taken by the first reader of the process
+	// and released by the last one, since it belongs to the process.
+	s.flockMu.Lock()
+	defer s.flockMu.Unlock()
+	if s.readers == 0 {
+		if err := lockFile(m.file, s.start, int64(len(s.slots)), false); err != nil {
+			s.mu.RUnlock()
+			return err
+		}
+	}
+	s.readers++
+	return nil
+}
+
+// runlock unlocks the shard s locked by rlock.
+func (m *MmapMap) runlock(s *mmapShard) {
+	if m.shared {
+		s.flockMu.Lock()
+		if s.readers--; s.readers == 0 {
+			unlockFile(m.file, s.start, int64(len(s.slots)))
+		}
+		s.flockMu.Unlock()
+	}
+	s.mu.RUnlock()
+}
+
+// lock locks the shard s for writing, or returns ErrClosed after Close.
+func (m *MmapMap) lock(s *mmapShard) error {
+	s.mu.Lock()
+	if m.closed.Load() {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+	if m.shared {
+		if err := lockFile(m.file, s.start, int64(len(s.slots)), true); err != nil {
+			s.mu.Unlock()
+			return err
+		}
+	}
+	return nil
+}
+
+// unlock unlocks the shard s locked by lock.
+func (m *MmapMap) unlock(s *mmapShard) {
+	if m.shared {
+		unlockFile(m.file, s.start, int64(len(s.slots)))
+	}
+	s.mu.Unlock()
+}
+
+// locate returns the shard of the given key and the slot at which the key is
+// looked for first.
+func (m *MmapMap) locate(key []byte) (s *mmapShard, first int) {
+	h := hashers.XXHash64Bytes(key)
+	return &m.shards[h>>m.shift], int(h % uint64(m.perShard))
+}
+
+// slot returns the i-th slot of the shard s.
+func (m *MmapMap) slot(s *mmapShard, i int) []byte {
+	return s.slots[i*m.slotSize : (i+1)*m.slotSize]
+}
+
+// keyOf returns the key of the given used slot.
+func (m *MmapMap) keyOf(slot []byte) []byte {
+	n := binary.LittleEndian.Uint32(slot[4:])
+	return slot[mmapSlotHeader : mmapSlotHeader+n]
+}
+
+// valueOf returns the value of the given used slot.
+func (m *MmapMap) valueOf(slot []byte) []byte {
+	n := binary.LittleEndian.Uint32(slot[8:])
+	return slot[mmapSlotHeader+m.keySize : mmapSlotHeader+m.keySize+int(n)]
+}
+
+// find returns the slot of the given key and true if the key exists in the
+// shard s. Otherwise, it returns the first free slot on the way, or nil if the
+// shard has none, and false. The caller must hold s.mu.
+func (m *MmapMap) find(s *mmapShard, first int, key []byte) (slot []byte, found bool) {
+	for i := 0; i < m.perShard; i++ {
+		sl := m.slot(s, (first+i)%m.perShard)
+		switch sl[0] {
+		case slotEmpty:
+			if slot == nil {
+				slot = sl
+			}
+			return slot, false
+		case slotDeleted:
+			if slot == nil {
+				slot = sl
+			}
+		case slotUsed:
+			if bytes.Equal(m.keyOf(sl), key) {
+				return sl, true
+			}
+		}
+	}
+	return slot, false
+}
+
+// Load returns a copy of the value associated with the given key and true if
+// the key exists. Otherwise, it returns nil and false.
+func (m *MmapMap) Load(key []byte) (value []byte, ok bool) {
+	if len(key) > m.keySize {
+		return nil, false
+	}
+	s, first := m.locate(key)
+	if m.rlock(s) != nil {
+		return nil, false
+	}
+	defer m.runlock(s)
+	if slot, found := m.find(s, first, key); found {
+		return bytes.Clone(m.valueOf(slot)), true
+	}
+	return nil, false
+}
+
+// Store sets a copy of the given value to a copy of the given key. It returns
+// ErrTooLarge if the key or the value does not fit in a slot, ErrFull if the
+// part of the file for the key has no free slot, and ErrClosed after Close.
+func (m *MmapMap) Store(key, value []byte) error {
+	if len(key) > m.keySize || len(value) > m.valueSize {
+		return ErrTooLarge
+	}
+	s, first := m.locate(key)
+	if err := m.lock(s); err != nil {
+		return err
+	}
+	defer m.unlock(s)
+
+	slot, found := m.find(s, first, key)
+	if slot == nil {
+		return ErrFull
+	}
+	copy(slot[mmapSlotHeader+m.keySize:], value)
+	binary.LittleEndian.PutUint32(slot[8:], uint32(len(value)))
+	if !found {
+		copy(slot[mmapSlotHeader:], key)
+		binary.LittleEndian.PutUint32(slot[4:], uint32(len(key)))
+		slot[0] = slotUsed // written last, so that a crash leaves no half slot
+		s.count++
+	}
+	return nil
+}
+
+// Delete removes the given key and its associated value. It returns ErrClosed
+// after Close.
+func (m *MmapMap) Delete(key []byte) error {
+	if len(key) > m.keySize {
+		return nil
+	}
+	s, first := m.locate(key)
+	if err := m.lock(s); err != nil {
+		return err
+	}
+	defer m.unlock(s)
+	if slot, found := m.find(s, first, key); found {
+		slot[0] = slotDeleted
+		s.count--
+	}
+	return nil
+}
+
+// Len returns the number of keys in the map. The keys of a shared map are
+// counted by visiting its slots, since the other processes may have changed
+// them.
+func (m *MmapMap) Len() (n int) {
+	for i := range m.shards {
+		s := &m.shards[i]
+		if m.rlock(s) != nil {
+			return 0
+		}
+		if !m.shared {
+			n += s.count
+		} else {
+			for j := 0; j < m.perShard; j++ {
+				if m.slot(s, j)[0] == slotUsed {
+					n++
+				}
+			}
+		}
+		m.runlock(s)
+	}
+	return
+}
+
+// Range iteratively applies the given function to each key-value pair until
+// the function returns false. The slices given to the function are views of
+// the mapping, which must not be modified or retained after the function
+// returns. Since each shard is locked while the function is applied to its
+// keys, the function must not update the map.
+func (m *MmapMap) Range(f func(key, value []byte) bool) {
+	for i := range m.shards {
+		s := &m.shards[i]
+		if m.rlock(s) != nil {
+			return
+		}
+		for j := 0; j < m.perShard; j++ {
+			if slot := m.slot(s, j); slot[0] == slotUsed && !f(m.keyOf(slot), m.valueOf(slot)) {
+				m.runlock(s)
+				return
+			}
+		}
+		m.runlock(s)
+	}
+}
+
+// Sync commits the mapping to stable storage, so that the updates made so far
+// survive a crash of the machine.
+func (m *MmapMap) Sync() error {
+	if m.closed.Load() {
+		return ErrClosed
+	}
+	return syncMapping(m.data)
+}
+
+// Close commits the mapping to stable storage, unmaps it, and closes the
+// file. The map must not be used after Close, except that Store and Delete
+// return ErrClosed and Load finds no key. Close does nothing if the map is
+// already closed.
+func (m *MmapMap) Close() error {
+	for i := range m.shards {
+		m.shards[i].mu.Lock()
+	}
+	defer func() {
+		for i := range m.shards {
+			m.shards[i].mu.Unlock()
+		}
+	}()
+	if m.closed.Swap(true) {
+		return nil
+	}
+	err := syncMapping(m.data)
+	if uerr := unmapFile(m.data); err == nil {
+		err = uerr
+	}
+	if cerr := m.file.Close(); err == nil {
+		err = cerr
+	}
+	m.data = nil
+	return err
+}
@@ -0,0 +1,20 @@
+//go:build !linux && !darwin
+
+package cmap
+
+import (
+	"errors"
+	"os"
+)
+
+var errNoMmap = errors.New("cmap: MmapMap is not supported on this platform")
+
+func mapFile(f *os.File, size int) ([]byte, error) { return nil, errNoMmap }
+
+func unmapFile(b []byte) error { return errNoMmap }
+
+func syncMapping(b []byte) error { return errNoMmap }
+
+func lockFile(f *os.File, start, length int64, exclusive bool) error { return errNoMmap }
+
+func unlockFile(f *os.File, start, length int64) error { return errNoMmap }
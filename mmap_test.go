@@ -0,0 +1,180 @@
+//go:build linux || darwin
+
+package cmap_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"testing/quick"
+
+	"github.com/decillion/go-cmap"
+)
+
+type mmapMapCall struct {
+	Delete bool
+	Key    uint8
+	Value  []byte
+}
+
+func TestMmapMapMatchesBuiltInMap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "map")
+	f := func(calls []mmapMapCall) bool {
+		os.Remove(path)
+		m, err := cmap.OpenMmapMap(path, 64, 4, 1<<8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		builtin := make(map[string][]byte)
+		for _, c := range calls {
+			k := strconv.Itoa(int(c.Key % 32))
+			if c.Delete {
+				m.Delete([]byte(k))
+				delete(builtin, k)
+				continue
+			}
+			v := c.Value[:min(len(c.Value), 1<<8)]
+			if err := m.Store([]byte(k), v); err != nil {
+				t.Fatal(err)
+			}
+			builtin[k] = bytes.Clone(v)
+		}
+		// The keys and values survive reopening the file.
+		if err := m.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if m, err = cmap.OpenMmapMap(path, 0, 0, 0); err != nil {
+			t.Fatal(err)
+		}
+		defer m.Close()
+		for i := 0; i < 32; i++ {
+			k := strconv.Itoa(i)
+			v, ok := m.Load([]byte(k))
+			if w, found := builtin[k]; ok != found || !bytes.Equal(v, w) {
+				return false
+			}
+		}
+		n := 0
+		m.Range(func(k, v []byte) bool {
+			n++
+			return bytes.Equal(builtin[string(k)], v)
+		})
+		return n == len(builtin) && m.Len() == len(builtin)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMmapMapLimits(t *testing.T) {
+	dir := t.TempDir()
+	m, err := cmap.OpenMmapMap(filepath.Join(dir, "map"), 4, 8, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Store([]byte("123456789"), nil); err != cmap.ErrTooLarge {
+		t.Errorf("Store of a key too large = %v; want ErrTooLarge", err)
+	}
+	if err := m.Store([]byte("k"), []byte("123456789")); err != cmap.ErrTooLarge {
+		t.Errorf("Store of a value too large = %v; want ErrTooLarge", err)
+	}
+	var stored int
+	for i := 0; i < 100; i++ {
+		if err := m.Store([]byte(strconv.Itoa(i)), nil); err == cmap.ErrFull {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		stored++
+	}
+	if stored < 4 || stored == 100 || m.Len() != stored {
+		t.Errorf("%v keys are stored and Len() = %v in a file for 4 keys", stored, m.Len())
+	}
+	m.Delete([]byte("0"))
+	if err := m.Store([]byte("new"), []byte("value")); err != nil {
+		t.Errorf("Store into the slot of a deleted key = %v", err)
+	}
+	if err := m.Sync(); err != nil {
+		t.Error(err)
+	}
+
+	m.Close()
+	if err := m.Store([]byte("k"), nil); err != cmap.ErrClosed {
+		t.Errorf("Store after Close = %v; want ErrClosed", err)
+	}
+	if _, ok := m.Load([]byte("new")); ok {
+		t.Error("Load after Close finds a key")
+	}
+	if err := m.Close(); err != nil {
+		t.Errorf("Close of a closed map = %v", err)
+	}
+
+	garbage := filepath.Join(dir, "garbage")
+	os.WriteFile(garbage, []byte("not a map"), 0o666)
+	if _, err := cmap.OpenMmapMap(garbage, 4, 8, 8); err == nil {
+		t.Error("OpenMmapMap accepts a file of garbage")
+	}
+}
+
+// TestSharedMmapMap runs itself in worker processes, which update a shared map
+// along with the parent process.
+func TestSharedMmapMap(t *testing.T) {
+	const keys = 200
+	path, worker := os.Getenv("CMAP_SHARED_MMAP_PATH"), os.Getenv("CMAP_SHARED_MMAP_WORKER")
+	if path == "" {
+		path, worker = filepath.Join(t.TempDir(), "map"), "parent"
+	}
+	m, err := cmap.OpenSharedMmapMap(path, 4*keys, 16, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	var workers []*exec.Cmd
+	if worker == "parent" {
+		for i := 0; i < 2; i++ {
+			cmd := exec.Command(os.Args[0], "-test.run=^TestSharedMmapMap$")
+			cmd.Env = append(os.Environ(), "CMAP_SHARED_MMAP_PATH="+path, fmt.Sprintf("CMAP_SHARED_MMAP_WORKER=%v", i))
+			if err := cmd.Start(); err != nil {
+				t.Fatal(err)
+			}
+			workers = append(workers, cmd)
+		}
+	}
+	// Every process overwrites a common key with a value of its own, which
+	// is never seen torn by the others.
+	value := bytes.Repeat([]byte(worker[:1]), 64)
+	for i := 0; i < keys; i++ {
+		if err := m.Store([]byte(worker+strconv.Itoa(i)), value); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Store([]byte("common"), value); err != nil {
+			t.Fatal(err)
+		}
+		if v, ok := m.Load([]byte("common")); !ok || !bytes.Equal(v, bytes.Repeat(v[:1], 64)) {
+			t.Fatalf("Load of the common key = %q, %v", v, ok)
+		}
+	}
+	if worker != "parent" {
+		return
+	}
+	for _, cmd := range workers {
+		if err := cmd.Wait(); err != nil {
+			t.Fatalf("a worker process fails: %v", err)
+		}
+	}
+	for _, w := range []string{"parent", "0", "1"} {
+		for i := 0; i < keys; i++ {
+			if _, ok := m.Load([]byte(w + strconv.Itoa(i))); !ok {
+				t.Fatalf("the key %v of the process %v is lost", i, w)
+			}
+		}
+	}
+	if m.Len() != 3*keys+1 {
+		t.Errorf("Len() = %v; want %v", m.Len(), 3*keys+1)
+	}
+}
@@ -0,0 +1,52 @@
+//go:build linux || darwin
+
+package cmap
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// mapFile maps the first size bytes of the file f into memory for reading
+// and writing, sharing the updates with the file.
+func mapFile(f *os.File, size int) ([]byte, error) {
+	return syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+}
+
+// unmapFile unmaps the mapping b returned by mapFile.
+func unmapFile(b []byte) error {
+	return syscall.Munmap(b)
+}
+
+// syncMapping writes the mapping b back to its file and waits until it is
+// written.
+func syncMapping(b []byte) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC, uintptr(unsafe.Pointer(unsafe.SliceData(b))), uintptr(len(b)), syscall.MS_SYNC)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// lockFile locks the given range of the file f against the other processes,
+// for reading if exclusive is false and for writing otherwise, waiting until
+// the locks of the other processes allow it. The locks belong to the process,
+// so they do not exclude the goroutines of the process from each other.
+func lockFile(f *os.File, start, length int64, exclusive bool) error {
+	lk := syscall.Flock_t{Type: syscall.F_RDLCK, Start: start, Len: length}
+	if exclusive {
+		lk.Type = syscall.F_WRLCK
+	}
+	for {
+		if err := syscall.FcntlFlock(f.Fd(), syscall.F_SETLKW, &lk); err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+// unlockFile releases the lock of the given range of the file f.
+func unlockFile(f *os.File, start, length int64) error {
+	lk := syscall.Flock_t{Type: syscall.F_UNLCK, Start: start, Len: length}
+	return syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &lk)
+}
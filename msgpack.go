@@ -0,0 +1,562 @@
+package cmap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// WithMsgpackCodecs sets the codecs of the keys and values of a map for
+// MarshalMsgpack and UnmarshalMsgpack. Each codec must encode a key or value
+// as a single MessagePack object and decode it from one. The default codecs
+// encode the basic types as WriteTo does, and the values of interface types
+// holding them, nil, []interface{}, map[string]interface{}, or
+// map[interface{}]interface{}. Integers keep their signedness, so a Map whose
+// keys are integers gets them back as int64 or uint64 values. A nil codec
+// stands for the default one. K and V must be the types of the keys and values
+// of the map, or else MarshalMsgpack and UnmarshalMsgpack fail.
+func WithMsgpackCodecs[K comparable, V any](keys Codec[K], values Codec[V]) Option {
+	return func(o *options) { o.msgpackKeys, o.msgpackValues = keys, values }
+}
+
+// MarshalMsgpack encodes the key-value pairs the map contains at a single
+// point in time as a MessagePack map. The pairs are encoded straight from the
+// tables while all the shards are locked, as GobEncode does.
+func (m *MapOf[K, V]) MarshalMsgpack() ([]byte, error) {
+	keys, values, err := m.msgpackCodecs()
+	if err != nil {
+		return nil, err
+	}
+	m.lockAll()
+	defer m.unlockAll()
+
+	b := appendMsgpackHeader(nil, 0x80, 0xde, m.Len())
+	for i := range m.shards {
+		m.shards[i].table().Range(func(k K, v V) bool {
+			if b, err = keys.Encode(b, k); err == nil {
+				b, err = values.Encode(b, v)
+			}
+			return err == nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// UnmarshalMsgpack stores the key-value pairs of the given MessagePack map
+// into the map, keeping the keys already in the map, where nil stands for the
+// empty map. The tables are grown for the pairs before they are stored. The
+// map must have been created by one of the constructors, which give the hash
+// function of the keys.
+func (m *MapOf[K, V]) UnmarshalMsgpack(data []byte) error {
+	if m.shards == nil {
+		return errors.New("cmap: UnmarshalMsgpack into a map not created by a constructor")
+	}
+	keys, values, err := m.msgpackCodecs()
+	if err != nil {
+		return err
+	}
+	if len(data) == 1 && data[0] == 0xc0 {
+		return nil
+	}
+	n, data, err := readMsgpackHeader(data, 0x80, 0xde)
+	if err != nil {
+		return err
+	}
+	if n > len(data)/2 {
+		return errBadMsgpack
+	}
+	m.Reserve(m.Len() + n)
+	for i := 0; i < n; i++ {
+		size, err := skipMsgpack(data)
+		if err != nil {
+			return err
+		}
+		k, err := keys.Decode(data[:size])
+		if err != nil {
+			return err
+		}
+		data = data[size:]
+		if size, err = skipMsgpack(data); err != nil {
+			return err
+		}
+		v, err := values.Decode(data[:size])
+		if err != nil {
+			return err
+		}
+		data = data[size:]
+		m.Store(k, v)
+	}
+	if len(data) != 0 {
+		return errBadMsgpack
+	}
+	return nil
+}
+
+// msgpackCodecs returns the MessagePack codecs of the keys and values of the
+// map.
+func (m *MapOf[K, V]) msgpackCodecs() (keys Codec[K], values Codec[V], err error) {
+	if keys, err = msgpackCodecOf[K](m.opts.msgpackKeys, "keys"); err != nil {
+		return
+	}
+	values, err = msgpackCodecOf[V](m.opts.msgpackValues, "values")
+	return
+}
+
+// msgpackCodecOf returns the codec given by WithMsgpackCodecs, or the default
+// codec if c is nil.
+func msgpackCodecOf[T any](c any, of string) (Codec[T], error) {
+	if c == nil {
+		if !(msgpackCodec[T]{}).supports() {
+			return nil, fmt.Errorf("cmap: no MessagePack codec for the %v of type %v; see WithMsgpackCodecs", of, reflect.TypeFor[T]())
+		}
+		return msgpackCodec[T]{}, nil
+	}
+	codec, ok := c.(Codec[T])
+	if !ok {
+		return nil, fmt.Errorf("cmap: the MessagePack codec of the %v is %T, not a Codec[%v]", of, c, reflect.TypeFor[T]())
+	}
+	return codec, nil
+}
+
+// msgpackCodec is the default MessagePack codec.
+type msgpackCodec[T any] struct{}
+
+// supports reports whether the codec supports T.
+func (msgpackCodec[T]) supports() bool {
+	if _, ok := any(new(T)).(*interface{}); ok {
+		return true
+	}
+	return binaryCodec[T]{}.supports()
+}
+
+func (msgpackCodec[T]) Encode(b []byte, value T) ([]byte, error) {
+	return appendMsgpack(b, value)
+}
+
+func (msgpackCodec[T]) Decode(data []byte) (value T, err error) {
+	v, rest, err := decodeMsgpack(data, 0)
+	if err != nil {
+		return value, err
+	}
+	if len(rest) != 0 {
+		return value, errBadMsgpack
+	}
+	if p, ok := any(&value).(*interface{}); ok {
+		*p = v
+		return
+	}
+	dst := reflect.ValueOf(&value).Elem()
+	switch x := v.(type) {
+	case int64:
+		switch dst.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if !dst.OverflowInt(x) {
+				dst.SetInt(x)
+				return
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if x >= 0 && !dst.OverflowUint(uint64(x)) {
+				dst.SetUint(uint64(x))
+				return
+			}
+		case reflect.Float32, reflect.Float64:
+			dst.SetFloat(float64(x))
+			return
+		}
+	case uint64:
+		switch dst.Kind() {
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if !dst.OverflowUint(x) {
+				dst.SetUint(x)
+				return
+			}
+		case reflect.Float32, reflect.Float64:
+			dst.SetFloat(float64(x))
+			return
+		}
+	case float64:
+		if dst.Kind() == reflect.Float32 || dst.Kind() == reflect.Float64 {
+			dst.SetFloat(x)
+			return
+		}
+	case string:
+		switch p := any(&value).(type) {
+		case *string:
+			*p = x
+			return
+		case *[]byte:
+			*p = []byte(x)
+			return
+		}
+	case []byte:
+		switch p := any(&value).(type) {
+		case *[]byte:
+			*p = x
+			return
+		case *string:
+			*p = string(x)
+			return
+		}
+	case bool:
+		if p, ok := any(&value).(*bool); ok {
+			*p = x
+			return
+		}
+	}
+	return value, fmt.Errorf("cmap: cannot decode MessagePack %T into %v", v, dst.Type())
+}
+
+var errBadMsgpack = errors.New("cmap: malformed MessagePack")
+
+// maxMsgpackDepth is the deepest nesting of arrays and maps decoded, as in
+// encoding/json, beyond which the input is rejected instead of the decoder
+// overflowing its stack.
+const maxMsgpackDepth = 10000
+
+// appendMsgpack appends the MessagePack encoding of the given value.
+func appendMsgpack(b []byte, value any) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return append(b, 0xc0), nil
+	case bool:
+		if v {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case int:
+		return appendMsgpackInt(b, int64(v)), nil
+	case int8:
+		return appendMsgpackInt(b, int64(v)), nil
+	case int16:
+		return appendMsgpackInt(b, int64(v)), nil
+	case int32:
+		return appendMsgpackInt(b, int64(v)), nil
+	case int64:
+		return appendMsgpackInt(b, v), nil
+	case uint:
+		return appendMsgpackUint(b, uint64(v)), nil
+	case uint8:
+		return appendMsgpackUint(b, uint64(v)), nil
+	case uint16:
+		return appendMsgpackUint(b, uint64(v)), nil
+	case uint32:
+		return appendMsgpackUint(b, uint64(v)), nil
+	case uint64:
+		return appendMsgpackUint(b, v), nil
+	case uintptr:
+		return appendMsgpackUint(b, uint64(v)), nil
+	case float32:
+		return binary.BigEndian.AppendUint32(append(b, 0xca), math.Float32bits(v)), nil
+	case float64:
+		return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(v)), nil
+	case string:
+		if len(v) < 32 {
+			b = append(b, 0xa0|byte(len(v)))
+		} else {
+			b = appendMsgpackLen(b, 0xd9, len(v))
+		}
+		return append(b, v...), nil
+	case []byte:
+		return append(appendMsgpackLen(b, 0xc4, len(v)), v...), nil
+	case []interface{}:
+		b = appendMsgpackHeader(b, 0x90, 0xdc, len(v))
+		var err error
+		for _, e := range v {
+			if b, err = appendMsgpack(b, e); err != nil {
+				return b, err
+			}
+		}
+		return b, nil
+	case map[string]interface{}:
+		b = appendMsgpackHeader(b, 0x80, 0xde, len(v))
+		var err error
+		for k, e := range v {
+			if b, err = appendMsgpack(b, k); err != nil {
+				return b, err
+			}
+			if b, err = appendMsgpack(b, e); err != nil {
+				return b, err
+			}
+		}
+		return b, nil
+	case map[interface{}]interface{}:
+		b = appendMsgpackHeader(b, 0x80, 0xde, len(v))
+		var err error
+		for k, e := range v {
+			if b, err = appendMsgpack(b, k); err != nil {
+				return b, err
+			}
+			if b, err = appendMsgpack(b, e); err != nil {
+				return b, err
+			}
+		}
+		return b, nil
+	}
+	return b, fmt.Errorf("cmap: no MessagePack codec for %T", value)
+}
+
+func appendMsgpackInt(b []byte, v int64) []byte {
+	switch {
+	case v >= 0:
+		return appendMsgpackUint(b, uint64(v))
+	case v >= -32:
+		return append(b, byte(v))
+	case v >= math.MinInt8:
+		return append(b, 0xd0, byte(v))
+	case v >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(v))
+	case v >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(v))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(v))
+}
+
+func appendMsgpackUint(b []byte, v uint64) []byte {
+	switch {
+	case v < 0x80:
+		return append(b, byte(v))
+	case v <= math.MaxUint8:
+		return append(b, 0xcc, byte(v))
+	case v <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xcd), uint16(v))
+	case v <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, 0xce), uint32(v))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xcf), v)
+}
+
+// appendMsgpackLen appends the length n in the smallest of the formats of
+// 8-, 16-, and 32-bit lengths starting at the given code.
+func appendMsgpackLen(b []byte, code byte, n int) []byte {
+	switch {
+	case n <= math.MaxUint8:
+		return append(b, code, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, code+1), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, code+2), uint32(n))
+}
+
+// appendMsgpackHeader appends the header of an array or a map of n elements,
+// whose fixed format starts at fix and whose 16-bit format is code.
+func appendMsgpackHeader(b []byte, fix, code byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, fix|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, code), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, code+1), uint32(n))
+}
+
+// readMsgpackHeader reads the header of an array or a map written by
+// appendMsgpackHeader.
+func readMsgpackHeader(data []byte, fix, code byte) (n int, rest []byte, err error) {
+	if len(data) == 0 {
+		return 0, nil, errBadMsgpack
+	}
+	switch c := data[0]; {
+	case c&0xf0 == fix:
+		return int(c & 0x0f), data[1:], nil
+	case c == code && len(data) >= 3:
+		return int(binary.BigEndian.Uint16(data[1:])), data[3:], nil
+	case c == code+1 && len(data) >= 5:
+		return int(binary.BigEndian.Uint32(data[1:])), data[5:], nil
+	}
+	return 0, nil, errBadMsgpack
+}
+
+// msgpackFormat returns the sizes of the header and the payload of the object
+// at the front of data, and the number of objects following them if it is an
+// array or a map, or an error if the front of data is not an object.
+func msgpackFormat(data []byte) (header, payload, objects int, err error) {
+	if len(data) == 0 {
+		return 0, 0, 0, errBadMsgpack
+	}
+	c := data[0]
+	length := func(size int) int {
+		if len(data) < 1+size {
+			err = errBadMsgpack
+			return 0
+		}
+		switch size {
+		case 1:
+			return int(data[1])
+		case 2:
+			return int(binary.BigEndian.Uint16(data[1:]))
+		}
+		return int(binary.BigEndian.Uint32(data[1:]))
+	}
+	switch {
+	case c <= 0x7f || c >= 0xe0 || c == 0xc0 || c == 0xc2 || c == 0xc3:
+		return 1, 0, 0, nil
+	case c&0xf0 == 0x80:
+		return 1, 0, 2 * int(c&0x0f), nil
+	case c&0xf0 == 0x90:
+		return 1, 0, int(c & 0x0f), nil
+	case c&0xe0 == 0xa0:
+		return 1, int(c & 0x1f), 0, nil
+	}
+	switch c {
+	case 0xcc, 0xd0:
+		return 1, 1, 0, nil
+	case 0xcd, 0xd1:
+		return 1, 2, 0, nil
+	case 0xca, 0xce, 0xd2:
+		return 1, 4, 0, nil
+	case 0xcb, 0xcf, 0xd3:
+		return 1, 8, 0, nil
+	case 0xc4, 0xd9:
+		return 2, length(1), 0, err
+	case 0xc5, 0xda:
+		return 3, length(2), 0, err
+	case 0xc6, 0xdb:
+		return 5, length(4), 0, err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return 2, 1 << (c - 0xd4), 0, nil
+	case 0xc7:
+		return 3, length(1), 0, err
+	case 0xc8:
+		return 4, length(2), 0, err
+	case 0xc9:
+		return 6, length(4), 0, err
+	case 0xdc:
+		return 3, 0, length(2), err
+	case 0xdd:
+		return 5, 0, length(4), err
+	case 0xde:
+		return 3, 0, 2 * length(2), err
+	case 0xdf:
+		return 5, 0, 2 * length(4), err
+	}
+	return 0, 0, 0, errBadMsgpack
+}
+
+// skipMsgpack returns the size of the object at the front of data.
+func skipMsgpack(data []byte) (size int, err error) {
+	for objects := 1; objects > 0; objects-- {
+		header, payload, nested, err := msgpackFormat(data[size:])
+		if err != nil {
+			return 0, err
+		}
+		if size += header + payload; size > len(data) || nested > len(data)-size {
+			return 0, errBadMsgpack
+		}
+		objects += nested
+	}
+	return size, nil
+}
+
+// decodeMsgpack decodes the object at the front of data into the types that
+// appendMsgpack encodes, where integers are decoded into int64 unless they
+// only fit in uint64. The object is nested in depth arrays and maps.
+func decodeMsgpack(data []byte, depth int) (v any, rest []byte, err error) {
+	if depth > maxMsgpackDepth {
+		return nil, nil, errBadMsgpack
+	}
+	header, payload, objects, err := msgpackFormat(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if header+payload > len(data) {
+		return nil, nil, errBadMsgpack
+	}
+	c, body, rest := data[0], data[header:header+payload], data[header+payload:]
+	switch {
+	case c <= 0x7f:
+		return int64(c), rest, nil
+	case c >= 0xe0:
+		return int64(int8(c)), rest, nil
+	case c&0xe0 == 0xa0 || c == 0xd9 || c == 0xda || c == 0xdb:
+		return string(body), rest, nil
+	case c&0xf0 == 0x90 || c == 0xdc || c == 0xdd:
+		if objects > len(rest) {
+			return nil, nil, errBadMsgpack
+		}
+		a := make([]interface{}, objects)
+		for i := range a {
+			if a[i], rest, err = decodeMsgpack(rest, depth+1); err != nil {
+				return nil, nil, err
+			}
+		}
+		return a, rest, nil
+	case c&0xf0 == 0x80 || c == 0xde || c == 0xdf:
+		return decodeMsgpackMap(rest, objects/2, depth+1)
+	}
+	switch c {
+	case 0xc0:
+		return nil, rest, nil
+	case 0xc2, 0xc3:
+		return c == 0xc3, rest, nil
+	case 0xcc:
+		return int64(body[0]), rest, nil
+	case 0xcd:
+		return int64(binary.BigEndian.Uint16(body)), rest, nil
+	case 0xce:
+		return int64(binary.BigEndian.Uint32(body)), rest, nil
+	case 0xcf:
+		if n := binary.BigEndian.Uint64(body); n > math.MaxInt64 {
+			return n, rest, nil
+		} else {
+			return int64(n), rest, nil
+		}
+	case 0xd0:
+		return int64(int8(body[0])), rest, nil
+	case 0xd1:
+		return int64(int16(binary.BigEndian.Uint16(body))), rest, nil
+	case 0xd2:
+		return int64(int32(binary.BigEndian.Uint32(body))), rest, nil
+	case 0xd3:
+		return int64(binary.BigEndian.Uint64(body)), rest, nil
+	case 0xca:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(body))), rest, nil
+	case 0xcb:
+		return math.Float64frombits(binary.BigEndian.Uint64(body)), rest, nil
+	case 0xc4, 0xc5, 0xc6:
+		return append([]byte(nil), body...), rest, nil
+	}
+	return nil, nil, fmt.Errorf("cmap: no MessagePack codec for the extension type %#x", c)
+}
+
+// decodeMsgpackMap decodes the n pairs of a map at the front of data into a
+// map[string]interface{} if all the keys are strings, or else into a
+// map[interface{}]interface{}. The pairs are nested in depth arrays and maps.
+func decodeMsgpackMap(data []byte, n, depth int) (v any, rest []byte, err error) {
+	if n > len(data)/2 {
+		return nil, nil, errBadMsgpack
+	}
+	keys, values := make([]any, n), make([]any, n)
+	strings := true
+	for i := 0; i < n; i++ {
+		if keys[i], data, err = decodeMsgpack(data, depth); err != nil {
+			return nil, nil, err
+		}
+		if values[i], data, err = decodeMsgpack(data, depth); err != nil {
+			return nil, nil, err
+		}
+		switch keys[i].(type) {
+		case string:
+		case []byte, []interface{}, map[string]interface{}, map[interface{}]interface{}:
+			return nil, nil, errors.New("cmap: a MessagePack map with keys of an incomparable type")
+		default:
+			strings = false
+		}
+	}
+	if strings {
+		m := make(map[string]interface{}, n)
+		for i, k := range keys {
+			m[k.(string)] = values[i]
+		}
+		return m, data, nil
+	}
+	m := make(map[interface{}]interface{}, n)
+	for i, k := range keys {
+		m[k] = values[i]
+	}
+	return m, data, nil
+}
@@ -0,0 +1,153 @@
+package cmap_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"testing/quick"
+
+	"github.com/decillion/go-cmap"
+)
+
+func TestMsgpackMatchesBuiltInMap(t *testing.T) {
+	f := func(builtin map[int64]string) bool {
+		m := cmap.NewMapOf[int64, string](func(key int64) uint64 { return uint64(key) })
+		for k, v := range builtin {
+			m.Store(k, v)
+		}
+		data, err := m.MarshalMsgpack()
+		if err != nil {
+			return false
+		}
+		decoded := cmap.NewMapOf[int64, string](func(key int64) uint64 { return uint64(key) })
+		if err := decoded.UnmarshalMsgpack(data); err != nil || decoded.Len() != len(builtin) {
+			return false
+		}
+		for k, v := range builtin {
+			if w, ok := decoded.Load(k); !ok || w != v {
+				return false
+			}
+		}
+		// Every prefix of the encoding is malformed.
+		for i := range data {
+			if decoded.UnmarshalMsgpack(data[:i]) == nil {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMsgpackEncoding(t *testing.T) {
+	m := cmap.NewMapOf[int8, []byte](func(key int8) uint64 { return uint64(key) })
+	m.Store(-1, []byte{0xff})
+	data, err := m.MarshalMsgpack()
+	if want := []byte{0x81, 0xff, 0xc4, 0x01, 0xff}; err != nil || !bytes.Equal(data, want) {
+		t.Errorf("MarshalMsgpack = %x, %v; want %x, nil", data, err, want)
+	}
+	// 200 does not fit in int8, and strings are decoded into []byte.
+	if err := m.UnmarshalMsgpack([]byte{0x81, 0xcc, 200, 0xa1, 'x'}); err == nil {
+		t.Error("UnmarshalMsgpack accepts a key out of the range of int8")
+	}
+	if err := m.UnmarshalMsgpack([]byte{0x81, 0x02, 0xa1, 'x'}); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := m.Load(2); !ok || string(v) != "x" {
+		t.Errorf("Load(2) = %q, %v after UnmarshalMsgpack; want x, true", v, ok)
+	}
+	if err := m.UnmarshalMsgpack([]byte{0xc0}); err != nil || m.Len() != 2 {
+		t.Errorf("UnmarshalMsgpack(nil) = %v and Len() = %v; want nil and 2", err, m.Len())
+	}
+
+	// The keys of a Map keep their integer types, unlike in JSON.
+	a := cmap.NewMap(cmap.SeededHasher)
+	a.Store(int64(-300), "negative")
+	a.Store(uint64(1<<63), "huge")
+	a.Store("nested", map[string]interface{}{"list": []interface{}{true, nil, 1.5}})
+	data, err = a.MarshalMsgpack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := cmap.NewMap(cmap.SeededHasher)
+	if err := b.UnmarshalMsgpack(data); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := b.Load(int64(-300)); v != "negative" {
+		t.Errorf("Load(int64(-300)) = %v after a round trip; want negative", v)
+	}
+	if v, _ := b.Load(uint64(1 << 63)); v != "huge" {
+		t.Errorf("Load(uint64(1<<63)) = %v after a round trip; want huge", v)
+	}
+	if v, _ := b.Load("nested"); v.(map[string]interface{})["list"].([]interface{})[2] != 1.5 {
+		t.Errorf(`Load("nested") = %v after a round trip`, v)
+	}
+	a.Store(struct{}{}, 1)
+	if _, err := a.MarshalMsgpack(); err == nil {
+		t.Error("MarshalMsgpack accepts a key of a struct type")
+	}
+
+	// Arrays nested too deeply are rejected rather than overflowing the stack.
+	nested := func(depth int) []byte {
+		data := append([]byte{0x81, 0xa1, 'x'}, bytes.Repeat([]byte{0x91}, depth)...)
+		return append(data, 0xc0)
+	}
+	if err := b.UnmarshalMsgpack(nested(1000)); err != nil {
+		t.Errorf("UnmarshalMsgpack = %v for arrays nested 1000 deep; want nil", err)
+	}
+	if err := b.UnmarshalMsgpack(nested(1 << 24)); err == nil {
+		t.Error("UnmarshalMsgpack accepts arrays nested 1<<24 deep")
+	}
+
+	var zero cmap.MapOf[string, int]
+	if err := zero.UnmarshalMsgpack([]byte{0x80}); err == nil {
+		t.Error("UnmarshalMsgpack into the zero map does not fail")
+	}
+}
+
+type msgpackPoint struct{ X, Y int8 }
+
+// msgpackPointCodec encodes a point as an array of its coordinates.
+type msgpackPointCodec struct{}
+
+func (msgpackPointCodec) Encode(b []byte, p msgpackPoint) ([]byte, error) {
+	return append(b, 0x92, byte(p.X)&0x7f, byte(p.Y)&0x7f), nil
+}
+
+func (msgpackPointCodec) Decode(data []byte) (msgpackPoint, error) {
+	if len(data) != 3 || data[0] != 0x92 {
+		return msgpackPoint{}, errors.New("not a point")
+	}
+	return msgpackPoint{int8(data[1]), int8(data[2])}, nil
+}
+
+func TestMsgpackCodecs(t *testing.T) {
+	hash := func(p msgpackPoint) uint64 { return uint64(p.X)<<8 | uint64(p.Y) }
+	m := cmap.NewMapOf[msgpackPoint, string](hash)
+	m.Store(msgpackPoint{1, 2}, "a")
+	if _, err := m.MarshalMsgpack(); err == nil {
+		t.Error("MarshalMsgpack accepts keys of a struct type without a codec")
+	}
+
+	m = cmap.NewMapOf[msgpackPoint, string](hash, cmap.WithMsgpackCodecs[msgpackPoint, string](msgpackPointCodec{}, nil))
+	m.Store(msgpackPoint{1, 2}, "a")
+	m.Store(msgpackPoint{3, 4}, "b")
+	data, err := m.MarshalMsgpack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := cmap.NewMapOf[msgpackPoint, string](hash, cmap.WithMsgpackCodecs[msgpackPoint, string](msgpackPointCodec{}, nil))
+	if err := decoded.UnmarshalMsgpack(data); err != nil || decoded.Len() != 2 {
+		t.Fatalf("UnmarshalMsgpack = %v and Len() = %v; want nil and 2", err, decoded.Len())
+	}
+	if v, _ := decoded.Load(msgpackPoint{3, 4}); v != "b" {
+		t.Errorf("Load({3, 4}) = %v after a round trip; want b", v)
+	}
+
+	wrong := cmap.NewMapOf[string, string](cmap.StringHasher, cmap.WithMsgpackCodecs[msgpackPoint, string](msgpackPointCodec{}, nil))
+	if err := wrong.UnmarshalMsgpack([]byte{0x80}); err == nil {
+		t.Error("UnmarshalMsgpack accepts codecs of the wrong types")
+	}
+}
@@ -0,0 +1,89 @@
+package cmap
+
+// MultiMapOf is a concurrent map that associates each key of type K with
+// multiple values of type V. Values of a key are kept in the order they were
+// appended.
+//
+// The values of a key are stored in an immutable slice which is replaced on
+// every update, so readers never observe a partially updated slice.
+type MultiMapOf[K, V comparable] struct {
+	m *MapOf[K, []V]
+}
+
+// MultiMap is a concurrent multimap whose keys and values are of arbitrary
+// types.
+type MultiMap = MultiMapOf[interface{}, interface{}]
+
+// NewMultiMap returns an empty multimap whose keys are hashed by the given
+// function.
+func NewMultiMap[H Hash](hasher func(key interface{}) H) (m *MultiMap) {
+	return NewMultiMapOf[interface{}, interface{}](hasher)
+}
+
+// NewMultiMapOf returns an empty multimap from keys of type K to values of
+// type V whose keys are hashed by the given function.
+func NewMultiMapOf[K, V comparable, H Hash](hasher func(key K) H) (m *MultiMapOf[K, V]) {
+	return &MultiMapOf[K, V]{m: NewMapOf[K, []V](hasher)}
+}
+
+// Append adds the given value to the values of the given key.
+func (m *MultiMapOf[K, V]) Append(key K, value V) {
+	m.m.compute(key, func(old []V, _ bool) ([]V, bool) {
+		return append(old[:len(old):len(old)], value), true
+	})
+}
+
+// LoadAll returns the values of the given key, or nil if the key does not
+// exist. The returned slice is shared with other callers and must not be
+// modified.
+func (m *MultiMapOf[K, V]) LoadAll(key K) (values []V) {
+	values, _ = m.m.Load(key)
+	return
+}
+
+// RemoveValue removes the first occurrence of the given value from the values
+// of the given key, and removes the key if no values remain. It reports
+// whether the value was removed.
+func (m *MultiMapOf[K, V]) RemoveValue(key K, value V) (removed bool) {
+	if values, _ := m.m.Load(key); !contains(values, value) {
+		return false
+	}
+	m.m.compute(key, func(old []V, _ bool) ([]V, bool) {
+		for i, v := range old {
+			if v == value {
+				removed = true
+				values := make([]V, 0, len(old)-1)
+				values = append(append(values, old[:i]...), old[i+1:]...)
+				return values, len(values) > 0
+			}
+		}
+		return old, len(old) > 0
+	})
+	return
+}
+
+func contains[V comparable](values []V, value V) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Delete removes the given key and all of its values.
+func (m *MultiMapOf[K, V]) Delete(key K) {
+	m.m.Delete(key)
+}
+
+// Len returns the number of keys in the multimap.
+func (m *MultiMapOf[K, V]) Len() int {
+	return m.m.Len()
+}
+
+// Range iteratively applies the given function to each key and its values
+// until the function returns false. The slices passed to the function must not
+// be modified.
+func (m *MultiMapOf[K, V]) Range(f func(key K, values []V) bool) {
+	m.m.Range(f)
+}
@@ -0,0 +1,72 @@
+package cmap_test
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"testing/quick"
+
+	"github.com/decillion/go-cmap"
+)
+
+type multiMapCall struct {
+	Remove     bool
+	Key, Value uint8
+}
+
+func TestMultiMapMatchesBuiltInMap(t *testing.T) {
+	f := func(calls []multiMapCall) bool {
+		m := cmap.NewMultiMapOf[uint64, uint8](cmap.Uint64Hasher)
+		b := make(map[uint64][]uint8)
+		for _, c := range calls {
+			k, v := uint64(c.Key%16), c.Value%4
+			if !c.Remove {
+				m.Append(k, v)
+				b[k] = append(b[k], v)
+				continue
+			}
+			removed := false
+			for i, w := range b[k] {
+				if w == v {
+					b[k] = append(b[k][:i], b[k][i+1:]...)
+					removed = true
+					break
+				}
+			}
+			if len(b[k]) == 0 {
+				delete(b, k)
+			}
+			if m.RemoveValue(k, v) != removed {
+				return false
+			}
+		}
+		for k := uint64(0); k < 16; k++ {
+			if !reflect.DeepEqual(m.LoadAll(k), b[k]) {
+				return false
+			}
+		}
+		return m.Len() == len(b)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMultiMapConcurrentAppend(t *testing.T) {
+	const goroutines, appends = 8, 1 << 8
+	m := cmap.NewMultiMap(cmap.DefaultHasher)
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < appends; i++ {
+				m.Append("key", i)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := len(m.LoadAll("key")); n != goroutines*appends {
+		t.Errorf("LoadAll returned %v values; want %v", n, goroutines*appends)
+	}
+}
@@ -0,0 +1,113 @@
+package cmap
+
+// OnceMapOf is a concurrent map from keys of type K to values of type V that
+// are each initialized once by a function given with the first lookup of the
+// key. The slot of a missing key is added at once, and its value is then
+// initialized outside the critical section of the map, while the other
+// goroutines looking up the key wait for the initialization instead of
+// repeating it. Unlike MapOf.ComputeIfAbsent, the initialization may take
+// long and may use the map, and unlike a LoadingMapOf, each lookup gives the
+// function that initializes the value.
+type OnceMapOf[K comparable, V any] struct {
+	m *MapOf[K, *onceSlot[V]]
+}
+
+// onceSlot is the slot of a key of a OnceMapOf. Its value and failed are set
+// before ready is closed.
+type onceSlot[V any] struct {
+	ready  chan struct{}
+	value  V
+	failed bool // whether the initialization panicked, which removes the slot
+}
+
+// OnceMap is a concurrent once map whose keys and values are of arbitrary
+// types.
+type OnceMap = OnceMapOf[interface{}, interface{}]
+
+// NewOnceMap returns an empty once map whose keys are hashed by the given
+// function.
+func NewOnceMap[H Hash](hasher func(key interface{}) H) (m *OnceMap) {
+	return NewOnceMapOf[interface{}, interface{}](hasher)
+}
+
+// NewOnceMapOf returns an empty once map from keys of type K to values of type
+// V whose keys are hashed by the given function.
+func NewOnceMapOf[K comparable, V any, H Hash](hasher func(key K) H) (m *OnceMapOf[K, V]) {
+	return &OnceMapOf[K, V]{m: NewMapOf[K, *onceSlot[V]](hasher)}
+}
+
+// GetOrCreate returns the value of the given key and true if the key exists,
+// waiting for its initialization if it is in progress. Otherwise, it adds the
+// key, initializes its value by init, and returns the value and false. If init
+// panics, the key is removed and the panic is propagated, while the goroutines
+// waiting for the initialization try again, each initializing the value by the
+// function it gave if the key is still missing.
+func (m *OnceMapOf[K, V]) GetOrCreate(key K, init func() V) (value V, loaded bool) {
+	for {
+		s, ok := m.m.Load(key)
+		if !ok {
+			s = &onceSlot[V]{ready: make(chan struct{})}
+			if s, ok = m.m.LoadOrStore(key, s); !ok {
+				return m.initialize(key, s, init), false
+			}
+		}
+		if <-s.ready; !s.failed {
+			return s.value, true
+		}
+	}
+}
+
+// initialize initializes the value of the slot s of the given key by init.
+func (m *OnceMapOf[K, V]) initialize(key K, s *onceSlot[V], init func() V) V {
+	defer func() {
+		if r := recover(); r != nil {
+			s.failed = true
+			m.m.CompareAndDelete(key, s)
+			close(s.ready)
+			panic(r)
+		}
+	}()
+	s.value = init()
+	close(s.ready)
+	return s.value
+}
+
+// Load returns the value of the given key and true if the key exists, waiting
+// for its initialization if it is in progress. Otherwise, or if the
+// initialization panics, it returns the zero value and false.
+func (m *OnceMapOf[K, V]) Load(key K) (value V, ok bool) {
+	s, ok := m.m.Load(key)
+	if !ok {
+		return
+	}
+	if <-s.ready; s.failed {
+		return value, false
+	}
+	return s.value, true
+}
+
+// Delete removes the given key. An initialization of its value in progress
+// goes on, and its value is returned to the goroutines already waiting for it.
+func (m *OnceMapOf[K, V]) Delete(key K) {
+	m.m.Delete(key)
+}
+
+// Len returns the number of keys in the map, including those whose values are
+// being initialized.
+func (m *OnceMapOf[K, V]) Len() int {
+	return m.m.Len()
+}
+
+// Range iteratively applies the given function to each key and its value until
+// the function returns false, skipping the keys whose values are being
+// initialized. It gives the same guarantees as MapOf.Range.
+func (m *OnceMapOf[K, V]) Range(f func(key K, value V) bool) {
+	m.m.Range(func(k K, s *onceSlot[V]) bool {
+		select {
+		case <-s.ready:
+			return s.failed || f(k, s.value)
+		default:
+			return true
+		}
+	})
+}
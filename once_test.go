@@ -0,0 +1,93 @@
+package cmap_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/decillion/go-cmap"
+)
+
+func TestOnceMapInitializesOnce(t *testing.T) {
+	const goroutines = 8
+	m := cmap.NewOnceMapOf[string, *int](cmap.StringHasher)
+	var calls atomic.Int32
+	init := func() *int {
+		calls.Add(1)
+		time.Sleep(10 * time.Millisecond) // lets the other goroutines wait for the value
+		return new(int)
+	}
+	values := make([]*int, goroutines)
+	created := make([]bool, goroutines)
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var loaded bool
+			values[g], loaded = m.GetOrCreate("key", init)
+			created[g] = !loaded
+		}()
+	}
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("init is called %v times; want once", n)
+	}
+	n := 0
+	for g := range values {
+		if values[g] != values[0] || values[g] == nil {
+			t.Fatalf("GetOrCreate returns %v; want the same value to every goroutine", values)
+		}
+		if created[g] {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Errorf("%v goroutines create the value; want one", n)
+	}
+	if v, ok := m.Load("key"); !ok || v != values[0] || m.Len() != 1 {
+		t.Errorf(`Load("key") = %v, %v and Len() = %v; want %v, true and 1`, v, ok, m.Len(), values[0])
+	}
+}
+
+func TestOnceMapPanic(t *testing.T) {
+	m := cmap.NewOnceMap(cmap.DefaultHasher)
+	started, release := make(chan struct{}), make(chan struct{})
+	done := make(chan interface{})
+	go func() {
+		defer func() { done <- recover() }()
+		m.GetOrCreate("key", func() interface{} {
+			close(started)
+			<-release
+			panic("no value")
+		})
+	}()
+	<-started
+	waiter := make(chan interface{})
+	go func() {
+		v, _ := m.GetOrCreate("key", func() interface{} { return 1 })
+		waiter <- v
+	}()
+	time.Sleep(10 * time.Millisecond) // lets the waiter wait for the value
+	close(release)
+	if r := <-done; r != "no value" {
+		t.Errorf("GetOrCreate recovers %v from a panicking init; want no value", r)
+	}
+	if v := <-waiter; v != 1 {
+		t.Errorf("GetOrCreate returns %v to the waiter after a panic; want 1", v)
+	}
+
+	m.Delete("key")
+	if _, ok := m.Load("key"); ok || m.Len() != 0 {
+		t.Errorf("the deleted key is loaded or Len() = %v", m.Len())
+	}
+	m.GetOrCreate("a", func() interface{} { return 2 })
+	m.Range(func(key, value interface{}) bool {
+		if key != "a" || value != 2 {
+			t.Errorf("Range visits %v with %v; want a with 2", key, value)
+		}
+		return true
+	})
+}
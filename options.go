@@ -0,0 +1,159 @@
+package cmap
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+)
+
+// Option configures when the tables of a map are resized. Options are given
+// to the constructors of maps, such as NewMap and NewMapOf.
+type Option func(o *options)
+
+// options are the thresholds that trigger resizes of the tables of a map.
+type options struct {
+	iniCapacity   uint    // the number of buckets of an empty table
+	minLoadFactor float64 // the load factor of a table shrunk by deletions
+	maxLoadFactor float64 // the load factor beyond which a table grows
+	maxBucketSize uint    // the size of a bucket beyond which a table grows
+	minMapSize    uint    // the number of keys below which a table is not grown
+	maxKeys       int64   // the limit of the number of keys or their cost, or zero if unlimited
+	cost          any     // the func(value V) int64 giving the cost of a value, or nil
+	policy        Policy  // what to do with a new key stored in a full map
+	onEvict       any     // the func(key K, value V) called on evicted keys, or nil
+	onExpire      any     // the func(key K, value V) called on expired keys, or nil
+	randomOrder   bool    // whether Range starts at a random position
+	counters      bool    // whether the operations are counted for Stats
+	hotKeys       int     // the number of keys tracked for TopKeys, or zero if none
+	hotEvery      int     // one in every hotEvery operations is sampled for TopKeys
+	ageKeys       int     // the number of keys whose times of stores are kept for AgeHistogram, or zero if none
+	ageEvery      int     // one in every ageEvery keys is sampled for AgeHistogram
+	keyCodec      any     // the Codec[K] of WriteTo and ReadFrom, or nil
+	valueCodec    any     // the Codec[V] of WriteTo and ReadFrom, or nil
+	msgpackKeys   any     // the Codec[K] of MarshalMsgpack and UnmarshalMsgpack, or nil
+	msgpackValues any     // the Codec[V] of MarshalMsgpack and UnmarshalMsgpack, or nil
+	resizeHook    func(oldCapacity, newCapacity uint, elapsed time.Duration)
+	hook          Hook                  // the hook of WithHook, or nil
+	logger        *slog.Logger          // the logger of WithLogger, or nil
+	stringHasher  func(s string) uint64 // the hash function of WithStringHasher, or nil
+
+	minMapSizeSet bool
+}
+
+// newOptions returns the options with the given ones applied to the defaults.
+// The minimum map size follows the initial capacity unless it is specified.
+func newOptions(opts []Option) (o options) {
+	o = options{
+		iniCapacity:   iniCapacity,
+		minLoadFactor: minLoadFactor,
+		maxLoadFactor: maxLoadFactor,
+		maxBucketSize: maxBucketSize,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if !o.minMapSizeSet {
+		o.minMapSize = o.iniCapacity * midLoadFactor
+	}
+	return
+}
+
+// fitCapacity returns the number of buckets of a table that holds the given
+// number of keys at the minimum load factor.
+func (o *options) fitCapacity(keys uint) (capacity uint) {
+	capacity = uint(float64(keys) / o.minLoadFactor)
+	if capacity < o.iniCapacity {
+		capacity = o.iniCapacity
+	}
+	return
+}
+
+// reserveCapacity returns the number of buckets of a table that holds the
+// given number of keys halfway between the minimum and maximum load factors,
+// so that the table does not grow while the keys are inserted.
+func (o *options) reserveCapacity(keys uint) (capacity uint) {
+	capacity = uint(math.Ceil(float64(keys) * 2 / (o.minLoadFactor + o.maxLoadFactor)))
+	if capacity < o.iniCapacity {
+		capacity = o.iniCapacity
+	}
+	return
+}
+
+// WithInitialCapacity sets the number of buckets each shard of an empty map
+// starts with. A larger capacity saves resizes while the map is filled, and a
+// smaller one saves memory in maps that stay small. It panics if buckets is
+// less than two, since a table of one bucket could not grow.
+func WithInitialCapacity(buckets uint) Option {
+	if buckets < 2 {
+		panic(fmt.Sprintf("cmap: WithInitialCapacity(%v) out of range", buckets))
+	}
+	return func(o *options) { o.iniCapacity = buckets }
+}
+
+// WithLoadFactors sets the average numbers of keys per bucket between which a
+// table is kept. A table grows when its load factor exceeds max, and a table
+// with many deleted keys shrinks to the load factor min. Lower load factors
+// make lookups faster at the cost of memory. It panics unless 0 < min < max.
+func WithLoadFactors(min, max float64) Option {
+	if !(0 < min && min < max) {
+		panic(fmt.Sprintf("cmap: WithLoadFactors(%v, %v) out of range", min, max))
+	}
+	return func(o *options) { o.minLoadFactor, o.maxLoadFactor = min, max }
+}
+
+// WithMaxBucketSize sets the number of keys in a bucket beyond which a table
+// grows regardless of its load factor. It bounds the time of the lookup of
+// the worst key when hashes collide. It panics if size is zero.
+func WithMaxBucketSize(size uint) Option {
+	if size == 0 {
+		panic("cmap: WithMaxBucketSize of zero")
+	}
+	return func(o *options) { o.maxBucketSize = size }
+}
+
+// WithMinMapSize sets the number of keys in a table below which the table is
+// not grown, so that small maps do not spend time on resizes. Such a table is
+// only shrunk back to the initial capacity once keys are deleted from it. The
+// size defaults to four times the initial capacity.
+func WithMinMapSize(size uint) Option {
+	return func(o *options) { o.minMapSize, o.minMapSizeSet = size, true }
+}
+
+// WithResizeHook sets the function called every time the table of a shard is
+// replaced with a new one holding its keys, namely when the table is resized
+// and when Compact or Reserve rebuilds it. The function is given the numbers of
+// buckets of the old and new tables and the time taken to fill the new table.
+// An automatic resize takes longer than copying the keys since it proceeds
+// bit by bit with updates. The function is called while the shard is locked,
+// so it must not call the methods of the map and should return quickly.
+func WithResizeHook(hook func(oldCapacity, newCapacity uint, elapsed time.Duration)) Option {
+	return func(o *options) { o.resizeHook = hook }
+}
+
+// WithRandomOrder makes Range, and the iterators built on it, start at a
+// random shard and a random bucket of each shard every time, as Range of the
+// built-in map does. Code relying on the order of keys thus fails early, and
+// a Range stopped after a few keys visits a different sample of the map every
+// time. The order is not randomized in the deterministic mode.
+func WithRandomOrder() Option {
+	return func(o *options) { o.randomOrder = true }
+}
+
+// WithStringHasher makes the map hash the keys that are strings by the given
+// function instead of the one given to the constructor, which still hashes the
+// keys of the other types. A function processing eight bytes at a time, such
+// as hashers.XXHash64 or hashers.WyHash, hashes long keys such as URLs much
+// faster than one processing a byte at a time, such as hashers.String. The
+// option is ignored by the maps whose keys are hashed to 128 bits.
+func WithStringHasher(hasher func(s string) uint64) Option {
+	return func(o *options) { o.stringHasher = hasher }
+}
+
+// WithCounters makes the map count the hits and misses of Load, the values
+// stored, the keys deleted, and the keys evicted, which are reported by Stats.
+// The counters are kept per shard apart from the rest of the shard, but they
+// still cost every operation an atomic addition, so they are off by default.
+func WithCounters() Option {
+	return func(o *options) { o.counters = true }
+}
@@ -0,0 +1,187 @@
+package cmap
+
+import (
+	"iter"
+	"sync"
+	"sync/atomic"
+)
+
+// OrderedMapOf is a concurrent map with keys of type K and values of type V
+// that remembers the order in which keys are inserted. Range visits keys in
+// that order, and Oldest and Newest return the first and last pairs.
+//
+// The pairs are threaded on a doubly linked list, which is updated together
+// with the map inside a single critical section. Updating the value of an
+// existing key does not move the key. Load never blocks.
+type OrderedMapOf[K comparable, V any] struct {
+	mu    sync.Mutex
+	nodes *MapOf[K, *orderedNode[K, V]]
+	root  orderedNode[K, V] // root.next is the oldest node and root.prev the newest
+}
+
+// orderedNode is a pair of an OrderedMapOf. The links are guarded by the mutex
+// of the map. A removed node keeps its next link, so that Range standing at
+// the node can go on to the nodes after it.
+type orderedNode[K comparable, V any] struct {
+	key        K
+	value      atomic.Pointer[V]
+	prev, next *orderedNode[K, V]
+	removed    bool
+}
+
+// OrderedMap is a concurrent map that remembers the insertion order and whose
+// keys and values are of arbitrary types.
+type OrderedMap = OrderedMapOf[interface{}, interface{}]
+
+// NewOrderedMap returns an empty ordered map whose keys are hashed by the
+// given function.
+func NewOrderedMap[H Hash](hasher func(key interface{}) H) (m *OrderedMap) {
+	return NewOrderedMapOf[interface{}, interface{}](hasher)
+}
+
+// NewOrderedMapOf returns an empty ordered map with keys of type K and values
+// of type V, where keys are hashed by the given function.
+func NewOrderedMapOf[K comparable, V any, H Hash](hasher func(key K) H) (m *OrderedMapOf[K, V]) {
+	m = &OrderedMapOf[K, V]{nodes: NewMapOf[K, *orderedNode[K, V]](hasher)}
+	m.root.next, m.root.prev = &m.root, &m.root
+	return
+}
+
+// Load returns the value associated with the given key and true if the key
+// exists. Otherwise, it returns the zero value and false.
+func (m *OrderedMapOf[K, V]) Load(key K) (value V, ok bool) {
+	n, ok := m.nodes.Load(key)
+	if !ok {
+		return
+	}
+	return *n.value.Load(), true
+}
+
+// Store sets the value for the given key. A new key becomes the newest one,
+// while an existing key keeps its place.
+func (m *OrderedMapOf[K, V]) Store(key K, value V) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if n, ok := m.nodes.Load(key); ok {
+		n.value.Store(&value)
+		return
+	}
+	m.insert(key, value)
+}
+
+// LoadOrStore returns the existing value of the given key and true if the key
+// exists. Otherwise, it stores the given value as the newest and returns the
+// value and false.
+func (m *OrderedMapOf[K, V]) LoadOrStore(key K, value V) (actual V, loaded bool) {
+	if actual, loaded = m.Load(key); loaded {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if actual, loaded = m.Load(key); loaded {
+		return
+	}
+	m.insert(key, value)
+	return value, false
+}
+
+// insert appends a node of the given key and value to the list and the map.
+// The caller must hold m.mu.
+func (m *OrderedMapOf[K, V]) insert(key K, value V) {
+	n := &orderedNode[K, V]{key: key, prev: m.root.prev, next: &m.root}
+	n.value.Store(&value)
+	n.prev.next = n
+	m.root.prev = n
+	m.nodes.Store(key, n)
+}
+
+// Delete removes the given key and its associated value.
+func (m *OrderedMapOf[K, V]) Delete(key K) {
+	m.LoadAndDelete(key)
+}
+
+// LoadAndDelete removes the given key and returns the associated value and
+// true if the key exists. Otherwise, it returns the zero value and false.
+func (m *OrderedMapOf[K, V]) LoadAndDelete(key K) (value V, loaded bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, loaded := m.nodes.LoadAndDelete(key)
+	if !loaded {
+		return
+	}
+	m.unlink(n)
+	return *n.value.Load(), true
+}
+
+// unlink removes the node from the list. The caller must hold m.mu.
+func (m *OrderedMapOf[K, V]) unlink(n *orderedNode[K, V]) {
+	n.prev.next = n.next
+	n.next.prev = n.prev
+	n.prev = nil
+	n.removed = true
+}
+
+// Oldest returns the key inserted first among the keys in the map and its
+// value, and true. It returns the zero values and false if the map is empty.
+func (m *OrderedMapOf[K, V]) Oldest() (key K, value V, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.pair(m.root.next)
+}
+
+// Newest returns the key inserted last among the keys in the map and its
+// value, and true. It returns the zero values and false if the map is empty.
+func (m *OrderedMapOf[K, V]) Newest() (key K, value V, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.pair(m.root.prev)
+}
+
+// pair returns the pair of the node and true unless the node is the root.
+func (m *OrderedMapOf[K, V]) pair(n *orderedNode[K, V]) (key K, value V, ok bool) {
+	if n == &m.root {
+		return
+	}
+	return n.key, *n.value.Load(), true
+}
+
+// Len returns the number of pairs in the map.
+func (m *OrderedMapOf[K, V]) Len() int {
+	return m.nodes.Len()
+}
+
+// Range iteratively applies the given function to each key-value pair in the
+// order of insertion until the function returns false.
+//
+// Range visits exactly once every key that exists during the whole call, and
+// also visits the keys inserted during Range that still exist when Range
+// reaches them. A key deleted and inserted again during Range becomes the
+// newest key, so it may be visited twice. The map is locked only while Range
+// steps from a pair to the next, so the function may update the map.
+func (m *OrderedMapOf[K, V]) Range(f func(key K, value V) bool) {
+	m.mu.Lock()
+	n := m.root.next
+	for n != &m.root {
+		k, v := n.key, *n.value.Load()
+		m.mu.Unlock()
+		if !f(k, v) {
+			return
+		}
+		m.mu.Lock()
+		for n = n.next; n.removed; n = n.next {
+		}
+	}
+	m.mu.Unlock()
+}
+
+// All returns an iterator over the key-value pairs in the map in the order of
+// insertion, which gives the same guarantees as Range.
+func (m *OrderedMapOf[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(key K, value V) bool) {
+		m.Range(yield)
+	}
+}
@@ -0,0 +1,92 @@
+package cmap_test
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+	"testing/quick"
+
+	"github.com/decillion/go-cmap"
+)
+
+type orderedMapCall struct {
+	Delete     bool
+	Key, Value uint8
+}
+
+func TestOrderedMapMatchesBuiltInMap(t *testing.T) {
+	f := func(calls []orderedMapCall) bool {
+		m := cmap.NewOrderedMapOf[uint64, uint64](cmap.Uint64Hasher)
+		builtin := make(map[uint64]uint64)
+		var order []uint64
+		for _, c := range calls {
+			k, v := uint64(c.Key%16), uint64(c.Value)
+			if c.Delete {
+				if _, ok := builtin[k]; ok {
+					delete(builtin, k)
+					order = slices.DeleteFunc(order, func(o uint64) bool { return o == k })
+				}
+				m.Delete(k)
+				continue
+			}
+			if _, ok := builtin[k]; !ok {
+				order = append(order, k)
+			}
+			builtin[k] = v
+			m.Store(k, v)
+		}
+		for i := uint64(0); i < 16; i++ {
+			v, ok := m.Load(i)
+			if w, found := builtin[i]; ok != found || v != w {
+				return false
+			}
+		}
+		var keys []uint64
+		m.Range(func(k, v uint64) bool {
+			keys = append(keys, k)
+			return v == builtin[k]
+		})
+		if !slices.Equal(keys, order) {
+			return false
+		}
+		oldest, _, ok := m.Oldest()
+		newest, _, _ := m.Newest()
+		if len(order) == 0 {
+			return !ok && m.Len() == 0
+		}
+		return oldest == order[0] && newest == order[len(order)-1] && m.Len() == len(order)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOrderedMapRangeWithUpdates(t *testing.T) {
+	m := cmap.NewOrderedMapOf[int, int](func(key int) uint64 { return uint64(key) })
+	for i := 0; i < 10; i++ {
+		m.Store(i, i)
+	}
+	var keys []int
+	m.Range(func(k, v int) bool {
+		keys = append(keys, k)
+		switch {
+		case k == 2:
+			m.Delete(2) // the key being visited
+			m.Delete(3)
+			m.Delete(4)
+		case k == 5:
+			m.Store(10, 10)
+			m.Store(0, -1) // keeps its place
+		}
+		return true
+	})
+	if want := []int{0, 1, 2, 5, 6, 7, 8, 9, 10}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("Range visited %v; want %v", keys, want)
+	}
+	if k, v, ok := m.Oldest(); k != 0 || v != -1 || !ok {
+		t.Errorf("Oldest() = %v, %v, %v; want 0, -1, true", k, v, ok)
+	}
+	if k, v, ok := m.Newest(); k != 10 || v != 10 || !ok {
+		t.Errorf("Newest() = %v, %v, %v; want 10, 10, true", k, v, ok)
+	}
+}
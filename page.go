@@ -0,0 +1,116 @@
+package cmap
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/decillion/go-cmap/hmap"
+)
+
+// Entry is a key-value pair of a map.
+type Entry[K comparable, V any] = hmap.Entry[K, V]
+
+// Cursor is the position of a paged iteration by RangePage. The zero value is
+// the beginning of a map. A cursor is a value independent of the map, so it
+// can be kept, or sent to a client as text, between the pages.
+type Cursor struct {
+	hash uint64 // the smallest hash of the keys not yet visited
+	done bool   // whether all the keys have been visited
+}
+
+// Done reports whether the cursor is at the end of a map.
+func (c Cursor) Done() bool {
+	return c.done
+}
+
+// MarshalText encodes the cursor as text, which UnmarshalText decodes.
+func (c Cursor) MarshalText() ([]byte, error) {
+	if c.done {
+		return []byte("end"), nil
+	}
+	return strconv.AppendUint(nil, c.hash, 16), nil
+}
+
+// UnmarshalText decodes a cursor encoded by MarshalText.
+func (c *Cursor) UnmarshalText(text []byte) error {
+	if string(text) == "end" {
+		*c = Cursor{done: true}
+		return nil
+	}
+	hash, err := strconv.ParseUint(string(text), 16, 64)
+	if err != nil {
+		return errors.New("cmap: invalid cursor " + strconv.Quote(string(text)))
+	}
+	*c = Cursor{hash: hash}
+	return nil
+}
+
+// RangePage returns the key-value pairs of a page of the map that starts at
+// the given cursor, along with the cursor of the next page, which is done
+// once the whole map has been visited. No state of the iteration is kept in
+// the map, so the pages can be requested at any time, and an iteration can be
+// abandoned at any page.
+//
+// The keys are visited in the order of their hashes. A page holds at least
+// limit pairs unless it is the last one, and holds more only if the hashes of
+// several keys collide at the end of the page. Paging through the map from
+// the zero cursor visits each key at most once, and visits exactly once every
+// key that exists from the first page to the last one, even if the map is
+// resized in between. Building a page takes time proportional to the number
+// of keys in the shards it spans. It panics if limit is not positive.
+func (m *MapOf[K, V]) RangePage(token Cursor, limit int) (entries []Entry[K, V], next Cursor) {
+	if limit <= 0 {
+		panic(fmt.Sprintf("cmap: RangePage limit %v out of range", limit))
+	}
+	if token.done {
+		return nil, token
+	}
+	type hashed struct {
+		hash uint64
+		Entry[K, V]
+	}
+	var page []hashed
+	from := token.hash
+	for i := int(from >> m.shift); i < len(m.shards); i++ {
+		start := len(page)
+		m.settle(&m.shards[i]).RangeHashed(func(k K, d hmap.Digest, v V) bool {
+			if d.Uint64() >= from {
+				page = append(page, hashed{d.Uint64(), Entry[K, V]{Key: k, Value: v}})
+			}
+			return true
+		})
+		slices.SortFunc(page[start:], func(a, b hashed) int {
+			return cmp.Compare(a.hash, b.hash)
+		})
+		if len(page) > limit {
+			// Keys of the same hash are kept in the same page, since
+			// the cursor could not tell them apart.
+			n := limit
+			for n < len(page) && page[n].hash == page[limit-1].hash {
+				n++
+			}
+			if n < len(page) {
+				next = Cursor{hash: page[n].hash}
+				page = page[:n]
+				break
+			}
+		}
+		if i == len(m.shards)-1 {
+			next = Cursor{done: true}
+			break
+		}
+		from = uint64(i+1) << m.shift
+		if len(page) >= limit {
+			next = Cursor{hash: from}
+			break
+		}
+	}
+	entries = make([]Entry[K, V], len(page))
+	for i, p := range page {
+		entries[i] = p.Entry
+	}
+	return
+}
@@ -0,0 +1,50 @@
+package cmap
+
+// maxSampleTries is the number of buckets probed for an entry of Sample
+// before it gives up on the entry.
+const maxSampleTries = 1 << 10
+
+// Sample returns n entries of the map drawn independently at random, so a key
+// may be drawn more than once, without visiting the whole map. A shard is
+// chosen by the number of its keys, and then its buckets are probed at random
+// until an entry is drawn from one of them, with a chance proportional to the
+// number of entries in the bucket, which makes every key about as likely to be
+// drawn as another. It returns fewer entries only if the map is empty, or
+// nearly so while it is updated concurrently. Like Stats, it is not a
+// consistent snapshot of a map updated concurrently.
+func (m *MapOf[K, V]) Sample(n int) (entries []Entry[K, V]) {
+	sizes := make([]int64, len(m.shards))
+	var total int64
+	for i := range m.shards {
+		sizes[i] = max(m.shards[i].size.Load(), 0)
+		total += sizes[i]
+	}
+	if n <= 0 || total == 0 {
+		return nil
+	}
+	r := sampleSource()
+	var bucket []Entry[K, V]
+	for len(entries) < n {
+		i, x := 0, r.Int64N(total)
+		for ; x >= sizes[i]; i++ {
+			x -= sizes[i]
+		}
+		drawn := false
+		for tries := 0; !drawn && tries < maxSampleTries; tries++ {
+			h := m.shards[i].table()
+			buckets, largest := h.StatBuckets()
+			bucket = bucket[:0]
+			h.RangePart(r.IntN(int(buckets)), int(buckets), func(k K, v V) bool {
+				bucket = append(bucket, Entry[K, V]{Key: k, Value: v})
+				return true
+			})
+			if drawn = r.IntN(max(int(largest), len(bucket), 1)) < len(bucket); drawn {
+				entries = append(entries, bucket[r.IntN(len(bucket))])
+			}
+		}
+		if !drawn {
+			break
+		}
+	}
+	return
+}
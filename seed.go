@@ -0,0 +1,46 @@
+//go:build !cmap_deterministic
+
+package cmap
+
+import (
+	"hash/maphash"
+	"math/rand/v2"
+)
+
+// backgroundMigration is whether resizes are carried out by goroutines.
+const backgroundMigration = true
+
+// processSeed is the seed of SeededHasher and SeededHasherOf. It is chosen at
+// random when the process starts.
+var processSeed = maphash.MakeSeed()
+
+func seededHasher(key interface{}) uint64 {
+	if s, ok := key.(string); ok {
+		return maphash.String(processSeed, s)
+	}
+	return maphash.Comparable(processSeed, key)
+}
+
+func seededHasherOf[K comparable](key K) uint64 {
+	return maphash.Comparable(processSeed, key)
+}
+
+// newSeed returns a random nonzero seed of a map.
+func newSeed() uint64 {
+	for {
+		if seed := rand.Uint64(); seed != 0 {
+			return seed
+		}
+	}
+}
+
+// randomOffset returns a random number in [0, n), where n is positive, at
+// which Range starts in the random order.
+func randomOffset(n int) int {
+	return rand.IntN(n)
+}
+
+// sampleSource returns the source of random numbers of a call to Sample.
+func sampleSource() *rand.Rand {
+	return rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+}
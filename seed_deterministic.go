@@ -0,0 +1,51 @@
+//go:build cmap_deterministic
+
+package cmap
+
+import (
+	"math/rand/v2"
+
+	"github.com/decillion/go-cmap/hashers"
+)
+
+// In the deterministic mode, enabled by the build tag cmap_deterministic, every
+// map has the same seed and SeededHasher does not depend on a random seed, so
+// keys are placed into the same buckets in every process. Range thus visits
+// keys in the same order whenever the same operations are applied to the map,
+// which makes tests and golden files exercising code on top of the package
+// reproducible. The mode must not be used in production, because it exposes
+// maps to keys crafted to collide.
+
+// backgroundMigration is whether resizes are carried out by goroutines. It is
+// disabled because the order of keys visited by Range depends on the progress
+// of a resize.
+const backgroundMigration = false
+
+// fixedSeed is the seed of every map in the deterministic mode.
+const fixedSeed = 0x9e3779b97f4a7c15
+
+func seededHasher(key interface{}) uint64 {
+	return hashers.TypeHasher64(key)
+}
+
+func seededHasherOf[K comparable](key K) uint64 {
+	return hashers.TypeHasher64(key)
+}
+
+// newSeed returns the fixed seed of a map.
+func newSeed() uint64 {
+	return fixedSeed
+}
+
+// randomOffset returns zero, so that Range visits keys in the same order even
+// in maps with the random order.
+func randomOffset(n int) int {
+	return 0
+}
+
+// sampleSource returns a source of random numbers with a fixed seed, so that
+// Sample draws the same entries whenever the same operations are applied to
+// the map.
+func sampleSource() *rand.Rand {
+	return rand.New(rand.NewPCG(fixedSeed, fixedSeed))
+}
@@ -0,0 +1,83 @@
+package cmap
+
+// SetOf is a concurrent set of elements of type K. It is built on MapOf and
+// shares its concurrency properties, but does not store values.
+type SetOf[K comparable] struct {
+	m *MapOf[K, struct{}]
+}
+
+// Set is a concurrent set whose elements are of arbitrary types.
+type Set = SetOf[interface{}]
+
+// NewSet returns an empty set whose elements are hashed by the given function.
+func NewSet[H Hash](hasher func(key interface{}) H) (s *Set) {
+	return NewSetOf[interface{}](hasher)
+}
+
+// NewSetOf returns an empty set of elements of type K which are hashed by the
+// given function.
+func NewSetOf[K comparable, H Hash](hasher func(key K) H) (s *SetOf[K]) {
+	return &SetOf[K]{m: NewMapOf[K, struct{}](hasher)}
+}
+
+// Add adds the given element to the set. It reports whether the element was
+// added, that is, whether it did not exist in the set.
+func (s *SetOf[K]) Add(key K) (added bool) {
+	_, loaded := s.m.LoadOrStore(key, struct{}{})
+	return !loaded
+}
+
+// Remove removes the given element from the set. It reports whether the
+// element existed in the set.
+func (s *SetOf[K]) Remove(key K) (removed bool) {
+	_, removed = s.m.LoadAndDelete(key)
+	return
+}
+
+// Contains reports whether the given element exists in the set.
+func (s *SetOf[K]) Contains(key K) bool {
+	_, ok := s.m.Load(key)
+	return ok
+}
+
+// Len returns the number of elements in the set.
+func (s *SetOf[K]) Len() int {
+	return s.m.Len()
+}
+
+// Clear removes all elements from the set.
+func (s *SetOf[K]) Clear() {
+	s.m.Clear()
+}
+
+// Range iteratively applies the given function to each element until the
+// function returns false. It gives the same guarantees as MapOf.Range.
+func (s *SetOf[K]) Range(f func(key K) bool) {
+	s.m.Range(func(k K, _ struct{}) bool {
+		return f(k)
+	})
+}
+
+// Union returns a new set containing the elements in either the set or
+// other. The new set hashes its elements as the set does.
+func (s *SetOf[K]) Union(other *SetOf[K]) (union *SetOf[K]) {
+	union = &SetOf[K]{m: s.m.Clone()}
+	other.Range(func(k K) bool {
+		union.Add(k)
+		return true
+	})
+	return
+}
+
+// Intersect returns a new set containing the elements in both the set and
+// other. The new set hashes its elements as the set does.
+func (s *SetOf[K]) Intersect(other *SetOf[K]) (intersection *SetOf[K]) {
+	intersection = &SetOf[K]{m: NewMapOfFunc[K, struct{}](s.m.hasher, s.m.equal)}
+	s.Range(func(k K) bool {
+		if other.Contains(k) {
+			intersection.Add(k)
+		}
+		return true
+	})
+	return
+}
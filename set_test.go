@@ -0,0 +1,79 @@
+package cmap_test
+
+import (
+	"testing"
+	"testing/quick"
+
+	"github.com/decillion/go-cmap"
+)
+
+func newSet(keys []uint8) (s *cmap.SetOf[uint64], b map[uint64]bool) {
+	s, b = cmap.NewSetOf[uint64](cmap.Uint64Hasher), make(map[uint64]bool)
+	for _, k := range keys {
+		s.Add(uint64(k))
+		b[uint64(k)] = true
+	}
+	return
+}
+
+func setEquals(s *cmap.SetOf[uint64], b map[uint64]bool) bool {
+	n := 0
+	s.Range(func(k uint64) bool {
+		n++
+		return b[k]
+	})
+	return n == len(b) && s.Len() == len(b)
+}
+
+func TestSetMatchesBuiltInMap(t *testing.T) {
+	f := func(adds, removes []uint8) bool {
+		s, b := newSet(adds)
+		for _, k := range removes {
+			if s.Remove(uint64(k)) != b[uint64(k)] {
+				return false
+			}
+			delete(b, uint64(k))
+		}
+		for k := uint64(0); k < 1<<8; k++ {
+			if s.Contains(k) != b[k] {
+				return false
+			}
+		}
+		return setEquals(s, b)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSetAdd(t *testing.T) {
+	s := cmap.NewSet(cmap.DefaultHasher)
+	if !s.Add("a") {
+		t.Error("Add of a new element returned false")
+	}
+	if s.Add("a") {
+		t.Error("Add of an existing element returned true")
+	}
+}
+
+func TestSetUnionAndIntersect(t *testing.T) {
+	f := func(xs, ys []uint8) bool {
+		x, bx := newSet(xs)
+		y, by := newSet(ys)
+		union, intersection := make(map[uint64]bool), make(map[uint64]bool)
+		for k := range bx {
+			union[k] = true
+			if by[k] {
+				intersection[k] = true
+			}
+		}
+		for k := range by {
+			union[k] = true
+		}
+		return setEquals(x.Union(y), union) && setEquals(x.Intersect(y), intersection) &&
+			setEquals(x, bx) && setEquals(y, by)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,403 @@
+package cmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"slices"
+)
+
+// Codec encodes the keys or values of type T into the snapshots written by
+// WriteTo and decodes them back for ReadFrom. Encode appends the encoding of
+// the given value to b, and Decode decodes a value from the whole of data,
+// which it must not retain.
+type Codec[T any] interface {
+	Encode(b []byte, value T) ([]byte, error)
+	Decode(data []byte) (T, error)
+}
+
+// WithCodecs sets the codecs of the keys and values of a map for WriteTo and
+// ReadFrom, which by default encode the keys and values of the basic types,
+// namely strings, byte slices, booleans, integers, and floating-point numbers,
+// and nothing else. A nil codec stands for the default one. K and V must be
+// the types of the keys and values of the map, or else WriteTo and ReadFrom
+// fail.
+func WithCodecs[K comparable, V any](keys Codec[K], values Codec[V]) Option {
+	return func(o *options) { o.keyCodec, o.valueCodec = keys, values }
+}
+
+// snapshotMagic starts every snapshot, followed by the version of the format.
+var snapshotMagic = []byte("cmap\x01")
+
+// maxSnapshotReserve is the number of pairs at most for which the tables are
+// grown before a snapshot is read, so that a snapshot claiming more pairs than
+// it holds does not make the map allocate tables for all of them. The tables
+// of a larger snapshot grow as its pairs are stored.
+const maxSnapshotReserve = 1 << 20
+
+// WriteTo writes a snapshot of the key-value pairs the map contains at a
+// single point in time to w, and returns the number of bytes written. After
+// a magic number and the version of the format, the snapshot holds the number
+// of pairs as an unsigned varint followed by the pairs, each of which is the
+// key and the value encoded by their codecs and prefixed with their lengths as
+// unsigned varints. The pairs are written straight from
+// the tables while all the shards are locked, as GobEncode does, so updates of
+// the map wait until the snapshot is written.
+func (m *MapOf[K, V]) WriteTo(w io.Writer) (n int64, err error) {
+	m.lockAll()
+	defer m.unlockAll()
+	return m.writeSnapshot(w)
+}
+
+// writeSnapshot is WriteTo on the map whose shards are all locked.
+func (m *MapOf[K, V]) writeSnapshot(w io.Writer) (n int64, err error) {
+	keys, values, err := m.codecs()
+	if err != nil {
+		return 0, err
+	}
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	bw.Write(snapshotMagic)
+	buf := binary.AppendUvarint(nil, uint64(m.Len()))
+	var scratch []byte
+	for i := range m.shards {
+		m.shards[i].table().Range(func(k K, v V) bool {
+			if buf, scratch, err = appendPair(buf, scratch, keys, values, k, v); err != nil {
+				return false
+			}
+			_, err = bw.Write(buf)
+			buf = buf[:0]
+			return err == nil
+		})
+		if err != nil {
+			return cw.n, err
+		}
+	}
+	if _, err = bw.Write(buf); err == nil {
+		err = bw.Flush()
+	}
+	return cw.n, err
+}
+
+// appendPair appends a key-value pair of a snapshot to buf, encoding the key
+// and the value into scratch first.
+func appendPair[K comparable, V any](buf, scratch []byte, keys Codec[K], values Codec[V], k K, v V) (_, _ []byte, err error) {
+	if scratch, err = keys.Encode(scratch[:0], k); err != nil {
+		return buf, scratch, err
+	}
+	buf = append(binary.AppendUvarint(buf, uint64(len(scratch))), scratch...)
+	if scratch, err = values.Encode(scratch[:0], v); err != nil {
+		return buf, scratch, err
+	}
+	return append(binary.AppendUvarint(buf, uint64(len(scratch))), scratch...), scratch, nil
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = w.w.Write(p)
+	w.n += int64(n)
+	return
+}
+
+// ReadFrom reads a snapshot written by WriteTo from r and stores its key-value
+// pairs into the map, keeping the keys already in the map, and returns the
+// number of bytes read. The tables are grown for the pairs before they are
+// stored, so that the map is not resized while they are. Since r is read
+// through a buffer unless it is an io.ByteReader, bytes past the end of the
+// snapshot may be read from r.
+func (m *MapOf[K, V]) ReadFrom(r io.Reader) (n int64, err error) {
+	keys, values, err := m.codecs()
+	if err != nil {
+		return 0, err
+	}
+	br, ok := r.(snapshotReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	cr := &countingReader{r: br}
+	err = m.readSnapshot(cr, keys, values)
+	return cr.n, err
+}
+
+// readSnapshot reads a snapshot from cr and stores its key-value pairs into
+// the map. It returns io.EOF if cr ends before the snapshot starts.
+func (m *MapOf[K, V]) readSnapshot(cr *countingReader, keys Codec[K], values Codec[V]) (err error) {
+	magic := make([]byte, len(snapshotMagic))
+	if _, err = io.ReadFull(cr, magic); err != nil {
+		return err
+	}
+	if !bytes.Equal(magic, snapshotMagic) {
+		return errors.New("cmap: not a snapshot written by WriteTo")
+	}
+	count, err := binary.ReadUvarint(cr)
+	if err != nil {
+		return err
+	}
+	if count > uint64(math.MaxInt-m.Len()) {
+		return errors.New("cmap: too many pairs in the snapshot")
+	}
+	m.Reserve(m.Len() + int(min(count, maxSnapshotReserve)))
+	var buf []byte
+	for i := uint64(0); i < count; i++ {
+		var k K
+		if buf, err = readField(cr, buf); err != nil {
+			return err
+		}
+		if k, err = keys.Decode(buf); err != nil {
+			return err
+		}
+		if buf, err = readField(cr, buf); err != nil {
+			return err
+		}
+		v, err := values.Decode(buf)
+		if err != nil {
+			return err
+		}
+		m.Store(k, v)
+	}
+	return nil
+}
+
+// snapshotReader is the reader a snapshot is read from.
+type snapshotReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r snapshotReader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (n int, err error) {
+	n, err = r.r.Read(p)
+	r.n += int64(n)
+	return
+}
+
+func (r *countingReader) ReadByte() (c byte, err error) {
+	if c, err = r.r.ReadByte(); err == nil {
+		r.n++
+	}
+	return
+}
+
+// fieldStep is the number of bytes of a field read at a time, so that the
+// memory allocated for a field grows with the bytes actually read instead of
+// following the length claimed by a truncated or hostile snapshot.
+const fieldStep = 1 << 20
+
+// readField reads a field prefixed with its length into buf, reusing buf if
+// it is large enough.
+func readField(r *countingReader, buf []byte) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return buf, noEOF(err)
+	}
+	if size > math.MaxInt32 {
+		return buf, errors.New("cmap: a field of the snapshot is too large")
+	}
+	for buf = buf[:0]; len(buf) < int(size); {
+		n := min(int(size)-len(buf), fieldStep)
+		buf = slices.Grow(buf, n)[:len(buf)+n]
+		if _, err = io.ReadFull(r, buf[len(buf)-n:]); err != nil {
+			return buf, noEOF(err)
+		}
+	}
+	return buf, nil
+}
+
+// noEOF turns io.EOF into io.ErrUnexpectedEOF, since a snapshot ends only
+// after its last pair.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// codecs returns the codecs of the keys and values of the map.
+func (m *MapOf[K, V]) codecs() (keys Codec[K], values Codec[V], err error) {
+	if keys, err = codecOf[K](m.opts.keyCodec, "keys"); err != nil {
+		return
+	}
+	values, err = codecOf[V](m.opts.valueCodec, "values")
+	return
+}
+
+// codecOf returns the codec given by WithCodecs, or the default codec if c is
+// nil.
+func codecOf[T any](c any, of string) (Codec[T], error) {
+	if c == nil {
+		if !(binaryCodec[T]{}).supports() {
+			return nil, fmt.Errorf("cmap: no codec for the %v of type %v; see WithCodecs", of, reflect.TypeFor[T]())
+		}
+		return binaryCodec[T]{}, nil
+	}
+	codec, ok := c.(Codec[T])
+	if !ok {
+		return nil, fmt.Errorf("cmap: the codec of the %v is %T, not a Codec[%v]", of, c, reflect.TypeFor[T]())
+	}
+	return codec, nil
+}
+
+// binaryCodec is the default codec, which encodes integers as varints,
+// floating-point numbers by their bits in little-endian order, and strings and
+// byte slices as they are.
+type binaryCodec[T any] struct{}
+
+// supports reports whether the codec supports T.
+func (binaryCodec[T]) supports() bool {
+	switch any(new(T)).(type) {
+	case *string, *[]byte, *bool, *int, *int8, *int16, *int32, *int64,
+		*uint, *uint8, *uint16, *uint32, *uint64, *uintptr, *float32, *float64:
+		return true
+	}
+	return false
+}
+
+func (binaryCodec[T]) Encode(b []byte, value T) ([]byte, error) {
+	switch v := any(value).(type) {
+	case string:
+		return append(b, v...), nil
+	case []byte:
+		return append(b, v...), nil
+	case bool:
+		if v {
+			return append(b, 1), nil
+		}
+		return append(b, 0), nil
+	case int:
+		return binary.AppendVarint(b, int64(v)), nil
+	case int8:
+		return binary.AppendVarint(b, int64(v)), nil
+	case int16:
+		return binary.AppendVarint(b, int64(v)), nil
+	case int32:
+		return binary.AppendVarint(b, int64(v)), nil
+	case int64:
+		return binary.AppendVarint(b, v), nil
+	case uint:
+		return binary.AppendUvarint(b, uint64(v)), nil
+	case uint8:
+		return binary.AppendUvarint(b, uint64(v)), nil
+	case uint16:
+		return binary.AppendUvarint(b, uint64(v)), nil
+	case uint32:
+		return binary.AppendUvarint(b, uint64(v)), nil
+	case uint64:
+		return binary.AppendUvarint(b, v), nil
+	case uintptr:
+		return binary.AppendUvarint(b, uint64(v)), nil
+	case float32:
+		return binary.LittleEndian.AppendUint32(b, math.Float32bits(v)), nil
+	case float64:
+		return binary.LittleEndian.AppendUint64(b, math.Float64bits(v)), nil
+	}
+	return b, fmt.Errorf("cmap: no codec for %T", value)
+}
+
+func (binaryCodec[T]) Decode(data []byte) (value T, err error) {
+	switch p := any(&value).(type) {
+	case *string:
+		*p = string(data)
+	case *[]byte:
+		*p = bytes.Clone(data)
+	case *bool:
+		if err = checkLen(data, 1); err == nil {
+			*p = data[0] != 0
+		}
+	case *int:
+		var n int64
+		n, err = decodeVarint(data, 64)
+		*p = int(n)
+	case *int8:
+		var n int64
+		n, err = decodeVarint(data, 8)
+		*p = int8(n)
+	case *int16:
+		var n int64
+		n, err = decodeVarint(data, 16)
+		*p = int16(n)
+	case *int32:
+		var n int64
+		n, err = decodeVarint(data, 32)
+		*p = int32(n)
+	case *int64:
+		*p, err = decodeVarint(data, 64)
+	case *uint:
+		var n uint64
+		n, err = decodeUvarint(data, 64)
+		*p = uint(n)
+	case *uint8:
+		var n uint64
+		n, err = decodeUvarint(data, 8)
+		*p = uint8(n)
+	case *uint16:
+		var n uint64
+		n, err = decodeUvarint(data, 16)
+		*p = uint16(n)
+	case *uint32:
+		var n uint64
+		n, err = decodeUvarint(data, 32)
+		*p = uint32(n)
+	case *uint64:
+		*p, err = decodeUvarint(data, 64)
+	case *uintptr:
+		var n uint64
+		n, err = decodeUvarint(data, 64)
+		*p = uintptr(n)
+	case *float32:
+		if err = checkLen(data, 4); err == nil {
+			*p = math.Float32frombits(binary.LittleEndian.Uint32(data))
+		}
+	case *float64:
+		if err = checkLen(data, 8); err == nil {
+			*p = math.Float64frombits(binary.LittleEndian.Uint64(data))
+		}
+	default:
+		err = fmt.Errorf("cmap: no codec for %v", reflect.TypeFor[T]())
+	}
+	return
+}
+
+var errBadField = errors.New("cmap: a field of the snapshot is malformed")
+
+// checkLen reports an error unless data has the given length.
+func checkLen(data []byte, n int) error {
+	if len(data) != n {
+		return errBadField
+	}
+	return nil
+}
+
+// decodeVarint decodes the whole of data as a varint of the given number of
+// bits.
+func decodeVarint(data []byte, bits int) (int64, error) {
+	n, size := binary.Varint(data)
+	if size != len(data) || size <= 0 || n<<(64-bits)>>(64-bits) != n {
+		return 0, errBadField
+	}
+	return n, nil
+}
+
+// decodeUvarint decodes the whole of data as an unsigned varint of the given
+// number of bits.
+func decodeUvarint(data []byte, bits int) (uint64, error) {
+	n, size := binary.Uvarint(data)
+	if size != len(data) || size <= 0 || n<<(64-bits)>>(64-bits) != n {
+		return 0, errBadField
+	}
+	return n, nil
+}
@@ -0,0 +1,128 @@
+package cmap_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"runtime"
+	"testing"
+	"testing/quick"
+
+	"github.com/decillion/go-cmap"
+)
+
+func TestSnapshotMatchesBuiltInMap(t *testing.T) {
+	f := func(builtin map[int32]string, trailer []byte) bool {
+		m := cmap.NewMapOf[int32, string](func(key int32) uint32 { return uint32(key) })
+		for k, v := range builtin {
+			m.Store(k, v)
+		}
+		var buf bytes.Buffer
+		written, err := m.WriteTo(&buf)
+		if err != nil || written != int64(buf.Len()) {
+			return false
+		}
+		buf.Write(trailer)
+		restored := cmap.NewMapOf[int32, string](func(key int32) uint32 { return uint32(key) })
+		read, err := restored.ReadFrom(&buf)
+		if err != nil || read != written || !bytes.Equal(buf.Bytes(), trailer) || restored.Len() != len(builtin) {
+			return false
+		}
+		for k, v := range builtin {
+			if w, ok := restored.Load(k); !ok || w != v {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+type codecPoint struct{ x, y int32 }
+
+type pointCodec struct{}
+
+func (pointCodec) Encode(b []byte, p codecPoint) ([]byte, error) {
+	return binary.LittleEndian.AppendUint32(binary.LittleEndian.AppendUint32(b, uint32(p.x)), uint32(p.y)), nil
+}
+
+func (pointCodec) Decode(data []byte) (codecPoint, error) {
+	if len(data) != 8 {
+		return codecPoint{}, errors.New("bad codecPoint")
+	}
+	return codecPoint{int32(binary.LittleEndian.Uint32(data)), int32(binary.LittleEndian.Uint32(data[4:]))}, nil
+}
+
+type floatCodec struct{}
+
+func (floatCodec) Encode(b []byte, f float64) ([]byte, error) { return append(b, byte(f)), nil }
+func (floatCodec) Decode(data []byte) (float64, error)        { return float64(data[0]), nil }
+
+func TestSnapshotCodecs(t *testing.T) {
+	hasher := func(p codecPoint) uint64 { return uint64(p.x)<<32 | uint64(uint32(p.y)) }
+	m := cmap.NewMapOf[codecPoint, []byte](hasher)
+	if _, err := m.WriteTo(io.Discard); err == nil {
+		t.Error("WriteTo succeeds with keys of a struct type without codecs")
+	}
+	m = cmap.NewMapOf[codecPoint, []byte](hasher, cmap.WithCodecs[codecPoint, []byte](pointCodec{}, nil))
+	m.Store(codecPoint{1, -2}, []byte("a"))
+	if _, err := m.WriteTo(io.Discard); err != nil {
+		t.Errorf("WriteTo = %v with the default codec of the values", err)
+	}
+
+	var buf bytes.Buffer
+	c := cmap.NewMapOf[codecPoint, float64](hasher, cmap.WithCodecs[codecPoint, float64](pointCodec{}, floatCodec{}))
+	c.Store(codecPoint{1, -2}, 3)
+	c.Store(codecPoint{-3, 4}, 5)
+	if _, err := c.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := bytes.Clone(buf.Bytes())
+	restored := cmap.NewMapOf[codecPoint, float64](hasher, cmap.WithCodecs[codecPoint, float64](pointCodec{}, floatCodec{}))
+	if _, err := restored.ReadFrom(&buf); err != nil || restored.Len() != 2 {
+		t.Errorf("ReadFrom = %v with %v keys; want nil with 2 keys", err, restored.Len())
+	}
+	if v, ok := restored.Load(codecPoint{-3, 4}); !ok || v != 5 {
+		t.Errorf("Load(codecPoint{-3, 4}) = %v, %v after ReadFrom; want 5, true", v, ok)
+	}
+
+	wrong := cmap.NewMapOf[codecPoint, float64](hasher, cmap.WithCodecs[codecPoint, []byte](pointCodec{}, nil))
+	if _, err := wrong.ReadFrom(bytes.NewReader(snapshot)); err == nil {
+		t.Error("ReadFrom succeeds with a codec of another type")
+	}
+	for n := 0; n < len(snapshot); n++ {
+		r := cmap.NewMapOf[codecPoint, float64](hasher, cmap.WithCodecs[codecPoint, float64](pointCodec{}, floatCodec{}))
+		if _, err := r.ReadFrom(bytes.NewReader(snapshot[:n])); err == nil {
+			t.Errorf("ReadFrom succeeds on the first %v bytes of a snapshot", n)
+		}
+	}
+	if _, err := restored.ReadFrom(bytes.NewReader([]byte("not a snapshot"))); err == nil {
+		t.Error("ReadFrom succeeds on garbage")
+	}
+
+	// A snapshot claiming 2^40 pairs that holds none.
+	hostile := binary.AppendUvarint([]byte("cmap\x01"), 1<<40)
+	if _, err := restored.ReadFrom(bytes.NewReader(hostile)); err == nil {
+		t.Error("ReadFrom succeeds on a snapshot claiming pairs it does not hold")
+	}
+	if stats := restored.Stats(); stats.Buckets > 1<<24 {
+		t.Errorf("ReadFrom grows the tables to %v buckets for a snapshot of no pairs", stats.Buckets)
+	}
+
+	// A key claiming 2 GiB of which a few bytes follow.
+	hostile = binary.AppendUvarint(binary.AppendUvarint([]byte("cmap\x01"), 1), math.MaxInt32)
+	hostile = append(hostile, "short"...)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, err := restored.ReadFrom(bytes.NewReader(hostile)); err == nil {
+		t.Error("ReadFrom succeeds on a snapshot with a truncated key")
+	}
+	runtime.ReadMemStats(&after)
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<24 {
+		t.Errorf("ReadFrom allocates %v bytes for a key of which 5 bytes are read", n)
+	}
+}
@@ -0,0 +1,185 @@
+package cmap
+
+import (
+	"cmp"
+	"expvar"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/decillion/go-cmap/hmap"
+)
+
+// Stats are the statistics of a map, which are collected from its shards one
+// by one. They are thus not a consistent snapshot if the map is updated
+// concurrently.
+type Stats struct {
+	Keys          int           // the number of keys, as returned by Len
+	Entries       uint          // the number of entries in the tables, including Tombstones
+	Tombstones    uint          // the number of logically deleted entries
+	Buckets       uint          // the total number of buckets of the tables
+	LargestBucket uint          // the number of entries in the largest bucket
+	Shards        int           // the number of shards
+	Resizing      int           // the number of shards whose tables are being resized
+	Resizes       int64         // the number of completed resizes of all the shards
+	LastResize    time.Duration // the duration of the latest resize, or zero if none
+
+	// The counts of operations since the map was created, which are zero
+	// unless the map is created with WithCounters.
+	Hits      int64 // the number of calls to Load that found the key
+	Misses    int64 // the number of calls to Load that did not find the key
+	Stores    int64 // the number of values stored by Store, Swap, and the like
+	Deletes   int64 // the number of keys removed, including evicted ones
+	Evictions int64 // the number of keys evicted to make room for others
+}
+
+// counters are the counts of operations on a shard. They start on a cache
+// line of their own, so that counting Load does not slow down the loads of the
+// table of the shard by the other cores.
+type counters struct {
+	_                                        [cacheLineSize]byte
+	hits, misses, stores, deletes, evictions atomic.Int64
+}
+
+// count adds one to the given counter of a shard if the map counts operations.
+func (m *MapOf[K, V]) count(c *atomic.Int64) {
+	if m.opts.counters {
+		c.Add(1)
+	}
+}
+
+// Stats returns the statistics of the map. The counts of entries and buckets
+// are of the current tables of the shards, not of the new tables being filled
+// by resizes in progress.
+func (m *MapOf[K, V]) Stats() (stats Stats) {
+	stats.Shards = len(m.shards)
+	var resizedAt int64
+	for i := range m.shards {
+		s := &m.shards[i]
+		h := s.table()
+		entries, deleted := h.StatEntries()
+		buckets, largest := h.StatBuckets()
+		stats.Keys += int(s.size.Load())
+		stats.Entries += entries
+		stats.Tombstones += deleted
+		stats.Buckets += buckets
+		if largest > stats.LargestBucket {
+			stats.LargestBucket = largest
+		}
+		if h.Next() != nil {
+			stats.Resizing++
+		}
+		stats.Resizes += s.resizes.Load()
+		if at := s.resizedAt.Load(); at > resizedAt {
+			resizedAt = at
+			stats.LastResize = time.Duration(s.lastResize.Load())
+		}
+		stats.Hits += s.counters.hits.Load()
+		stats.Misses += s.counters.misses.Load()
+		stats.Stores += s.counters.stores.Load()
+		stats.Deletes += s.counters.deletes.Load()
+		stats.Evictions += s.counters.evictions.Load()
+	}
+	return
+}
+
+// BucketHistogram returns the distribution of the lengths of the chains of
+// entries in the buckets of the current tables of the shards, in which the i-th
+// element is the number of buckets whose chains have i entries, including
+// tombstones. A hash function that spreads the keys well leaves few buckets
+// far longer than the others, while one that clusters keys shows up as a long
+// tail. Like Stats, it is not a consistent snapshot of a map updated
+// concurrently.
+func (m *MapOf[K, V]) BucketHistogram() (histogram []uint) {
+	for i := range m.shards {
+		for n, buckets := range m.shards[i].table().BucketHistogram() {
+			if n == len(histogram) {
+				histogram = append(histogram, 0)
+			}
+			histogram[n] += buckets
+		}
+	}
+	return
+}
+
+// Link is an entry of a chain reported by LongestChains: a key, its digest,
+// and whether the key has a value rather than being a tombstone.
+type Link[K comparable] = hmap.Link[K]
+
+// LongestChains returns the entries of up to n of the longest chains in the
+// buckets of the current tables of the shards, longest first, so that the
+// keys whose hashes collide can be found and the hash function fixed. The keys
+// of a chain share the bucket of their digests, which are the hashes of the
+// keys mixed with the seed of the map, so keys whose hashes are equal share a
+// chain in every map while other collisions differ from map to map. The chains
+// include tombstones, as those of BucketHistogram do, and like BucketHistogram,
+// it is not a consistent snapshot of a map updated concurrently.
+func (m *MapOf[K, V]) LongestChains(n int) (chains [][]Link[K]) {
+	for i := range m.shards {
+		chains = append(chains, m.shards[i].table().LongestChains(n)...)
+	}
+	slices.SortStableFunc(chains, func(a, b []Link[K]) int {
+		return cmp.Compare(len(b), len(a))
+	})
+	return chains[:min(n, len(chains))]
+}
+
+// Publish publishes the statistics of the map by expvar under the given name,
+// so that they are served as JSON at /debug/vars along with the other
+// variables of the process. They are read from the map every time they are
+// served. It panics if the name is already in use, as expvar.Publish does.
+func (m *MapOf[K, V]) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() any { return m.Stats() }))
+}
+
+// StatsWindow is a window of time over the statistics of a map, whose counts
+// of operations and of resizes are those since the window started rather than
+// since the map was created, so that their rates over an interval are read
+// without differencing the counts of Stats. The other statistics are those of
+// the moment they are read. A window is safe for concurrent use, and the
+// windows of a map are independent of each other and of Stats, which keeps
+// counting from the creation of the map.
+type StatsWindow struct {
+	stats func() Stats
+	mu    sync.Mutex
+	start Stats     // the statistics at the start of the window
+	since time.Time // the time the window started
+}
+
+// StatsWindow returns a window over the statistics of the map that starts now.
+func (m *MapOf[K, V]) StatsWindow() *StatsWindow {
+	return &StatsWindow{stats: m.Stats, start: m.Stats(), since: time.Now()}
+}
+
+// Snapshot returns the statistics of the window and the time since it started,
+// by which its counts are divided to make their rates.
+func (w *StatsWindow) Snapshot() (stats Stats, elapsed time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	stats, now := w.stats(), time.Now()
+	return w.start.since(stats), now.Sub(w.since)
+}
+
+// Reset returns the statistics of the window as Snapshot does, and starts the
+// window over, so that calling Reset every minute returns the counts of each
+// minute, none of which is counted twice or lost.
+func (w *StatsWindow) Reset() (stats Stats, elapsed time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now, end := time.Now(), w.stats()
+	stats, elapsed = w.start.since(end), now.Sub(w.since)
+	w.start, w.since = end, now
+	return
+}
+
+// since returns the statistics end with the counts of s subtracted from them.
+func (s *Stats) since(end Stats) Stats {
+	end.Resizes -= s.Resizes
+	end.Hits -= s.Hits
+	end.Misses -= s.Misses
+	end.Stores -= s.Stores
+	end.Deletes -= s.Deletes
+	end.Evictions -= s.Evictions
+	return end
+}
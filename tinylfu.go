@@ -0,0 +1,148 @@
+package cmap
+
+import (
+	"math/bits"
+	"sync"
+	"sync/atomic"
+
+	"github.com/decillion/go-cmap/hmap"
+)
+
+// tinyLFU is the admission filter of the EvictTinyLFU policy. It estimates how
+// often keys are used with a frequency sketch, and keeps a window of the keys
+// inserted last, so that a new key can build up its frequency before it has to
+// compete with the key chosen by the CLOCK algorithm.
+type tinyLFU[K comparable] struct {
+	sketch sketch
+	window window[K]
+}
+
+// newTinyLFU returns the admission filter of a map according to the options
+// o, or nil unless the policy of the map is EvictTinyLFU.
+func newTinyLFU[K comparable](o *options) *tinyLFU[K] {
+	if o.maxKeys == 0 || o.policy != EvictTinyLFU {
+		return nil
+	}
+	f := &tinyLFU[K]{}
+	f.sketch.init(o.maxKeys)
+	f.window.init(o.maxKeys/100 + 1)
+	return f
+}
+
+// sketch is a count-min sketch of four rows of 4-bit counters that estimates
+// the number of times each key has been used. The counters are halved once
+// the number of uses reaches ten times the limit of keys, so that the keys
+// used often long ago give way to the keys used often recently.
+type sketch struct {
+	words   []atomic.Uint64 // 16 counters per word, row after row
+	shift   uint            // the shift of a hash to the index of a counter in a row
+	uses    atomic.Int64    // the number of uses since the counters were halved
+	resetAt int64
+}
+
+// sketchSeeds derive the hashes of the rows from the upper half of a digest.
+var sketchSeeds = [4]uint64{0x9e3779b97f4a7c15, 0xbf58476d1ce4e5b9, 0x94d049bb133111eb, 0xd6e8feb86659fd93}
+
+func (s *sketch) init(keys int64) {
+	width := 16 << bits.Len64(uint64(keys-1)/16) // counters per row
+	s.words = make([]atomic.Uint64, 4*width/16)
+	s.shift = uint(64 - bits.Len(uint(width-1)))
+	s.resetAt = 10 * keys
+}
+
+// counter returns the word and the shift of the counter of the digest d in
+// the given row.
+func (s *sketch) counter(d hmap.Digest, row int) (w *atomic.Uint64, shift uint) {
+	i := (d.Uint64() * sketchSeeds[row]) >> s.shift
+	return &s.words[row*len(s.words)/4+int(i/16)], uint(i%16) * 4
+}
+
+// increment records a use of the key of the digest d.
+func (s *sketch) increment(d hmap.Digest) {
+	for row := range sketchSeeds {
+		w, shift := s.counter(d, row)
+		for {
+			old := w.Load()
+			if old>>shift&0xf == 0xf || w.CompareAndSwap(old, old+1<<shift) {
+				break
+			}
+		}
+	}
+	if s.uses.Add(1) == s.resetAt {
+		for i := range s.words {
+			for {
+				old := s.words[i].Load()
+				if s.words[i].CompareAndSwap(old, old>>1&0x7777777777777777) {
+					break
+				}
+			}
+		}
+		s.uses.Add(-s.resetAt / 2)
+	}
+}
+
+// frequency returns the estimated number of uses of the key of the digest d.
+func (s *sketch) frequency(d hmap.Digest) (n uint64) {
+	n = 0xf
+	for row := range sketchSeeds {
+		w, shift := s.counter(d, row)
+		if c := w.Load() >> shift & 0xf; c < n {
+			n = c
+		}
+	}
+	return
+}
+
+// window is a ring of the keys inserted last into a full map.
+type window[K comparable] struct {
+	mu   sync.Mutex
+	keys []windowKey[K]
+	next int  // the index at which the next key is put
+	full bool // whether every slot of keys is taken
+}
+
+type windowKey[K comparable] struct {
+	key    K
+	digest hmap.Digest
+}
+
+func (w *window[K]) init(size int64) {
+	w.keys = make([]windowKey[K], size)
+}
+
+// push puts the key of the digest d into the window and returns the oldest key
+// and true if it is pushed out of the window.
+func (w *window[K]) push(key K, d hmap.Digest) (oldest windowKey[K], ok bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	oldest, ok = w.keys[w.next], w.full
+	w.keys[w.next] = windowKey[K]{key, d}
+	if w.next++; w.next == len(w.keys) {
+		w.next, w.full = 0, true
+	}
+	return
+}
+
+// evictTinyLFU removes a key from the full map to make room for the new key of
+// the digest d, and reports whether a key is removed. The new key enters the
+// window, and the key pushed out of it competes with the key chosen by the
+// CLOCK algorithm: the one used less often is removed, which is the key out of
+// the window unless it has been used more often than the other.
+func (m *MapOf[K, V]) evictTinyLFU(key K, d hmap.Digest) (evicted bool) {
+	candidate, ok := m.filter.window.push(key, d)
+	m.sweepVictims(d, func(victim K) bool {
+		if ok {
+			_, vd := m.locate(victim)
+			if m.filter.sketch.frequency(candidate.digest) <= m.filter.sketch.frequency(vd) {
+				if evicted = m.evictKey(candidate.key); evicted {
+					return true
+				}
+			}
+			ok = false // the candidate is admitted or gone
+		}
+		evicted = m.evictKey(victim)
+		return evicted
+	})
+	return
+}
@@ -0,0 +1,394 @@
+package cmap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// TTLMapOf is a concurrent map with keys of type K and values of type V in
+// which a key may be stored with a time to live. A key whose time has passed
+// is expired: it is treated as absent by every method, and it is deleted from
+// the map by the first method that finds it expired, or by the janitor
+// started by StartJanitor.
+//
+// WriteTo is the only encoding of the map, and it carries the times at which
+// the keys expire. The map has no Export, MarshalJSON, GobEncode or
+// MarshalMsgpack, since the formats of those have no place for a deadline; a
+// copy of the pairs made by Range and encoded in such a format loses them.
+// DurableTTLMapOf keeps the deadlines in its log and checkpoints.
+type TTLMapOf[K comparable, V any] struct {
+	m        *MapOf[K, ttlValue[V]]
+	onExpire func(key K, value V) // nil unless WithOnExpire is given
+
+	mu   sync.Mutex    // guards stop and done
+	stop chan struct{} // closed to stop the janitor, or nil if none runs
+	done chan struct{} // closed when the janitor has stopped
+}
+
+// ttlValue is a value of a TTLMapOf with the time at which it expires in
+// nanoseconds since the Unix epoch, or zero if it never expires.
+type ttlValue[V any] struct {
+	value    V
+	deadline int64
+}
+
+// expired reports whether the value is expired at the given time.
+func (v ttlValue[V]) expired(now int64) bool {
+	return v.deadline != 0 && v.deadline <= now
+}
+
+// TTLMap is a concurrent map with times to live whose keys and values are of
+// arbitrary types.
+type TTLMap = TTLMapOf[interface{}, interface{}]
+
+// NewTTLMap returns an empty map with times to live whose keys are hashed by
+// the given function.
+func NewTTLMap[H Hash](hasher func(key interface{}) H, opts ...Option) (m *TTLMap) {
+	return NewTTLMapOf[interface{}, interface{}](hasher, opts...)
+}
+
+// NewTTLMapOf returns an empty map with times to live with keys of type K and
+// values of type V, where keys are hashed by the given function. The functions
+// given by WithCostLimit and WithOnEvict and the codecs given by WithCodecs take
+// the values stored, without their times to live.
+func NewTTLMapOf[K comparable, V any, H Hash](hasher func(key K) H, opts ...Option) (m *TTLMapOf[K, V]) {
+	m = &TTLMapOf[K, V]{}
+	o := newOptions(opts)
+	opts = opts[:len(opts):len(opts)]
+	if o.cost != nil {
+		cost, ok := o.cost.(func(value V) int64)
+		if !ok {
+			panic(fmt.Sprintf("cmap: the cost function of WithCostLimit is %T, not %v", o.cost, reflect.TypeFor[func(value V) int64]()))
+		}
+		opts = append(opts, func(o *options) {
+			o.cost = func(v ttlValue[V]) int64 { return cost(v.value) }
+		})
+	}
+	if o.onEvict != nil {
+		onEvict, ok := o.onEvict.(func(key K, value V))
+		if !ok {
+			panic(fmt.Sprintf("cmap: the function of WithOnEvict is %T, not %v", o.onEvict, reflect.TypeFor[func(key K, value V)]()))
+		}
+		opts = append(opts, WithOnEvict(func(k K, v ttlValue[V]) { onEvict(k, v.value) }))
+	}
+	if o.onExpire != nil {
+		onExpire, ok := o.onExpire.(func(key K, value V))
+		if !ok {
+			panic(fmt.Sprintf("cmap: the function of WithOnExpire is %T, not %v", o.onExpire, reflect.TypeFor[func(key K, value V)]()))
+		}
+		m.onExpire = onExpire
+	}
+	values, err := codecOf[V](o.valueCodec, "values")
+	opts = append(opts, func(o *options) { o.valueCodec = ttlCodec[V]{values, err} })
+	m.m = NewMapOf[K, ttlValue[V]](hasher, opts...)
+	return
+}
+
+// WithOnExpire sets the function called with every key and value of a map
+// with times to live that is deleted from the map after expiring, whether it
+// is found expired by a method of the map or by the janitor, or replaced by
+// a new value. The function is called once the key is gone, while no lock of
+// the map is held, so it may call the methods of the map. K and V must be the
+// types of the keys and values of the map, or else NewTTLMapOf panics. The
+// option is ignored by the other maps.
+func WithOnExpire[K comparable, V any](f func(key K, value V)) Option {
+	return func(o *options) { o.onExpire = f }
+}
+
+// expire calls the function of WithOnExpire on the given key and its value
+// just removed from the map, which is absent if loaded is false, if the value
+// was expired at the given time.
+func (m *TTLMapOf[K, V]) expire(key K, v ttlValue[V], loaded bool, now int64) {
+	if loaded && m.onExpire != nil && v.expired(now) {
+		m.onExpire(key, v.value)
+	}
+}
+
+// Load returns the value associated with the given key and true if the key
+// exists and is not expired. Otherwise, it returns the zero value and false.
+func (m *TTLMapOf[K, V]) Load(key K) (value V, ok bool) {
+	v, ok := m.load(key)
+	return v.value, ok
+}
+
+// LoadWithExpiration is like Load but also returns the time at which the key
+// expires, which is the zero time if the key never expires.
+func (m *TTLMapOf[K, V]) LoadWithExpiration(key K) (value V, expiresAt time.Time, ok bool) {
+	v, ok := m.load(key)
+	if ok && v.deadline != 0 {
+		expiresAt = time.Unix(0, v.deadline)
+	}
+	return v.value, expiresAt, ok
+}
+
+// load returns the value of the given key with its deadline and true if the
+// key exists and is not expired, deleting the key if it is expired.
+func (m *TTLMapOf[K, V]) load(key K) (v ttlValue[V], ok bool) {
+	if v, ok = m.m.Load(key); ok && v.expired(time.Now().UnixNano()) {
+		m.deleteExpired(key)
+		return ttlValue[V]{}, false
+	}
+	return
+}
+
+// Store sets the value for the given key, which never expires.
+func (m *TTLMapOf[K, V]) Store(key K, value V) {
+	m.store(key, ttlValue[V]{value: value})
+}
+
+// StoreWithTTL sets the value for the given key, which expires once the given
+// duration has passed. A key stored with a non-positive duration is expired
+// at once.
+func (m *TTLMapOf[K, V]) StoreWithTTL(key K, value V, ttl time.Duration) {
+	m.store(key, ttlValue[V]{value: value, deadline: deadline(ttl)})
+}
+
+// store sets the given value to the given key. The value it replaces is looked
+// at only if there is a function of WithOnExpire to call on it.
+func (m *TTLMapOf[K, V]) store(key K, v ttlValue[V]) {
+	if m.onExpire == nil {
+		m.m.Store(key, v)
+		return
+	}
+	now := time.Now().UnixNano()
+	var old ttlValue[V]
+	var loaded bool
+	if _, stored := m.m.compute(key, func(o ttlValue[V], ok bool) (ttlValue[V], bool) {
+		old, loaded = o, ok
+		return v, true
+	}); stored {
+		m.expire(key, old, loaded, now)
+	}
+}
+
+// deadline returns the time at which a value stored now with the given time
+// to live expires.
+func deadline(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 1 // the earliest time that is not zero
+	}
+	return time.Now().Add(ttl).UnixNano()
+}
+
+// Touch sets the time to live of the given key to the given duration from
+// now, keeping its value, and reports whether the key exists and is not
+// expired. A key touched with a non-positive duration is expired at once.
+func (m *TTLMapOf[K, V]) Touch(key K, ttl time.Duration) (touched bool) {
+	now := time.Now().UnixNano()
+	var expired ttlValue[V]
+	var found bool
+	m.m.compute(key, func(old ttlValue[V], ok bool) (ttlValue[V], bool) {
+		if found = ok && old.expired(now); found {
+			expired = old
+			return old, false
+		}
+		if touched = ok; touched {
+			old.deadline = deadline(ttl)
+		}
+		return old, ok
+	})
+	m.expire(key, expired, found, now)
+	return
+}
+
+// LoadOrStore returns the existing value of the given key and true if the key
+// exists and is not expired. Otherwise, it stores the given value, which never
+// expires, and returns the value and false.
+func (m *TTLMapOf[K, V]) LoadOrStore(key K, value V) (actual V, loaded bool) {
+	now := time.Now().UnixNano()
+	var expired ttlValue[V]
+	var found bool
+	if _, stored := m.m.compute(key, func(old ttlValue[V], ok bool) (ttlValue[V], bool) {
+		if ok && !old.expired(now) {
+			actual, loaded = old.value, true
+			return old, true
+		}
+		expired, found = old, ok
+		actual = value
+		return ttlValue[V]{value: value}, true
+	}); stored && !loaded {
+		m.expire(key, expired, found, now)
+	}
+	return
+}
+
+// Delete removes the given key and its associated value.
+func (m *TTLMapOf[K, V]) Delete(key K) {
+	if m.onExpire == nil {
+		m.m.Delete(key)
+		return
+	}
+	m.LoadAndDelete(key)
+}
+
+// LoadAndDelete removes the given key and returns the associated value and
+// true if the key exists and is not expired. Otherwise, it returns the zero
+// value and false.
+func (m *TTLMapOf[K, V]) LoadAndDelete(key K) (value V, loaded bool) {
+	v, loaded := m.m.LoadAndDelete(key)
+	if now := time.Now().UnixNano(); !loaded || v.expired(now) {
+		m.expire(key, v, loaded, now)
+		return value, false
+	}
+	return v.value, true
+}
+
+// deleteExpired removes the given key if it is expired, and reports whether it
+// is removed.
+func (m *TTLMapOf[K, V]) deleteExpired(key K) (found bool) {
+	now := time.Now().UnixNano()
+	var expired ttlValue[V]
+	m.m.compute(key, func(old ttlValue[V], ok bool) (ttlValue[V], bool) {
+		if found = ok && old.expired(now); found {
+			expired = old
+		}
+		return old, ok && !found
+	})
+	m.expire(key, expired, found, now)
+	return
+}
+
+// Len returns the number of keys in the map, which includes the expired keys
+// that have not been deleted yet.
+func (m *TTLMapOf[K, V]) Len() int {
+	return m.m.Len()
+}
+
+// Range iteratively applies the given function to each key-value pair that is
+// not expired until the function returns false, deleting the expired keys it
+// finds. It gives the same guarantees as MapOf.Range.
+func (m *TTLMapOf[K, V]) Range(f func(key K, value V) bool) {
+	now := time.Now().UnixNano()
+	m.m.Range(func(k K, v ttlValue[V]) bool {
+		if v.expired(now) {
+			m.deleteExpired(k)
+			return true
+		}
+		return f(k, v.value)
+	})
+}
+
+// StartJanitor starts a goroutine that deletes the expired keys from the map
+// every time the given interval passes, so that keys which are never looked
+// up again do not occupy the map. A janitor already running is stopped first.
+// The janitor runs until Close is called, which must be done before the map
+// is discarded. It panics if interval is not positive.
+func (m *TTLMapOf[K, V]) StartJanitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.stopJanitor()
+	stop, done := make(chan struct{}), make(chan struct{})
+	m.stop, m.done = stop, done
+	go func() {
+		defer close(done)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				m.sweep()
+			}
+		}
+	}()
+}
+
+// stopJanitor stops the janitor if it is running and waits until it has
+// stopped. The caller must hold m.mu.
+func (m *TTLMapOf[K, V]) stopJanitor() {
+	if m.stop == nil {
+		return
+	}
+	close(m.stop)
+	<-m.done
+	m.stop, m.done = nil, nil
+}
+
+// Close stops the janitor started by StartJanitor and waits until it has
+// stopped. The map can still be used after Close, but expired keys are then
+// deleted only when they are found. Close does nothing if no janitor runs.
+func (m *TTLMapOf[K, V]) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stopJanitor()
+}
+
+// DeleteExpired deletes the keys that are expired from the map, as the
+// janitor does at every interval.
+func (m *TTLMapOf[K, V]) DeleteExpired() {
+	m.deleteAllExpired()
+}
+
+// deleteAllExpired is DeleteExpired that returns the number of keys deleted.
+func (m *TTLMapOf[K, V]) deleteAllExpired() (n int) {
+	now := time.Now().UnixNano()
+	m.m.Range(func(k K, v ttlValue[V]) bool {
+		if v.expired(now) && m.deleteExpired(k) {
+			n++
+		}
+		return true
+	})
+	return
+}
+
+// sweep deletes the expired keys for the janitor and logs the sweep.
+func (m *TTLMapOf[K, V]) sweep() {
+	start := time.Now()
+	n := m.deleteAllExpired()
+	if o := &m.m.opts; o.logEnabled(slog.LevelDebug) {
+		o.log(slog.LevelDebug, "cmap: the janitor swept the expired keys", slog.Int("deleted", n),
+			slog.Int("keys", m.Len()), slog.Duration("elapsed", time.Since(start)))
+	}
+}
+
+// WriteTo writes a snapshot of the map to w as MapOf.WriteTo does, and returns
+// the number of bytes written. Each value is written with the wall-clock time
+// at which it expires, so that ReadFrom restores it with the same deadline
+// instead of a fresh time to live.
+func (m *TTLMapOf[K, V]) WriteTo(w io.Writer) (n int64, err error) {
+	return m.m.WriteTo(w)
+}
+
+// ReadFrom reads a snapshot written by WriteTo from r as MapOf.ReadFrom does,
+// and returns the number of bytes read. The keys that have expired since the
+// snapshot was written are deleted once it is read, as DeleteExpired does.
+func (m *TTLMapOf[K, V]) ReadFrom(r io.Reader) (n int64, err error) {
+	n, err = m.m.ReadFrom(r)
+	m.DeleteExpired()
+	return
+}
+
+// ttlCodec is the codec of the values of a TTLMapOf, which encodes the
+// deadline of a value as a varint followed by the value encoded by the codec
+// of the values given to the map. Its err is the error of finding that codec.
+type ttlCodec[V any] struct {
+	values Codec[V]
+	err    error
+}
+
+func (c ttlCodec[V]) Encode(b []byte, v ttlValue[V]) ([]byte, error) {
+	if c.err != nil {
+		return b, c.err
+	}
+	return c.values.Encode(binary.AppendVarint(b, v.deadline), v.value)
+}
+
+func (c ttlCodec[V]) Decode(data []byte) (v ttlValue[V], err error) {
+	if c.err != nil {
+		return v, c.err
+	}
+	deadline, n := binary.Varint(data)
+	if n <= 0 {
+		return v, errors.New("cmap: a value of the snapshot without a deadline")
+	}
+	value, err := c.values.Decode(data[n:])
+	return ttlValue[V]{value, deadline}, err
+}
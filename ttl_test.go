@@ -0,0 +1,242 @@
+package cmap_test
+
+import (
+	"bytes"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/decillion/go-cmap"
+)
+
+type ttlMapCall struct {
+	Op         uint8
+	Key, Value uint8
+}
+
+func TestTTLMapMatchesBuiltInMap(t *testing.T) {
+	f := func(calls []ttlMapCall) bool {
+		m := cmap.NewTTLMapOf[uint64, uint64](cmap.Uint64Hasher)
+		builtin := make(map[uint64]uint64)
+		for _, c := range calls {
+			k, v := uint64(c.Key%16), uint64(c.Value)
+			switch c.Op % 5 {
+			case 0:
+				m.Store(k, v)
+				builtin[k] = v
+			case 1:
+				m.StoreWithTTL(k, v, time.Hour)
+				builtin[k] = v
+			case 2:
+				m.StoreWithTTL(k, v, -time.Second) // expired at once
+				delete(builtin, k)
+			case 3:
+				actual, loaded := m.LoadOrStore(k, v)
+				w, found := builtin[k]
+				if !found {
+					builtin[k], w = v, v
+				}
+				if actual != w || loaded != found {
+					return false
+				}
+			default:
+				value, loaded := m.LoadAndDelete(k)
+				w, found := builtin[k]
+				delete(builtin, k)
+				if value != w || loaded != found {
+					return false
+				}
+			}
+		}
+		for i := uint64(0); i < 16; i++ {
+			v, ok := m.Load(i)
+			if w, found := builtin[i]; ok != found || v != w {
+				return false
+			}
+		}
+		n := 0
+		m.Range(func(k, v uint64) bool {
+			n++
+			return builtin[k] == v
+		})
+		return n == len(builtin) && m.Len() == len(builtin)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTTLMapExpiresKeys(t *testing.T) {
+	const ttl = 20 * time.Millisecond
+	m := cmap.NewTTLMapOf[int, int](func(key int) uint64 { return uint64(key) })
+	m.StoreWithTTL(0, 0, ttl)
+	m.StoreWithTTL(1, 1, time.Hour)
+	m.Store(2, 2)
+	if v, ok := m.Load(0); !ok || v != 0 {
+		t.Fatalf("Load(0) = %v, %v before the key expires; want 0, true", v, ok)
+	}
+
+	time.Sleep(2 * ttl)
+	if v, ok := m.Load(0); ok {
+		t.Errorf("Load(0) = %v, %v after the key expires; want 0, false", v, ok)
+	}
+	if m.Len() != 2 {
+		t.Errorf("Len() = %v after Load of the expired key; want 2", m.Len())
+	}
+	m.StoreWithTTL(2, 2, ttl) // a key without a time to live gets one
+	time.Sleep(2 * ttl)
+	m.Range(func(k, _ int) bool {
+		if k != 1 {
+			t.Errorf("Range visited the expired key %v", k)
+		}
+		return true
+	})
+	if m.Len() != 1 {
+		t.Errorf("Len() = %v after Range; want 1", m.Len())
+	}
+}
+
+func TestTTLMapTouch(t *testing.T) {
+	const ttl = 20 * time.Millisecond
+	m := cmap.NewTTLMapOf[int, int](func(key int) uint64 { return uint64(key) })
+	m.Store(0, 0)
+	m.StoreWithTTL(1, 1, ttl)
+	if v, at, ok := m.LoadWithExpiration(0); v != 0 || !at.IsZero() || !ok {
+		t.Errorf("LoadWithExpiration(0) = %v, %v, %v; want 0, the zero time, true", v, at, ok)
+	}
+	before := time.Now()
+	if !m.Touch(0, time.Hour) || !m.Touch(1, time.Hour) || m.Touch(2, time.Hour) {
+		t.Error("Touch does not report whether the key exists")
+	}
+	for k := 0; k < 2; k++ {
+		v, at, ok := m.LoadWithExpiration(k)
+		if v != k || !ok || at.Before(before.Add(time.Hour)) || at.After(time.Now().Add(time.Hour)) {
+			t.Errorf("LoadWithExpiration(%v) = %v, %v, %v after Touch; want %v, an hour later, true", k, v, at, ok, k)
+		}
+	}
+
+	m.Touch(0, ttl)
+	time.Sleep(2 * ttl)
+	if m.Touch(0, time.Hour) {
+		t.Error("Touch brings back an expired key")
+	}
+	if _, _, ok := m.LoadWithExpiration(0); ok || m.Len() != 1 {
+		t.Errorf("LoadWithExpiration(0) = _, _, %v and Len() = %v after the key expires; want false and 1", ok, m.Len())
+	}
+	if m.Touch(1, -time.Second); m.Len() != 1 {
+		t.Errorf("Len() = %v after touching a key with a negative duration; want 1", m.Len())
+	} else if _, ok := m.Load(1); ok || m.Len() != 0 {
+		t.Errorf("Load(1) = _, %v and Len() = %v after the key expires; want false and 0", ok, m.Len())
+	}
+}
+
+func TestTTLMapSnapshot(t *testing.T) {
+	const ttl = 20 * time.Millisecond
+	m := cmap.NewTTLMapOf[string, int](cmap.StringHasher)
+	m.Store("forever", 0)
+	m.StoreWithTTL("hour", 1, time.Hour)
+	m.StoreWithTTL("soon", 2, ttl)
+	_, hour, _ := m.LoadWithExpiration("hour")
+	var b bytes.Buffer
+	if _, err := m.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * ttl)
+
+	read := cmap.NewTTLMapOf[string, int](cmap.StringHasher)
+	if _, err := read.ReadFrom(&b); err != nil {
+		t.Fatal(err)
+	}
+	if read.Len() != 2 {
+		t.Errorf("Len() = %v after ReadFrom; want 2 without the key expired since WriteTo", read.Len())
+	}
+	if v, at, ok := read.LoadWithExpiration("forever"); v != 0 || !at.IsZero() || !ok {
+		t.Errorf(`LoadWithExpiration("forever") = %v, %v, %v after ReadFrom; want 0, the zero time, true`, v, at, ok)
+	}
+	if v, at, ok := read.LoadWithExpiration("hour"); v != 1 || !at.Equal(hour) || !ok {
+		t.Errorf(`LoadWithExpiration("hour") = %v, %v, %v after ReadFrom; want 1, %v, true`, v, at, ok, hour)
+	}
+
+	points := cmap.NewTTLMapOf[string, codecPoint](cmap.StringHasher)
+	points.Store("p", codecPoint{})
+	if _, err := points.WriteTo(&b); err == nil {
+		t.Error("WriteTo accepts values of a struct type without a codec")
+	}
+	points = cmap.NewTTLMapOf[string, codecPoint](cmap.StringHasher, cmap.WithCodecs[string, codecPoint](nil, pointCodec{}))
+	points.StoreWithTTL("p", codecPoint{1, 2}, time.Hour)
+	b.Reset()
+	if _, err := points.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	points = cmap.NewTTLMapOf[string, codecPoint](cmap.StringHasher, cmap.WithCodecs[string, codecPoint](nil, pointCodec{}))
+	if _, err := points.ReadFrom(&b); err != nil {
+		t.Fatal(err)
+	}
+	if v, at, ok := points.LoadWithExpiration("p"); v != (codecPoint{1, 2}) || at.IsZero() || !ok {
+		t.Errorf(`LoadWithExpiration("p") = %v, %v, %v after ReadFrom with a codec`, v, at, ok)
+	}
+}
+
+func TestTTLMapOnExpire(t *testing.T) {
+	const ttl = 10 * time.Millisecond
+	expired := make(map[int]int)
+	m := cmap.NewTTLMapOf[int, int](func(key int) uint64 { return uint64(key) }, cmap.WithOnExpire(func(k, v int) {
+		expired[k]++
+	}))
+	for i := 0; i < 6; i++ {
+		m.StoreWithTTL(i, i, ttl)
+		m.Store(-i-1, i) // never expires
+	}
+	time.Sleep(2 * ttl)
+	m.Load(0)
+	m.Store(1, 1)
+	m.LoadOrStore(2, 2)
+	m.Delete(3)
+	m.LoadAndDelete(4)
+	m.DeleteExpired()
+	m.Range(func(int, int) bool { return true })
+	for i := 0; i < 6; i++ {
+		if expired[i] != 1 {
+			t.Errorf("the function of WithOnExpire is called %v times on the key %v; want 1", expired[i], i)
+		}
+	}
+	if len(expired) != 6 {
+		t.Errorf("the function of WithOnExpire is called on %v keys; want 6", len(expired))
+	}
+
+	var evicted []int
+	l := cmap.NewTTLMapOf[int, string](func(key int) uint64 { return uint64(key) },
+		cmap.WithCostLimit(4, func(v string) int64 { return int64(len(v)) }, cmap.Evict),
+		cmap.WithOnEvict(func(k int, _ string) { evicted = append(evicted, k) }))
+	l.StoreWithTTL(0, "ab", time.Hour)
+	l.Store(1, "ab")
+	l.Store(2, "abcd")
+	if len(evicted) != 2 || l.Len() != 1 {
+		t.Errorf("%v keys are evicted and Len() = %v; want 2 and 1", len(evicted), l.Len())
+	}
+}
+
+func TestTTLMapJanitor(t *testing.T) {
+	const ttl = 10 * time.Millisecond
+	m := cmap.NewTTLMapOf[int, int](func(key int) uint64 { return uint64(key) })
+	defer m.Close()
+	for i := 0; i < capacity; i++ {
+		m.StoreWithTTL(i, i, ttl)
+	}
+	m.Store(capacity, capacity)
+	m.StartJanitor(ttl)
+	m.StartJanitor(ttl) // replaces the running janitor
+
+	deadline := time.Now().Add(10 * time.Second)
+	for m.Len() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Len() = %v long after the keys expired; want 1", m.Len())
+		}
+		time.Sleep(ttl)
+	}
+	m.Close()
+	m.Close() // does nothing
+	if v, ok := m.Load(capacity); !ok || v != capacity {
+		t.Errorf("Load(%v) = %v, %v after Close; want %v, true", capacity, v, ok, capacity)
+	}
+}
@@ -0,0 +1,148 @@
+package cmap
+
+import "unsafe"
+
+// StringMap is a concurrent map specialized for string keys. Keys are neither
+// boxed into interfaces nor hashed by reflection.
+type StringMap = MapOf[string, interface{}]
+
+// NewStringMap returns an empty hash map whose keys are hashed by
+// StringHasher.
+func NewStringMap() (m *StringMap) {
+	return NewMapOf[string, interface{}](StringHasher)
+}
+
+// StringHasher is a hash function for strings. It implements 32-bit FNV-1a and
+// does not allocate.
+func StringHasher(key string) uint32 {
+	const (
+		offset32 = 2166136261
+		prime32  = 16777619
+	)
+	hash := uint32(offset32)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= prime32
+	}
+	return hash
+}
+
+// Uint64Map is a concurrent map specialized for uint64 keys. Keys are neither
+// boxed into interfaces nor hashed by reflection.
+type Uint64Map = MapOf[uint64, interface{}]
+
+// NewUint64Map returns an empty hash map whose keys are hashed by
+// Uint64Hasher.
+func NewUint64Map() (m *Uint64Map) {
+	return NewMapOf[uint64, interface{}](Uint64Hasher)
+}
+
+// Uint64Hasher is a hash function for uint64 values. It applies the finalizer
+// of MurmurHash3 so that every bit of the key affects every bit of the hash,
+// which matters for keys such as snowflake IDs whose low bits are regular.
+func Uint64Hasher(key uint64) uint32 {
+	key ^= key >> 33
+	key *= 0xff51afd7ed558ccd
+	key ^= key >> 33
+	key *= 0xc4ceb9fe1a85ec53
+	key ^= key >> 33
+	return uint32(key)
+}
+
+// BytesMap is a concurrent map specialized for byte slice keys, which cannot
+// be keys of MapOf because they are not comparable. Keys are compared by their
+// contents.
+//
+// Store, LoadOrStore, and Swap copy a new key into the map, so the caller may
+// modify the slice afterwards. The other methods only look at the given slice
+// during the call and never copy it.
+type BytesMap struct {
+	m *MapOf[string, interface{}]
+}
+
+// NewBytesMap returns an empty hash map whose keys are hashed by BytesHasher.
+func NewBytesMap() (m *BytesMap) {
+	return &BytesMap{m: NewMapOf[string, interface{}](StringHasher)}
+}
+
+// BytesHasher is a hash function for byte slices. It returns the same hash as
+// StringHasher for the string with the same contents and does not allocate.
+func BytesHasher(key []byte) uint32 {
+	return StringHasher(bytesView(key))
+}
+
+// bytesView returns a string sharing the memory with b. The string must not
+// be retained beyond the lifetime of the contents of b.
+func bytesView(b []byte) string {
+	return unsafe.String(unsafe.SliceData(b), len(b))
+}
+
+// Load returns the value associated with the given key and true if the key
+// exists. Otherwise, it returns nil and false.
+func (m *BytesMap) Load(key []byte) (value interface{}, ok bool) {
+	return m.m.Load(bytesView(key))
+}
+
+// Store sets the given value to the given key.
+func (m *BytesMap) Store(key []byte, value interface{}) {
+	m.m.Store(string(key), value)
+}
+
+// LoadOrStore returns the existing value for the given key and true if the key
+// exists. Otherwise, it stores the given value and returns it and false.
+func (m *BytesMap) LoadOrStore(key []byte, value interface{}) (actual interface{}, loaded bool) {
+	if actual, loaded = m.m.Load(bytesView(key)); loaded {
+		return
+	}
+	return m.m.LoadOrStore(string(key), value)
+}
+
+// Swap sets the given value to the given key and returns the previous value
+// and true if the key exists. Otherwise, it returns nil and false.
+func (m *BytesMap) Swap(key []byte, value interface{}) (previous interface{}, loaded bool) {
+	return m.m.Swap(string(key), value)
+}
+
+// CompareAndSwap sets the given new value to the given key if the key exists
+// and its value is equal to old. It reports whether the value was replaced.
+// The old value must be of a comparable type.
+func (m *BytesMap) CompareAndSwap(key []byte, old, new interface{}) (swapped bool) {
+	return m.m.CompareAndSwap(bytesView(key), old, new)
+}
+
+// Delete logically removes the given key and its associated value.
+func (m *BytesMap) Delete(key []byte) {
+	m.m.Delete(bytesView(key))
+}
+
+// LoadAndDelete logically removes the given key and returns its associated
+// value and true if the key exists. Otherwise, it returns nil and false.
+func (m *BytesMap) LoadAndDelete(key []byte) (value interface{}, loaded bool) {
+	return m.m.LoadAndDelete(bytesView(key))
+}
+
+// CompareAndDelete logically removes the given key if the key exists and its
+// value is equal to old. It reports whether the key was removed. The old value
+// must be of a comparable type.
+func (m *BytesMap) CompareAndDelete(key []byte, old interface{}) (removed bool) {
+	return m.m.CompareAndDelete(bytesView(key), old)
+}
+
+// Clear removes all keys from the map.
+func (m *BytesMap) Clear() {
+	m.m.Clear()
+}
+
+// Len returns the number of keys in the map.
+func (m *BytesMap) Len() int {
+	return m.m.Len()
+}
+
+// Range iteratively applies the given function to each key-value pair until
+// the function returns false. The key passed to the function shares the memory
+// with the map and must not be modified.
+func (m *BytesMap) Range(f func(key []byte, value interface{}) bool) {
+	m.m.Range(func(k string, v interface{}) bool {
+		return f(unsafe.Slice(unsafe.StringData(k), len(k)), v)
+	})
+}